@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/listenbrainz"
+)
+
+// Фейковый ListenBrainz для локальной проверки скробблинга:
+//
+//	go run ./cmd/fake-listenbrainz -tokens dev-token:alice
+//	LISTENBRAINZ_URL=http://localhost:9500
+func main() {
+	addr := flag.String("addr", ":9500", "адрес сервера")
+	tokens := flag.String("tokens", "dev-token:dev", "токены через запятую в виде токен:пользователь")
+	flag.Parse()
+
+	users := make(map[string]string)
+	for _, pair := range strings.Split(*tokens, ",") {
+		token, user, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok {
+			users[token] = user
+		}
+	}
+
+	fake := listenbrainz.NewFakeServer(users)
+	mux := http.NewServeMux()
+	mux.Handle("/1/", fake)
+	mux.HandleFunc("/listens/", func(w http.ResponseWriter, r *http.Request) {
+		user := strings.TrimPrefix(r.URL.Path, "/listens/")
+		w.Header().Set("Content-Type", "application/json")
+		if err := listenbrainz.WriteListens(w, fake.Listens(user)); err != nil {
+			log.Println(err)
+		}
+	})
+
+	log.Printf("Фейковый ListenBrainz на %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
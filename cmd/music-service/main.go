@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
 
 	"github.com/Bossnicks/music-streaming-service-kurs/internal/music"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/auth"
 	"github.com/Bossnicks/music-streaming-service-kurs/pkg/database"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/listenbrainz"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/notify"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/realtime"
 	"github.com/Bossnicks/music-streaming-service-kurs/pkg/storage"
 
 	"github.com/labstack/echo/v4"
@@ -29,8 +35,73 @@ func main() {
 		AllowMethods: []string{echo.GET, echo.POST, echo.PUT, echo.DELETE},                                  // Разрешенные HTTP-методы
 	}))
 
+	// Персональные токены принимаются везде, где принимается JWT, но только в пределах своих областей
+	auth.SetPersonalTokenStore(auth.NewSQLPersonalTokenStore(db))
+	e.Use(auth.RequirePersonalTokenScopes(map[string]string{
+		"POST /songs/upload":                                "tracks:write",
+		"PUT /songs/:id":                                    "tracks:write",
+		"DELETE /songs/:id":                                 "tracks:write",
+		"PUT /songs/:id/credits":                            "tracks:write",
+		"PUT /songs/:id/metadata":                           "tracks:write",
+		"GET /songs/:id/statistics":                         "stats:read",
+		"GET /songs/:id/retention":                          "stats:read",
+		"GET /songs/:id/intensity":                          "stats:read",
+		"GET /songs/:id/time-of-day":                        "stats:read",
+		"GET /songs/:id/geography":                          "stats:read",
+		"GET /songs/:id/listens":                            "stats:read",
+		"GET /songs/:id/trackparts":                         "stats:read",
+		"GET /beatstreet/api/users/allplaylist":             "playlists:read",
+		"POST /beatstreet/api/users/addnewplaylist":         "playlists:write",
+		"PUT /beatstreet/api/users/updateplaylist/:id":      "playlists:write",
+		"DELETE /beatstreet/api/users/deleteplaylist/:id":   "playlists:write",
+		"POST /songs/:playlistId/playlist/addsong/:trackID": "playlists:write",
+		"POST /playlists/:id/tracks":                        "playlists:write",
+		"PUT /playlists/:id/tracks":                         "playlists:write",
+		"PUT /playlists/:id/tracks/:trackID/position":       "playlists:write",
+		"DELETE /playlists/:id/tracks/:trackID":             "playlists:write",
+		"GET /playlists/:id/members":                        "playlists:read",
+		"PUT /playlists/:id/members/:userID":                "playlists:write",
+		"DELETE /playlists/:id/members/:userID":             "playlists:write",
+		"GET /playlists/:id/invites":                        "playlists:write",
+		"POST /playlists/:id/invites":                       "playlists:write",
+		"DELETE /playlists/:id/invites/:inviteID":           "playlists:write",
+		"POST /playlists/invites/:token/accept":             "playlists:write",
+		"PUT /playlists/:id/visibility":                     "playlists:write",
+		"POST /playlists/:id/follow":                        "playlists:write",
+		"DELETE /playlists/:id/follow":                      "playlists:write",
+		"POST /playlists/smart":                             "playlists:write",
+		"PUT /playlists/:id/rules":                          "playlists:write",
+		"POST /playlists/:id/refresh":                       "playlists:write",
+		"GET /playlists/:id/export":                         "playlists:read",
+		"POST /playlists/import":                            "playlists:write",
+		"GET /playlists/:id/revisions":                      "playlists:read",
+		"GET /playlists/:id/revisions/diff":                 "playlists:read",
+		"POST /playlists/:id/revisions/:revision/restore":   "playlists:write",
+		"PUT /playlists/:id/cover":                          "playlists:write",
+		"DELETE /playlists/:id/cover":                       "playlists:write",
+		"GET /library/playlists":                            "playlists:read",
+		"GET /library/albums":                               "playlists:read",
+		"POST /albums/:id/save":                             "social:write",
+		"DELETE /albums/:id/save":                           "social:write",
+		"POST /albums":                                      "albums:write",
+		"GET /albums":                                       "albums:read",
+		"DELETE /albums/:id":                                "albums:write",
+		"PUT /albums/:id":                                   "albums:write",
+		"PUT /albums/:id/cover":                             "albums:write",
+		"PUT /albums/:id/tracks":                            "albums:write",
+		"POST /albums/:id/tracks":                           "albums:write",
+		"DELETE /albums/:id/tracks/:trackID":                "albums:write",
+		"GET /albums/:id/export":                            "albums:read",
+		"GET /tracks/available-for-album":                   "albums:read",
+		"POST /songs/:id/likes":                             "social:write",
+		"DELETE /songs/:id/likes":                           "social:write",
+		"POST /songs/:id/reposts":                           "social:write",
+		"DELETE /songs/:id/reposts":                         "social:write",
+		"POST /songs/:id/comments":                          "social:write",
+	}))
+
 	repo := music.NewRepository(db)
-	service := music.NewService(repo)
+	service := music.NewService(repo, listenbrainz.NewClientFromEnv(), notify.NewNotifier(db), realtime.NewPublisher(db), minioStorage)
 	handler := music.NewHandler(service, minioStorage)
 
 	e.GET("/songs/:id/info", handler.GetTrackInfo)
@@ -42,6 +113,32 @@ func main() {
 	e.DELETE("/beatstreet/api/users/deleteplaylist/:id", handler.DeletePlaylist)
 	e.GET("/beatstreet/api/users/allplaylist", handler.GetUserPlaylists)
 	e.POST("/songs/:playlistId/playlist/addsong/:trackID", handler.AddSongToPlaylist)
+	e.POST("/playlists/:id/tracks", handler.InsertPlaylistTrack)
+	e.PUT("/playlists/:id/tracks", handler.ReplacePlaylistTracks)
+	e.PUT("/playlists/:id/tracks/:trackID/position", handler.MovePlaylistTrack)
+	e.DELETE("/playlists/:id/tracks/:trackID", handler.RemovePlaylistTrack)
+	e.GET("/playlists/:id/members", handler.GetPlaylistMembers)
+	e.PUT("/playlists/:id/members/:userID", handler.UpdatePlaylistMember)
+	e.DELETE("/playlists/:id/members/:userID", handler.RemovePlaylistMember)
+	e.GET("/playlists/:id/invites", handler.GetPlaylistInvites)
+	e.POST("/playlists/:id/invites", handler.CreatePlaylistInvite)
+	e.DELETE("/playlists/:id/invites/:inviteID", handler.RevokePlaylistInvite)
+	e.POST("/playlists/invites/:token/accept", handler.AcceptPlaylistInvite)
+	e.PUT("/playlists/:id/visibility", handler.UpdatePlaylistVisibility)
+	e.POST("/playlists/:id/follow", handler.FollowPlaylist)
+	e.DELETE("/playlists/:id/follow", handler.UnfollowPlaylist)
+	e.POST("/playlists/smart", handler.CreateSmartPlaylist)
+	e.PUT("/playlists/:id/rules", handler.UpdateSmartPlaylistRules)
+	e.POST("/playlists/:id/refresh", handler.RefreshSmartPlaylist)
+	e.GET("/playlists/:id/export", handler.ExportPlaylist)
+	e.POST("/playlists/import", handler.ImportPlaylist)
+	e.GET("/playlists/:id/revisions", handler.GetPlaylistRevisions)
+	e.GET("/playlists/:id/revisions/diff", handler.DiffPlaylistRevisions)
+	e.POST("/playlists/:id/revisions/:revision/restore", handler.RestorePlaylistRevision)
+	e.PUT("/playlists/:id/cover", handler.UploadPlaylistCover)
+	e.DELETE("/playlists/:id/cover", handler.ResetPlaylistCover)
+	e.GET("/library/playlists", handler.GetLibraryPlaylists)
+	e.GET("/library/albums", handler.GetLibraryAlbums)
 	e.POST("/songs/upload", handler.UploadTrack)
 	e.PUT("/songs/:id", handler.UpdateTrack)
 	e.DELETE("/songs/:id", handler.DeleteTrack)
@@ -65,6 +162,25 @@ func main() {
 	e.GET("/artists/top", handler.GetTopUsersByPopularity)
 	e.GET("/artists/:id", handler.GetUserByID)
 	e.GET("/artists/:id/tracks", handler.GetArtistTracks)
+	e.GET("/artists/:id/recent", handler.GetUserRecentTracks)
+	e.GET("/artists/:id/reports/:period/:key", handler.GetListeningReport)
+	e.GET("/artists/:id/reports/:period/:key/card", handler.GetListeningReportCard)
+	e.GET("/reports", handler.GetListeningReports)
+	e.GET("/scrobbling", handler.GetScrobbleConnection)
+	e.PUT("/scrobbling/listenbrainz", handler.ConnectScrobbler)
+	e.DELETE("/scrobbling/listenbrainz", handler.DisconnectScrobbler)
+	e.GET("/scrobbling/export", handler.ExportScrobbles)
+	e.POST("/history/import", handler.ImportListeningHistory)
+	e.GET("/history/imports", handler.GetListeningImports)
+	e.GET("/history/imports/:id", handler.GetListeningImport)
+	e.DELETE("/history/imports/:id", handler.DeleteListeningImport)
+	e.PUT("/artists/me/profile", handler.UpdateArtistProfile)
+	e.POST("/artists/me/banner", handler.UploadArtistBanner)
+	e.POST("/artists/me/verification", handler.RequestVerification)
+	e.GET("/artists/me/verification", handler.GetVerificationStatus)
+	e.GET("/admin/artist-verifications", handler.GetVerificationRequests)
+	e.PUT("/admin/artist-verifications/:id", handler.ReviewVerificationRequest)
+	e.DELETE("/admin/artists/:id/verification", handler.RevokeVerification)
 	e.GET("/playlists/:id", handler.GetPlaylist)
 	e.GET("/songs/:id/statistics", handler.GetSongStatistics)
 	e.GET("/globalstatistics", handler.GetTrackStatisticsGlobal)
@@ -79,6 +195,14 @@ func main() {
 	e.GET("/albums", handler.GetAlbums)
 	e.GET("/albums/:id", handler.GetAlbum)
 	e.DELETE("/albums/:id", handler.DeleteAlbum)
+	e.PUT("/albums/:id", handler.UpdateAlbum)
+	e.PUT("/albums/:id/cover", handler.UploadAlbumCover)
+	e.PUT("/albums/:id/tracks", handler.ReplaceAlbumTracks)
+	e.POST("/albums/:id/tracks", handler.AddAlbumTrack)
+	e.DELETE("/albums/:id/tracks/:trackID", handler.RemoveAlbumTrack)
+	e.GET("/albums/:id/export", handler.ExportAlbumRelease)
+	e.POST("/albums/:id/save", handler.SaveAlbum)
+	e.DELETE("/albums/:id/save", handler.UnsaveAlbum)
 	// e.PATCH("/albums/:id/hide", handler.ToggleAlbumVisibility)
 	e.GET("/tracks/available-for-album", handler.GetAvailableTracks)
 	//e.GET("/playlists/addsong", )
@@ -86,6 +210,20 @@ func main() {
     e.GET("/songs/:id/intensity", handler.GetPlayIntensity)
     e.GET("/songs/:id/time-of-day", handler.GetTimeOfDay)
     e.GET("/songs/:id/geography", handler.GetGeography)
+    e.PUT("/songs/:id/credits", handler.UpdateTrackCredits)
+    e.PUT("/songs/:id/metadata", handler.UpdateTrackMetadata)
+
+	if err := service.FailStaleImports(); err != nil {
+		log.Printf("Ошибка очистки прерванных импортов: %v", err)
+	}
+
+	// Итоги за месяц и год считаются в фоне, пересчет нужен только после смены периода
+	go service.RunReportJobs(context.Background(), time.Hour)
+	go service.RunScrobbleWorker(context.Background(), 30*time.Second)
+	go service.RunSmartPlaylistJobs(context.Background(), 15*time.Minute)
+	go service.RunPlaylistCoverJobs(context.Background(), 10*time.Minute)
+	go service.RunPlaylistCoverWorker(context.Background())
+	go service.RunAlbumReleaseJobs(context.Background(), time.Minute)
 
 	log.Println("Запуск music-service на порту 11000")
 	if err := e.Start(":11000"); err != nil {
@@ -1,10 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/Bossnicks/music-streaming-service-kurs/internal/user"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/auth"
 	"github.com/Bossnicks/music-streaming-service-kurs/pkg/database"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/mailer"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/notify"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/oidc"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/realtime"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/storage"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -18,14 +29,61 @@ func main() {
 	}
 
 	e := echo.New()
+	// IP для журнала входов и задержек после неудачных попыток: X-Forwarded-For учитываем
+	// только от доверенных прокси (TRUSTED_PROXIES=10.0.0.0/8,...), иначе берем адрес соединения
+	e.IPExtractor = echo.ExtractIPDirect()
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		// Частные сети по умолчанию не доверяем: только явно перечисленные адреса
+		options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+		for _, cidr := range strings.Split(proxies, ",") {
+			_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				log.Fatalf("Некорректный TRUSTED_PROXIES: %v", err)
+			}
+			options = append(options, echo.TrustIPRange(ipNet))
+		}
+		e.IPExtractor = echo.ExtractIPFromXFFHeader(options...)
+	}
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"http://localhost:5173", "http://127.0.0.1:5173", "http://172.20.10.2:5173"}, // Разрешенные источники
 		AllowMethods: []string{echo.GET, echo.POST, echo.PUT, echo.DELETE},                                  // Разрешенные HTTP-методы
 	}))
 
+	// Персональные токены принимаются везде, где принимается JWT, но только в пределах своих областей
+	auth.SetPersonalTokenStore(auth.NewSQLPersonalTokenStore(db))
+	e.Use(auth.RequirePersonalTokenScopes(map[string]string{
+		"POST /beatstreet/api/users/follow/:id":     "social:write",
+		"DELETE /beatstreet/api/users/unfollow/:id": "social:write",
+		"POST /beatstreet/api/users/block/:id":      "social:write",
+		"DELETE /beatstreet/api/users/block/:id":    "social:write",
+		"POST /beatstreet/api/users/mute/:id":       "social:write",
+		"DELETE /beatstreet/api/users/mute/:id":     "social:write",
+	}))
+
+	minioStorage, err := storage.NewMinioStorage()
+	if err != nil {
+		log.Fatalf("Ошибка подключения к MinIO: %v", err)
+	}
+
+	// Письма уходят через очередь email_outbox; MAIL_TRANSPORT=capture складывает их в файлы
+	transport, err := mailer.NewTransport(mailer.LoadConfig())
+	if err != nil {
+		log.Fatalf("Ошибка настройки почты: %v", err)
+	}
+	outbox := mailer.NewOutbox(db, mailer.NewRenderer())
+	go mailer.NewWorker(db, transport, 10*time.Second).Run(context.Background())
+
+	// События от обоих сервисов приходят через LISTEN/NOTIFY и раздаются клиентам по SSE
+	hub, err := realtime.NewHub(database.DSN())
+	if err != nil {
+		log.Fatalf("Ошибка подписки на события: %v", err)
+	}
+	go hub.Run(context.Background())
+
 	repo := user.NewRepository(db)
-	service := user.NewService(repo)
+	service := user.NewService(repo, oidc.LoadRegistryFromEnv(), outbox, minioStorage, notify.NewNotifier(db), hub)
+	go service.RunPrivacyJobs(context.Background(), time.Minute)
 	handler := user.NewHandler(service)
 
 	e.POST("/beatstreet/api/users/signup", handler.Register)
@@ -47,6 +105,50 @@ func main() {
 	e.GET("/beatstreet/api/music/homepage", handler.GetUserFeed)
 	e.GET("/search", handler.SearchHandler)
 	e.POST("/beatstreet/api/users/reset-password", handler.ResetPassword)
+	e.POST("/beatstreet/api/users/login/2fa", handler.VerifyTwoFactorLogin)
+	e.GET("/beatstreet/api/users/2fa", handler.GetTwoFactorStatus)
+	e.POST("/beatstreet/api/users/2fa/enroll", handler.EnrollTwoFactor)
+	e.POST("/beatstreet/api/users/2fa/confirm", handler.ConfirmTwoFactor)
+	e.POST("/beatstreet/api/users/2fa/disable", handler.DisableTwoFactor)
+	e.POST("/beatstreet/api/users/2fa/recovery-codes", handler.RegenerateRecoveryCodes)
+	e.POST("/beatstreet/api/users/2fa/step-up", handler.StepUpTwoFactor)
+	e.GET("/admin/2fa/policies", handler.GetTwoFactorPolicies)
+	e.PUT("/admin/2fa/policies/:role", handler.SetTwoFactorPolicy)
+	e.GET("/beatstreet/api/users/oidc/providers", handler.GetOIDCProviders)
+	e.GET("/beatstreet/api/users/oidc/:provider/login", handler.StartOIDCLogin)
+	e.GET("/beatstreet/api/users/oidc/:provider/callback", handler.OIDCCallback)
+	e.POST("/beatstreet/api/users/oidc/:provider/link", handler.StartOIDCLink)
+	e.DELETE("/beatstreet/api/users/oidc/:provider", handler.UnlinkOIDCIdentity)
+	e.GET("/beatstreet/api/users/identities", handler.GetIdentities)
+	e.GET("/beatstreet/api/users/login-activity", handler.GetLoginActivity)
+	e.GET("/admin/security-events", handler.GetSecurityEvents)
+	e.PUT("/admin/users/:id/unlock", handler.UnlockUser)
+	e.POST("/beatstreet/api/users/tokens", handler.CreatePersonalToken)
+	e.GET("/beatstreet/api/users/tokens", handler.GetPersonalTokens)
+	e.DELETE("/beatstreet/api/users/tokens/:id", handler.RevokePersonalToken)
+	e.PUT("/beatstreet/api/users/locale", handler.SetLocale)
+	e.PUT("/beatstreet/api/users/email", handler.ChangeEmail)
+	e.POST("/beatstreet/api/users/export", handler.RequestDataExport)
+	e.GET("/beatstreet/api/users/export", handler.GetDataExports)
+	e.GET("/beatstreet/api/users/export/:id/download", handler.DownloadDataExport)
+	e.POST("/beatstreet/api/users/delete-account", handler.DeleteAccount)
+	e.DELETE("/beatstreet/api/users/delete-account", handler.CancelAccountDeletion)
+	e.GET("/beatstreet/api/users/privacy", handler.GetPrivacySettings)
+	e.PUT("/beatstreet/api/users/privacy", handler.UpdatePrivacySettings)
+	e.GET("/beatstreet/api/users/follow-requests", handler.GetFollowRequests)
+	e.PUT("/beatstreet/api/users/follow-requests/:id", handler.AcceptFollowRequest)
+	e.DELETE("/beatstreet/api/users/follow-requests/:id", handler.DeclineFollowRequest)
+	e.POST("/beatstreet/api/users/block/:id", handler.BlockUser)
+	e.DELETE("/beatstreet/api/users/block/:id", handler.UnblockUser)
+	e.GET("/beatstreet/api/users/blocked", handler.GetBlockedUsers)
+	e.POST("/beatstreet/api/users/mute/:id", handler.MuteUser)
+	e.DELETE("/beatstreet/api/users/mute/:id", handler.UnmuteUser)
+	e.GET("/beatstreet/api/users/muted", handler.GetMutedUsers)
+	e.GET("/beatstreet/api/users/notifications", handler.GetNotifications)
+	e.GET("/beatstreet/api/users/notifications/unread-count", handler.GetUnreadNotificationCount)
+	e.PUT("/beatstreet/api/users/notifications/read-all", handler.MarkAllNotificationsRead)
+	e.PUT("/beatstreet/api/users/notifications/:id/read", handler.MarkNotificationRead)
+	e.GET("/beatstreet/api/users/stream", handler.StreamEvents)
 
 	log.Println("Запуск user-service на порту 12000")
 	if err := e.Start(":12000"); err != nil {
@@ -24,6 +24,8 @@ func (g *Gateway) initRoutes() {
 	g.server.Any("/music/*", g.proxyToService("http://localhost:11000"))
 	g.server.Any("/user/*", g.proxyToService("http://localhost:12000"))
 	g.server.Any("/stats/*", g.proxyToService("http://localhost:13000"))
+	// Поток событий (SSE); ReverseProxy сам сбрасывает буфер для text/event-stream
+	g.server.GET("/beatstreet/api/users/stream", g.proxyToService("http://localhost:12000"))
 }
 
 func (g *Gateway) proxyToService(target string) echo.HandlerFunc {
@@ -17,6 +17,8 @@ import (
 
 	"github.com/Bossnicks/music-streaming-service-kurs/pkg/auth"
 	"github.com/Bossnicks/music-streaming-service-kurs/pkg/errorspkg"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/listenbrainz"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/playlistfile"
 	"github.com/Bossnicks/music-streaming-service-kurs/pkg/yandex"
 
 	"github.com/Bossnicks/music-streaming-service-kurs/pkg/storage"
@@ -33,6 +35,19 @@ func NewHandler(service *Service, storage *storage.MinioStorage) *Handler {
 	return &Handler{service: service, storage: storage}
 }
 
+// viewer возвращает id и признак админа из необязательного токена; для гостя id = 0
+func viewer(c echo.Context) (int, bool) {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return 0, false
+	}
+	claims, err := auth.ParseRequestToken(c, strings.TrimPrefix(authHeader, "Bearer "))
+	if err != nil {
+		return 0, false
+	}
+	return claims.UserID, claims.Role == "admin"
+}
+
 func (h *Handler) GetNeuroData(c echo.Context) error {
 	mood := c.Param("mood")
 
@@ -55,7 +70,7 @@ func (h *Handler) AddPlaylist(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -65,6 +80,7 @@ func (h *Handler) AddPlaylist(c echo.Context) error {
 	// Получение данных из формы
 	title := c.FormValue("title")
 	description := c.FormValue("description")
+	visibility := c.FormValue("visibility")
 
 	if title == "" {
 		fmt.Println(err)
@@ -73,10 +89,10 @@ func (h *Handler) AddPlaylist(c echo.Context) error {
 
 	// Загрузка обложки (если есть)
 
-	playlistID, err := h.service.AddPlaylist(title, description, userID)
+	playlistID, err := h.service.AddPlaylist(title, description, visibility, userID)
 	if err != nil {
 		fmt.Println(err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при создании плейлиста"})
+		return playlistEditError(c, err, "Ошибка при создании плейлиста")
 	}
 
 	file, err := c.FormFile("cover")
@@ -87,12 +103,10 @@ func (h *Handler) AddPlaylist(c echo.Context) error {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при загрузке обложки"})
 		}
 		defer src.Close()
-		fileExtension := filepath.Ext(file.Filename)
 
-		err = h.storage.UploadImage("playlist", fmt.Sprintf("%d%s", playlistID, fileExtension), src)
-		if err != nil {
+		if err := h.service.SetPlaylistCover(playlistID, userID, src); err != nil {
 			fmt.Println(err)
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка загрузки m3u8"})
+			return playlistEditError(c, err, "Ошибка при загрузке обложки")
 		}
 	}
 
@@ -111,7 +125,7 @@ func (h *Handler) UpdatePlaylist(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -131,7 +145,7 @@ func (h *Handler) UpdatePlaylist(c echo.Context) error {
 	// Вызов сервиса
 	err = h.service.UpdatePlaylist(playlistID, title, description, claims.UserID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return playlistEditError(c, err, "Ошибка обновления плейлиста")
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Плейлист обновлен"})
@@ -145,7 +159,7 @@ func (h *Handler) DeletePlaylist(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -159,7 +173,7 @@ func (h *Handler) DeletePlaylist(c echo.Context) error {
 	// Вызов сервиса
 	err = h.service.DeletePlaylist(playlistID, claims.UserID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return playlistEditError(c, err, "Ошибка удаления плейлиста")
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Плейлист удален"})
@@ -283,7 +297,7 @@ func (h *Handler) GetUserPlaylists(c echo.Context) error {
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Вы не вошли в аккаунт"})
 	}
@@ -307,7 +321,7 @@ func (h *Handler) UploadTrack(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -450,7 +464,7 @@ func (h *Handler) UpdateTrack(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -492,11 +506,15 @@ func (h *Handler) DeleteTrack(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
 
+	if err := h.service.RequireStepUp(claims.UserID, c.Request().Header.Get("X-Step-Up-Token")); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
 	id, _ := strconv.Atoi(c.Param("id"))
 	fmt.Println("del song", id, claims.UserID)
 	err = h.service.DeleteTrack(id, claims.UserID)
@@ -514,7 +532,7 @@ func (h *Handler) GetTopListenedTracks(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -534,7 +552,7 @@ func (h *Handler) GetTopListenedUsers(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -557,7 +575,7 @@ func (h *Handler) GetMyWave(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -602,7 +620,7 @@ func (h *Handler) GetRecentTracks(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -615,6 +633,25 @@ func (h *Handler) GetRecentTracks(c echo.Context) error {
 	return c.JSON(http.StatusOK, tracks)
 }
 
+// GetUserRecentTracks - недавно прослушанное в профиле пользователя с учетом приватности истории
+func (h *Handler) GetUserRecentTracks(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	viewerID, isAdmin := viewer(c)
+	tracks, err := h.service.GetUserRecentTracks(userID, viewerID, isAdmin)
+	if errors.Is(err, errorspkg.ErrPrivateContent) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка получения треков"})
+	}
+
+	return c.JSON(http.StatusOK, tracks)
+}
+
 func (h *Handler) GetRecommendationByAI(c echo.Context) error {
 	authHeader := c.Request().Header.Get("Authorization")
 	if authHeader == "" {
@@ -622,7 +659,7 @@ func (h *Handler) GetRecommendationByAI(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	_, err := auth.ParseJWT(tokenString)
+	_, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -647,7 +684,7 @@ func (h *Handler) AddLike(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -672,7 +709,7 @@ func (h *Handler) AddSongToPlaylist(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	_, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Недостаточно прав"})
 	}
@@ -687,12 +724,30 @@ func (h *Handler) AddSongToPlaylist(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
 	}
 
-	_, err = h.service.AddSongToPlaylist(playlistId, trackID)
+	revision, err := h.service.AddSongToPlaylist(playlistId, trackID, claims.UserID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка добавления в плейлист"})
+		return playlistEditError(c, err, "Ошибка добавления в плейлист")
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{"message": "Успешно добавлено"})
+	return c.JSON(http.StatusOK, map[string]interface{}{"message": "Успешно добавлено", "revision": revision})
+}
+
+// playlistEditError переводит ошибки правки плейлиста в HTTP-ответ; fallback - текст для 500
+func playlistEditError(c echo.Context, err error, fallback string) error {
+	switch {
+	case errors.Is(err, errorspkg.ErrInvalidPlaylistEdit):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, errorspkg.ErrPlaylistNotFound), errors.Is(err, errorspkg.ErrTrackNotFound), errors.Is(err, errorspkg.ErrTrackNotInPlaylist),
+		errors.Is(err, errorspkg.ErrPlaylistMemberNotFound), errors.Is(err, errorspkg.ErrInvalidInvite),
+		errors.Is(err, errorspkg.ErrPlaylistRevisionNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, errorspkg.ErrUserBlocked):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case errors.Is(err, errorspkg.ErrPlaylistRevisionConflict), errors.Is(err, errorspkg.ErrTrackAlreadyInPlaylist),
+		errors.Is(err, errorspkg.ErrSmartPlaylistReadOnly):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": fallback})
 }
 
 func (h *Handler) RemoveLike(c echo.Context) error {
@@ -702,7 +757,7 @@ func (h *Handler) RemoveLike(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -741,7 +796,7 @@ func (h *Handler) IsTrackLiked(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -766,7 +821,7 @@ func (h *Handler) AddRepost(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -791,7 +846,7 @@ func (h *Handler) RemoveRepost(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -830,7 +885,7 @@ func (h *Handler) IsTrackReposted(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -856,7 +911,7 @@ func (h *Handler) GetFavorites(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен неверный"})
@@ -877,7 +932,7 @@ func (h *Handler) GetComments(c echo.Context) error {
 
 	if authHeader != "" {
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := auth.ParseJWT(tokenString)
+		claims, err := auth.ParseRequestToken(c, tokenString)
 		if err == nil && claims.Role == "admin" {
 			isAdmin = true
 		}
@@ -909,7 +964,7 @@ func (h *Handler) AddComment(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -929,6 +984,9 @@ func (h *Handler) AddComment(c echo.Context) error {
 	if errors.Is(err, errorspkg.ErrCommentBanned) {
 		return c.JSON(http.StatusForbidden, map[string]string{"error": "Администратор запретил вам оставлять комментарии"})
 	}
+	if errors.Is(err, errorspkg.ErrUserBlocked) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Автор ограничил вам возможность комментировать"})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сохранения комментария"})
 	}
@@ -972,7 +1030,7 @@ func (h *Handler) AddTrackListen(c echo.Context) error {
 		listenerID = nil
 	} else {
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := auth.ParseJWT(tokenString)
+		claims, err := auth.ParseRequestToken(c, tokenString)
 		if err != nil {
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 		}
@@ -1061,7 +1119,8 @@ func (h *Handler) GetUserByID(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
 	}
 
-	user, err := h.service.GetUser(id)
+	viewerID, isAdmin := viewer(c)
+	user, err := h.service.GetArtistProfile(id, viewerID, isAdmin)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get user"})
 	}
@@ -1084,7 +1143,11 @@ func (h *Handler) GetArtistTracks(c echo.Context) error {
 		page = 1
 	}
 
-	songs, err := h.service.GetArtistTracks(artistID, page)
+	viewerID, isAdmin := viewer(c)
+	songs, err := h.service.GetArtistTracks(artistID, page, viewerID, isAdmin)
+	if errors.Is(err, errorspkg.ErrPrivateContent) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch songs"})
 	}
@@ -1101,7 +1164,7 @@ func (h *Handler) HideComment(c echo.Context) error {
 
 	if authHeader != "" {
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := auth.ParseJWT(tokenString)
+		claims, err := auth.ParseRequestToken(c, tokenString)
 		if err != nil || claims.Role != "admin" {
 			return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
 		}
@@ -1124,7 +1187,7 @@ func (h *Handler) UnhideComment(c echo.Context) error {
 
 	if authHeader != "" {
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := auth.ParseJWT(tokenString)
+		claims, err := auth.ParseRequestToken(c, tokenString)
 		if err != nil || claims.Role != "admin" {
 			return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
 		}
@@ -1143,22 +1206,16 @@ func (h *Handler) UnhideComment(c echo.Context) error {
 }
 
 func (h *Handler) GetPlaylist(c echo.Context) error {
-	authHeader := c.Request().Header.Get("Authorization")
-	isAdmin := false
-
-	if authHeader != "" {
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := auth.ParseJWT(tokenString)
-		if err == nil && claims.Role == "admin" {
-			isAdmin = true
-		}
-	}
+	viewerID, isAdmin := viewer(c)
 	playlistID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid playlist ID"})
 	}
 
-	playlist, err := h.service.GetPlaylistByID(playlistID, isAdmin)
+	playlist, err := h.service.GetPlaylistByID(playlistID, viewerID, isAdmin)
+	if errors.Is(err, errorspkg.ErrPrivateContent) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch playlist"})
 	}
@@ -1171,7 +1228,7 @@ func (h *Handler) HideTrack(c echo.Context) error {
 
 	if authHeader != "" {
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := auth.ParseJWT(tokenString)
+		claims, err := auth.ParseRequestToken(c, tokenString)
 		if err != nil || claims.Role != "admin" {
 			return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
 		}
@@ -1194,7 +1251,7 @@ func (h *Handler) UnhideTrack(c echo.Context) error {
 
 	if authHeader != "" {
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := auth.ParseJWT(tokenString)
+		claims, err := auth.ParseRequestToken(c, tokenString)
 		if err != nil || claims.Role != "admin" {
 			return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
 		}
@@ -1251,7 +1308,7 @@ func (h *Handler) GetTrackStatisticsGlobal(c echo.Context) error {
 		return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
 	}
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil || claims.Role != "admin" {
 		return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
 
@@ -1293,7 +1350,7 @@ func (h *Handler) CreateAlbum(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -1308,6 +1365,7 @@ func (h *Handler) CreateAlbum(c echo.Context) error {
 		ReleaseDate  time.Time `json:"release_date"`
 		TrackIDs     []int     `json:"track_ids"`
 		Is_Announced bool      `json:"is_announced"`
+		AlbumMetadata
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -1321,10 +1379,12 @@ func (h *Handler) CreateAlbum(c echo.Context) error {
 		claims.UserID,
 		req.TrackIDs,
 		req.Is_Announced,
+		req.AlbumMetadata,
 	)
 
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Не получилось создать альбом"})
+		fmt.Println(err)
+		return albumEditError(c, err, "Не получилось создать альбом")
 	}
 
 	return c.JSON(http.StatusCreated, map[string]interface{}{
@@ -1349,7 +1409,7 @@ func (h *Handler) DeleteAlbum(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -1358,8 +1418,12 @@ func (h *Handler) DeleteAlbum(c echo.Context) error {
 		return c.JSON(http.StatusForbidden, map[string]string{"error": "Неверная роль"})
 	}
 
+	if err := h.service.RequireStepUp(claims.UserID, c.Request().Header.Get("X-Step-Up-Token")); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
 	if err := h.service.DeleteAlbum(albumID, claims.UserID); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return albumEditError(c, err, "Ошибка удаления альбома")
 	}
 	return c.JSON(http.StatusOK, map[string]string{"message": "Альбом удален"})
 }
@@ -1414,7 +1478,7 @@ func (h *Handler) GetAvailableTracks(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -1434,7 +1498,7 @@ func (h *Handler) GetAlbums(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -1453,7 +1517,8 @@ func (h *Handler) GetAlbum(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID альбома"})
 	}
 
-	album, err := h.service.GetAlbumDetails(albumID)
+	viewerID, _ := viewer(c)
+	album, err := h.service.GetAlbumDetails(albumID, viewerID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -1461,79 +1526,1689 @@ func (h *Handler) GetAlbum(c echo.Context) error {
 	return c.JSON(http.StatusOK, album)
 }
 
+// SaveAlbum добавляет альбом в библиотеку. Для анонсированного альбома это пре-сейв:
+// он попадет в библиотеку в день выхода
+func (h *Handler) SaveAlbum(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
 
-func (h *Handler) GetAudienceRetention(c echo.Context) error {
-    trackID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid track ID"})
-    }
-    
-    period := c.QueryParam("period")
-    if period == "" {
-        period = "6m" // default
-    }
-    
-    data, err := h.service.GetAudienceRetention(trackID, period)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get audience retention: " + err.Error()})
-    }
-    
-    return c.JSON(http.StatusOK, data)
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID альбома"})
+	}
+
+	presaved, err := h.service.SaveAlbum(albumID, claims.UserID)
+	if errors.Is(err, errorspkg.ErrAlbumNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Альбом не найден"})
+	}
+	if err != nil {
+		fmt.Println(err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сохранения альбома"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{"saved": !presaved, "presaved": presaved})
 }
 
-func (h *Handler) GetPlayIntensity(c echo.Context) error {
-    trackID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid track ID"})
-    }
-    
-    period := c.QueryParam("period")
-    if period == "" {
-        period = "6m"
-    }
-    
-    data, err := h.service.GetPlayIntensity(trackID, period)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get play intensity: " + err.Error()})
-    }
-    
-    return c.JSON(http.StatusOK, data)
+func (h *Handler) UnsaveAlbum(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID альбома"})
+	}
+
+	if err := h.service.UnsaveAlbum(albumID, claims.UserID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка удаления альбома из библиотеки"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{"saved": false, "presaved": false})
 }
 
-func (h *Handler) GetTimeOfDay(c echo.Context) error {
-    trackID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid track ID"})
-    }
-    
-    period := c.QueryParam("period")
-    if period == "" {
-        period = "6m"
-    }
-    
-    data, err := h.service.GetTimeOfDay(trackID, period)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get time of day data: " + err.Error()})
-    }
-    
-    return c.JSON(http.StatusOK, data)
+// GetLibraryAlbums - сохраненные альбомы и пре-сейвы пользователя
+func (h *Handler) GetLibraryAlbums(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	albums, err := h.service.GetLibraryAlbums(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка получения библиотеки"})
+	}
+
+	return c.JSON(http.StatusOK, albums)
 }
 
-func (h *Handler) GetGeography(c echo.Context) error {
-    trackID, err := strconv.Atoi(c.Param("id"))
-    if err != nil {
-        return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid track ID"})
-    }
-    
-    period := c.QueryParam("period")
-    if period == "" {
-        period = "6m"
-    }
-    
-    data, err := h.service.GetGeography(trackID, period)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get geography data: " + err.Error()})
-    }
-    
-    return c.JSON(http.StatusOK, data)
-}
\ No newline at end of file
+// albumEditError переводит ошибки правки альбома в коды ответа
+func albumEditError(c echo.Context, err error, fallback string) error {
+	switch {
+	case errors.Is(err, errorspkg.ErrInvalidAlbumEdit), errors.Is(err, errorspkg.ErrTracksUnavailable):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, errorspkg.ErrAlbumNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, errorspkg.ErrReleaseCodeTaken):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": fallback})
+}
+
+// ExportAlbumRelease отдает DDEX-подобное JSON-описание релиза для дистрибьютора
+func (h *Handler) ExportAlbumRelease(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "artist" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Неверная роль"})
+	}
+
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID альбома"})
+	}
+
+	msg, err := h.service.ExportAlbumRelease(albumID, claims.UserID)
+	if err != nil {
+		fmt.Println(err)
+		return albumEditError(c, err, "Ошибка выгрузки релиза")
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="release-%d.json"`, albumID))
+	return c.JSON(http.StatusOK, msg)
+}
+
+// UpdateAlbum меняет название, описание, дату выхода и анонс; пропущенные поля не меняются
+func (h *Handler) UpdateAlbum(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "artist" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Неверная роль"})
+	}
+
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID альбома"})
+	}
+
+	var req UpdateAlbumRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	if err := h.service.UpdateAlbum(albumID, claims.UserID, req); err != nil {
+		fmt.Println(err)
+		return albumEditError(c, err, "Ошибка при обновлении альбома")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Альбом обновлен"})
+}
+
+func (h *Handler) UploadAlbumCover(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "artist" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Неверная роль"})
+	}
+
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID альбома"})
+	}
+
+	file, err := c.FormFile("cover")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Файл обложки обязателен"})
+	}
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка открытия файла"})
+	}
+	defer src.Close()
+
+	if err := h.service.SetAlbumCover(albumID, claims.UserID, src); err != nil {
+		fmt.Println(err)
+		return albumEditError(c, err, "Ошибка при загрузке обложки")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Обложка обновлена"})
+}
+
+// AddAlbumTrack добавляет трек в выпущенный альбом: {"track_id", "disc_number", "track_number"}
+func (h *Handler) AddAlbumTrack(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "artist" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Неверная роль"})
+	}
+
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID альбома"})
+	}
+
+	var req AlbumTrackRef
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	if err := h.service.AddAlbumTrack(albumID, claims.UserID, req); err != nil {
+		fmt.Println(err)
+		return albumEditError(c, err, "Ошибка при добавлении трека")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Трек добавлен в альбом"})
+}
+
+func (h *Handler) RemoveAlbumTrack(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "artist" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Неверная роль"})
+	}
+
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID альбома"})
+	}
+
+	trackID, err := strconv.Atoi(c.Param("trackID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID трека"})
+	}
+
+	if err := h.service.RemoveAlbumTrack(albumID, claims.UserID, trackID); err != nil {
+		fmt.Println(err)
+		return albumEditError(c, err, "Ошибка при удалении трека")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Трек удален из альбома"})
+}
+
+// ReplaceAlbumTracks задает трек-лист целиком, в том числе порядок и разбиение по дискам
+func (h *Handler) ReplaceAlbumTracks(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "artist" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Неверная роль"})
+	}
+
+	albumID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID альбома"})
+	}
+
+	var req ReplaceAlbumTracksRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	if err := h.service.ReplaceAlbumTracks(albumID, claims.UserID, req.Tracks); err != nil {
+		fmt.Println(err)
+		return albumEditError(c, err, "Ошибка при изменении трек-листа")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Трек-лист обновлен"})
+}
+
+
+func (h *Handler) GetAudienceRetention(c echo.Context) error {
+    trackID, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid track ID"})
+    }
+    
+    period := c.QueryParam("period")
+    if period == "" {
+        period = "6m" // default
+    }
+    
+    data, err := h.service.GetAudienceRetention(trackID, period)
+    if err != nil {
+        return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get audience retention: " + err.Error()})
+    }
+    
+    return c.JSON(http.StatusOK, data)
+}
+
+func (h *Handler) GetPlayIntensity(c echo.Context) error {
+    trackID, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid track ID"})
+    }
+    
+    period := c.QueryParam("period")
+    if period == "" {
+        period = "6m"
+    }
+    
+    data, err := h.service.GetPlayIntensity(trackID, period)
+    if err != nil {
+        return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get play intensity: " + err.Error()})
+    }
+    
+    return c.JSON(http.StatusOK, data)
+}
+
+func (h *Handler) GetTimeOfDay(c echo.Context) error {
+    trackID, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid track ID"})
+    }
+    
+    period := c.QueryParam("period")
+    if period == "" {
+        period = "6m"
+    }
+    
+    data, err := h.service.GetTimeOfDay(trackID, period)
+    if err != nil {
+        return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get time of day data: " + err.Error()})
+    }
+    
+    return c.JSON(http.StatusOK, data)
+}
+
+func (h *Handler) GetGeography(c echo.Context) error {
+    trackID, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid track ID"})
+    }
+    
+    period := c.QueryParam("period")
+    if period == "" {
+        period = "6m"
+    }
+    
+    data, err := h.service.GetGeography(trackID, period)
+    if err != nil {
+        return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get geography data: " + err.Error()})
+    }
+    
+    return c.JSON(http.StatusOK, data)
+}
+// Профиль артиста
+func (h *Handler) UpdateArtistProfile(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "artist" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Неверная роль"})
+	}
+
+	var req UpdateArtistProfileRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	if err := h.service.UpdateArtistProfile(claims.UserID, req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Профиль обновлен"})
+}
+
+func (h *Handler) UploadArtistBanner(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "artist" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Неверная роль"})
+	}
+
+	file, err := c.FormFile("banner")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Файл баннера обязателен"})
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка открытия файла"})
+	}
+	defer src.Close()
+
+	if err := h.service.SetArtistBanner(claims.UserID, src); err != nil {
+		if errors.Is(err, errorspkg.ErrInvalidBanner) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка загрузки баннера"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"banner_url": fmt.Sprintf("/images/%d/banner", claims.UserID)})
+}
+
+// Верификация артистов
+func (h *Handler) RequestVerification(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "artist" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Неверная роль"})
+	}
+
+	var req CreateVerificationRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	id, err := h.service.RequestVerification(claims.UserID, req)
+	if errors.Is(err, errorspkg.ErrVerificationPending) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]int{"id": id})
+}
+
+func (h *Handler) GetVerificationStatus(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	req, err := h.service.GetVerificationStatus(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сервера"})
+	}
+	if req == nil {
+		return c.JSON(http.StatusOK, map[string]string{"status": "none"})
+	}
+
+	return c.JSON(http.StatusOK, req)
+}
+
+func (h *Handler) GetVerificationRequests(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "admin" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
+	}
+
+	requests, err := h.service.GetVerificationRequests(c.QueryParam("status"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сервера"})
+	}
+
+	return c.JSON(http.StatusOK, requests)
+}
+
+func (h *Handler) ReviewVerificationRequest(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "admin" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
+	}
+
+	requestID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID заявки"})
+	}
+
+	var req ReviewVerificationRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	found, err := h.service.ReviewVerificationRequest(requestID, claims.UserID, req)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сервера"})
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Заявка не найдена или уже рассмотрена"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Заявка рассмотрена"})
+}
+
+func (h *Handler) RevokeVerification(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "admin" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if err := h.service.RevokeVerification(userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сервера"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Верификация снята"})
+}
+
+// Участники трека
+func (h *Handler) UpdateTrackCredits(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	trackID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid track ID"})
+	}
+
+	var req UpdateCreditsRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	if err := h.service.SetTrackCredits(trackID, claims.UserID, req.Credits); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Участники трека обновлены"})
+}
+
+// UpdateTrackMetadata задает ISRC, пометку explicit и язык трека
+func (h *Handler) UpdateTrackMetadata(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	trackID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid track ID"})
+	}
+
+	var req UpdateTrackMetadataRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	if err := h.service.SetTrackMetadata(trackID, claims.UserID, req); err != nil {
+		switch {
+		case errors.Is(err, errorspkg.ErrInvalidTrackEdit):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case errors.Is(err, errorspkg.ErrTrackNotFound):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Трек не найден или у вас нет прав"})
+		case errors.Is(err, errorspkg.ErrReleaseCodeTaken):
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Не удалось обновить метаданные трека"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Метаданные трека обновлены"})
+}
+
+// Итоги прослушиваний
+func (h *Handler) GetListeningReports(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	reports, err := h.service.GetListeningReports(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сервера"})
+	}
+
+	return c.JSON(http.StatusOK, reports)
+}
+
+func (h *Handler) GetListeningReport(c echo.Context) error {
+	report, err := h.findListeningReport(c)
+	if report == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+func (h *Handler) GetListeningReportCard(c echo.Context) error {
+	report, err := h.findListeningReport(c)
+	if report == nil {
+		return err
+	}
+
+	card, err := h.service.RenderReportCard(report)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка создания карточки"})
+	}
+
+	// Доступ к карточке зависит от приватности истории, поэтому общие кеши ее хранить не должны
+	c.Response().Header().Set("Cache-Control", "private, no-store")
+	return c.Blob(http.StatusOK, "image/svg+xml", card)
+}
+
+// findListeningReport при отсутствии итогов сам пишет ответ и возвращает nil
+func (h *Handler) findListeningReport(c echo.Context) (*ListeningReport, error) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	viewerID, isAdmin := viewer(c)
+	report, err := h.service.GetListeningReport(userID, c.Param("period"), c.Param("key"), viewerID, isAdmin)
+	if errors.Is(err, errorspkg.ErrPrivateContent) {
+		return nil, c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if report == nil {
+		return nil, c.JSON(http.StatusNotFound, map[string]string{"error": "Итоги за этот период еще не готовы"})
+	}
+	return report, nil
+}
+
+// Скробблинг
+func (h *Handler) ConnectScrobbler(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	var req ConnectScrobblerRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	conn, err := h.service.ConnectScrobbler(claims.UserID, req.Token)
+	if errors.Is(err, listenbrainz.ErrInvalidToken) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Не удалось проверить токен: " + err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, conn)
+}
+
+func (h *Handler) DisconnectScrobbler(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if err := h.service.DisconnectScrobbler(claims.UserID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сервера"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Скробблинг отключен"})
+}
+
+func (h *Handler) GetScrobbleConnection(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	conn, err := h.service.GetScrobbleConnection(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сервера"})
+	}
+	if conn == nil {
+		return c.JSON(http.StatusOK, map[string]bool{"connected": false})
+	}
+
+	return c.JSON(http.StatusOK, conn)
+}
+
+// ExportScrobbles отдает историю файлом, который принимает импорт ListenBrainz; from и to - даты ГГГГ-ММ-ДД
+func (h *Handler) ExportScrobbles(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	from := time.Unix(0, 0)
+	to := time.Now().Add(time.Minute)
+	if v := c.QueryParam("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректная дата from"})
+		}
+	}
+	if v := c.QueryParam("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректная дата to"})
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	listens, err := h.service.ExportScrobbles(claims.UserID, from, to)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка выгрузки прослушиваний"})
+	}
+
+	c.Response().Header().Set("Content-Disposition", `attachment; filename="listenbrainz-listens.json"`)
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	c.Response().WriteHeader(http.StatusOK)
+	return listenbrainz.WriteListens(c.Response(), listens)
+}
+
+// Импорт истории прослушиваний
+const maxImportFileSize = 100 << 20
+
+func (h *Handler) ImportListeningHistory(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Файл истории обязателен"})
+	}
+	if file.Size > maxImportFileSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "Файл слишком большой"})
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка открытия файла"})
+	}
+	defer src.Close()
+
+	imp, err := h.service.ImportListeningHistory(claims.UserID, c.FormValue("source"), filepath.Base(file.Filename), src)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusAccepted, imp)
+}
+
+func (h *Handler) GetListeningImports(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	imports, err := h.service.GetListeningImports(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сервера"})
+	}
+
+	return c.JSON(http.StatusOK, imports)
+}
+
+func (h *Handler) GetListeningImport(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	importID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID импорта"})
+	}
+
+	imp, err := h.service.GetListeningImport(importID, claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сервера"})
+	}
+	if imp == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Импорт не найден"})
+	}
+
+	return c.JSON(http.StatusOK, imp)
+}
+
+func (h *Handler) DeleteListeningImport(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	importID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID импорта"})
+	}
+
+	deleted, err := h.service.DeleteListeningImport(importID, claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сервера"})
+	}
+	if !deleted {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Импорт не найден или еще выполняется"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Импорт и его прослушивания удалены"})
+}
+
+func (h *Handler) InsertPlaylistTrack(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	var req PlaylistTrackRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	revision, err := h.service.InsertPlaylistTrack(playlistID, claims.UserID, req)
+	if err != nil {
+		return playlistEditError(c, err, "Ошибка добавления в плейлист")
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{"revision": revision})
+}
+
+func (h *Handler) RemovePlaylistTrack(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+	trackID, err := strconv.Atoi(c.Param("trackID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID трека"})
+	}
+
+	// У DELETE нет тела, ревизия передается параметром
+	var revision *int
+	if c.QueryParam("revision") != "" {
+		rev, err := strconv.Atoi(c.QueryParam("revision"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректная ревизия"})
+		}
+		revision = &rev
+	}
+
+	newRevision, err := h.service.RemovePlaylistTrack(playlistID, trackID, claims.UserID, revision)
+	if err != nil {
+		return playlistEditError(c, err, "Ошибка удаления из плейлиста")
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{"revision": newRevision})
+}
+
+func (h *Handler) MovePlaylistTrack(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+	trackID, err := strconv.Atoi(c.Param("trackID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID трека"})
+	}
+
+	var req PlaylistTrackRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+	revision, err := h.service.MovePlaylistTrack(playlistID, trackID, claims.UserID, req)
+	if err != nil {
+		return playlistEditError(c, err, "Ошибка перемещения трека")
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{"revision": revision})
+}
+
+func (h *Handler) ReplacePlaylistTracks(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	var req ReplacePlaylistTracksRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	revision, err := h.service.ReplacePlaylistTracks(playlistID, claims.UserID, req)
+	if err != nil {
+		return playlistEditError(c, err, "Ошибка сохранения плейлиста")
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{"revision": revision})
+}
+
+func (h *Handler) GetPlaylistMembers(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	members, err := h.service.GetPlaylistMembers(playlistID, claims.UserID)
+	if err != nil {
+		return playlistEditError(c, err, "Ошибка получения участников")
+	}
+
+	return c.JSON(http.StatusOK, members)
+}
+
+func (h *Handler) UpdatePlaylistMember(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+	memberID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	var req UpdatePlaylistMemberRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	if err := h.service.UpdatePlaylistMember(playlistID, claims.UserID, memberID, req.Role); err != nil {
+		return playlistEditError(c, err, "Ошибка изменения роли")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Роль изменена"})
+}
+
+func (h *Handler) RemovePlaylistMember(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+	memberID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if err := h.service.RemovePlaylistMember(playlistID, claims.UserID, memberID); err != nil {
+		return playlistEditError(c, err, "Ошибка удаления участника")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Участник удален"})
+}
+
+func (h *Handler) CreatePlaylistInvite(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	var req CreatePlaylistInviteRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	invite, err := h.service.CreatePlaylistInvite(playlistID, claims.UserID, req)
+	if err != nil {
+		return playlistEditError(c, err, "Ошибка создания приглашения")
+	}
+
+	return c.JSON(http.StatusCreated, invite)
+}
+
+func (h *Handler) GetPlaylistInvites(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	invites, err := h.service.GetPlaylistInvites(playlistID, claims.UserID)
+	if err != nil {
+		return playlistEditError(c, err, "Ошибка получения приглашений")
+	}
+
+	return c.JSON(http.StatusOK, invites)
+}
+
+func (h *Handler) RevokePlaylistInvite(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+	inviteID, err := strconv.Atoi(c.Param("inviteID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID приглашения"})
+	}
+
+	if err := h.service.RevokePlaylistInvite(playlistID, claims.UserID, inviteID); err != nil {
+		return playlistEditError(c, err, "Ошибка отзыва приглашения")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Приглашение отозвано"})
+}
+
+func (h *Handler) AcceptPlaylistInvite(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, role, err := h.service.AcceptPlaylistInvite(c.Param("token"), claims.UserID)
+	if err != nil {
+		return playlistEditError(c, err, "Ошибка принятия приглашения")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"playlist_id": playlistID, "role": role})
+}
+
+func (h *Handler) UpdatePlaylistVisibility(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	var req UpdatePlaylistVisibilityRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	if err := h.service.SetPlaylistVisibility(playlistID, claims.UserID, req.Visibility); err != nil {
+		return playlistEditError(c, err, "Ошибка изменения видимости")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"visibility": req.Visibility})
+}
+
+// CreateSmartPlaylist создает плейлист, состав которого задается правилами
+func (h *Handler) CreateSmartPlaylist(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	var req SmartPlaylistRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+	if req.Title == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Название плейлиста обязательно"})
+	}
+
+	playlistID, err := h.service.CreateSmartPlaylist(claims.UserID, req)
+	if err != nil {
+		fmt.Println(err)
+		return playlistEditError(c, err, "Ошибка при создании плейлиста")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":     "Плейлист создан",
+		"playlist_id": playlistID,
+	})
+}
+
+func (h *Handler) UpdateSmartPlaylistRules(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	var req SmartPlaylistRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	if err := h.service.UpdateSmartRules(playlistID, claims.UserID, req); err != nil {
+		fmt.Println(err)
+		return playlistEditError(c, err, "Ошибка изменения правил")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Правила обновлены"})
+}
+
+func (h *Handler) RefreshSmartPlaylist(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	if err := h.service.RefreshSmartPlaylist(playlistID, claims.UserID); err != nil {
+		fmt.Println(err)
+		return playlistEditError(c, err, "Ошибка пересчета плейлиста")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Плейлист пересчитан"})
+}
+
+// UploadPlaylistCover заменяет мозаику своей обложкой; доступно владельцам
+func (h *Handler) UploadPlaylistCover(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	file, err := c.FormFile("cover")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Файл обложки обязателен"})
+	}
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка открытия файла"})
+	}
+	defer src.Close()
+
+	if err := h.service.SetPlaylistCover(playlistID, claims.UserID, src); err != nil {
+		fmt.Println(err)
+		return playlistEditError(c, err, "Ошибка при загрузке обложки")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Обложка обновлена"})
+}
+
+// ResetPlaylistCover удаляет свою обложку, после чего снова собирается мозаика
+func (h *Handler) ResetPlaylistCover(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	if err := h.service.ResetPlaylistCover(playlistID, claims.UserID); err != nil {
+		fmt.Println(err)
+		return playlistEditError(c, err, "Ошибка удаления обложки")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Обложка удалена"})
+}
+
+func (h *Handler) GetPlaylistRevisions(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+	before, _ := strconv.Atoi(c.QueryParam("before"))
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+
+	revisions, err := h.service.GetPlaylistRevisions(playlistID, claims.UserID, before, limit)
+	if err != nil {
+		return playlistEditError(c, err, "Ошибка получения истории плейлиста")
+	}
+
+	return c.JSON(http.StatusOK, revisions)
+}
+
+// DiffPlaylistRevisions сравнивает ревизии ?from= и ?to=
+func (h *Handler) DiffPlaylistRevisions(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+	from, errFrom := strconv.Atoi(c.QueryParam("from"))
+	to, errTo := strconv.Atoi(c.QueryParam("to"))
+	if errFrom != nil || errTo != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Укажите ревизии from и to"})
+	}
+
+	diff, err := h.service.DiffPlaylistRevisions(playlistID, claims.UserID, from, to)
+	if err != nil {
+		return playlistEditError(c, err, "Ошибка сравнения ревизий")
+	}
+
+	return c.JSON(http.StatusOK, diff)
+}
+
+func (h *Handler) RestorePlaylistRevision(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+	target, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректная ревизия"})
+	}
+
+	var req RestorePlaylistRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	revision, missing, err := h.service.RestorePlaylistRevision(playlistID, claims.UserID, target, req.Revision)
+	if err != nil {
+		fmt.Println(err)
+		return playlistEditError(c, err, "Ошибка восстановления плейлиста")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"revision":       revision,
+		"missing_tracks": missing,
+	})
+}
+
+// publicBaseURL - адрес music-service для ссылок в выгружаемых файлах. За шлюзом его нужно задать в MUSIC_PUBLIC_URL
+func publicBaseURL(c echo.Context) string {
+	if base := os.Getenv("MUSIC_PUBLIC_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return c.Scheme() + "://" + c.Request().Host
+}
+
+// ExportPlaylist отдает плейлист файлом: ?format=m3u8|xspf|jspf
+func (h *Handler) ExportPlaylist(c echo.Context) error {
+	viewerID, isAdmin := viewer(c)
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = playlistfile.FormatM3U8
+	}
+	if playlistfile.FormatFromName("playlist."+format) != format {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": playlistfile.ErrUnknownFormat.Error()})
+	}
+
+	playlist, err := h.service.ExportPlaylist(playlistID, viewerID, isAdmin, publicBaseURL(c))
+	if errors.Is(err, errorspkg.ErrPrivateContent) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return playlistEditError(c, err, "Ошибка выгрузки плейлиста")
+	}
+
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="playlist-%d.%s"`, playlistID, format))
+	c.Response().Header().Set(echo.HeaderContentType, playlistfile.ContentType(format))
+	c.Response().WriteHeader(http.StatusOK)
+	return playlistfile.Write(format, c.Response(), *playlist)
+}
+
+const maxPlaylistFileSize = 5 << 20
+
+// ImportPlaylist создает плейлист из файла M3U/M3U8, XSPF или JSPF; формат берется из поля format или расширения
+func (h *Handler) ImportPlaylist(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Файл плейлиста обязателен"})
+	}
+	if file.Size > maxPlaylistFileSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "Файл слишком большой"})
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка открытия файла"})
+	}
+	defer src.Close()
+
+	result, err := h.service.ImportPlaylist(claims.UserID, c.FormValue("format"), filepath.Base(file.Filename), c.FormValue("visibility"), src)
+	if err != nil {
+		fmt.Println(err)
+		return playlistEditError(c, err, "Ошибка импорта плейлиста")
+	}
+
+	return c.JSON(http.StatusCreated, result)
+}
+
+func (h *Handler) FollowPlaylist(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	count, err := h.service.FollowPlaylist(playlistID, claims.UserID)
+	if errors.Is(err, errorspkg.ErrPrivateContent) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return playlistEditError(c, err, "Ошибка подписки на плейлист")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"followed": true, "followers_count": count})
+}
+
+func (h *Handler) UnfollowPlaylist(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректный ID плейлиста"})
+	}
+
+	count, err := h.service.UnfollowPlaylist(playlistID, claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка отписки от плейлиста"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"followed": false, "followers_count": count})
+}
+
+func (h *Handler) GetLibraryPlaylists(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	playlists, err := h.service.GetLibraryPlaylists(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка получения библиотеки"})
+	}
+
+	return c.JSON(http.StatusOK, playlists)
+}
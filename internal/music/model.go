@@ -1,20 +1,63 @@
 package music
 
-import "time"
+import (
+	"time"
+
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/smartplaylist"
+)
 
 type Track struct {
-	ID                   int       `json:"id"`
-	Artist               string    `json:"author_id"`
-	Title                string    `json:"title"`
-	Avatar               string    `json:"avatar"`
-	Description          string    `json:"description"`
-	Duration             int       `json:"duration"`
-	Created_at           time.Time `json:"created_at"`
-	Is_blocked           bool      `json:"is_blocked"`
-	Updated_at           time.Time `json:"updated_at"`
-	Author               User      `json:"author"`
-	Genre                string    `json:"genre"`
-	RecommendationReason string    `json:"recommendation_reason"`
+	ID                   int           `json:"id"`
+	Artist               string        `json:"author_id"`
+	Title                string        `json:"title"`
+	Avatar               string        `json:"avatar"`
+	Description          string        `json:"description"`
+	Duration             int           `json:"duration"`
+	Created_at           time.Time     `json:"created_at"`
+	Is_blocked           bool          `json:"is_blocked"`
+	Updated_at           time.Time     `json:"updated_at"`
+	Author               User          `json:"author"`
+	Genre                string        `json:"genre"`
+	RecommendationReason string        `json:"recommendation_reason"`
+	Credits              []TrackCredit `json:"credits,omitempty"`
+	Position             int           `json:"position,omitempty"`     // позиция в плейлисте
+	AddedBy              *User         `json:"added_by,omitempty"`     // кто добавил трек в плейлист
+	DiscNumber           int           `json:"disc_number,omitempty"`  // номер диска в альбоме
+	TrackNumber          int           `json:"track_number,omitempty"` // номер трека на диске
+	ISRC                 string        `json:"isrc,omitempty"`
+	Explicit             bool          `json:"explicit,omitempty"`
+	Language             string        `json:"language,omitempty"` // язык исполнения, ISO 639
+}
+
+// UpdateTrackMetadataRequest - метаданные трека для дистрибуции. Отсутствующие поля не меняются,
+// пустые isrc и language очищают поле
+type UpdateTrackMetadataRequest struct {
+	ISRC     *string `json:"isrc"`
+	Explicit *bool   `json:"explicit"`
+	Language *string `json:"language"`
+}
+
+// Роли участников трека
+const (
+	CreditPrimary  = "primary"
+	CreditFeatured = "featured"
+	CreditProducer = "producer"
+	CreditComposer = "composer"
+	CreditRemixer  = "remixer"
+)
+
+var CreditRoles = []string{CreditPrimary, CreditFeatured, CreditProducer, CreditComposer, CreditRemixer}
+
+// TrackCredit - участник трека помимо автора; UserID пустой, если указано только имя
+type TrackCredit struct {
+	UserID *int   `json:"user_id,omitempty"`
+	Name   string `json:"name"`
+	Avatar string `json:"avatar,omitempty"`
+	Role   string `json:"role"`
+}
+
+type UpdateCreditsRequest struct {
+	Credits []TrackCredit `json:"credits"`
 }
 
 type GetMyWaveRequest struct {
@@ -45,17 +88,85 @@ type User struct {
 	Username   string `json:"username"`
 	Avatar     string `json:"avatar"`
 	Popularity int    `json:"popularity"`
+	IsPrivate  bool   `json:"is_private,omitempty"`
 }
 
 type Playlist struct {
-	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Avatar      string    `json:"avatar"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Author      User      `json:"author"`
-	Tracks      []Track   `json:"tracks"`
+	ID           int                       `json:"id"`
+	Title        string                    `json:"title"`
+	Description  string                    `json:"description"`
+	Avatar       string                    `json:"avatar"`
+	CreatedAt    time.Time                 `json:"created_at"`
+	UpdatedAt    time.Time                 `json:"updated_at"`
+	Revision     int                       `json:"revision"`
+	Author       User                      `json:"author"`
+	Tracks       []Track                   `json:"tracks"`
+	Role         string                    `json:"role,omitempty"` // роль текущего пользователя
+	Members      []PlaylistMember          `json:"members,omitempty"`
+	Visibility   string                    `json:"visibility,omitempty"`
+	Followers    int                       `json:"followers_count"`
+	IsFollowed   bool                      `json:"is_followed"`
+	SmartRules   *smartplaylist.Definition `json:"smart_rules,omitempty"`
+	SmartRefresh string                    `json:"smart_refresh,omitempty"`
+}
+
+// Режимы пересчета умного плейлиста
+const (
+	SmartRefreshOnRead    = "on_read"
+	SmartRefreshScheduled = "scheduled"
+)
+
+type SmartPlaylistRequest struct {
+	Title       string                   `json:"title"`
+	Description string                   `json:"description"`
+	Visibility  string                   `json:"visibility"`
+	Rules       smartplaylist.Definition `json:"rules"`
+	Refresh     string                   `json:"refresh"`
+}
+
+// Видимость плейлиста
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+type UpdatePlaylistVisibilityRequest struct {
+	Visibility string `json:"visibility"`
+}
+
+// Роли участников плейлиста
+const (
+	PlaylistOwner  = "owner"
+	PlaylistEditor = "editor"
+	PlaylistViewer = "viewer"
+)
+
+type PlaylistMember struct {
+	User      User      `json:"user"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PlaylistInvite - ссылка-приглашение; Token отдается только при создании
+type PlaylistInvite struct {
+	ID        int        `json:"id"`
+	Role      string     `json:"role"`
+	Token     string     `json:"token,omitempty"`
+	MaxUses   *int       `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type CreatePlaylistInviteRequest struct {
+	Role           string `json:"role"`
+	MaxUses        *int   `json:"max_uses"`
+	ExpiresInHours int    `json:"expires_in_hours"` // 0 - бессрочно
+}
+
+type UpdatePlaylistMemberRequest struct {
+	Role string `json:"role"`
 }
 
 type TrackStatistics struct {
@@ -112,6 +223,68 @@ type Album struct {
 	Is_Hidden    bool      `json:"is_hidden"`
 	Release_Date time.Time `json:"release_date"`
 	Is_Announced bool      `json:"is_announced"`
+	Is_Released  bool      `json:"is_released"`
+	AlbumMetadata
+	// Для вошедшего пользователя: альбом в библиотеке или ждет выхода в пре-сейве
+	Is_Saved    bool `json:"is_saved"`
+	Is_Presaved bool `json:"is_presaved"`
+}
+
+// AlbumRelease - альбом, опубликованный фоновой задачей. Presavers - кто получил его в библиотеку по пре-сейву
+type AlbumRelease struct {
+	AlbumID     int
+	AuthorID    int
+	Title       string
+	ReleaseDate time.Time
+	Presavers   []int
+}
+
+// Типы релизов
+const (
+	ReleaseSingle      = "single"
+	ReleaseEP          = "ep"
+	ReleaseAlbum       = "album"
+	ReleaseCompilation = "compilation"
+)
+
+var ReleaseTypes = []string{ReleaseSingle, ReleaseEP, ReleaseAlbum, ReleaseCompilation}
+
+// AlbumMetadata - метаданные релиза для дистрибуции. PLine и CLine хранятся без знаков ℗ и ©
+type AlbumMetadata struct {
+	ReleaseType string `json:"release_type"`
+	Label       string `json:"label"`
+	UPC         string `json:"upc,omitempty"` // UPC-A или EAN-13
+	PLine       string `json:"p_line"`
+	CLine       string `json:"c_line"`
+	Explicit    bool   `json:"explicit"`
+	Language    string `json:"language"`
+}
+
+// UpdateAlbumRequest - частичное изменение альбома: пропущенные поля не меняются, пустой upc очищает код
+type UpdateAlbumRequest struct {
+	Title       *string    `json:"title"`
+	Description *string    `json:"description"`
+	ReleaseDate *time.Time `json:"release_date"`
+	IsAnnounced *bool      `json:"is_announced"`
+	ReleaseType *string    `json:"release_type"`
+	Label       *string    `json:"label"`
+	UPC         *string    `json:"upc"`
+	PLine       *string    `json:"p_line"`
+	CLine       *string    `json:"c_line"`
+	Explicit    *bool      `json:"explicit"`
+	Language    *string    `json:"language"`
+}
+
+// AlbumTrackRef - место трека в альбоме. Нулевой диск означает первый, нулевой номер - конец диска
+type AlbumTrackRef struct {
+	TrackID     int `json:"track_id"`
+	DiscNumber  int `json:"disc_number"`
+	TrackNumber int `json:"track_number"`
+}
+
+// ReplaceAlbumTracksRequest задает весь трек-лист; номера на каждом диске идут в порядке списка
+type ReplaceAlbumTracksRequest struct {
+	Tracks []AlbumTrackRef `json:"tracks"`
 }
 
 type RetentionPoint struct {
@@ -138,3 +311,302 @@ type GeographyData struct {
 	MapData      []CountryData `json:"map_data"`
 	TopCountries []CountryData `json:"top_countries"`
 }
+
+type ArtistLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+type ArtistStats struct {
+	Followers        int `json:"followers"`
+	Tracks           int `json:"tracks"`
+	Albums           int `json:"albums"`
+	MonthlyListeners int `json:"monthly_listeners"`
+}
+
+// ArtistProfile - страница артиста (/artists/:id)
+type ArtistProfile struct {
+	User
+	Role       string       `json:"role"`
+	Bio        string       `json:"bio"`
+	Location   string       `json:"location"`
+	Links      []ArtistLink `json:"links"`
+	Genres     []string     `json:"genres"`
+	BannerURL  string       `json:"banner_url,omitempty"`
+	IsVerified bool         `json:"is_verified"`
+	VerifiedAt *time.Time   `json:"verified_at,omitempty"`
+	Stats      *ArtistStats `json:"stats,omitempty"`
+}
+
+type UpdateArtistProfileRequest struct {
+	Bio      string       `json:"bio"`
+	Location string       `json:"location"`
+	Links    []ArtistLink `json:"links"`
+	Genres   []string     `json:"genres"`
+}
+
+type VerificationRequest struct {
+	ID         int          `json:"id"`
+	UserID     int          `json:"user_id"`
+	Username   string       `json:"username"`
+	Message    string       `json:"message"`
+	Links      []ArtistLink `json:"links"`
+	Status     string       `json:"status"`
+	ReviewNote *string      `json:"review_note,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	ReviewedAt *time.Time   `json:"reviewed_at,omitempty"`
+}
+
+type CreateVerificationRequest struct {
+	Message string       `json:"message"`
+	Links   []ArtistLink `json:"links"`
+}
+
+type ReviewVerificationRequest struct {
+	Approve bool   `json:"approve"`
+	Note    string `json:"note"`
+}
+
+// Итоги прослушиваний
+const (
+	ReportYear  = "year"
+	ReportMonth = "month"
+)
+
+type ReportTrack struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	ArtistID int    `json:"artist_id"`
+	Artist   string `json:"artist"`
+	Listens  int    `json:"listens"`
+	Minutes  int    `json:"minutes"`
+}
+
+type ReportArtist struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Listens  int    `json:"listens"`
+	Minutes  int    `json:"minutes"`
+}
+
+type ReportGenre struct {
+	Genre   string `json:"genre"`
+	Listens int    `json:"listens"`
+}
+
+// ListeningPersonality строится по средним AudioFeatures прослушанных треков
+type ListeningPersonality struct {
+	Type        string  `json:"type"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	TempoBPM    float64 `json:"tempo_bpm"`
+	Energy      float64 `json:"energy"`
+	Brightness  float64 `json:"brightness"`
+}
+
+type ListeningReport struct {
+	UserID         int                   `json:"user_id"`
+	Period         string                `json:"period"`
+	Key            string                `json:"key"`
+	PeriodStart    time.Time             `json:"period_start"`
+	PeriodEnd      time.Time             `json:"period_end"`
+	TotalListens   int                   `json:"total_listens"`
+	TotalMinutes   int                   `json:"total_minutes"`
+	ListeningDays  int                   `json:"listening_days"`
+	LongestStreak  int                   `json:"longest_streak"`
+	MostActiveHour int                   `json:"most_active_hour"`
+	NewArtists     int                   `json:"new_artists"`
+	NewTracks      int                   `json:"new_tracks"`
+	TopTracks      []ReportTrack         `json:"top_tracks"`
+	TopArtists     []ReportArtist        `json:"top_artists"`
+	TopGenres      []ReportGenre         `json:"top_genres"`
+	TopDiscoveries []ReportArtist        `json:"top_discoveries"`
+	Personality    *ListeningPersonality `json:"personality,omitempty"`
+	GeneratedAt    time.Time             `json:"generated_at"`
+}
+
+type ReportSummary struct {
+	Period       string    `json:"period"`
+	Key          string    `json:"key"`
+	PeriodStart  time.Time `json:"period_start"`
+	TotalMinutes int       `json:"total_minutes"`
+	GeneratedAt  time.Time `json:"generated_at"`
+}
+
+// audioProfile - средние характеристики прослушанного звука
+type audioProfile struct {
+	TempoBPM         float64
+	RMSEMean         float64
+	SpectralCentroid float64
+	ZeroCrossingRate float64
+	Genres           int
+}
+
+// Скробблинг
+type ScrobbleConnection struct {
+	Service          string     `json:"service"`
+	ExternalUsername string     `json:"external_username"`
+	Enabled          bool       `json:"enabled"`
+	LastError        string     `json:"last_error,omitempty"`
+	LastSubmittedAt  *time.Time `json:"last_submitted_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	Pending          int        `json:"pending"`
+	Failed           int        `json:"failed"`
+}
+
+type ConnectScrobblerRequest struct {
+	Token string `json:"token"`
+}
+
+// ScrobbleListen - прослушивание для выгрузки; поля совпадают с тем, что нужно формату ListenBrainz
+type ScrobbleListen struct {
+	TrackID    int
+	Title      string
+	Artist     string
+	Album      string
+	Duration   int
+	ListenTime int
+	ListenedAt time.Time
+}
+
+type queuedScrobble struct {
+	ID       int
+	UserID   int
+	Token    string
+	Attempts int
+	Payload  []byte
+}
+
+// Импорт истории прослушиваний
+type ListeningImport struct {
+	ID              int               `json:"id"`
+	Source          string            `json:"source"`
+	FileName        string            `json:"file_name"`
+	Status          string            `json:"status"`
+	Total           int               `json:"total"`
+	Matched         int               `json:"matched"`
+	Imported        int               `json:"imported"`
+	Skipped         int               `json:"skipped"`
+	Unmatched       int               `json:"unmatched"`
+	UnmatchedSample []UnmatchedListen `json:"unmatched_sample,omitempty"`
+	Error           string            `json:"error,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	FinishedAt      *time.Time        `json:"finished_at,omitempty"`
+}
+
+// UnmatchedListen - трек из файла, который не нашелся в каталоге
+type UnmatchedListen struct {
+	Artist string `json:"artist"`
+	Title  string `json:"title"`
+	Plays  int    `json:"plays"`
+}
+
+// ImportedListen - сопоставленное прослушивание, готовое к записи в track_listens
+type ImportedListen struct {
+	TrackID    int
+	ListenTime int
+	PlayedAt   time.Time
+}
+
+// PlaylistTrackRequest - вставка или перемещение трека. Revision необязательна: если передана,
+// изменение применяется только к этой версии плейлиста
+type PlaylistTrackRequest struct {
+	TrackID  int  `json:"track_id"`
+	Position int  `json:"position"`
+	Revision *int `json:"revision"`
+}
+
+type ReplacePlaylistTracksRequest struct {
+	TrackIDs []int `json:"track_ids"`
+	Revision *int  `json:"revision"`
+}
+
+// PlaylistImportResult - итог импорта плейлиста из файла. Unmatched - записи, которых нет в каталоге
+type PlaylistImportResult struct {
+	PlaylistID int                      `json:"playlist_id"`
+	Total      int                      `json:"total"`
+	Matched    int                      `json:"matched"`
+	Duplicates int                      `json:"duplicates"`
+	Unmatched  []UnmatchedPlaylistEntry `json:"unmatched"`
+}
+
+type UnmatchedPlaylistEntry struct {
+	Position int    `json:"position"`
+	Artist   string `json:"artist"`
+	Title    string `json:"title"`
+	Location string `json:"location,omitempty"`
+}
+
+// Виды изменений плейлиста в истории
+const (
+	ChangeCreate      = "create"
+	ChangeImport      = "import"
+	ChangeAdd         = "add"
+	ChangeRemove      = "remove"
+	ChangeMove        = "move"
+	ChangeReplace     = "replace"
+	ChangeRename      = "rename"
+	ChangeDescription = "description"
+	ChangeRestore     = "restore"
+	ChangeRefresh     = "refresh" // пересчет умного плейлиста
+)
+
+// PlaylistChange - одна запись журнала. From и To - позиции трека, Old и New - прежний и новый текст,
+// Revision - ревизия, к которой откатили плейлист
+type PlaylistChange struct {
+	Action   string `json:"action"`
+	TrackID  int    `json:"track_id,omitempty"`
+	From     int    `json:"from,omitempty"`
+	To       int    `json:"to,omitempty"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+	Added    int    `json:"added,omitempty"`
+	Removed  int    `json:"removed,omitempty"`
+	Revision int    `json:"revision,omitempty"`
+}
+
+type PlaylistRevision struct {
+	Revision   int            `json:"revision"`
+	Change     PlaylistChange `json:"change"`
+	User       *User          `json:"user,omitempty"` // nil - системное изменение
+	Title      string         `json:"title"`
+	TrackCount int            `json:"track_count"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+type PlaylistSnapshot struct {
+	Revision    int
+	Title       string
+	Description string
+	TrackIDs    []int
+}
+
+// PlaylistDiff - разница между ревизиями From и To. Moved - треки, сменившие порядок относительно остальных,
+// а не все, у кого сдвинулась позиция
+type PlaylistDiff struct {
+	From        int                 `json:"from"`
+	To          int                 `json:"to"`
+	Title       *TextChange         `json:"title,omitempty"`
+	Description *TextChange         `json:"description,omitempty"`
+	Added       []PlaylistDiffTrack `json:"added"`
+	Removed     []PlaylistDiffTrack `json:"removed"`
+	Moved       []PlaylistDiffTrack `json:"moved"`
+}
+
+type TextChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// PlaylistDiffTrack: From - позиция в ревизии From, To - в ревизии To; Title пуст, если трек удален из каталога
+type PlaylistDiffTrack struct {
+	TrackID int    `json:"track_id"`
+	Title   string `json:"title,omitempty"`
+	Artist  string `json:"artist,omitempty"`
+	From    int    `json:"from,omitempty"`
+	To      int    `json:"to,omitempty"`
+}
+
+type RestorePlaylistRequest struct {
+	Revision *int `json:"revision"` // текущая ревизия для проверки конфликта
+}
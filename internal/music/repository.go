@@ -2,6 +2,7 @@ package music
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
@@ -10,6 +11,9 @@ import (
 	"time"
 
 	"github.com/Bossnicks/music-streaming-service-kurs/pkg/errorspkg"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/historyimport"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/privacy"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/smartplaylist"
 
 	"github.com/lib/pq"
 )
@@ -22,26 +26,76 @@ func NewRepository(db *sql.DB) *Repository {
 	return &Repository{db: db}
 }
 
-func (r *Repository) AddPlaylist(title, description string, userID int) (int, error) {
+func (r *Repository) AddPlaylist(title, description, visibility string, userID int) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	var playlistID int
-	query := "INSERT INTO playlists (title, description, author_id) VALUES ($1, $2, $3) RETURNING id"
-	err := r.db.QueryRow(query, title, description, userID).Scan(&playlistID)
+	query := "INSERT INTO playlists (title, description, author_id, visibility) VALUES ($1, $2, $3, $4) RETURNING id"
+	err = tx.QueryRow(query, title, description, userID, visibility).Scan(&playlistID)
 	if err != nil {
 		return 0, err
 	}
-	return playlistID, nil
+	if err := recordPlaylistRevision(tx, playlistID, userID, PlaylistChange{Action: ChangeCreate}); err != nil {
+		return 0, err
+	}
+	return playlistID, tx.Commit()
 }
 
+// playlistOwnerCond - автор или участник с ролью owner; $1 - id плейлиста, $2 - пользователь
+const playlistOwnerCond = `(author_id = $2 OR EXISTS (
+	SELECT 1 FROM playlist_members WHERE playlist_id = $1 AND user_id = $2 AND role = 'owner'))`
+
+// UpdatePlaylist меняет название и описание; каждое изменение - отдельная ревизия в истории
 func (r *Repository) UpdatePlaylist(playlistID int, title, description string, userID int) error {
-	query := `UPDATE playlists SET title = $1, description = $2 WHERE id = $3 AND author_id = $4`
-	_, err := r.db.Exec(query, title, description, playlistID, userID)
-	return err
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldTitle, oldDescription string
+	query := `SELECT title, COALESCE(description, '') FROM playlists WHERE id = $1 AND ` + playlistOwnerCond + ` FOR UPDATE`
+	err = tx.QueryRow(query, playlistID, userID).Scan(&oldTitle, &oldDescription)
+	if err == sql.ErrNoRows {
+		return errorspkg.ErrPlaylistNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if title != oldTitle {
+		if _, err := tx.Exec("UPDATE playlists SET title = $2 WHERE id = $1", playlistID, title); err != nil {
+			return err
+		}
+		if _, err := bumpPlaylistRevision(tx, playlistID, userID, PlaylistChange{Action: ChangeRename, Old: oldTitle, New: title}); err != nil {
+			return err
+		}
+	}
+	if description != oldDescription {
+		if _, err := tx.Exec("UPDATE playlists SET description = $2 WHERE id = $1", playlistID, description); err != nil {
+			return err
+		}
+		if _, err := bumpPlaylistRevision(tx, playlistID, userID, PlaylistChange{Action: ChangeDescription, Old: oldDescription, New: description}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 func (r *Repository) DeletePlaylist(playlistID int, userID int) error {
-	query := `DELETE FROM playlists WHERE id = $1 AND author_id = $2`
-	_, err := r.db.Exec(query, playlistID, userID)
-	return err
+	query := `DELETE FROM playlists WHERE id = $1 AND ` + playlistOwnerCond
+	res, err := r.db.Exec(query, playlistID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errorspkg.ErrPlaylistNotFound
+	}
+	return nil
 }
 
 func (r *Repository) UpdateTrack(id int, title, description, genre string, userID int) error {
@@ -58,10 +112,49 @@ func (r *Repository) UpdateTrack(id int, title, description, genre string, userI
 	return err
 }
 
+// DeleteTrack удаляет трек автора и закрывает дыры в позициях плейлистов, где он был
 func (r *Repository) DeleteTrack(id, userID int) error {
-	query := "DELETE FROM tracks WHERE id = $1 AND author_id = $2"
-	_, err := r.db.Exec(query, id, userID)
-	return err
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Блокируем затронутые плейлисты в порядке id, как и остальные правки состава
+	var playlistIDs []int
+	err = tx.QueryRow(`
+		SELECT COALESCE(array_agg(id ORDER BY id), '{}') FROM (
+			SELECT p.id FROM playlists p
+			WHERE p.id IN (
+				SELECT tp.playlist_id FROM tracks_playlists tp
+				JOIN tracks t ON t.id = tp.track_id
+				WHERE tp.track_id = $1 AND t.author_id = $2
+			)
+			ORDER BY p.id
+			FOR UPDATE OF p
+		) locked`, id, userID).Scan(pq.Array(&playlistIDs))
+	if err != nil {
+		return err
+	}
+
+	if len(playlistIDs) > 0 {
+		if _, err := tx.Exec("DELETE FROM tracks_playlists WHERE track_id = $1", id); err != nil {
+			return err
+		}
+		if err := renumberPlaylists(tx, playlistIDs); err != nil {
+			return err
+		}
+		// Владельцы плейлистов трек не убирали - изменение системное
+		for _, playlistID := range playlistIDs {
+			if _, err := bumpPlaylistRevision(tx, playlistID, 0, PlaylistChange{Action: ChangeRemove, TrackID: id}); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM tracks WHERE id = $1 AND author_id = $2", id, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // func (r *Repository) GetTrackByID(id int) (*Track, error) {
@@ -90,7 +183,10 @@ func (r *Repository) GetTrackByID(id int) (*Track, error) {
 			t.created_at, 
 			u.id AS user_id, 
 			u.username, 
-			u.avatar 
+			u.avatar,
+			COALESCE(t.isrc, ''),
+			t.explicit,
+			t.language
 		FROM tracks t
 		JOIN users u ON t.author_id = u.id
 		WHERE t.id = $1`
@@ -107,6 +203,9 @@ func (r *Repository) GetTrackByID(id int) (*Track, error) {
 		&track.Author.Username,
 		&track.Author.Avatar,
 		//&track.Author.Popularity,
+		&track.ISRC,
+		&track.Explicit,
+		&track.Language,
 	)
 
 	// Если произошла ошибка
@@ -116,11 +215,23 @@ func (r *Repository) GetTrackByID(id int) (*Track, error) {
 		}
 		return nil, err
 	}
+
+	credits, err := r.GetTrackCredits([]int{track.ID})
+	if err != nil {
+		return nil, err
+	}
+	track.Credits = credits[track.ID]
 	return &track, nil
 }
 
+// GetUserPlaylists возвращает собственные плейлисты и те, где пользователь участник
 func (r *Repository) GetUserPlaylists(userID int) ([]Playlist, error) {
-	query := "SELECT id, title FROM playlists WHERE author_id = $1"
+	query := `
+		SELECT p.id, p.title, COALESCE(p.avatar, ''), CASE WHEN p.author_id = $1 THEN 'owner' ELSE m.role END
+		FROM playlists p
+		LEFT JOIN playlist_members m ON m.playlist_id = p.id AND m.user_id = $1
+		WHERE p.author_id = $1 OR m.user_id IS NOT NULL
+		ORDER BY p.id`
 	rows, err := r.db.Query(query, userID)
 	if err != nil {
 		return nil, err
@@ -130,7 +241,7 @@ func (r *Repository) GetUserPlaylists(userID int) ([]Playlist, error) {
 	var playlists []Playlist
 	for rows.Next() {
 		var p Playlist
-		if err := rows.Scan(&p.ID, &p.Title); err != nil {
+		if err := rows.Scan(&p.ID, &p.Title, &p.Avatar, &p.Role); err != nil {
 			return nil, err
 		}
 		playlists = append(playlists, p)
@@ -190,39 +301,276 @@ func (r *Repository) AddLike(userID, trackID int) (bool, error) {
 	return rowsAffected > 0, nil
 }
 
-func (r *Repository) AddSongToPlaylist(playlistId, trackID int) (bool, error) {
-	// Получаем максимальную позицию для данного playlist_id и track_id
-	query := `SELECT COALESCE(MAX(position), 0) FROM tracks_playlists WHERE playlist_id = $1 AND track_id = $2`
-	var maxPosition int
-	err := r.db.QueryRow(query, playlistId, trackID).Scan(&maxPosition)
+// lockPlaylist блокирует плейлист до конца транзакции, чтобы правки порядка шли по очереди,
+// проверяет право на правку (owner или editor) и сверяет ревизию, если клиент ее передал.
+// Возвращает текущую ревизию
+func lockPlaylist(tx *sql.Tx, playlistID, userID int, revision *int) (int, error) {
+	var current int
+	var role string
+	var smart bool
+	err := tx.QueryRow(`
+		SELECT p.revision, CASE WHEN p.author_id = $2 THEN 'owner' ELSE COALESCE(m.role, '') END,
+			p.smart_rules IS NOT NULL
+		FROM playlists p
+		LEFT JOIN playlist_members m ON m.playlist_id = p.id AND m.user_id = $2
+		WHERE p.id = $1
+		FOR UPDATE OF p`, playlistID, userID).Scan(&current, &role, &smart)
+	if err == sql.ErrNoRows || err == nil && role != PlaylistOwner && role != PlaylistEditor {
+		return 0, errorspkg.ErrPlaylistNotFound
+	}
 	if err != nil {
-		fmt.Println(err)
-		return false, err
+		return 0, err
+	}
+	if smart {
+		return 0, errorspkg.ErrSmartPlaylistReadOnly
 	}
+	if revision != nil && *revision != current {
+		return 0, errorspkg.ErrPlaylistRevisionConflict
+	}
+	return current, nil
+}
 
-	// Вставляем новый трек с позицией +1
-	insertQuery := `
-		INSERT INTO tracks_playlists (playlist_id, track_id, position)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (playlist_id, track_id) DO NOTHING
-	`
-	res, err := r.db.Exec(insertQuery, playlistId, trackID, maxPosition+1)
+// bumpPlaylistRevision увеличивает ревизию и записывает изменение в историю; userID = 0 - системное изменение
+func bumpPlaylistRevision(tx *sql.Tx, playlistID, userID int, change PlaylistChange) (int, error) {
+	var revision int
+	err := tx.QueryRow("UPDATE playlists SET revision = revision + 1, updated_at = NOW() WHERE id = $1 RETURNING revision", playlistID).Scan(&revision)
 	if err != nil {
-		fmt.Println(err)
-		return false, err
+		return 0, err
+	}
+	return revision, recordPlaylistRevision(tx, playlistID, userID, change)
+}
+
+// recordPlaylistRevision сохраняет текущую ревизию плейлиста вместе со снимком названия, описания и состава
+func recordPlaylistRevision(tx *sql.Tx, playlistID, userID int, change PlaylistChange) error {
+	details, err := json.Marshal(change)
+	if err != nil {
+		return err
 	}
+	_, err = tx.Exec(`
+		INSERT INTO playlist_revisions (playlist_id, revision, user_id, action, details, title, description, track_ids)
+		SELECT p.id, p.revision, NULLIF($2, 0), $3, $4, p.title, COALESCE(p.description, ''),
+		       COALESCE((SELECT array_agg(tp.track_id ORDER BY tp.position) FROM tracks_playlists tp WHERE tp.playlist_id = p.id), '{}')
+		FROM playlists p WHERE p.id = $1`, playlistID, userID, change.Action, details)
+	return err
+}
 
-	rowsAffected, err := res.RowsAffected()
+// playlistLength - последняя занятая позиция; при плотной нумерации равна числу треков,
+// а если где-то осталась дыра, добавление в конец все равно не столкнется с существующей позицией
+func playlistLength(tx *sql.Tx, playlistID int) (int, error) {
+	var n int
+	err := tx.QueryRow("SELECT COALESCE(MAX(position), 0) FROM tracks_playlists WHERE playlist_id = $1", playlistID).Scan(&n)
+	return n, err
+}
+
+// renumberPlaylists закрывает дыры в позициях после удаления треков из плейлистов
+func renumberPlaylists(tx *sql.Tx, playlistIDs []int) error {
+	_, err := tx.Exec(`
+		UPDATE tracks_playlists tp SET position = n.rn
+		FROM (
+			SELECT playlist_id, track_id, ROW_NUMBER() OVER (PARTITION BY playlist_id ORDER BY position) AS rn
+			FROM tracks_playlists
+			WHERE playlist_id = ANY($1)
+		) n
+		WHERE tp.playlist_id = n.playlist_id AND tp.track_id = n.track_id AND tp.position <> n.rn`,
+		pq.Array(playlistIDs))
+	return err
+}
+
+// InsertPlaylistTrack вставляет трек на позицию position (с 1), сдвигая следующие; 0 - в конец
+func (r *Repository) InsertPlaylistTrack(playlistID, trackID, position, userID int, revision *int) (int, error) {
+	tx, err := r.db.Begin()
 	if err != nil {
-		return false, err
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockPlaylist(tx, playlistID, userID, revision); err != nil {
+		return 0, err
+	}
+
+	var trackExists, inPlaylist bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM tracks WHERE id = $2),
+		       EXISTS (SELECT 1 FROM tracks_playlists WHERE playlist_id = $1 AND track_id = $2)`,
+		playlistID, trackID).Scan(&trackExists, &inPlaylist)
+	if err != nil {
+		return 0, err
+	}
+	if !trackExists {
+		return 0, errorspkg.ErrTrackNotFound
+	}
+	if inPlaylist {
+		return 0, errorspkg.ErrTrackAlreadyInPlaylist
+	}
+
+	n, err := playlistLength(tx, playlistID)
+	if err != nil {
+		return 0, err
+	}
+	if position < 1 || position > n+1 {
+		position = n + 1
+	}
+
+	if _, err := tx.Exec("UPDATE tracks_playlists SET position = position + 1 WHERE playlist_id = $1 AND position >= $2", playlistID, position); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT INTO tracks_playlists (playlist_id, track_id, position, added_by) VALUES ($1, $2, $3, $4)", playlistID, trackID, position, userID); err != nil {
+		return 0, err
+	}
+
+	newRevision, err := bumpPlaylistRevision(tx, playlistID, userID, PlaylistChange{Action: ChangeAdd, TrackID: trackID, To: position})
+	if err != nil {
+		return 0, err
+	}
+	return newRevision, tx.Commit()
+}
+
+func (r *Repository) RemovePlaylistTrack(playlistID, trackID, userID int, revision *int) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockPlaylist(tx, playlistID, userID, revision); err != nil {
+		return 0, err
+	}
+
+	var position int
+	err = tx.QueryRow("DELETE FROM tracks_playlists WHERE playlist_id = $1 AND track_id = $2 RETURNING position", playlistID, trackID).Scan(&position)
+	if err == sql.ErrNoRows {
+		return 0, errorspkg.ErrTrackNotInPlaylist
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE tracks_playlists SET position = position - 1 WHERE playlist_id = $1 AND position > $2", playlistID, position); err != nil {
+		return 0, err
+	}
+
+	newRevision, err := bumpPlaylistRevision(tx, playlistID, userID, PlaylistChange{Action: ChangeRemove, TrackID: trackID, From: position})
+	if err != nil {
+		return 0, err
+	}
+	return newRevision, tx.Commit()
+}
+
+// MovePlaylistTrack переносит трек на позицию to; позиции вне списка прижимаются к краям
+func (r *Repository) MovePlaylistTrack(playlistID, trackID, to, userID int, revision *int) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	current, err := lockPlaylist(tx, playlistID, userID, revision)
+	if err != nil {
+		return 0, err
 	}
 
-	// Если строка не была вставлена (из-за конфликта)
-	if rowsAffected == 0 {
-		return false, fmt.Errorf("конфликт: трек с таким ID уже существует в плейлисте")
+	var from int
+	err = tx.QueryRow("SELECT position FROM tracks_playlists WHERE playlist_id = $1 AND track_id = $2", playlistID, trackID).Scan(&from)
+	if err == sql.ErrNoRows {
+		return 0, errorspkg.ErrTrackNotInPlaylist
 	}
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := playlistLength(tx, playlistID)
+	if err != nil {
+		return 0, err
+	}
+	if to < 1 {
+		to = 1
+	}
+	if to > n {
+		to = n
+	}
+	if to == from {
+		return current, nil
+	}
+
+	if from < to {
+		_, err = tx.Exec("UPDATE tracks_playlists SET position = position - 1 WHERE playlist_id = $1 AND position > $2 AND position <= $3", playlistID, from, to)
+	} else {
+		_, err = tx.Exec("UPDATE tracks_playlists SET position = position + 1 WHERE playlist_id = $1 AND position >= $3 AND position < $2", playlistID, from, to)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE tracks_playlists SET position = $3 WHERE playlist_id = $1 AND track_id = $2", playlistID, trackID, to); err != nil {
+		return 0, err
+	}
+
+	newRevision, err := bumpPlaylistRevision(tx, playlistID, userID, PlaylistChange{Action: ChangeMove, TrackID: trackID, From: from, To: to})
+	if err != nil {
+		return 0, err
+	}
+	return newRevision, tx.Commit()
+}
+
+// ReplacePlaylistTracks задает весь состав и порядок. Оставшиеся треки не пересоздаются,
+// чтобы сохранить их служебные поля
+func (r *Repository) ReplacePlaylistTracks(playlistID int, trackIDs []int, userID, revision int) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockPlaylist(tx, playlistID, userID, &revision); err != nil {
+		return 0, err
+	}
+
+	var found int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM tracks WHERE id = ANY($1)", pq.Array(trackIDs)).Scan(&found); err != nil {
+		return 0, err
+	}
+	if found != len(trackIDs) {
+		return 0, errorspkg.ErrTrackNotFound
+	}
+
+	change, err := replaceTracks(tx, playlistID, trackIDs, userID)
+	if err != nil {
+		return 0, err
+	}
+	change.Action = ChangeReplace
+
+	newRevision, err := bumpPlaylistRevision(tx, playlistID, userID, change)
+	if err != nil {
+		return 0, err
+	}
+	return newRevision, tx.Commit()
+}
+
+// replaceTracks приводит состав к trackIDs и возвращает, сколько треков добавлено и удалено
+func replaceTracks(tx *sql.Tx, playlistID int, trackIDs []int, userID int) (PlaylistChange, error) {
+	var change PlaylistChange
+	res, err := tx.Exec("DELETE FROM tracks_playlists WHERE playlist_id = $1 AND NOT (track_id = ANY($2))", playlistID, pq.Array(trackIDs))
+	if err != nil {
+		return change, err
+	}
+	removed, _ := res.RowsAffected()
+
+	_, err = tx.Exec(`
+		UPDATE tracks_playlists tp SET position = t.ord
+		FROM UNNEST($2::int[]) WITH ORDINALITY AS t(id, ord)
+		WHERE tp.playlist_id = $1 AND tp.track_id = t.id`, playlistID, pq.Array(trackIDs))
+	if err != nil {
+		return change, err
+	}
+	res, err = tx.Exec(`
+		INSERT INTO tracks_playlists (playlist_id, track_id, position, added_by)
+		SELECT $1, t.id, t.ord, $3 FROM UNNEST($2::int[]) WITH ORDINALITY AS t(id, ord)
+		ON CONFLICT (playlist_id, track_id) DO NOTHING`, playlistID, pq.Array(trackIDs), userID)
+	if err != nil {
+		return change, err
+	}
+	added, _ := res.RowsAffected()
 
-	return true, nil
+	change.Added, change.Removed = int(added), int(removed)
+	return change, nil
 }
 
 // RemoveLike удаляет лайк и возвращает true, если он был удален
@@ -354,6 +702,23 @@ func (r *Repository) GetCommentsByTrackID(trackID int, isAdmin bool) ([]Comment,
 	return comments, nil
 }
 
+func (r *Repository) GetCommentByID(commentID int) (*Comment, error) {
+	var comment Comment
+	err := r.db.QueryRow(`
+		SELECT c.id, c.text, c.moment, c.created_at, c.is_hidden, u.id, u.username, u.avatar
+		FROM comments c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.id = $1`, commentID).Scan(&comment.ID, &comment.Text, &comment.Moment, &comment.CreatedAt, &comment.IsHidden,
+		&comment.User.ID, &comment.User.Username, &comment.User.Avatar)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
 func (r *Repository) AddComment(trackID, userID int, text string, moment int) (int, error) {
 	// Проверяем, разрешено ли пользователю оставлять комментарии
 	var canComment bool
@@ -368,6 +733,19 @@ func (r *Repository) AddComment(trackID, userID int, text string, moment int) (i
 		return 0, errorspkg.ErrCommentBanned
 	}
 
+	// Автор трека заблокировал пользователя
+	var blocked bool
+	queryBlocked := `SELECT EXISTS (
+		SELECT 1 FROM user_blocks b JOIN tracks t ON t.author_id = b.blocker_id
+		WHERE t.id = $1 AND b.blocked_id = $2
+	)`
+	if err := r.db.QueryRow(queryBlocked, trackID, userID).Scan(&blocked); err != nil {
+		return 0, err
+	}
+	if blocked {
+		return 0, errorspkg.ErrUserBlocked
+	}
+
 	var id int
 	query := `INSERT INTO comments (track_id, user_id, text, moment) 
 	          VALUES ($1, $2, $3, $4) RETURNING id`
@@ -439,7 +817,7 @@ func (r *Repository) GetTrackPartsByTrackID(trackID int) ([]TrackPartsAverage, e
 
 func (r *Repository) GetTrackListens(trackID int) (int, error) {
 	var count int
-	query := `SELECT COUNT(*) FROM track_listens WHERE track_id = $1`
+	query := `SELECT COUNT(*) FROM track_listens WHERE track_id = $1 AND NOT is_imported`
 	err := r.db.QueryRow(query, trackID).Scan(&count)
 	if err != nil {
 		return 0, err
@@ -455,6 +833,7 @@ func (r *Repository) GetTopUsersByPopularity() ([]User, error) {
 		LEFT JOIN (
 			SELECT track_id, COUNT(*) AS listen_count
 			FROM track_listens
+			WHERE NOT is_imported
 			GROUP BY track_id
 		) tl ON t.id = tl.track_id
 		GROUP BY u.id
@@ -510,6 +889,7 @@ func (r *Repository) GetArtistTracks(artistID, page int) ([]Track, error) {
 		SELECT id, author_id, title, description, duration, created_at
 		FROM tracks
 		WHERE author_id = $1
+		   OR id IN (SELECT track_id FROM track_credits WHERE user_id = $1)
 		ORDER BY created_at DESC
 		LIMIT $2 OFFSET $3;
 	`
@@ -529,7 +909,13 @@ func (r *Repository) GetArtistTracks(artistID, page int) ([]Track, error) {
 		}
 		tracks = append(tracks, track)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
+	if err := r.attachCredits(tracks); err != nil {
+		return nil, err
+	}
 	return tracks, nil
 }
 
@@ -556,6 +942,12 @@ func (r *Repository) GetPlaylistByID(playlistID int, isAdmin bool) (*Playlist, e
 			p.description AS playlist_description,
 			p.created_at AS playlist_created_at,
 			p.updated_at AS playlist_updated_at,
+			p.revision AS playlist_revision,
+			p.visibility AS playlist_visibility,
+			(SELECT COUNT(*) FROM playlist_follows pf WHERE pf.playlist_id = p.id) AS playlist_followers,
+			p.smart_rules,
+			p.smart_refresh,
+			COALESCE(p.avatar, '') AS playlist_avatar,
 			u.id AS author_id,
 			u.username AS author_username,
 			COALESCE(t.id, 0) AS track_id,
@@ -565,18 +957,24 @@ func (r *Repository) GetPlaylistByID(playlistID int, isAdmin bool) (*Playlist, e
 			COALESCE(t.created_at, NOW()) AS track_created_at,
 			COALESCE(t.is_blocked, false) AS track_is_blocked,
 			COALESCE(u2.id, 0) AS track_author_id,
-			COALESCE(u2.username, '') AS track_author_username
+			COALESCE(u2.username, '') AS track_author_username,
+			COALESCE(tp.position, 0) AS track_position,
+			COALESCE(u3.id, 0) AS added_by_id,
+			COALESCE(u3.username, '') AS added_by_username
 		FROM playlists p
 		JOIN users u ON p.author_id = u.id
 		LEFT JOIN tracks_playlists tp ON p.id = tp.playlist_id
 		LEFT JOIN tracks t ON tp.track_id = t.id
 		LEFT JOIN users u2 ON t.author_id = u2.id
+		LEFT JOIN users u3 ON tp.added_by = u3.id
 		WHERE p.id = $1`
 
 	if !isAdmin {
 		query += " AND (t.id IS NULL OR t.is_blocked = false)"
 	}
 
+	query += " ORDER BY tp.position"
+
 	rows, err := r.db.Query(query, playlistID)
 	if err != nil {
 		fmt.Println(err)
@@ -586,11 +984,14 @@ func (r *Repository) GetPlaylistByID(playlistID int, isAdmin bool) (*Playlist, e
 
 	var playlist Playlist
 	var tracks []Track
+	var smartRules []byte
+	var smartRefresh string
 	firstRow := true // Флаг для первой строки
 
 	for rows.Next() {
 		var track Track
 		var trackAuthor User
+		var addedBy User
 
 		err := rows.Scan(
 			&playlist.ID,
@@ -598,6 +999,12 @@ func (r *Repository) GetPlaylistByID(playlistID int, isAdmin bool) (*Playlist, e
 			&playlist.Description,
 			&playlist.CreatedAt,
 			&playlist.UpdatedAt,
+			&playlist.Revision,
+			&playlist.Visibility,
+			&playlist.Followers,
+			&smartRules,
+			&smartRefresh,
+			&playlist.Avatar,
 			&playlist.Author.ID,
 			&playlist.Author.Username,
 			&track.ID,
@@ -608,6 +1015,9 @@ func (r *Repository) GetPlaylistByID(playlistID int, isAdmin bool) (*Playlist, e
 			&track.Is_blocked,
 			&trackAuthor.ID,
 			&trackAuthor.Username,
+			&track.Position,
+			&addedBy.ID,
+			&addedBy.Username,
 		)
 
 		if err != nil {
@@ -623,6 +1033,9 @@ func (r *Repository) GetPlaylistByID(playlistID int, isAdmin bool) (*Playlist, e
 		// Если трек реально существует (id != 0), добавляем его в список
 		if track.ID != 0 {
 			track.Author = trackAuthor
+			if addedBy.ID != 0 {
+				track.AddedBy = &addedBy
+			}
 			tracks = append(tracks, track)
 		}
 	}
@@ -632,6 +1045,15 @@ func (r *Repository) GetPlaylistByID(playlistID int, isAdmin bool) (*Playlist, e
 		return nil, fmt.Errorf("playlist not found")
 	}
 
+	if smartRules != nil {
+		var def smartplaylist.Definition
+		if err := json.Unmarshal(smartRules, &def); err != nil {
+			return nil, err
+		}
+		playlist.SmartRules = &def
+		playlist.SmartRefresh = smartRefresh
+	}
+
 	playlist.Tracks = tracks
 	return &playlist, nil
 }
@@ -675,14 +1097,14 @@ func (r *Repository) GetSongStatistics(trackID int) (*TrackStatistics, error) {
 			ARRAY(
 				SELECT l.country
 				FROM track_listens l
-				WHERE l.track_id = $1
+				WHERE l.track_id = $1 AND NOT l.is_imported
 				GROUP BY l.country
 				ORDER BY COUNT(l.country) DESC
 				LIMIT 5
 			) AS top_countries
 
 			FROM track_listens l
-			WHERE l.track_id = $1;
+			WHERE l.track_id = $1 AND NOT l.is_imported;
 				`
 
 	row := r.db.QueryRow(query, trackID)
@@ -715,7 +1137,7 @@ func (r *Repository) GetGlobalStatistics(days int) (int, int, int, int, error) {
 	// Количество всех прослушиваний
 	queryListens := fmt.Sprintf(`
         SELECT COUNT(*) FROM track_listens
-        WHERE created_at >= NOW() - INTERVAL '%d days'
+        WHERE created_at >= NOW() - INTERVAL '%d days' AND NOT is_imported
     `, days)
 	if err := r.db.QueryRow(queryListens).Scan(&listens); err != nil {
 		fmt.Println(err)
@@ -734,7 +1156,7 @@ func (r *Repository) GetGlobalStatistics(days int) (int, int, int, int, error) {
 	// Количество уникальных слушателей
 	queryListeners := fmt.Sprintf(`
         SELECT COUNT(DISTINCT listener_id) FROM track_listens
-        WHERE created_at >= NOW() - INTERVAL '%d days'
+        WHERE created_at >= NOW() - INTERVAL '%d days' AND NOT is_imported
     `, days)
 	if err := r.db.QueryRow(queryListeners).Scan(&listeners); err != nil {
 		return 0, 0, 0, 0, err
@@ -926,7 +1348,7 @@ func (r *Repository) GetRecentTracks(userID int) ([]Track, error) {
 			t.id, t.author_id, t.title, t.description, t.duration, t.is_blocked, t.created_at
 		FROM track_listens tl
 		JOIN tracks t ON tl.track_id = t.id
-		WHERE tl.listener_id = $1
+		WHERE tl.listener_id = $1 AND NOT tl.is_imported
 		ORDER BY tl.created_at DESC
 		LIMIT 10
 	`
@@ -972,7 +1394,11 @@ func (r *Repository) GetTopListenedUsers(userID int) ([]User, error) {
 		FROM track_listens tl
 		JOIN tracks t ON tl.track_id = t.id
 		JOIN users u ON t.author_id = u.id
-		WHERE tl.listener_id = $1 AND tl.created_at >= NOW() - INTERVAL '%s'
+		WHERE tl.listener_id = $1 AND (
+			tl.created_at >= NOW() - INTERVAL '%s'
+			-- импортированная история старше, поэтому для нее окно шире
+			OR (tl.is_imported AND tl.created_at >= NOW() - INTERVAL '1 year')
+		)
 		GROUP BY u.id
 		ORDER BY listen_count DESC
 		LIMIT 10;
@@ -1136,31 +1562,52 @@ func (r *Repository) GetMyWaveTracks(activity, character, mood string, userID in
 	return tracks, nil
 }
 
-func (r *Repository) CreateAlbum(title, description string, releaseDate time.Time, userID int, is_Announced bool) (int, error) {
+func (r *Repository) CreateAlbum(title, description string, releaseDate time.Time, userID int, is_Announced bool, meta AlbumMetadata) (int, error) {
 	var id int
 	query := `
         INSERT INTO albums 
-            (title, description, author_id, release_date, is_announced) 
-        VALUES ($1, $2, $3, $4, $5) 
+            (title, description, author_id, release_date, is_announced, published_at,
+             release_type, label, upc, p_line, c_line, explicit, language) 
+        VALUES ($1, $2, $3, $4, $5, CASE WHEN $13 THEN NULL ELSE NOW() END,
+             $6, $7, NULLIF($8, ''), $9, $10, $11, $12) 
         RETURNING id
     `
-	err := r.db.QueryRow(query, title, description, userID, releaseDate, is_Announced).Scan(&id)
+	// Один параметр в двух местах запроса получил бы от Postgres один выведенный тип,
+	// поэтому сравнение с текущим временем делаем здесь
+	scheduled := releaseDate.After(time.Now())
+	err := r.db.QueryRow(query, title, description, userID, releaseDate, is_Announced,
+		meta.ReleaseType, meta.Label, meta.UPC, meta.PLine, meta.CLine, meta.Explicit, meta.Language, scheduled).Scan(&id)
+	if isUniqueViolation(err) {
+		return 0, errorspkg.ErrReleaseCodeTaken
+	}
 	fmt.Println(err)
 	return id, err
 }
 
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// AddTracksToAlbum кладет треки на первый диск в порядке списка
 func (r *Repository) AddTracksToAlbum(albumID int, trackIDs []int) error {
-	query := `INSERT INTO tracks_albums (album_id, track_id) VALUES ($1, $2)`
-	for _, trackID := range trackIDs {
-		_, err := r.db.Exec(query, albumID, trackID)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	query := `
+		INSERT INTO tracks_albums (album_id, track_id, disc_number, track_number)
+		SELECT $1, t.id, 1, t.ord FROM UNNEST($2::int[]) WITH ORDINALITY AS t(id, ord)`
+	_, err := r.db.Exec(query, albumID, pq.Array(trackIDs))
+	return err
+}
+
+func (r *Repository) CheckTracksAvailability(trackIDs []int, userID int, compilation bool) (bool, error) {
+	return checkTracksAvailability(r.db, trackIDs, userID, 0, compilation, false)
 }
 
-func (r *Repository) CheckTracksAvailability(trackIDs []int, userID int) (bool, error) {
+// checkTracksAvailability: треки принадлежат артисту, трек входит максимум в один его альбом,
+// не считая сборников. В сборник можно брать треки из альбомов, но не дважды.
+// replace - трек-лист albumID заменяется целиком, его текущие треки не мешают
+func checkTracksAvailability(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, trackIDs []int, userID, albumID int, compilation, replace bool) (bool, error) {
 	query := `
         SELECT COUNT(*) 
         FROM tracks 
@@ -1170,59 +1617,519 @@ func (r *Repository) CheckTracksAvailability(trackIDs []int, userID int) (bool,
             SELECT track_id FROM tracks_albums
             JOIN albums ON albums.id = tracks_albums.album_id
             WHERE albums.author_id = $2
+              AND ((albums.id = $3 AND NOT $5)
+                OR (albums.id <> $3 AND NOT $4 AND albums.release_type <> 'compilation'))
         )
     `
 	var count int
-	err := r.db.QueryRow(query, pq.Array(trackIDs), userID).Scan(&count)
+	err := q.QueryRow(query, pq.Array(trackIDs), userID, albumID, compilation, replace).Scan(&count)
 	return count == len(trackIDs), err
 }
 
-func (r *Repository) DeleteAlbum(albumID, userID int) error {
-	query := `
-        DELETE FROM albums
-        WHERE id = $1
-        AND author_id = $2
-    `
-	_, err := r.db.Exec(query, albumID, userID)
-	return err
+func albumIsCompilation(tx *sql.Tx, albumID int) (bool, error) {
+	var compilation bool
+	err := tx.QueryRow("SELECT release_type = 'compilation' FROM albums WHERE id = $1", albumID).Scan(&compilation)
+	return compilation, err
 }
 
-// func (r *Repository) ToggleAlbumVisibility(albumID, userID int) error {
-//     query := `
-//         UPDATE albums
-//         SET is_hidden = NOT is_hidden
-//         WHERE id = $1
-//         AND author_id = $2
-//     `
-//     res, err := r.db.Exec(query, albumID, userID)
-//     return checkAffected(res, err)
-// }
-
-func (r *Repository) GetAvailableTracks(userID int) ([]*Track, error) {
-	query := `
-        SELECT t.id, t.title, t.duration 
-        FROM tracks t
-        LEFT JOIN tracks_albums ta ON t.id = ta.track_id
-        LEFT JOIN albums a ON ta.album_id = a.id AND a.author_id = $1
-        WHERE t.author_id = $1 AND a.id IS NULL
-    `
-	rows, err := r.db.Query(query, userID)
+// lockArtistAlbums блокирует все альбомы артиста до конца транзакции: иначе две параллельные правки
+// могли бы положить один трек в два альбома. Возвращает ErrAlbumNotFound, если альбом не его
+func lockArtistAlbums(tx *sql.Tx, albumID, userID int) error {
+	rows, err := tx.Query("SELECT id FROM albums WHERE author_id = $1 ORDER BY id FOR UPDATE", userID)
 	if err != nil {
-		fmt.Println(err)
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var tracks []*Track
+	found := false
 	for rows.Next() {
-		var track Track
-		if err := rows.Scan(
-			&track.ID,
-			&track.Title,
-			&track.Duration,
-		); err != nil {
-			fmt.Println(err)
-			return nil, err
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		found = found || id == albumID
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return errorspkg.ErrAlbumNotFound
+	}
+	return nil
+}
+
+// GetAlbumMeta возвращает альбом без треков и без проверки даты выхода - для правок автора
+func (r *Repository) GetAlbumMeta(albumID int) (*Album, error) {
+	var album Album
+	m := &album.AlbumMetadata
+	err := r.db.QueryRow(`
+		SELECT a.id, a.title, COALESCE(a.description, ''), a.release_date, a.is_announced, a.published_at IS NOT NULL,
+		       u.id, u.username, a.release_type, a.label, COALESCE(a.upc, ''), a.p_line, a.c_line, a.explicit, a.language
+		FROM albums a
+		JOIN users u ON u.id = a.author_id
+		WHERE a.id = $1`, albumID).
+		Scan(&album.ID, &album.Title, &album.Description, &album.Release_Date, &album.Is_Announced, &album.Is_Released,
+			&album.Author.ID, &album.Author.Username, &m.ReleaseType, &m.Label, &m.UPC, &m.PLine, &m.CLine, &m.Explicit, &m.Language)
+	if err == sql.ErrNoRows {
+		return nil, errorspkg.ErrAlbumNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
+
+// SaveAlbum кладет вышедший альбом в библиотеку, а анонсированный - в пре-сейв.
+// Возвращает true для пре-сейва. Неанонсированный будущий альбом считается несуществующим
+func (r *Repository) SaveAlbum(albumID, userID int) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Блокировка строки альбома не дает задаче публикации перенести пре-сейвы раньше, чем мы его добавим
+	var released, announced bool
+	err = tx.QueryRow("SELECT published_at IS NOT NULL, is_announced FROM albums WHERE id = $1 FOR SHARE", albumID).
+		Scan(&released, &announced)
+	if err == sql.ErrNoRows || (err == nil && !released && !announced) {
+		return false, errorspkg.ErrAlbumNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	table := "album_saves"
+	if !released {
+		table = "album_presaves"
+	}
+	_, err = tx.Exec("INSERT INTO "+table+" (album_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", albumID, userID)
+	if err != nil {
+		return false, err
+	}
+	return !released, tx.Commit()
+}
+
+// UnsaveAlbum убирает альбом из библиотеки и отменяет пре-сейв
+func (r *Repository) UnsaveAlbum(albumID, userID int) error {
+	for _, table := range []string{"album_saves", "album_presaves"} {
+		if _, err := r.db.Exec("DELETE FROM "+table+" WHERE album_id = $1 AND user_id = $2", albumID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetLibraryAlbums - сохраненные альбомы, последние первыми, и ожидающие выхода пре-сейвы
+func (r *Repository) GetLibraryAlbums(userID int) ([]Album, error) {
+	rows, err := r.db.Query(`
+		SELECT a.id, a.title, COALESCE(a.description, ''), a.release_date, a.is_announced, a.cover_updated_at,
+		       u.id, u.username, a.published_at IS NOT NULL, l.saved, a.release_type, a.explicit
+		FROM (
+			SELECT album_id, created_at, TRUE AS saved FROM album_saves WHERE user_id = $1
+			UNION ALL
+			SELECT album_id, created_at, FALSE FROM album_presaves WHERE user_id = $1
+		) l
+		JOIN albums a ON a.id = l.album_id
+		JOIN users u ON u.id = a.author_id
+		ORDER BY l.saved, l.created_at DESC, a.id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := []Album{}
+	for rows.Next() {
+		var a Album
+		var coverUpdatedAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Title, &a.Description, &a.Release_Date, &a.Is_Announced, &coverUpdatedAt,
+			&a.Author.ID, &a.Author.Username, &a.Is_Released, &a.Is_Saved, &a.ReleaseType, &a.Explicit); err != nil {
+			return nil, err
+		}
+		a.Is_Presaved = !a.Is_Saved
+		if coverUpdatedAt.Valid {
+			a.Avatar = albumCoverURL(a.ID, coverUpdatedAt.Time)
+		}
+		albums = append(albums, a)
+	}
+	return albums, rows.Err()
+}
+
+// PublishDueAlbums публикует альбомы, дата выхода которых наступила, и переносит их пре-сейвы в библиотеки.
+// SKIP LOCKED позволяет запускать задачу на нескольких экземплярах сервиса
+func (r *Repository) PublishDueAlbums(limit int) ([]AlbumRelease, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, author_id, title, release_date FROM albums
+		WHERE published_at IS NULL AND release_date <= NOW()
+		ORDER BY release_date, id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED`, limit)
+	if err != nil {
+		return nil, err
+	}
+	var releases []AlbumRelease
+	for rows.Next() {
+		var rel AlbumRelease
+		if err := rows.Scan(&rel.AlbumID, &rel.AuthorID, &rel.Title, &rel.ReleaseDate); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		releases = append(releases, rel)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range releases {
+		albumID := releases[i].AlbumID
+		if _, err := tx.Exec("UPDATE albums SET published_at = NOW() WHERE id = $1", albumID); err != nil {
+			return nil, err
+		}
+		var presavers pq.Int64Array
+		err := tx.QueryRow(`
+			WITH moved AS (
+				DELETE FROM album_presaves WHERE album_id = $1 RETURNING user_id, created_at
+			), saved AS (
+				INSERT INTO album_saves (album_id, user_id, created_at)
+				SELECT $1, user_id, NOW() FROM moved
+				ON CONFLICT DO NOTHING
+			)
+			SELECT COALESCE(array_agg(user_id ORDER BY created_at), '{}') FROM moved`, albumID).Scan(&presavers)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range presavers {
+			releases[i].Presavers = append(releases[i].Presavers, int(id))
+		}
+	}
+	return releases, tx.Commit()
+}
+
+// albumCoverURL - адрес обложки; версия в запросе сбрасывает кэш после замены
+func albumCoverURL(albumID int, updatedAt time.Time) string {
+	return fmt.Sprintf("/images/%d/album?v=%d", albumID, updatedAt.Unix())
+}
+
+// SetAlbumCoverUpdated отмечает загрузку новой обложки
+func (r *Repository) SetAlbumCoverUpdated(albumID int) error {
+	_, err := r.db.Exec("UPDATE albums SET cover_updated_at = NOW() WHERE id = $1", albumID)
+	return err
+}
+
+// UpdateAlbum меняет только переданные поля. Сборник, ставший обычным альбомом, не должен
+// делить треки с другими альбомами артиста
+func (r *Repository) UpdateAlbum(albumID, userID int, req UpdateAlbumRequest) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockArtistAlbums(tx, albumID, userID); err != nil {
+		return err
+	}
+	if req.ReleaseType != nil && *req.ReleaseType != ReleaseCompilation {
+		var shared bool
+		err := tx.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM tracks_albums ta
+				JOIN tracks_albums other ON other.track_id = ta.track_id AND other.album_id <> ta.album_id
+				JOIN albums a ON a.id = other.album_id
+				WHERE ta.album_id = $1 AND a.author_id = $2 AND a.release_type <> 'compilation'
+			)`, albumID, userID).Scan(&shared)
+		if err != nil {
+			return err
+		}
+		if shared {
+			return errorspkg.ErrTracksUnavailable
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE albums
+		SET title = COALESCE($3, title),
+		    description = COALESCE($4, description),
+		    release_date = COALESCE($5, release_date),
+		    is_announced = COALESCE($6, is_announced),
+		    release_type = COALESCE($7, release_type),
+		    label = COALESCE($8, label),
+		    upc = CASE WHEN $9::TEXT IS NULL THEN upc ELSE NULLIF($9, '') END,
+		    p_line = COALESCE($10, p_line),
+		    c_line = COALESCE($11, c_line),
+		    explicit = COALESCE($12, explicit),
+		    language = COALESCE($13, language)
+		WHERE id = $1 AND author_id = $2`,
+		albumID, userID, req.Title, req.Description, req.ReleaseDate, req.IsAnnounced,
+		req.ReleaseType, req.Label, req.UPC, req.PLine, req.CLine, req.Explicit, req.Language)
+	if isUniqueViolation(err) {
+		return errorspkg.ErrReleaseCodeTaken
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetTrackMetadata сохраняет переданные ISRC, explicit и язык трека; false, если трек не найден или чужой
+func (r *Repository) SetTrackMetadata(trackID, userID int, req UpdateTrackMetadataRequest) (bool, error) {
+	res, err := r.db.Exec(`
+		UPDATE tracks
+		SET isrc = CASE WHEN $3::TEXT IS NULL THEN isrc ELSE NULLIF($3, '') END,
+		    explicit = COALESCE($4, explicit),
+		    language = COALESCE($5, language),
+		    updated_at = NOW()
+		WHERE id = $1 AND author_id = $2`,
+		trackID, userID, req.ISRC, req.Explicit, req.Language)
+	if isUniqueViolation(err) {
+		return false, errorspkg.ErrReleaseCodeTaken
+	}
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetAlbumTrackList - все треки альбома по порядку с метаданными, в том числе до публикации
+func (r *Repository) GetAlbumTrackList(albumID int) ([]Track, error) {
+	rows, err := r.db.Query(`
+		SELECT t.id, t.title, COALESCE(t.duration, 0), u.id, u.username, ta.disc_number, ta.track_number,
+		       COALESCE(t.isrc, ''), t.explicit, t.language
+		FROM tracks_albums ta
+		JOIN tracks t ON t.id = ta.track_id
+		JOIN users u ON u.id = t.author_id
+		WHERE ta.album_id = $1
+		ORDER BY ta.disc_number, ta.track_number`, albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tracks []Track
+	for rows.Next() {
+		var t Track
+		if err := rows.Scan(&t.ID, &t.Title, &t.Duration, &t.Author.ID, &t.Author.Username, &t.DiscNumber, &t.TrackNumber,
+			&t.ISRC, &t.Explicit, &t.Language); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := r.attachCredits(tracks); err != nil {
+		return nil, err
+	}
+	return tracks, nil
+}
+
+// AddAlbumTrack вставляет трек на диск disc под номером number, сдвигая следующие; 0 - в конец диска.
+// Новый диск можно начать только следующим по номеру
+func (r *Repository) AddAlbumTrack(albumID, userID int, ref AlbumTrackRef) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockArtistAlbums(tx, albumID, userID); err != nil {
+		return err
+	}
+	compilation, err := albumIsCompilation(tx, albumID)
+	if err != nil {
+		return err
+	}
+	ok, err := checkTracksAvailability(tx, []int{ref.TrackID}, userID, albumID, compilation, false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errorspkg.ErrTracksUnavailable
+	}
+
+	var discs, onDisc int
+	err = tx.QueryRow(`
+		SELECT COALESCE(MAX(disc_number), 0), COUNT(*) FILTER (WHERE disc_number = $2)
+		FROM tracks_albums WHERE album_id = $1`, albumID, ref.DiscNumber).Scan(&discs, &onDisc)
+	if err != nil {
+		return err
+	}
+	if ref.DiscNumber > discs+1 {
+		return fmt.Errorf("%w: в альбоме %d диск(а), новый диск должен иметь номер %d", errorspkg.ErrInvalidAlbumEdit, discs, discs+1)
+	}
+	number := ref.TrackNumber
+	if number < 1 || number > onDisc+1 {
+		number = onDisc + 1
+	}
+
+	_, err = tx.Exec(`
+		UPDATE tracks_albums SET track_number = track_number + 1
+		WHERE album_id = $1 AND disc_number = $2 AND track_number >= $3`, albumID, ref.DiscNumber, number)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO tracks_albums (album_id, track_id, disc_number, track_number) VALUES ($1, $2, $3, $4)`,
+		albumID, ref.TrackID, ref.DiscNumber, number)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveAlbumTrack убирает трек и сдвигает следующие; опустевший диск убирается из нумерации.
+// Последний трек убрать нельзя - альбом без треков не создается
+func (r *Repository) RemoveAlbumTrack(albumID, userID, trackID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockArtistAlbums(tx, albumID, userID); err != nil {
+		return err
+	}
+
+	var total int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM tracks_albums WHERE album_id = $1", albumID).Scan(&total); err != nil {
+		return err
+	}
+
+	var disc, number int
+	err = tx.QueryRow(`
+		DELETE FROM tracks_albums WHERE album_id = $1 AND track_id = $2
+		RETURNING disc_number, track_number`, albumID, trackID).Scan(&disc, &number)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: трека нет в альбоме", errorspkg.ErrInvalidAlbumEdit)
+	}
+	if err != nil {
+		return err
+	}
+	if total == 1 {
+		return fmt.Errorf("%w: в альбоме должен остаться хотя бы один трек", errorspkg.ErrInvalidAlbumEdit)
+	}
+
+	queries := []string{
+		"UPDATE tracks_albums SET track_number = track_number - 1 WHERE album_id = $1 AND disc_number = $2 AND track_number > $3",
+		`UPDATE tracks_albums SET disc_number = disc_number - 1
+		 WHERE album_id = $1 AND disc_number > $2
+		   AND NOT EXISTS (SELECT 1 FROM tracks_albums WHERE album_id = $1 AND disc_number = $2)`,
+	}
+	for i, q := range queries {
+		args := []interface{}{albumID, disc, number}
+		if i == 1 {
+			args = args[:2]
+		}
+		if _, err := tx.Exec(q, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ReplaceAlbumTracks задает весь трек-лист; refs уже пронумерованы сервисом
+func (r *Repository) ReplaceAlbumTracks(albumID, userID int, refs []AlbumTrackRef) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockArtistAlbums(tx, albumID, userID); err != nil {
+		return err
+	}
+
+	trackIDs := make([]int, len(refs))
+	discs := make([]int, len(refs))
+	numbers := make([]int, len(refs))
+	for i, ref := range refs {
+		trackIDs[i], discs[i], numbers[i] = ref.TrackID, ref.DiscNumber, ref.TrackNumber
+	}
+
+	compilation, err := albumIsCompilation(tx, albumID)
+	if err != nil {
+		return err
+	}
+	ok, err := checkTracksAvailability(tx, trackIDs, userID, albumID, compilation, true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errorspkg.ErrTracksUnavailable
+	}
+
+	if _, err := tx.Exec("DELETE FROM tracks_albums WHERE album_id = $1", albumID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO tracks_albums (album_id, track_id, disc_number, track_number)
+		SELECT $1, t.id, t.disc, t.num FROM UNNEST($2::int[], $3::int[], $4::int[]) AS t(id, disc, num)`,
+		albumID, pq.Array(trackIDs), pq.Array(discs), pq.Array(numbers))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Repository) DeleteAlbum(albumID, userID int) error {
+	query := `
+        DELETE FROM albums
+        WHERE id = $1
+        AND author_id = $2
+    `
+	res, err := r.db.Exec(query, albumID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errorspkg.ErrAlbumNotFound
+	}
+	return nil
+}
+
+// func (r *Repository) ToggleAlbumVisibility(albumID, userID int) error {
+//     query := `
+//         UPDATE albums
+//         SET is_hidden = NOT is_hidden
+//         WHERE id = $1
+//         AND author_id = $2
+//     `
+//     res, err := r.db.Exec(query, albumID, userID)
+//     return checkAffected(res, err)
+// }
+
+func (r *Repository) GetAvailableTracks(userID int) ([]*Track, error) {
+	query := `
+        SELECT t.id, t.title, t.duration 
+        FROM tracks t
+        LEFT JOIN tracks_albums ta ON t.id = ta.track_id
+        LEFT JOIN albums a ON ta.album_id = a.id AND a.author_id = $1
+        WHERE t.author_id = $1 AND a.id IS NULL
+    `
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tracks []*Track
+	for rows.Next() {
+		var track Track
+		if err := rows.Scan(
+			&track.ID,
+			&track.Title,
+			&track.Duration,
+		); err != nil {
+			fmt.Println(err)
+			return nil, err
 		}
 		tracks = append(tracks, &track)
 	}
@@ -1231,7 +2138,8 @@ func (r *Repository) GetAvailableTracks(userID int) ([]*Track, error) {
 
 func (r *Repository) GetAlbumsByAuthor(userID int) ([]*Album, error) {
 	query := `
-        SELECT id, title, description, release_date, is_announced
+        SELECT id, title, description, release_date, is_announced,
+               release_type, label, COALESCE(upc, ''), p_line, c_line, explicit, language
         FROM albums 
         WHERE author_id = $1 AND is_announced = true
         ORDER BY release_date DESC
@@ -1252,6 +2160,13 @@ func (r *Repository) GetAlbumsByAuthor(userID int) ([]*Album, error) {
 			&album.Description,
 			&album.Release_Date,
 			&album.Is_Announced,
+			&album.ReleaseType,
+			&album.Label,
+			&album.UPC,
+			&album.PLine,
+			&album.CLine,
+			&album.Explicit,
+			&album.Language,
 		); err != nil {
 			return nil, err
 		}
@@ -1260,24 +2175,23 @@ func (r *Repository) GetAlbumsByAuthor(userID int) ([]*Album, error) {
 	return albums, nil
 }
 
-func (r *Repository) GetAlbumWithTracks(albumID int) (*Album, error) {
+// GetAlbumWithTracks отдает опубликованный или анонсированный альбом; треки видны только после публикации
+func (r *Repository) GetAlbumWithTracks(albumID, viewerID int) (*Album, error) {
 	// Получение базовой информации об альбоме
 	albumQuery := `
         SELECT a.id, a.title, a.description, a.release_date, a.is_announced,
-               u.id, u.username
+               u.id, u.username, a.cover_updated_at, a.published_at IS NOT NULL,
+               EXISTS (SELECT 1 FROM album_saves s WHERE s.album_id = a.id AND s.user_id = $2),
+               EXISTS (SELECT 1 FROM album_presaves ps WHERE ps.album_id = a.id AND ps.user_id = $2),
+               a.release_type, a.label, COALESCE(a.upc, ''), a.p_line, a.c_line, a.explicit, a.language
         FROM albums a
         JOIN users u ON a.author_id = u.id
-        WHERE a.id = $1 AND (
-    a.release_date < NOW()
-    OR (
-      a.release_date > NOW()
-      AND a.is_announced = true
-    )
-  );
+        WHERE a.id = $1 AND (a.published_at IS NOT NULL OR a.is_announced = true);
     `
 
 	var album Album
-	err := r.db.QueryRow(albumQuery, albumID).Scan(
+	var coverUpdatedAt sql.NullTime
+	err := r.db.QueryRow(albumQuery, albumID, viewerID).Scan(
 		&album.ID,
 		&album.Title,
 		&album.Description,
@@ -1285,23 +2199,39 @@ func (r *Repository) GetAlbumWithTracks(albumID int) (*Album, error) {
 		&album.Is_Announced,
 		&album.Author.ID,
 		&album.Author.Username,
+		&coverUpdatedAt,
+		&album.Is_Released,
+		&album.Is_Saved,
+		&album.Is_Presaved,
+		&album.ReleaseType,
+		&album.Label,
+		&album.UPC,
+		&album.PLine,
+		&album.CLine,
+		&album.Explicit,
+		&album.Language,
 	)
 
 	if err != nil {
 		fmt.Println(err)
 		return nil, err
 	}
+	if coverUpdatedAt.Valid {
+		album.Avatar = albumCoverURL(album.ID, coverUpdatedAt.Time)
+	}
 
 	// Получение треков альбома
 	tracksQuery := `
         SELECT t.id, t.title, t.duration,
-       u.id, u.username
+       u.id, u.username, ta.disc_number, ta.track_number,
+       COALESCE(t.isrc, ''), t.explicit, t.language
 FROM tracks t
 JOIN tracks_albums ta ON t.id = ta.track_id
 JOIN users u ON t.author_id = u.id
 JOIN albums a ON ta.album_id = a.id
 WHERE ta.album_id = $1
-  AND a.release_date < NOW();
+  AND a.published_at IS NOT NULL
+ORDER BY ta.disc_number, ta.track_number;
     `
 
 	rows, err := r.db.Query(tracksQuery, albumID)
@@ -1319,6 +2249,11 @@ WHERE ta.album_id = $1
 			&track.Duration,
 			&track.Author.ID,
 			&track.Author.Username,
+			&track.DiscNumber,
+			&track.TrackNumber,
+			&track.ISRC,
+			&track.Explicit,
+			&track.Language,
 		); err != nil {
 			fmt.Println(err)
 			return nil, err
@@ -1326,6 +2261,9 @@ WHERE ta.album_id = $1
 		album.Tracks = append(album.Tracks, track)
 	}
 
+	if err := r.attachCredits(album.Tracks); err != nil {
+		return nil, err
+	}
 	return &album, nil
 }
 
@@ -1346,7 +2284,7 @@ first_listens AS (
         listener_id,
         total_listen_time
     FROM track_listens
-    WHERE track_id = $1
+    WHERE track_id = $1 AND NOT is_imported
       AND created_at >= NOW() - INTERVAL '1 month' * $2
     ORDER BY listener_id, created_at
 ),
@@ -1541,7 +2479,7 @@ func (r *Repository) GetGeography(trackID int, period string) (*GeographyData, e
 	topQuery := `
         SELECT country, COUNT(DISTINCT listener_id) AS listeners
         FROM track_listens
-        WHERE track_id = $1 AND created_at >= NOW() - INTERVAL '1 month' * $2
+        WHERE track_id = $1 AND NOT is_imported AND created_at >= NOW() - INTERVAL '1 month' * $2
         GROUP BY country
         ORDER BY listeners DESC
         LIMIT 10
@@ -1565,7 +2503,7 @@ func (r *Repository) GetGeography(trackID int, period string) (*GeographyData, e
 	mapQuery := `
         SELECT country, COUNT(DISTINCT listener_id) AS listeners
         FROM track_listens
-        WHERE track_id = $1 AND created_at >= NOW() - INTERVAL '1 month' * $2
+        WHERE track_id = $1 AND NOT is_imported AND created_at >= NOW() - INTERVAL '1 month' * $2
         GROUP BY country
     `
 	rows, err = r.db.Query(mapQuery, trackID, months)
@@ -1584,3 +2522,1572 @@ func (r *Repository) GetGeography(trackID int, period string) (*GeographyData, e
 
 	return data, nil
 }
+
+// GetTwoFactorState возвращает, включена ли у пользователя 2FA и обязательна ли она для его роли
+func (r *Repository) GetTwoFactorState(userID int) (bool, bool, error) {
+	query := `
+		SELECT u.totp_enabled, COALESCE(p.required, false)
+		FROM users u
+		LEFT JOIN role_2fa_policies p ON p.role = u.role
+		WHERE u.id = $1`
+	var enabled, required bool
+	err := r.db.QueryRow(query, userID).Scan(&enabled, &required)
+	return enabled, required, err
+}
+
+func (r *Repository) GetPrivacySettings(userID int) (privacy.Settings, error) {
+	var settings privacy.Settings
+	query := "SELECT private_profile, hide_follows, private_history, playlists_followers_only FROM users WHERE id = $1"
+	err := r.db.QueryRow(query, userID).Scan(&settings.PrivateProfile, &settings.HideFollows, &settings.PrivateHistory, &settings.PlaylistsFollowersOnly)
+	return settings, err
+}
+
+func (r *Repository) IsFollowing(followerID, followedID int) (bool, error) {
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM follows WHERE following_user_id = $1 AND followed_user_id = $2)"
+	err := r.db.QueryRow(query, followerID, followedID).Scan(&exists)
+	return exists, err
+}
+
+func (r *Repository) GetArtistProfile(userID int) (*ArtistProfile, error) {
+	query := `
+		SELECT u.id, u.username, u.avatar, u.role, u.private_profile,
+			COALESCE(ap.bio, ''), COALESCE(ap.location, ''), COALESCE(ap.links, '[]'), COALESCE(ap.genres, '{}'),
+			COALESCE(ap.has_banner, false), COALESCE(ap.is_verified, false), ap.verified_at
+		FROM users u
+		LEFT JOIN artist_profiles ap ON ap.user_id = u.id
+		WHERE u.id = $1`
+
+	var profile ArtistProfile
+	var links []byte
+	var hasBanner bool
+	err := r.db.QueryRow(query, userID).Scan(
+		&profile.ID, &profile.Username, &profile.Avatar, &profile.Role, &profile.IsPrivate,
+		&profile.Bio, &profile.Location, &links, pq.Array(&profile.Genres),
+		&hasBanner, &profile.IsVerified, &profile.VerifiedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(links, &profile.Links); err != nil {
+		return nil, err
+	}
+	if hasBanner {
+		profile.BannerURL = fmt.Sprintf("/images/%d/banner", userID)
+	}
+	return &profile, nil
+}
+
+// GetArtistStats - слушатели за месяц считаются как уникальные авторизованные слушатели за 28 дней
+func (r *Repository) GetArtistStats(userID int) (*ArtistStats, error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM follows WHERE followed_user_id = $1),
+			(SELECT COUNT(*) FROM tracks WHERE author_id = $1 AND is_blocked = false),
+			(SELECT COUNT(*) FROM albums WHERE author_id = $1),
+			(SELECT COUNT(DISTINCT tl.listener_id)
+				FROM track_listens tl JOIN tracks t ON t.id = tl.track_id
+				WHERE t.author_id = $1 AND NOT tl.is_imported AND tl.created_at >= NOW() - INTERVAL '28 days')`
+
+	var stats ArtistStats
+	err := r.db.QueryRow(query, userID).Scan(&stats.Followers, &stats.Tracks, &stats.Albums, &stats.MonthlyListeners)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func (r *Repository) UpsertArtistProfile(userID int, req UpdateArtistProfileRequest) error {
+	links, err := json.Marshal(req.Links)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO artist_profiles (user_id, bio, location, links, genres, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET bio = EXCLUDED.bio, location = EXCLUDED.location, links = EXCLUDED.links,
+			genres = EXCLUDED.genres, updated_at = NOW()`
+	_, err = r.db.Exec(query, userID, req.Bio, req.Location, links, pq.Array(req.Genres))
+	return err
+}
+
+func (r *Repository) SetArtistBanner(userID int) error {
+	query := `
+		INSERT INTO artist_profiles (user_id, has_banner) VALUES ($1, true)
+		ON CONFLICT (user_id) DO UPDATE SET has_banner = true, updated_at = NOW()`
+	_, err := r.db.Exec(query, userID)
+	return err
+}
+
+func (r *Repository) CreateVerificationRequest(userID int, message string, links []ArtistLink) (int, error) {
+	linksJSON, err := json.Marshal(links)
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	err = r.db.QueryRow(
+		"INSERT INTO artist_verification_requests (user_id, message, links) VALUES ($1, $2, $3) RETURNING id",
+		userID, message, linksJSON,
+	).Scan(&id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return 0, errorspkg.ErrVerificationPending
+	}
+	return id, err
+}
+
+const verificationColumns = `
+	v.id, v.user_id, u.username, v.message, v.links, v.status, v.review_note, v.created_at, v.reviewed_at`
+
+func scanVerificationRequest(scanner interface{ Scan(...interface{}) error }) (*VerificationRequest, error) {
+	var req VerificationRequest
+	var links []byte
+	err := scanner.Scan(&req.ID, &req.UserID, &req.Username, &req.Message, &links, &req.Status, &req.ReviewNote, &req.CreatedAt, &req.ReviewedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(links, &req.Links); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (r *Repository) GetLatestVerificationRequest(userID int) (*VerificationRequest, error) {
+	query := `SELECT` + verificationColumns + `
+		FROM artist_verification_requests v JOIN users u ON u.id = v.user_id
+		WHERE v.user_id = $1
+		ORDER BY v.created_at DESC
+		LIMIT 1`
+	req, err := scanVerificationRequest(r.db.QueryRow(query, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return req, err
+}
+
+func (r *Repository) GetVerificationRequests(status string) ([]VerificationRequest, error) {
+	query := `SELECT` + verificationColumns + `
+		FROM artist_verification_requests v JOIN users u ON u.id = v.user_id
+		WHERE v.status = $1
+		ORDER BY v.created_at`
+	rows, err := r.db.Query(query, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []VerificationRequest
+	for rows.Next() {
+		req, err := scanVerificationRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *req)
+	}
+	return requests, rows.Err()
+}
+
+// ReviewVerificationRequest закрывает заявку; при одобрении артист получает отметку
+func (r *Repository) ReviewVerificationRequest(requestID, reviewerID int, approve bool, note string) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	status := "rejected"
+	if approve {
+		status = "approved"
+	}
+
+	var userID int
+	err = tx.QueryRow(`
+		UPDATE artist_verification_requests
+		SET status = $1, reviewer_id = $2, review_note = NULLIF($3, ''), reviewed_at = NOW()
+		WHERE id = $4 AND status = 'pending'
+		RETURNING user_id`, status, reviewerID, note, requestID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if approve {
+		if err := setVerified(tx, userID, true); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+func (r *Repository) RevokeVerification(userID int) error {
+	return setVerified(r.db, userID, false)
+}
+
+func setVerified(exec interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, userID int, verified bool) error {
+	_, err := exec.Exec(`
+		INSERT INTO artist_profiles (user_id, is_verified, verified_at)
+		VALUES ($1, $2, CASE WHEN $2 THEN NOW() END)
+		ON CONFLICT (user_id) DO UPDATE
+		SET is_verified = EXCLUDED.is_verified, verified_at = EXCLUDED.verified_at, updated_at = NOW()`, userID, verified)
+	return err
+}
+
+// GetTrackCredits возвращает участников нескольких треков одним запросом
+func (r *Repository) GetTrackCredits(trackIDs []int) (map[int][]TrackCredit, error) {
+	result := make(map[int][]TrackCredit)
+	if len(trackIDs) == 0 {
+		return result, nil
+	}
+
+	rows, err := r.db.Query(`
+		SELECT tc.track_id, tc.user_id, COALESCE(u.username, tc.name), COALESCE(u.avatar, ''), tc.role
+		FROM track_credits tc
+		LEFT JOIN users u ON u.id = tc.user_id
+		WHERE tc.track_id = ANY($1)
+		ORDER BY tc.track_id, tc.position, tc.id`, pq.Array(trackIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var trackID int
+		var userID sql.NullInt64
+		var credit TrackCredit
+		if err := rows.Scan(&trackID, &userID, &credit.Name, &credit.Avatar, &credit.Role); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			credit.UserID = &id
+		}
+		result[trackID] = append(result[trackID], credit)
+	}
+	return result, rows.Err()
+}
+
+func (r *Repository) attachCredits(tracks []Track) error {
+	ids := make([]int, len(tracks))
+	for i, t := range tracks {
+		ids[i] = t.ID
+	}
+	credits, err := r.GetTrackCredits(ids)
+	if err != nil {
+		return err
+	}
+	for i := range tracks {
+		tracks[i].Credits = credits[tracks[i].ID]
+	}
+	return nil
+}
+
+// ReplaceTrackCredits заменяет список участников; false, если трек не найден или принадлежит другому автору
+func (r *Repository) ReplaceTrackCredits(trackID, authorID int, credits []TrackCredit) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var ownerID int
+	err = tx.QueryRow("SELECT author_id FROM tracks WHERE id = $1 FOR UPDATE", trackID).Scan(&ownerID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && ownerID != authorID) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM track_credits WHERE track_id = $1", trackID); err != nil {
+		return false, err
+	}
+	for i, credit := range credits {
+		_, err := tx.Exec(`
+			INSERT INTO track_credits (track_id, user_id, name, role, position)
+			VALUES ($1, $2, $3, $4, $5)`,
+			trackID, credit.UserID, credit.Name, credit.Role, i)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// Итоги прослушиваний. Все выборки берут прослушивания пользователя за полуинтервал [start, end)
+
+func (r *Repository) GetListeningTotals(userID int, start, end time.Time) (listens, seconds int, err error) {
+	err = r.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(total_listen_time), 0)
+		FROM track_listens
+		WHERE listener_id = $1 AND created_at >= $2 AND created_at < $3`,
+		userID, start, end).Scan(&listens, &seconds)
+	return listens, seconds, err
+}
+
+func (r *Repository) GetReportTopTracks(userID int, start, end time.Time, limit int) ([]ReportTrack, error) {
+	rows, err := r.db.Query(`
+		SELECT t.id, t.title, u.id, u.username, COUNT(*), COALESCE(SUM(l.total_listen_time), 0) / 60
+		FROM track_listens l
+		JOIN tracks t ON t.id = l.track_id
+		JOIN users u ON u.id = t.author_id
+		WHERE l.listener_id = $1 AND l.created_at >= $2 AND l.created_at < $3
+		GROUP BY t.id, t.title, u.id, u.username
+		ORDER BY COUNT(*) DESC, SUM(l.total_listen_time) DESC
+		LIMIT $4`, userID, start, end, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []ReportTrack{}
+	for rows.Next() {
+		var t ReportTrack
+		if err := rows.Scan(&t.ID, &t.Title, &t.ArtistID, &t.Artist, &t.Listens, &t.Minutes); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
+
+func (r *Repository) GetReportTopArtists(userID int, start, end time.Time, limit int) ([]ReportArtist, error) {
+	return r.queryReportArtists(`
+		SELECT u.id, u.username, COUNT(*), COALESCE(SUM(l.total_listen_time), 0) / 60
+		FROM track_listens l
+		JOIN tracks t ON t.id = l.track_id
+		JOIN users u ON u.id = t.author_id
+		WHERE l.listener_id = $1 AND l.created_at >= $2 AND l.created_at < $3
+		GROUP BY u.id, u.username
+		ORDER BY COUNT(*) DESC, SUM(l.total_listen_time) DESC
+		LIMIT $4`, userID, start, end, limit)
+}
+
+// GetReportDiscoveries - артисты, которых пользователь впервые послушал в этом периоде
+func (r *Repository) GetReportDiscoveries(userID int, start, end time.Time, limit int) ([]ReportArtist, error) {
+	return r.queryReportArtists(`
+		SELECT u.id, u.username,
+		       COUNT(*) FILTER (WHERE l.created_at >= $2 AND l.created_at < $3),
+		       COALESCE(SUM(l.total_listen_time) FILTER (WHERE l.created_at >= $2 AND l.created_at < $3), 0) / 60
+		FROM track_listens l
+		JOIN tracks t ON t.id = l.track_id
+		JOIN users u ON u.id = t.author_id
+		WHERE l.listener_id = $1 AND l.created_at < $3
+		GROUP BY u.id, u.username
+		HAVING MIN(l.created_at) >= $2
+		ORDER BY 3 DESC
+		LIMIT $4`, userID, start, end, limit)
+}
+
+func (r *Repository) queryReportArtists(query string, args ...interface{}) ([]ReportArtist, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artists := []ReportArtist{}
+	for rows.Next() {
+		var a ReportArtist
+		if err := rows.Scan(&a.ID, &a.Username, &a.Listens, &a.Minutes); err != nil {
+			return nil, err
+		}
+		artists = append(artists, a)
+	}
+	return artists, rows.Err()
+}
+
+func (r *Repository) GetReportTopGenres(userID int, start, end time.Time, limit int) ([]ReportGenre, error) {
+	rows, err := r.db.Query(`
+		SELECT LOWER(t.genre), COUNT(*)
+		FROM track_listens l
+		JOIN tracks t ON t.id = l.track_id
+		WHERE l.listener_id = $1 AND l.created_at >= $2 AND l.created_at < $3 AND COALESCE(t.genre, '') <> ''
+		GROUP BY LOWER(t.genre)
+		ORDER BY COUNT(*) DESC
+		LIMIT $4`, userID, start, end, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []ReportGenre{}
+	for rows.Next() {
+		var g ReportGenre
+		if err := rows.Scan(&g.Genre, &g.Listens); err != nil {
+			return nil, err
+		}
+		genres = append(genres, g)
+	}
+	return genres, rows.Err()
+}
+
+// GetListeningDays возвращает дни с прослушиваниями по возрастанию
+func (r *Repository) GetListeningDays(userID int, start, end time.Time) ([]time.Time, error) {
+	rows, err := r.db.Query(`
+		SELECT DISTINCT created_at::date
+		FROM track_listens
+		WHERE listener_id = $1 AND created_at >= $2 AND created_at < $3
+		ORDER BY 1`, userID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []time.Time
+	for rows.Next() {
+		var d time.Time
+		if err := rows.Scan(&d); err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
+func (r *Repository) GetMostActiveHour(userID int, start, end time.Time) (int, error) {
+	var hour int
+	err := r.db.QueryRow(`
+		SELECT EXTRACT(HOUR FROM created_at)::int
+		FROM track_listens
+		WHERE listener_id = $1 AND created_at >= $2 AND created_at < $3
+		GROUP BY 1
+		ORDER BY COUNT(*) DESC, 1
+		LIMIT 1`, userID, start, end).Scan(&hour)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return hour, err
+}
+
+// GetNewTrackCount - треки, впервые прослушанные в этом периоде
+func (r *Repository) GetNewTrackCount(userID int, start, end time.Time) (int, error) {
+	var count int
+	err := r.db.QueryRow(`
+		SELECT COUNT(*) FROM (
+			SELECT track_id
+			FROM track_listens
+			WHERE listener_id = $1 AND created_at < $3
+			GROUP BY track_id
+			HAVING MIN(created_at) >= $2
+		) n`, userID, start, end).Scan(&count)
+	return count, err
+}
+
+func (r *Repository) GetNewArtistCount(userID int, start, end time.Time) (int, error) {
+	var count int
+	err := r.db.QueryRow(`
+		SELECT COUNT(*) FROM (
+			SELECT t.author_id
+			FROM track_listens l
+			JOIN tracks t ON t.id = l.track_id
+			WHERE l.listener_id = $1 AND l.created_at < $3
+			GROUP BY t.author_id
+			HAVING MIN(l.created_at) >= $2
+		) n`, userID, start, end).Scan(&count)
+	return count, err
+}
+
+// getAudioProfile усредняет признаки по прослушиваниям; ok = false, если ни у одного трека нет признаков
+func (r *Repository) getAudioProfile(userID int, start, end time.Time) (profile audioProfile, ok bool, err error) {
+	var tempo, rmse, centroid, zcr sql.NullFloat64
+	err = r.db.QueryRow(`
+		SELECT AVG(t.tempo_bpm), AVG(t.rmse_mean), AVG(t.spectral_centroid), AVG(t.zero_crossing_rate),
+		       COUNT(DISTINCT LOWER(NULLIF(t.genre, '')))
+		FROM track_listens l
+		JOIN tracks t ON t.id = l.track_id
+		WHERE l.listener_id = $1 AND l.created_at >= $2 AND l.created_at < $3`,
+		userID, start, end).Scan(&tempo, &rmse, &centroid, &zcr, &profile.Genres)
+	if err != nil || !tempo.Valid {
+		return profile, false, err
+	}
+	profile.TempoBPM = tempo.Float64
+	profile.RMSEMean = rmse.Float64
+	profile.SpectralCentroid = centroid.Float64
+	profile.ZeroCrossingRate = zcr.Float64
+	return profile, true, nil
+}
+
+// GetUsersWithoutReport - слушатели периода, для которых итоги еще не посчитаны
+func (r *Repository) GetUsersWithoutReport(period string, start, end time.Time) ([]int, error) {
+	rows, err := r.db.Query(`
+		SELECT DISTINCT l.listener_id
+		FROM track_listens l
+		WHERE l.listener_id IS NOT NULL AND l.created_at >= $2 AND l.created_at < $3
+		  AND NOT EXISTS (
+			SELECT 1 FROM listening_reports lr
+			WHERE lr.user_id = l.listener_id AND lr.period = $1 AND lr.period_start = $2::date
+		  )`, period, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *Repository) SaveListeningReport(report *ListeningReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(`
+		INSERT INTO listening_reports (user_id, period, period_start, data, generated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, period, period_start)
+		DO UPDATE SET data = EXCLUDED.data, generated_at = EXCLUDED.generated_at`,
+		report.UserID, report.Period, report.PeriodStart, data, report.GeneratedAt)
+	return err
+}
+
+func (r *Repository) GetListeningReport(userID int, period string, start time.Time) (*ListeningReport, error) {
+	var data []byte
+	err := r.db.QueryRow(`
+		SELECT data FROM listening_reports
+		WHERE user_id = $1 AND period = $2 AND period_start = $3::date`,
+		userID, period, start).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var report ListeningReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (r *Repository) GetListeningReports(userID int) ([]ReportSummary, error) {
+	rows, err := r.db.Query(`
+		SELECT period, data->>'key', period_start, COALESCE((data->>'total_minutes')::int, 0), generated_at
+		FROM listening_reports
+		WHERE user_id = $1
+		ORDER BY period_start DESC, period DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []ReportSummary{}
+	for rows.Next() {
+		var s ReportSummary
+		if err := rows.Scan(&s.Period, &s.Key, &s.PeriodStart, &s.TotalMinutes, &s.GeneratedAt); err != nil {
+			return nil, err
+		}
+		reports = append(reports, s)
+	}
+	return reports, rows.Err()
+}
+
+// Скробблинг
+
+func (r *Repository) SaveScrobbleConnection(userID int, token, externalUsername string) error {
+	_, err := r.db.Exec(`
+		INSERT INTO scrobble_connections (user_id, token, external_username)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET token = EXCLUDED.token, external_username = EXCLUDED.external_username,
+		    enabled = TRUE, last_error = NULL`,
+		userID, token, externalUsername)
+	return err
+}
+
+// DeleteScrobbleConnection удаляет подключение вместе с неотправленной очередью
+func (r *Repository) DeleteScrobbleConnection(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM scrobble_queue WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM scrobble_connections WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Repository) GetScrobbleConnection(userID int) (*ScrobbleConnection, error) {
+	var c ScrobbleConnection
+	var lastError sql.NullString
+	err := r.db.QueryRow(`
+		SELECT sc.service, sc.external_username, sc.enabled, sc.last_error, sc.last_submitted_at, sc.created_at,
+		       (SELECT COUNT(*) FROM scrobble_queue q WHERE q.user_id = sc.user_id AND q.status = 'pending'),
+		       (SELECT COUNT(*) FROM scrobble_queue q WHERE q.user_id = sc.user_id AND q.status = 'failed')
+		FROM scrobble_connections sc
+		WHERE sc.user_id = $1`, userID).Scan(
+		&c.Service, &c.ExternalUsername, &c.Enabled, &lastError, &c.LastSubmittedAt, &c.CreatedAt,
+		&c.Pending, &c.Failed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.LastError = lastError.String
+	return &c, nil
+}
+
+// EnqueueScrobble ставит прослушивание в очередь, только если у пользователя включен скробблинг
+func (r *Repository) EnqueueScrobble(userID, listenID int, payload []byte) error {
+	_, err := r.db.Exec(`
+		INSERT INTO scrobble_queue (user_id, listen_id, payload)
+		SELECT $1, $2, $3
+		WHERE EXISTS (SELECT 1 FROM scrobble_connections WHERE user_id = $1 AND enabled)
+		ON CONFLICT (listen_id) DO NOTHING`,
+		userID, listenID, payload)
+	return err
+}
+
+func (r *Repository) IsScrobblingEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := r.db.QueryRow("SELECT EXISTS (SELECT 1 FROM scrobble_connections WHERE user_id = $1 AND enabled)", userID).Scan(&enabled)
+	return enabled, err
+}
+
+// ClaimScrobbles забирает готовые к отправке записи и откладывает их на lease,
+// чтобы параллельный воркер не взял их повторно, пока идет отправка
+func (r *Repository) ClaimScrobbles(limit int, lease time.Duration) ([]queuedScrobble, error) {
+	rows, err := r.db.Query(`
+		UPDATE scrobble_queue q
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		FROM scrobble_connections sc
+		WHERE q.id IN (
+			SELECT q2.id FROM scrobble_queue q2
+			JOIN scrobble_connections sc2 ON sc2.user_id = q2.user_id AND sc2.enabled
+			WHERE q2.status = 'pending' AND q2.next_attempt_at <= NOW()
+			ORDER BY q2.user_id, q2.id
+			LIMIT $1
+			FOR UPDATE OF q2 SKIP LOCKED
+		) AND sc.user_id = q.user_id
+		RETURNING q.id, q.user_id, sc.token, q.attempts, q.payload`,
+		limit, int(lease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []queuedScrobble
+	for rows.Next() {
+		var q queuedScrobble
+		if err := rows.Scan(&q.ID, &q.UserID, &q.Token, &q.Attempts, &q.Payload); err != nil {
+			return nil, err
+		}
+		batch = append(batch, q)
+	}
+	return batch, rows.Err()
+}
+
+func (r *Repository) MarkScrobblesSent(userID int, ids []int) error {
+	if _, err := r.db.Exec(`
+		UPDATE scrobble_queue SET status = 'sent', attempts = attempts + 1, sent_at = NOW(), last_error = NULL
+		WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return err
+	}
+	_, err := r.db.Exec("UPDATE scrobble_connections SET last_submitted_at = NOW(), last_error = NULL WHERE user_id = $1", userID)
+	return err
+}
+
+// RetryScrobble откладывает запись; после maxAttempts она помечается failed
+func (r *Repository) RetryScrobble(id, attempts, maxAttempts int, nextAttempt time.Time, reason string) error {
+	status := "pending"
+	if attempts >= maxAttempts {
+		status = "failed"
+	}
+	_, err := r.db.Exec(`
+		UPDATE scrobble_queue SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4
+		WHERE id = $5`, status, attempts, nextAttempt, reason, id)
+	return err
+}
+
+func (r *Repository) FailScrobbles(ids []int, reason string) error {
+	_, err := r.db.Exec(`
+		UPDATE scrobble_queue SET status = 'failed', attempts = attempts + 1, last_error = $2
+		WHERE id = ANY($1)`, pq.Array(ids), reason)
+	return err
+}
+
+// DisableScrobbleConnection выключает скробблинг, когда токен перестал действовать
+func (r *Repository) DisableScrobbleConnection(userID int, reason string) error {
+	_, err := r.db.Exec("UPDATE scrobble_connections SET enabled = FALSE, last_error = $2 WHERE user_id = $1", userID, reason)
+	return err
+}
+
+// GetScrobbleListens - прослушивания пользователя для выгрузки, от старых к новым
+func (r *Repository) GetScrobbleListens(userID int, from, to time.Time) ([]ScrobbleListen, error) {
+	rows, err := r.db.Query(`
+		SELECT t.id, t.title, u.username, COALESCE(al.title, ''), COALESCE(t.duration, 0),
+		       COALESCE(l.total_listen_time, 0), l.created_at
+		FROM track_listens l
+		JOIN tracks t ON t.id = l.track_id
+		JOIN users u ON u.id = t.author_id
+		LEFT JOIN LATERAL (
+			SELECT a.title FROM tracks_albums ta
+			JOIN albums a ON a.id = ta.album_id
+			WHERE ta.track_id = t.id
+			ORDER BY a.release_type = 'compilation', a.release_date
+			LIMIT 1
+		) al ON TRUE
+		WHERE l.listener_id = $1 AND NOT l.is_imported AND l.created_at >= $2 AND l.created_at < $3
+		ORDER BY l.created_at`, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var listens []ScrobbleListen
+	for rows.Next() {
+		var l ScrobbleListen
+		if err := rows.Scan(&l.TrackID, &l.Title, &l.Artist, &l.Album, &l.Duration, &l.ListenTime, &l.ListenedAt); err != nil {
+			return nil, err
+		}
+		listens = append(listens, l)
+	}
+	return listens, rows.Err()
+}
+
+// Импорт истории прослушиваний
+
+func (r *Repository) CreateListeningImport(userID int, source, fileName string, total int) (int, error) {
+	var id int
+	err := r.db.QueryRow(`
+		INSERT INTO listening_imports (user_id, source, file_name, total)
+		VALUES ($1, $2, $3, $4) RETURNING id`, userID, source, fileName, total).Scan(&id)
+	return id, err
+}
+
+// artistKeySQL повторяет historyimport.ArtistKey на стороне БД; по этому же выражению
+// построены индексы в миграции 0028
+func artistKeySQL(column string) string {
+	return fmt.Sprintf(`LEFT(REGEXP_REPLACE(LOWER(TRANSLATE(%s, 'Ёё', 'ее')), '[^[:alnum:]]', '', 'g'), %d)`, column, historyimport.ArtistKeyLength)
+}
+
+// GetImportCandidates - доступные треки с автором и участниками для сопоставления: только те,
+// у кого ключ одного из артистов есть в artistKeys (historyimport.ArtistKeys), и треки trackIDs
+func (r *Repository) GetImportCandidates(artistKeys []string, trackIDs []int) ([]historyimport.Candidate, error) {
+	rows, err := r.db.Query(`
+		WITH matched AS (
+			SELECT t.id FROM tracks t JOIN users u ON u.id = t.author_id
+			WHERE `+artistKeySQL("u.username")+` = ANY($1)
+			UNION
+			SELECT tc.track_id FROM track_credits tc
+			LEFT JOIN users cu ON cu.id = tc.user_id
+			WHERE tc.role IN ('primary', 'featured', 'remixer')
+			  AND (`+artistKeySQL("tc.name")+` = ANY($1) OR `+artistKeySQL("cu.username")+` = ANY($1))
+			UNION
+			SELECT UNNEST($2::int[])
+		)
+		SELECT t.id, t.title, COALESCE(t.duration, 0),
+		       ARRAY_PREPEND(u.username, ARRAY(
+		           SELECT COALESCE(cu.username, tc.name)
+		           FROM track_credits tc
+		           LEFT JOIN users cu ON cu.id = tc.user_id
+		           WHERE tc.track_id = t.id AND tc.role IN ('primary', 'featured', 'remixer')
+		       ))
+		FROM tracks t
+		JOIN users u ON u.id = t.author_id
+		WHERE t.is_blocked = false AND t.id IN (SELECT id FROM matched)`,
+		pq.Array(artistKeys), pq.Array(trackIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []historyimport.Candidate
+	for rows.Next() {
+		var c historyimport.Candidate
+		if err := rows.Scan(&c.TrackID, &c.Title, &c.Duration, pq.Array(&c.Artists)); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// InsertImportedListens пишет пачку прослушиваний; уже импортированные ранее пропускаются
+func (r *Repository) InsertImportedListens(userID, importID int, source string, listens []ImportedListen) (int, error) {
+	trackIDs := make([]int, len(listens))
+	seconds := make([]int, len(listens))
+	playedAt := make([]string, len(listens))
+	for i, l := range listens {
+		trackIDs[i] = l.TrackID
+		seconds[i] = l.ListenTime
+		playedAt[i] = l.PlayedAt.UTC().Format("2006-01-02 15:04:05")
+	}
+
+	res, err := r.db.Exec(`
+		INSERT INTO track_listens (listener_id, track_id, country, device, total_listen_time, created_at, is_imported, import_id)
+		SELECT $1, x.track_id, '', $2, x.seconds, x.played_at, TRUE, $3
+		FROM UNNEST($4::int[], $5::int[], $6::timestamp[]) AS x(track_id, seconds, played_at)
+		ON CONFLICT (listener_id, track_id, created_at) WHERE is_imported DO NOTHING`,
+		userID, source, importID, pq.Array(trackIDs), pq.Array(seconds), pq.Array(playedAt))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *Repository) FinishListeningImport(imp *ListeningImport) error {
+	sample, err := json.Marshal(imp.UnmatchedSample)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(`
+		UPDATE listening_imports
+		SET status = $2, matched = $3, imported = $4, skipped = $5, unmatched = $6,
+		    unmatched_sample = $7, error = NULLIF($8, ''), finished_at = NOW()
+		WHERE id = $1`,
+		imp.ID, imp.Status, imp.Matched, imp.Imported, imp.Skipped, imp.Unmatched, sample, imp.Error)
+	return err
+}
+
+// FailStaleImports помечает импорты, прерванные перезапуском сервиса
+func (r *Repository) FailStaleImports() error {
+	_, err := r.db.Exec(`
+		UPDATE listening_imports SET status = 'failed', error = 'импорт прерван перезапуском сервиса', finished_at = NOW()
+		WHERE status = 'processing'`)
+	return err
+}
+
+const listeningImportColumns = `id, source, file_name, status, total, matched, imported, skipped, unmatched,
+	unmatched_sample, COALESCE(error, ''), created_at, finished_at`
+
+func scanListeningImport(scanner interface{ Scan(...interface{}) error }) (*ListeningImport, error) {
+	var imp ListeningImport
+	var sample []byte
+	err := scanner.Scan(&imp.ID, &imp.Source, &imp.FileName, &imp.Status, &imp.Total, &imp.Matched, &imp.Imported,
+		&imp.Skipped, &imp.Unmatched, &sample, &imp.Error, &imp.CreatedAt, &imp.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(sample, &imp.UnmatchedSample); err != nil {
+		return nil, err
+	}
+	return &imp, nil
+}
+
+func (r *Repository) GetListeningImport(importID, userID int) (*ListeningImport, error) {
+	imp, err := scanListeningImport(r.db.QueryRow(
+		"SELECT "+listeningImportColumns+" FROM listening_imports WHERE id = $1 AND user_id = $2", importID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return imp, err
+}
+
+func (r *Repository) GetListeningImports(userID int) ([]ListeningImport, error) {
+	rows, err := r.db.Query("SELECT "+listeningImportColumns+" FROM listening_imports WHERE user_id = $1 ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imports := []ListeningImport{}
+	for rows.Next() {
+		imp, err := scanListeningImport(rows)
+		if err != nil {
+			return nil, err
+		}
+		// Список без примеров, они есть в карточке импорта
+		imp.UnmatchedSample = nil
+		imports = append(imports, *imp)
+	}
+	return imports, rows.Err()
+}
+
+// DeleteListeningImport удаляет импорт; его прослушивания удаляются каскадом
+func (r *Repository) DeleteListeningImport(importID, userID int) (bool, error) {
+	res, err := r.db.Exec("DELETE FROM listening_imports WHERE id = $1 AND user_id = $2 AND status <> 'processing'", importID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetPlaylistRole возвращает роль пользователя: автор - owner, не участник - пустая строка
+func (r *Repository) GetPlaylistRole(playlistID, userID int) (string, error) {
+	var role string
+	err := r.db.QueryRow(`
+		SELECT CASE WHEN p.author_id = $2 THEN 'owner' ELSE COALESCE(m.role, '') END
+		FROM playlists p
+		LEFT JOIN playlist_members m ON m.playlist_id = p.id AND m.user_id = $2
+		WHERE p.id = $1`, playlistID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", errorspkg.ErrPlaylistNotFound
+	}
+	return role, err
+}
+
+// GetPlaylistMembers возвращает автора первым, затем участников в порядке вступления
+func (r *Repository) GetPlaylistMembers(playlistID int) ([]PlaylistMember, error) {
+	rows, err := r.db.Query(`
+		SELECT id, username, avatar, role, created_at FROM (
+			SELECT u.id, u.username, u.avatar, 'owner' AS role, p.created_at, TRUE AS is_author
+			FROM playlists p JOIN users u ON u.id = p.author_id
+			WHERE p.id = $1
+			UNION ALL
+			SELECT u.id, u.username, u.avatar, m.role, m.created_at, FALSE
+			FROM playlist_members m JOIN users u ON u.id = m.user_id
+			WHERE m.playlist_id = $1
+		) members
+		ORDER BY is_author DESC, created_at`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []PlaylistMember{}
+	for rows.Next() {
+		var m PlaylistMember
+		if err := rows.Scan(&m.User.ID, &m.User.Username, &m.User.Avatar, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (r *Repository) SetPlaylistMemberRole(playlistID, userID int, role string) (bool, error) {
+	res, err := r.db.Exec("UPDATE playlist_members SET role = $3 WHERE playlist_id = $1 AND user_id = $2", playlistID, userID, role)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *Repository) RemovePlaylistMember(playlistID, userID int) (bool, error) {
+	res, err := r.db.Exec("DELETE FROM playlist_members WHERE playlist_id = $1 AND user_id = $2", playlistID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *Repository) CreatePlaylistInvite(playlistID, createdBy int, role, tokenHash string, maxUses *int, expiresAt *time.Time) (*PlaylistInvite, error) {
+	invite := PlaylistInvite{Role: role, MaxUses: maxUses, ExpiresAt: expiresAt}
+	err := r.db.QueryRow(`
+		INSERT INTO playlist_invites (playlist_id, token_hash, role, created_by, max_uses, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`,
+		playlistID, tokenHash, role, createdBy, maxUses, expiresAt).Scan(&invite.ID, &invite.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// GetPlaylistInvites возвращает действующие приглашения
+func (r *Repository) GetPlaylistInvites(playlistID int) ([]PlaylistInvite, error) {
+	rows, err := r.db.Query(`
+		SELECT id, role, max_uses, uses, expires_at, created_at
+		FROM playlist_invites
+		WHERE playlist_id = $1 AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		  AND (max_uses IS NULL OR uses < max_uses)
+		ORDER BY created_at DESC`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []PlaylistInvite{}
+	for rows.Next() {
+		var inv PlaylistInvite
+		var maxUses sql.NullInt64
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&inv.ID, &inv.Role, &maxUses, &inv.Uses, &expiresAt, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		if maxUses.Valid {
+			v := int(maxUses.Int64)
+			inv.MaxUses = &v
+		}
+		if expiresAt.Valid {
+			inv.ExpiresAt = &expiresAt.Time
+		}
+		invites = append(invites, inv)
+	}
+	return invites, rows.Err()
+}
+
+func (r *Repository) RevokePlaylistInvite(playlistID, inviteID int) (bool, error) {
+	res, err := r.db.Exec("UPDATE playlist_invites SET revoked_at = NOW() WHERE id = $1 AND playlist_id = $2 AND revoked_at IS NULL", inviteID, playlistID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// AcceptPlaylistInvite добавляет пользователя в плейлист. Приглашение может только повысить роль
+// (viewer -> editor); использование засчитывается, если роль изменилась
+func (r *Repository) AcceptPlaylistInvite(tokenHash string, userID int) (int, string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	var inviteID, playlistID, authorID int
+	var role string
+	err = tx.QueryRow(`
+		SELECT i.id, i.playlist_id, i.role, p.author_id
+		FROM playlist_invites i
+		JOIN playlists p ON p.id = i.playlist_id
+		WHERE i.token_hash = $1 AND i.revoked_at IS NULL
+		  AND (i.expires_at IS NULL OR i.expires_at > NOW())
+		  AND (i.max_uses IS NULL OR i.uses < i.max_uses)
+		FOR UPDATE OF i`, tokenHash).Scan(&inviteID, &playlistID, &role, &authorID)
+	if err == sql.ErrNoRows {
+		return 0, "", errorspkg.ErrInvalidInvite
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if authorID == userID {
+		return playlistID, PlaylistOwner, nil
+	}
+
+	var blocked bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM user_blocks
+		               WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1))`,
+		authorID, userID).Scan(&blocked)
+	if err != nil {
+		return 0, "", err
+	}
+	if blocked {
+		return 0, "", errorspkg.ErrUserBlocked
+	}
+
+	var current string
+	err = tx.QueryRow("SELECT role FROM playlist_members WHERE playlist_id = $1 AND user_id = $2", playlistID, userID).Scan(&current)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec("INSERT INTO playlist_members (playlist_id, user_id, role) VALUES ($1, $2, $3)", playlistID, userID, role)
+	case err != nil:
+	case current == PlaylistViewer && role == PlaylistEditor:
+		_, err = tx.Exec("UPDATE playlist_members SET role = $3 WHERE playlist_id = $1 AND user_id = $2", playlistID, userID, role)
+	default:
+		return playlistID, current, nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	if _, err := tx.Exec("UPDATE playlist_invites SET uses = uses + 1 WHERE id = $1", inviteID); err != nil {
+		return 0, "", err
+	}
+	return playlistID, role, tx.Commit()
+}
+
+// GetPlaylistSummary возвращает только то, что нужно для проверки доступа: автора и видимость
+func (r *Repository) GetPlaylistSummary(playlistID int) (*Playlist, error) {
+	var p Playlist
+	err := r.db.QueryRow("SELECT id, title, author_id, visibility FROM playlists WHERE id = $1", playlistID).
+		Scan(&p.ID, &p.Title, &p.Author.ID, &p.Visibility)
+	if err == sql.ErrNoRows {
+		return nil, errorspkg.ErrPlaylistNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *Repository) SetPlaylistVisibility(playlistID, userID int, visibility string) error {
+	query := `UPDATE playlists SET visibility = $3 WHERE id = $1 AND ` + playlistOwnerCond
+	res, err := r.db.Exec(query, playlistID, userID, visibility)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errorspkg.ErrPlaylistNotFound
+	}
+	return nil
+}
+
+func (r *Repository) IsPlaylistFollowed(playlistID, userID int) (bool, error) {
+	var followed bool
+	err := r.db.QueryRow("SELECT EXISTS (SELECT 1 FROM playlist_follows WHERE playlist_id = $1 AND user_id = $2)", playlistID, userID).Scan(&followed)
+	return followed, err
+}
+
+func (r *Repository) GetPlaylistFollowersCount(playlistID int) (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM playlist_follows WHERE playlist_id = $1", playlistID).Scan(&count)
+	return count, err
+}
+
+// FollowPlaylist сохраняет плейлист в библиотеку; при блокировке между пользователем и автором - ErrUserBlocked
+func (r *Repository) FollowPlaylist(playlistID, userID int) error {
+	var blocked bool
+	err := r.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM user_blocks b JOIN playlists p ON p.id = $1
+			WHERE (b.blocker_id = p.author_id AND b.blocked_id = $2) OR (b.blocker_id = $2 AND b.blocked_id = p.author_id)
+		)`, playlistID, userID).Scan(&blocked)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return errorspkg.ErrUserBlocked
+	}
+
+	_, err = r.db.Exec("INSERT INTO playlist_follows (playlist_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", playlistID, userID)
+	return err
+}
+
+func (r *Repository) UnfollowPlaylist(playlistID, userID int) error {
+	_, err := r.db.Exec("DELETE FROM playlist_follows WHERE playlist_id = $1 AND user_id = $2", playlistID, userID)
+	return err
+}
+
+// GetLibraryPlaylists объединяет собственные, совместные и сохраненные плейлисты; последние добавленные первыми.
+// Сохраненный плейлист пропадает из библиотеки, пока он закрыт или недоступен по настройкам автора
+func (r *Repository) GetLibraryPlaylists(userID int) ([]Playlist, error) {
+	rows, err := r.db.Query(`
+		SELECT p.id, p.title, p.description, COALESCE(p.avatar, ''), p.visibility, p.created_at, p.updated_at,
+		       u.id, u.username,
+		       CASE WHEN p.author_id = $1 THEN 'owner' ELSE COALESCE(m.role, '') END,
+		       f.user_id IS NOT NULL,
+		       (SELECT COUNT(*) FROM playlist_follows pf WHERE pf.playlist_id = p.id)
+		FROM playlists p
+		JOIN users u ON u.id = p.author_id
+		LEFT JOIN playlist_members m ON m.playlist_id = p.id AND m.user_id = $1
+		LEFT JOIN playlist_follows f ON f.playlist_id = p.id AND f.user_id = $1
+		WHERE p.author_id = $1
+		   OR m.user_id IS NOT NULL
+		   OR (f.user_id IS NOT NULL AND p.visibility <> 'private'
+		       AND ((NOT u.private_profile AND NOT u.playlists_followers_only)
+		            OR EXISTS (SELECT 1 FROM follows fu WHERE fu.following_user_id = $1 AND fu.followed_user_id = u.id)))
+		ORDER BY CASE WHEN p.author_id = $1 THEN p.created_at
+		              WHEN m.user_id IS NOT NULL THEN m.created_at
+		              ELSE f.created_at END DESC, p.id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		var p Playlist
+		if err := rows.Scan(&p.ID, &p.Title, &p.Description, &p.Avatar, &p.Visibility, &p.CreatedAt, &p.UpdatedAt,
+			&p.Author.ID, &p.Author.Username, &p.Role, &p.IsFollowed, &p.Followers); err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, p)
+	}
+	return playlists, rows.Err()
+}
+
+func (r *Repository) AddSmartPlaylist(title, description, visibility string, rules []byte, refresh string, userID int) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var playlistID int
+	err = tx.QueryRow(`
+		INSERT INTO playlists (title, description, author_id, visibility, smart_rules, smart_refresh)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		title, description, userID, visibility, rules, refresh).Scan(&playlistID)
+	if err != nil {
+		return 0, err
+	}
+	if err := recordPlaylistRevision(tx, playlistID, userID, PlaylistChange{Action: ChangeCreate}); err != nil {
+		return 0, err
+	}
+	return playlistID, tx.Commit()
+}
+
+// SetSmartRules меняет правила; обычный плейлист так в умный не превратить
+func (r *Repository) SetSmartRules(playlistID, userID int, rules []byte, refresh string) error {
+	query := `UPDATE playlists SET smart_rules = $3, smart_refresh = $4, smart_refreshed_at = NULL
+		WHERE id = $1 AND smart_rules IS NOT NULL AND ` + playlistOwnerCond
+	res, err := r.db.Exec(query, playlistID, userID, rules, refresh)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errorspkg.ErrPlaylistNotFound
+	}
+	return nil
+}
+
+// SmartPlaylistState - то, что нужно для пересчета: правила вычисляются от лица автора
+type SmartPlaylistState struct {
+	AuthorID    int
+	Rules       []byte
+	Refresh     string
+	RefreshedAt *time.Time
+}
+
+// GetSmartPlaylistState возвращает nil для обычного плейлиста
+func (r *Repository) GetSmartPlaylistState(playlistID int) (*SmartPlaylistState, error) {
+	var st SmartPlaylistState
+	err := r.db.QueryRow(`
+		SELECT author_id, smart_rules, smart_refresh, smart_refreshed_at
+		FROM playlists WHERE id = $1 AND smart_rules IS NOT NULL`, playlistID).
+		Scan(&st.AuthorID, &st.Rules, &st.Refresh, &st.RefreshedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// GetDueSmartPlaylists возвращает плейлисты с пересчетом по расписанию, не обновлявшиеся с before
+func (r *Repository) GetDueSmartPlaylists(before time.Time, limit int) ([]int, error) {
+	rows, err := r.db.Query(`
+		SELECT id FROM playlists
+		WHERE smart_rules IS NOT NULL AND smart_refresh = 'scheduled'
+		  AND (smart_refreshed_at IS NULL OR smart_refreshed_at < $1)
+		ORDER BY smart_refreshed_at NULLS FIRST
+		LIMIT $2`, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RefreshSmartPlaylist выполняет скомпилированный запрос и сохраняет результат как состав плейлиста.
+// Ревизия растет, только если состав или порядок изменились
+func (r *Repository) RefreshSmartPlaylist(playlistID int, query string, args []interface{}) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int
+	err = tx.QueryRow("SELECT id FROM playlists WHERE id = $1 AND smart_rules IS NOT NULL FOR UPDATE", playlistID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return errorspkg.ErrPlaylistNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var current pq.Int64Array
+	err = tx.QueryRow("SELECT COALESCE(array_agg(track_id ORDER BY position), '{}') FROM tracks_playlists WHERE playlist_id = $1", playlistID).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	var trackIDs pq.Int64Array
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		trackIDs = append(trackIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	changed := len(trackIDs) != len(current)
+	for i := 0; !changed && i < len(trackIDs); i++ {
+		changed = trackIDs[i] != current[i]
+	}
+
+	if changed {
+		if _, err := tx.Exec("DELETE FROM tracks_playlists WHERE playlist_id = $1", playlistID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO tracks_playlists (playlist_id, track_id, position)
+			SELECT $1, t.id, t.ord FROM UNNEST($2::int[]) WITH ORDINALITY AS t(id, ord)`, playlistID, trackIDs)
+		if err != nil {
+			return err
+		}
+		if _, err := bumpPlaylistRevision(tx, playlistID, 0, PlaylistChange{Action: ChangeRefresh}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE playlists SET smart_refreshed_at = NOW() WHERE id = $1", playlistID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ImportPlaylist создает плейлист сразу с треками, чтобы неудачный импорт не оставлял пустой плейлист
+func (r *Repository) ImportPlaylist(title, description, visibility string, userID int, trackIDs []int) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var playlistID int
+	err = tx.QueryRow("INSERT INTO playlists (title, description, author_id, visibility) VALUES ($1, $2, $3, $4) RETURNING id",
+		title, description, userID, visibility).Scan(&playlistID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO tracks_playlists (playlist_id, track_id, position, added_by)
+		SELECT $1, t.id, t.ord, $3 FROM UNNEST($2::int[]) WITH ORDINALITY AS t(id, ord)`,
+		playlistID, pq.Array(trackIDs), userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := recordPlaylistRevision(tx, playlistID, userID, PlaylistChange{Action: ChangeImport, Added: len(trackIDs)}); err != nil {
+		return 0, err
+	}
+	return playlistID, tx.Commit()
+}
+
+// GetPlaylistRevisions возвращает историю от новых ревизий к старым; before = 0 - с последней
+func (r *Repository) GetPlaylistRevisions(playlistID, before, limit int) ([]PlaylistRevision, error) {
+	rows, err := r.db.Query(`
+		SELECT pr.revision, pr.details, pr.title, COALESCE(array_length(pr.track_ids, 1), 0), pr.created_at,
+		       COALESCE(u.id, 0), COALESCE(u.username, '')
+		FROM playlist_revisions pr
+		LEFT JOIN users u ON u.id = pr.user_id
+		WHERE pr.playlist_id = $1 AND ($2 = 0 OR pr.revision < $2)
+		ORDER BY pr.revision DESC
+		LIMIT $3`, playlistID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []PlaylistRevision{}
+	for rows.Next() {
+		var rev PlaylistRevision
+		var details []byte
+		var user User
+		if err := rows.Scan(&rev.Revision, &details, &rev.Title, &rev.TrackCount, &rev.CreatedAt, &user.ID, &user.Username); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(details, &rev.Change); err != nil {
+			return nil, err
+		}
+		if user.ID != 0 {
+			rev.User = &user
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// GetPlaylistSnapshot возвращает состояние плейлиста на ревизии
+func (r *Repository) GetPlaylistSnapshot(playlistID, revision int) (*PlaylistSnapshot, error) {
+	snap := PlaylistSnapshot{Revision: revision}
+	var trackIDs pq.Int64Array
+	err := r.db.QueryRow(`
+		SELECT title, description, track_ids FROM playlist_revisions
+		WHERE playlist_id = $1 AND revision = $2`, playlistID, revision).Scan(&snap.Title, &snap.Description, &trackIDs)
+	if err == sql.ErrNoRows {
+		return nil, errorspkg.ErrPlaylistRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range trackIDs {
+		snap.TrackIDs = append(snap.TrackIDs, int(id))
+	}
+	return &snap, nil
+}
+
+// GetTrackSummaries - название и автор треков для отображения изменений; удаленных треков в ответе нет
+func (r *Repository) GetTrackSummaries(trackIDs []int) (map[int]Track, error) {
+	rows, err := r.db.Query(`
+		SELECT t.id, t.title, u.id, u.username
+		FROM tracks t
+		JOIN users u ON u.id = t.author_id
+		WHERE t.id = ANY($1)`, pq.Array(trackIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := make(map[int]Track, len(trackIDs))
+	for rows.Next() {
+		var t Track
+		if err := rows.Scan(&t.ID, &t.Title, &t.Author.ID, &t.Author.Username); err != nil {
+			return nil, err
+		}
+		tracks[t.ID] = t
+	}
+	return tracks, rows.Err()
+}
+
+// RestorePlaylistRevision возвращает название, описание и состав ревизии target новой ревизией.
+// Удаленные из каталога треки восстановить нельзя, их id возвращаются отдельно
+func (r *Repository) RestorePlaylistRevision(playlistID, target, userID int, revision *int) (int, []int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockPlaylist(tx, playlistID, userID, revision); err != nil {
+		return 0, nil, err
+	}
+
+	var title, description string
+	var snapshot pq.Int64Array
+	err = tx.QueryRow(`
+		SELECT title, description, track_ids FROM playlist_revisions
+		WHERE playlist_id = $1 AND revision = $2`, playlistID, target).Scan(&title, &description, &snapshot)
+	if err == sql.ErrNoRows {
+		return 0, nil, errorspkg.ErrPlaylistRevisionNotFound
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var existing pq.Int64Array
+	if err := tx.QueryRow("SELECT COALESCE(array_agg(id), '{}') FROM tracks WHERE id = ANY($1)", snapshot).Scan(&existing); err != nil {
+		return 0, nil, err
+	}
+	available := make(map[int64]bool, len(existing))
+	for _, id := range existing {
+		available[id] = true
+	}
+	trackIDs := []int{}
+	missing := []int{}
+	for _, id := range snapshot {
+		if available[id] {
+			trackIDs = append(trackIDs, int(id))
+		} else {
+			missing = append(missing, int(id))
+		}
+	}
+
+	change, err := replaceTracks(tx, playlistID, trackIDs, userID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := tx.Exec("UPDATE playlists SET title = $2, description = $3 WHERE id = $1", playlistID, title, description); err != nil {
+		return 0, nil, err
+	}
+	change.Action = ChangeRestore
+	change.Revision = target
+
+	newRevision, err := bumpPlaylistRevision(tx, playlistID, userID, change)
+	if err != nil {
+		return 0, nil, err
+	}
+	return newRevision, missing, tx.Commit()
+}
+
+// PlaylistCoverState - сведения для пересборки мозаики. Candidates - первые треки плейлиста по порядку
+type PlaylistCoverState struct {
+	Custom     bool
+	Checked    bool // обложка уже собиралась или проверялась
+	Tracks     []int
+	Candidates []int
+}
+
+func (r *Repository) GetPlaylistCoverState(playlistID, candidates int) (*PlaylistCoverState, error) {
+	var st PlaylistCoverState
+	var tracks, ids pq.Int64Array
+	err := r.db.QueryRow(`
+		SELECT p.cover_custom, p.cover_updated_at IS NOT NULL, p.cover_tracks,
+		       COALESCE((SELECT array_agg(x.track_id ORDER BY x.position) FROM (
+		           SELECT tp.track_id, tp.position FROM tracks_playlists tp
+		           JOIN tracks t ON t.id = tp.track_id AND t.is_blocked = false
+		           WHERE tp.playlist_id = p.id ORDER BY tp.position LIMIT $2) x), '{}')
+		FROM playlists p WHERE p.id = $1`, playlistID, candidates).Scan(&st.Custom, &st.Checked, &tracks, &ids)
+	if err == sql.ErrNoRows {
+		return nil, errorspkg.ErrPlaylistNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range tracks {
+		st.Tracks = append(st.Tracks, int(id))
+	}
+	for _, id := range ids {
+		st.Candidates = append(st.Candidates, int(id))
+	}
+	return &st, nil
+}
+
+// SetPlaylistAutoCover сохраняет собранную мозаику; своя обложка, загруженная за это время, не затирается.
+// Пустой avatar - у треков нет обложек
+func (r *Repository) SetPlaylistAutoCover(playlistID int, trackIDs []int, avatar string) error {
+	_, err := r.db.Exec(`
+		UPDATE playlists SET avatar = NULLIF($3, ''), cover_tracks = $2, cover_updated_at = NOW()
+		WHERE id = $1 AND NOT cover_custom`, playlistID, pq.Array(trackIDs), avatar)
+	return err
+}
+
+// SetPlaylistCustomCover отмечает, что у плейлиста своя обложка (custom) или снова мозаика
+func (r *Repository) SetPlaylistCustomCover(playlistID int, custom bool, avatar string) error {
+	_, err := r.db.Exec(`
+		UPDATE playlists SET cover_custom = $2, avatar = NULLIF($3, ''), cover_tracks = '{}', cover_updated_at = NOW()
+		WHERE id = $1`, playlistID, custom, avatar)
+	return err
+}
+
+// GetPlaylistsWithoutCover - плейлисты, для которых мозаика еще не собиралась
+func (r *Repository) GetPlaylistsWithoutCover(limit int) ([]int, error) {
+	rows, err := r.db.Query(`
+		SELECT id FROM playlists
+		WHERE cover_updated_at IS NULL AND NOT cover_custom
+		ORDER BY id LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -1,20 +1,69 @@
 package music
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/auth"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/covermosaic"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/ddex"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/errorspkg"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/historyimport"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/listenbrainz"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/notify"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/playlistfile"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/privacy"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/realtime"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/sharecard"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/smartplaylist"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/storage"
 )
 
 type Service struct {
-	repo *Repository
+	repo      *Repository
+	scrobbler *listenbrainz.Client
+	notifier  *notify.Notifier
+	events    *realtime.Publisher
+	storage   *storage.MinioStorage
+	covers    *coverQueue
+}
+
+func NewService(repo *Repository, scrobbler *listenbrainz.Client, notifier *notify.Notifier, events *realtime.Publisher, storage *storage.MinioStorage) *Service {
+	return &Service{repo: repo, scrobbler: scrobbler, notifier: notifier, events: events, storage: storage, covers: newCoverQueue()}
 }
 
-func NewService(repo *Repository) *Service {
-	return &Service{repo: repo}
+// AddPlaylist создает плейлист; пустая видимость означает public
+func (s *Service) AddPlaylist(title, description, visibility string, userID int) (int, error) {
+	if visibility == "" {
+		visibility = VisibilityPublic
+	}
+	if !validVisibility(visibility) {
+		return 0, fmt.Errorf("%w: неизвестная видимость %q", errorspkg.ErrInvalidPlaylistEdit, visibility)
+	}
+	return s.repo.AddPlaylist(title, description, visibility, userID)
 }
 
-func (s *Service) AddPlaylist(title string, description string, userID int) (int, error) {
-	return s.repo.AddPlaylist(title, description, userID)
+func validVisibility(v string) bool {
+	return v == VisibilityPublic || v == VisibilityUnlisted || v == VisibilityPrivate
 }
 
 func (s *Service) UpdatePlaylist(playlistID int, title, description string, userID int) error {
@@ -38,15 +87,69 @@ func (s *Service) CreateTrack(title, description, genre string, authorID int) (i
 }
 
 func (s *Service) AddLike(userID, trackID int) (bool, error) {
-	return s.repo.AddLike(userID, trackID)
+	added, err := s.repo.AddLike(userID, trackID)
+	if err == nil && added {
+		s.notifyTrackAuthor(notify.TypeLike, userID, trackID, nil)
+		go s.publishTrackCounters(trackID)
+	}
+	return added, err
+}
+
+// AddSongToPlaylist добавляет трек в конец плейлиста и возвращает новую ревизию
+func (s *Service) AddSongToPlaylist(playlistID, trackID, userID int) (int, error) {
+	return s.afterTracksChange(playlistID)(s.repo.InsertPlaylistTrack(playlistID, trackID, 0, userID, nil))
+}
+
+const maxPlaylistTracks = 10000
+
+func (s *Service) InsertPlaylistTrack(playlistID, userID int, req PlaylistTrackRequest) (int, error) {
+	if req.TrackID <= 0 {
+		return 0, fmt.Errorf("%w: не указан трек", errorspkg.ErrInvalidPlaylistEdit)
+	}
+	if req.Position < 0 {
+		return 0, fmt.Errorf("%w: позиция должна быть положительной", errorspkg.ErrInvalidPlaylistEdit)
+	}
+	return s.afterTracksChange(playlistID)(s.repo.InsertPlaylistTrack(playlistID, req.TrackID, req.Position, userID, req.Revision))
+}
+
+func (s *Service) RemovePlaylistTrack(playlistID, trackID, userID int, revision *int) (int, error) {
+	return s.afterTracksChange(playlistID)(s.repo.RemovePlaylistTrack(playlistID, trackID, userID, revision))
+}
+
+func (s *Service) MovePlaylistTrack(playlistID, trackID, userID int, req PlaylistTrackRequest) (int, error) {
+	if req.Position < 1 {
+		return 0, fmt.Errorf("%w: позиция должна быть положительной", errorspkg.ErrInvalidPlaylistEdit)
+	}
+	return s.afterTracksChange(playlistID)(s.repo.MovePlaylistTrack(playlistID, trackID, req.Position, userID, req.Revision))
 }
 
-func (s *Service) AddSongToPlaylist(playlistId, trackID int) (bool, error) {
-	return s.repo.AddSongToPlaylist(playlistId, trackID)
+// ReplacePlaylistTracks требует ревизию: без нее полная замена затерла бы чужие правки
+func (s *Service) ReplacePlaylistTracks(playlistID, userID int, req ReplacePlaylistTracksRequest) (int, error) {
+	if req.Revision == nil {
+		return 0, fmt.Errorf("%w: не указана ревизия плейлиста", errorspkg.ErrInvalidPlaylistEdit)
+	}
+	if len(req.TrackIDs) > maxPlaylistTracks {
+		return 0, fmt.Errorf("%w: в плейлисте может быть не больше %d треков", errorspkg.ErrInvalidPlaylistEdit, maxPlaylistTracks)
+	}
+	seen := make(map[int]bool, len(req.TrackIDs))
+	for _, id := range req.TrackIDs {
+		if seen[id] {
+			return 0, fmt.Errorf("%w: трек %d указан несколько раз", errorspkg.ErrInvalidPlaylistEdit, id)
+		}
+		seen[id] = true
+	}
+	if req.TrackIDs == nil {
+		req.TrackIDs = []int{}
+	}
+	return s.afterTracksChange(playlistID)(s.repo.ReplacePlaylistTracks(playlistID, req.TrackIDs, userID, *req.Revision))
 }
 
 func (s *Service) RemoveLike(userID, trackID int) (bool, error) {
-	return s.repo.RemoveLike(userID, trackID)
+	removed, err := s.repo.RemoveLike(userID, trackID)
+	if err == nil && removed {
+		go s.publishTrackCounters(trackID)
+	}
+	return removed, err
 }
 
 func (s *Service) GetLikeCount(trackID int) (int, error) {
@@ -62,11 +165,20 @@ func (s *Service) IsTrackLiked(userID, trackID int) (bool, error) {
 }
 
 func (s *Service) AddRepost(userID, trackID int) (bool, error) {
-	return s.repo.AddRepost(userID, trackID)
+	added, err := s.repo.AddRepost(userID, trackID)
+	if err == nil && added {
+		s.notifyTrackAuthor(notify.TypeRepost, userID, trackID, nil)
+		go s.publishTrackCounters(trackID)
+	}
+	return added, err
 }
 
 func (s *Service) RemoveRepost(userID, trackID int) (bool, error) {
-	return s.repo.RemoveRepost(userID, trackID)
+	removed, err := s.repo.RemoveRepost(userID, trackID)
+	if err == nil && removed {
+		go s.publishTrackCounters(trackID)
+	}
+	return removed, err
 }
 
 func (s *Service) GetRepostCount(trackID int) (int, error) {
@@ -82,11 +194,88 @@ func (s *Service) GetCommentsByTrackID(trackID int, isAdmin bool) ([]Comment, er
 }
 
 func (s *Service) AddComment(trackID, userID int, text string, moment int) (int, error) {
-	return s.repo.AddComment(trackID, userID, text, moment)
+	id, err := s.repo.AddComment(trackID, userID, text, moment)
+	if err == nil {
+		s.notifyTrackAuthor(notify.TypeComment, userID, trackID, map[string]interface{}{
+			"comment_id": id,
+			"text":       text,
+			"moment":     moment,
+		})
+		go s.publishComment(trackID, id)
+	}
+	return id, err
+}
+
+// notifyTrackAuthor сообщает автору трека о действии пользователя actorID
+func (s *Service) notifyTrackAuthor(eventType string, actorID, trackID int, data map[string]interface{}) {
+	track, err := s.repo.GetTrackByID(trackID)
+	if err != nil || track == nil {
+		return
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["title"] = track.Title
+
+	s.notifier.Emit(notify.Event{
+		RecipientID: track.Author.ID,
+		ActorID:     actorID,
+		Type:        eventType,
+		TargetType:  "track",
+		TargetID:    trackID,
+		Data:        data,
+	})
 }
 
 func (s *Service) AddTrackListen(listenerID int, trackID int, country string, device string, duration int, parts []TrackParts) (int, error) {
-	return s.repo.AddTrackListen(listenerID, trackID, country, device, duration, parts)
+	id, err := s.repo.AddTrackListen(listenerID, trackID, country, device, duration, parts)
+	if err != nil {
+		return id, err
+	}
+	go s.publishTrackCounters(trackID)
+	if listenerID == 0 {
+		return id, nil
+	}
+
+	// Ошибка скробблинга не должна мешать учету прослушивания
+	if err := s.enqueueScrobble(listenerID, id, trackID, duration); err != nil {
+		log.Printf("ошибка постановки прослушивания %d в очередь скробблинга: %v", id, err)
+	}
+	return id, nil
+}
+
+// publishTrackCounters рассылает открывшим трек актуальные счетчики вместо опроса /likes и /listens
+func (s *Service) publishTrackCounters(trackID int) {
+	likes, err := s.repo.GetLikeCount(trackID)
+	if err != nil {
+		log.Printf("ошибка подсчета лайков трека %d: %v", trackID, err)
+		return
+	}
+	reposts, err := s.repo.GetRepostCount(trackID)
+	if err != nil {
+		log.Printf("ошибка подсчета репостов трека %d: %v", trackID, err)
+		return
+	}
+	listens, err := s.repo.GetTrackListens(trackID)
+	if err != nil {
+		log.Printf("ошибка подсчета прослушиваний трека %d: %v", trackID, err)
+		return
+	}
+	s.events.Publish(realtime.TrackTopic(trackID), realtime.TypeTrackCounters, map[string]int{
+		"track_id": trackID,
+		"likes":    likes,
+		"reposts":  reposts,
+		"listens":  listens,
+	})
+}
+
+func (s *Service) publishComment(trackID, commentID int) {
+	comment, err := s.repo.GetCommentByID(commentID)
+	if err != nil || comment == nil {
+		log.Printf("ошибка получения комментария %d: %v", commentID, err)
+		return
+	}
+	s.events.Publish(realtime.TrackTopic(trackID), realtime.TypeComment, comment)
 }
 
 func (s *Service) GetTrackPartsByTrackID(trackID int) ([]TrackPartsAverage, error) {
@@ -101,14 +290,56 @@ func (s *Service) GetTopUsersByPopularity() ([]User, error) {
 	return s.repo.GetTopUsersByPopularity()
 }
 
-func (s *Service) GetUser(userID int) (*User, error) {
-	return s.repo.GetUserByID(userID)
+// GetArtistProfile для закрытого профиля возвращает посторонним только имя, аватар и отметку верификации
+func (s *Service) GetArtistProfile(userID, viewerID int, isAdmin bool) (*ArtistProfile, error) {
+	profile, err := s.repo.GetArtistProfile(userID)
+	if err != nil || profile == nil {
+		return profile, err
+	}
+
+	settings, access, err := s.privacyAccess(userID, viewerID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if !settings.CanViewProfile(access) {
+		return &ArtistProfile{User: profile.User, Role: profile.Role, IsVerified: profile.IsVerified}, nil
+	}
+
+	profile.Stats, err = s.repo.GetArtistStats(userID)
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
 }
 
-func (s *Service) GetArtistTracks(artistID, page int) ([]Track, error) {
+func (s *Service) GetArtistTracks(artistID, page, viewerID int, isAdmin bool) ([]Track, error) {
+	settings, access, err := s.privacyAccess(artistID, viewerID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if !settings.CanViewProfile(access) {
+		return nil, errorspkg.ErrPrivateContent
+	}
 	return s.repo.GetArtistTracks(artistID, page)
 }
 
+// privacyAccess загружает настройки владельца и определяет, кем ему приходится смотрящий (viewerID = 0 - гость)
+func (s *Service) privacyAccess(ownerID, viewerID int, isAdmin bool) (privacy.Settings, privacy.Access, error) {
+	settings, err := s.repo.GetPrivacySettings(ownerID)
+	if err != nil {
+		return settings, privacy.Access{}, err
+	}
+
+	access := privacy.Access{Owner: viewerID != 0 && viewerID == ownerID, Admin: isAdmin}
+	if viewerID != 0 && !access.Owner {
+		access.Follower, err = s.repo.IsFollowing(viewerID, ownerID)
+		if err != nil {
+			return settings, access, err
+		}
+	}
+	return settings, access, nil
+}
+
 func (s *Service) HideComment(commentID int) error {
 	return s.repo.HideComment(commentID)
 }
@@ -117,8 +348,519 @@ func (s *Service) UnhideComment(commentID int) error {
 	return s.repo.UnhideComment(commentID)
 }
 
-func (s *Service) GetPlaylistByID(playlistID int, isAdmin bool) (*Playlist, error) {
-	return s.repo.GetPlaylistByID(playlistID, isAdmin)
+func (s *Service) GetPlaylistByID(playlistID, viewerID int, isAdmin bool) (*Playlist, error) {
+	playlist, err := s.repo.GetPlaylistByID(playlistID, isAdmin)
+	if err != nil || playlist == nil || playlist.Author.ID == 0 {
+		return playlist, err
+	}
+
+	role, err := s.playlistAccess(playlist, viewerID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	// Пересчитываем только после проверки доступа: чужой запрос не должен запускать пересчет
+	if s.refreshSmartOnRead(playlistID) {
+		if playlist, err = s.repo.GetPlaylistByID(playlistID, isAdmin); err != nil || playlist == nil {
+			return playlist, err
+		}
+	}
+	playlist.Role = role
+	if playlist.Role != "" {
+		if playlist.Members, err = s.repo.GetPlaylistMembers(playlistID); err != nil {
+			return nil, err
+		}
+	}
+	if viewerID != 0 {
+		if playlist.IsFollowed, err = s.repo.IsPlaylistFollowed(playlistID, viewerID); err != nil {
+			return nil, err
+		}
+	}
+	return playlist, nil
+}
+
+// playlistAccess возвращает роль зрителя в плейлисте или ErrPrivateContent, если смотреть нельзя.
+// Участникам видимость и настройки приватности автора не мешают; unlisted доступен по ссылке
+func (s *Service) playlistAccess(playlist *Playlist, viewerID int, isAdmin bool) (string, error) {
+	if viewerID != 0 {
+		role, err := s.repo.GetPlaylistRole(playlist.ID, viewerID)
+		if err != nil || role != "" {
+			return role, err
+		}
+	}
+	if isAdmin {
+		return "", nil
+	}
+	if playlist.Visibility == VisibilityPrivate {
+		return "", errorspkg.ErrPrivateContent
+	}
+
+	settings, access, err := s.privacyAccess(playlist.Author.ID, viewerID, isAdmin)
+	if err != nil {
+		return "", err
+	}
+	if !settings.CanViewPlaylists(access) {
+		return "", errorspkg.ErrPrivateContent
+	}
+	return "", nil
+}
+
+func (s *Service) SetPlaylistVisibility(playlistID, userID int, visibility string) error {
+	if !validVisibility(visibility) {
+		return fmt.Errorf("%w: неизвестная видимость %q", errorspkg.ErrInvalidPlaylistEdit, visibility)
+	}
+	return s.repo.SetPlaylistVisibility(playlistID, userID, visibility)
+}
+
+// FollowPlaylist сохраняет чужой плейлист в библиотеку и возвращает число подписчиков
+func (s *Service) FollowPlaylist(playlistID, userID int) (int, error) {
+	playlist, err := s.repo.GetPlaylistSummary(playlistID)
+	if err != nil {
+		return 0, err
+	}
+	role, err := s.playlistAccess(playlist, userID, false)
+	if err != nil {
+		return 0, err
+	}
+	if role != "" {
+		return 0, fmt.Errorf("%w: плейлист уже в вашей библиотеке", errorspkg.ErrInvalidPlaylistEdit)
+	}
+	if err := s.repo.FollowPlaylist(playlistID, userID); err != nil {
+		return 0, err
+	}
+	return s.repo.GetPlaylistFollowersCount(playlistID)
+}
+
+func (s *Service) UnfollowPlaylist(playlistID, userID int) (int, error) {
+	if err := s.repo.UnfollowPlaylist(playlistID, userID); err != nil {
+		return 0, err
+	}
+	return s.repo.GetPlaylistFollowersCount(playlistID)
+}
+
+func (s *Service) GetLibraryPlaylists(userID int) ([]Playlist, error) {
+	return s.repo.GetLibraryPlaylists(userID)
+}
+
+// Умный плейлист в режиме on_read пересчитывается при открытии, но не чаще smartReadTTL
+const smartReadTTL = time.Minute
+
+const smartJobBatch = 100
+
+func validSmartRefresh(mode string) bool {
+	return mode == SmartRefreshOnRead || mode == SmartRefreshScheduled
+}
+
+// prepareSmartRules проверяет правила и режим пересчета и возвращает правила в виде для хранения
+func prepareSmartRules(rules *smartplaylist.Definition, refresh *string) ([]byte, error) {
+	if *refresh == "" {
+		*refresh = SmartRefreshOnRead
+	}
+	if !validSmartRefresh(*refresh) {
+		return nil, fmt.Errorf("%w: неизвестный режим пересчета %q", errorspkg.ErrInvalidPlaylistEdit, *refresh)
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errorspkg.ErrInvalidPlaylistEdit, err)
+	}
+	return json.Marshal(rules)
+}
+
+// CreateSmartPlaylist создает умный плейлист и сразу заполняет его по правилам
+func (s *Service) CreateSmartPlaylist(userID int, req SmartPlaylistRequest) (int, error) {
+	if req.Visibility == "" {
+		req.Visibility = VisibilityPublic
+	}
+	if !validVisibility(req.Visibility) {
+		return 0, fmt.Errorf("%w: неизвестная видимость %q", errorspkg.ErrInvalidPlaylistEdit, req.Visibility)
+	}
+	rules, err := prepareSmartRules(&req.Rules, &req.Refresh)
+	if err != nil {
+		return 0, err
+	}
+	playlistID, err := s.repo.AddSmartPlaylist(req.Title, req.Description, req.Visibility, rules, req.Refresh, userID)
+	if err != nil {
+		return 0, err
+	}
+	return playlistID, s.refreshSmartPlaylist(playlistID, userID, rules)
+}
+
+// UpdateSmartRules заменяет правила (только владелец) и пересчитывает состав
+func (s *Service) UpdateSmartRules(playlistID, userID int, req SmartPlaylistRequest) error {
+	rules, err := prepareSmartRules(&req.Rules, &req.Refresh)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.SetSmartRules(playlistID, userID, rules, req.Refresh); err != nil {
+		return err
+	}
+	state, err := s.repo.GetSmartPlaylistState(playlistID)
+	if err != nil || state == nil {
+		return err
+	}
+	return s.refreshSmartPlaylist(playlistID, state.AuthorID, rules)
+}
+
+// RefreshSmartPlaylist пересчитывает состав по запросу владельца или редактора
+func (s *Service) RefreshSmartPlaylist(playlistID, userID int) error {
+	if err := s.requirePlaylistRole(playlistID, userID, PlaylistOwner, PlaylistEditor); err != nil {
+		return err
+	}
+	state, err := s.repo.GetSmartPlaylistState(playlistID)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return errorspkg.ErrPlaylistNotFound
+	}
+	return s.refreshSmartPlaylist(playlistID, state.AuthorID, state.Rules)
+}
+
+// refreshSmartPlaylist вычисляет правила от лица автора: "мои лайки" - это лайки автора, а не зрителя
+func (s *Service) refreshSmartPlaylist(playlistID, authorID int, rules []byte) error {
+	var def smartplaylist.Definition
+	if err := json.Unmarshal(rules, &def); err != nil {
+		return err
+	}
+	query, args, err := smartplaylist.Compile(def, authorID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.RefreshSmartPlaylist(playlistID, query, args); err != nil {
+		return err
+	}
+	s.schedulePlaylistCover(playlistID)
+	return nil
+}
+
+// refreshSmartOnRead пересчитывает устаревший умный плейлист и сообщает, был ли пересчет;
+// ошибка не мешает показать прошлый состав. Случайный порядок при чтении не перемешивается:
+// иначе каждый просмотр создавал бы новую ревизию. Его пересчитывает владелец или расписание
+func (s *Service) refreshSmartOnRead(playlistID int) bool {
+	state, err := s.repo.GetSmartPlaylistState(playlistID)
+	if err != nil || state == nil || state.Refresh != SmartRefreshOnRead {
+		return false
+	}
+	if state.RefreshedAt != nil && time.Since(*state.RefreshedAt) < smartReadTTL {
+		return false
+	}
+	var def smartplaylist.Definition
+	if err := json.Unmarshal(state.Rules, &def); err != nil || def.Sort == smartplaylist.SortRandom {
+		return false
+	}
+	if err := s.refreshSmartPlaylist(playlistID, state.AuthorID, state.Rules); err != nil {
+		log.Printf("ошибка пересчета умного плейлиста %d: %v", playlistID, err)
+		return false
+	}
+	return true
+}
+
+// RunSmartPlaylistJobs пересчитывает умные плейлисты в режиме scheduled раз в interval
+func (s *Service) RunSmartPlaylistJobs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ids, err := s.repo.GetDueSmartPlaylists(time.Now().Add(-interval), smartJobBatch)
+		if err != nil {
+			log.Printf("ошибка получения умных плейлистов: %v", err)
+		}
+		for _, id := range ids {
+			state, err := s.repo.GetSmartPlaylistState(id)
+			if err == nil && state != nil {
+				err = s.refreshSmartPlaylist(id, state.AuthorID, state.Rules)
+			}
+			if err != nil {
+				log.Printf("ошибка пересчета умного плейлиста %d: %v", id, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// История плейлиста
+
+const (
+	defaultRevisionsLimit = 50
+	maxRevisionsLimit     = 200
+)
+
+// GetPlaylistRevisions доступна участникам плейлиста
+func (s *Service) GetPlaylistRevisions(playlistID, userID, before, limit int) ([]PlaylistRevision, error) {
+	if err := s.requirePlaylistRole(playlistID, userID, PlaylistOwner, PlaylistEditor, PlaylistViewer); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultRevisionsLimit
+	}
+	if limit > maxRevisionsLimit {
+		limit = maxRevisionsLimit
+	}
+	return s.repo.GetPlaylistRevisions(playlistID, before, limit)
+}
+
+func (s *Service) DiffPlaylistRevisions(playlistID, userID, from, to int) (*PlaylistDiff, error) {
+	if err := s.requirePlaylistRole(playlistID, userID, PlaylistOwner, PlaylistEditor, PlaylistViewer); err != nil {
+		return nil, err
+	}
+	a, err := s.repo.GetPlaylistSnapshot(playlistID, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.repo.GetPlaylistSnapshot(playlistID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := diffSnapshots(a, b)
+
+	var ids []int
+	for _, list := range [][]PlaylistDiffTrack{diff.Added, diff.Removed, diff.Moved} {
+		for _, t := range list {
+			ids = append(ids, t.TrackID)
+		}
+	}
+	if len(ids) > 0 {
+		tracks, err := s.repo.GetTrackSummaries(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, list := range [][]PlaylistDiffTrack{diff.Added, diff.Removed, diff.Moved} {
+			for i := range list {
+				if t, ok := tracks[list[i].TrackID]; ok {
+					list[i].Title = t.Title
+					list[i].Artist = t.Author.Username
+				}
+			}
+		}
+	}
+	return diff, nil
+}
+
+// diffSnapshots сравнивает две ревизии. Перемещенными считаются общие треки вне наибольшей
+// возрастающей подпоследовательности: вставка одного трека не делает перемещенными все следующие
+func diffSnapshots(a, b *PlaylistSnapshot) *PlaylistDiff {
+	diff := &PlaylistDiff{
+		From:    a.Revision,
+		To:      b.Revision,
+		Added:   []PlaylistDiffTrack{},
+		Removed: []PlaylistDiffTrack{},
+		Moved:   []PlaylistDiffTrack{},
+	}
+	if a.Title != b.Title {
+		diff.Title = &TextChange{Old: a.Title, New: b.Title}
+	}
+	if a.Description != b.Description {
+		diff.Description = &TextChange{Old: a.Description, New: b.Description}
+	}
+
+	posA := make(map[int]int, len(a.TrackIDs))
+	for i, id := range a.TrackIDs {
+		posA[id] = i + 1
+	}
+	posB := make(map[int]int, len(b.TrackIDs))
+	for i, id := range b.TrackIDs {
+		posB[id] = i + 1
+	}
+
+	for i, id := range a.TrackIDs {
+		if posB[id] == 0 {
+			diff.Removed = append(diff.Removed, PlaylistDiffTrack{TrackID: id, From: i + 1})
+		}
+	}
+
+	var common []int // треки в порядке ревизии To
+	for i, id := range b.TrackIDs {
+		if posA[id] == 0 {
+			diff.Added = append(diff.Added, PlaylistDiffTrack{TrackID: id, To: i + 1})
+		} else {
+			common = append(common, id)
+		}
+	}
+
+	seq := make([]int, len(common))
+	for i, id := range common {
+		seq[i] = posA[id]
+	}
+	stable := longestIncreasing(seq)
+	for i, id := range common {
+		if !stable[i] {
+			diff.Moved = append(diff.Moved, PlaylistDiffTrack{TrackID: id, From: posA[id], To: posB[id]})
+		}
+	}
+	return diff
+}
+
+// longestIncreasing отмечает элементы одной из наибольших строго возрастающих подпоследовательностей, O(n log n)
+func longestIncreasing(seq []int) []bool {
+	tails := []int{} // индексы в seq: tails[k] - конец лучшей подпоследовательности длины k+1
+	prev := make([]int, len(seq))
+	for i, v := range seq {
+		k := sort.Search(len(tails), func(j int) bool { return seq[tails[j]] >= v })
+		if k > 0 {
+			prev[i] = tails[k-1]
+		} else {
+			prev[i] = -1
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+
+	in := make([]bool, len(seq))
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+			in[i] = true
+		}
+	}
+	return in
+}
+
+// RestorePlaylistRevision откатывает плейлист к ревизии target; откат сам становится новой ревизией,
+// поэтому его тоже можно отменить
+func (s *Service) RestorePlaylistRevision(playlistID, userID, target int, revision *int) (int, []int, error) {
+	newRevision, missing, err := s.repo.RestorePlaylistRevision(playlistID, target, userID, revision)
+	if err == nil {
+		s.schedulePlaylistCover(playlistID)
+	}
+	return newRevision, missing, err
+}
+
+// requirePlaylistRole проверяет, что роль пользователя входит в allowed
+func (s *Service) requirePlaylistRole(playlistID, userID int, allowed ...string) error {
+	role, err := s.repo.GetPlaylistRole(playlistID, userID)
+	if err != nil {
+		return err
+	}
+	for _, r := range allowed {
+		if role == r {
+			return nil
+		}
+	}
+	return errorspkg.ErrPlaylistNotFound
+}
+
+func (s *Service) GetPlaylistMembers(playlistID, userID int) ([]PlaylistMember, error) {
+	if err := s.requirePlaylistRole(playlistID, userID, PlaylistOwner, PlaylistEditor, PlaylistViewer); err != nil {
+		return nil, err
+	}
+	return s.repo.GetPlaylistMembers(playlistID)
+}
+
+// UpdatePlaylistMember меняет роль участника; роль автора не меняется
+func (s *Service) UpdatePlaylistMember(playlistID, userID, memberID int, role string) error {
+	if role != PlaylistOwner && role != PlaylistEditor && role != PlaylistViewer {
+		return fmt.Errorf("%w: неизвестная роль %q", errorspkg.ErrInvalidPlaylistEdit, role)
+	}
+	if err := s.requirePlaylistRole(playlistID, userID, PlaylistOwner); err != nil {
+		return err
+	}
+	updated, err := s.repo.SetPlaylistMemberRole(playlistID, memberID, role)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return errorspkg.ErrPlaylistMemberNotFound
+	}
+	return nil
+}
+
+// RemovePlaylistMember: владелец удаляет участника, участник может выйти сам
+func (s *Service) RemovePlaylistMember(playlistID, userID, memberID int) error {
+	if memberID != userID {
+		if err := s.requirePlaylistRole(playlistID, userID, PlaylistOwner); err != nil {
+			return err
+		}
+	}
+	removed, err := s.repo.RemovePlaylistMember(playlistID, memberID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return errorspkg.ErrPlaylistMemberNotFound
+	}
+	return nil
+}
+
+const maxInviteLifetime = 30 * 24 * time.Hour
+
+func (s *Service) CreatePlaylistInvite(playlistID, userID int, req CreatePlaylistInviteRequest) (*PlaylistInvite, error) {
+	if req.Role != PlaylistEditor && req.Role != PlaylistViewer {
+		return nil, fmt.Errorf("%w: по ссылке можно выдать только роль editor или viewer", errorspkg.ErrInvalidPlaylistEdit)
+	}
+	if req.MaxUses != nil && *req.MaxUses < 1 {
+		return nil, fmt.Errorf("%w: max_uses должно быть положительным", errorspkg.ErrInvalidPlaylistEdit)
+	}
+	lifetime := time.Duration(req.ExpiresInHours) * time.Hour
+	if req.ExpiresInHours < 0 || lifetime > maxInviteLifetime {
+		return nil, fmt.Errorf("%w: срок действия ссылки - до %d часов", errorspkg.ErrInvalidPlaylistEdit, int(maxInviteLifetime.Hours()))
+	}
+	if err := s.requirePlaylistRole(playlistID, userID, PlaylistOwner); err != nil {
+		return nil, err
+	}
+
+	var expiresAt *time.Time
+	if lifetime > 0 {
+		t := time.Now().Add(lifetime)
+		expiresAt = &t
+	}
+
+	token, hash, err := generateInviteToken()
+	if err != nil {
+		return nil, err
+	}
+	invite, err := s.repo.CreatePlaylistInvite(playlistID, userID, req.Role, hash, req.MaxUses, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	invite.Token = token
+	return invite, nil
+}
+
+func (s *Service) GetPlaylistInvites(playlistID, userID int) ([]PlaylistInvite, error) {
+	if err := s.requirePlaylistRole(playlistID, userID, PlaylistOwner); err != nil {
+		return nil, err
+	}
+	return s.repo.GetPlaylistInvites(playlistID)
+}
+
+func (s *Service) RevokePlaylistInvite(playlistID, userID, inviteID int) error {
+	if err := s.requirePlaylistRole(playlistID, userID, PlaylistOwner); err != nil {
+		return err
+	}
+	revoked, err := s.repo.RevokePlaylistInvite(playlistID, inviteID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return errorspkg.ErrInvalidInvite
+	}
+	return nil
+}
+
+// AcceptPlaylistInvite возвращает id плейлиста и итоговую роль пользователя
+func (s *Service) AcceptPlaylistInvite(token string, userID int) (int, string, error) {
+	return s.repo.AcceptPlaylistInvite(hashInviteToken(token), userID)
+}
+
+// generateInviteToken возвращает токен для ссылки и его sha256 для БД
+func generateInviteToken() (string, string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashInviteToken(token), nil
+}
+
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func (s *Service) HideTrack(commentID int) error {
@@ -155,6 +897,18 @@ func (s *Service) GetRecentTracks(userID int) ([]Track, error) {
 	return s.repo.GetRecentTracks(userID)
 }
 
+// GetUserRecentTracks - история прослушиваний в чужом профиле
+func (s *Service) GetUserRecentTracks(userID, viewerID int, isAdmin bool) ([]Track, error) {
+	settings, access, err := s.privacyAccess(userID, viewerID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if !settings.CanViewHistory(access) {
+		return nil, errorspkg.ErrPrivateContent
+	}
+	return s.repo.GetRecentTracks(userID)
+}
+
 func (s *Service) GetTopListenedUsers(userID int) ([]User, error) {
 	return s.repo.GetTopListenedUsers(userID)
 }
@@ -171,17 +925,26 @@ func (s *Service) DeleteTrack(id, userID int) error {
 	return s.repo.DeleteTrack(id, userID)
 }
 
-func (s *Service) CreateAlbum(title, description string, releaseDate time.Time, userID int, trackIDs []int, is_Announced bool) (int, error) {
+func (s *Service) CreateAlbum(title, description string, releaseDate time.Time, userID int, trackIDs []int, is_Announced bool, meta AlbumMetadata) (int, error) {
 	if len(trackIDs) == 0 {
-		return 0, errors.New("album must contain at least one track")
+		return 0, fmt.Errorf("%w: в альбоме должен быть хотя бы один трек", errorspkg.ErrInvalidAlbumEdit)
+	}
+	if meta.ReleaseType == "" {
+		meta.ReleaseType = ReleaseAlbum
+	}
+	if err := normalizeAlbumMetadata(&meta); err != nil {
+		return 0, err
 	}
 
-	valid, err := s.repo.CheckTracksAvailability(trackIDs, userID)
-	if !valid || err != nil {
-		return 0, errors.New("invalid tracks selected")
+	valid, err := s.repo.CheckTracksAvailability(trackIDs, userID, meta.ReleaseType == ReleaseCompilation)
+	if err != nil {
+		return 0, err
+	}
+	if !valid {
+		return 0, errorspkg.ErrTracksUnavailable
 	}
 
-	albumID, err := s.repo.CreateAlbum(title, description, releaseDate, userID, is_Announced)
+	albumID, err := s.repo.CreateAlbum(title, description, releaseDate, userID, is_Announced, meta)
 	if err != nil {
 		return 0, err
 	}
@@ -190,11 +953,33 @@ func (s *Service) CreateAlbum(title, description string, releaseDate time.Time,
 		return 0, err
 	}
 
+	// Альбом с будущей датой виден подписчикам только если он анонсирован
+	eventType := notify.TypeAlbumRelease
+	if releaseDate.After(time.Now()) {
+		eventType = notify.TypeAlbumAnnounced
+	}
+	if eventType == notify.TypeAlbumRelease || is_Announced {
+		s.notifier.EmitToFollowers(userID, notify.Event{
+			Type:       eventType,
+			TargetType: "album",
+			TargetID:   albumID,
+			Data:       map[string]interface{}{"title": title, "release_date": releaseDate},
+		})
+	}
+
 	return albumID, nil
 }
 
+// DeleteAlbum удаляет альбом, затем его обложку из хранилища. Запись уже удалена,
+// поэтому ошибка хранилища только логируется
 func (s *Service) DeleteAlbum(albumID, userID int) error {
-	return s.repo.DeleteAlbum(albumID, userID)
+	if err := s.repo.DeleteAlbum(albumID, userID); err != nil {
+		return err
+	}
+	if err := s.storage.DeleteAlbumCover(albumID); err != nil {
+		log.Printf("ошибка удаления обложки альбома %d: %v", albumID, err)
+	}
+	return nil
 }
 
 // func (s *Service) ToggleAlbumVisibility(albumID, userID int) error {
@@ -209,8 +994,430 @@ func (s *Service) GetUserAlbums(userID int) ([]*Album, error) {
 	return s.repo.GetAlbumsByAuthor(userID)
 }
 
-func (s *Service) GetAlbumDetails(albumID int) (*Album, error) {
-	return s.repo.GetAlbumWithTracks(albumID)
+func (s *Service) GetAlbumDetails(albumID, viewerID int) (*Album, error) {
+	return s.repo.GetAlbumWithTracks(albumID, viewerID)
+}
+
+// SaveAlbum добавляет альбом в библиотеку; для анонса это пре-сейв до дня выхода
+func (s *Service) SaveAlbum(albumID, userID int) (bool, error) {
+	return s.repo.SaveAlbum(albumID, userID)
+}
+
+func (s *Service) UnsaveAlbum(albumID, userID int) error {
+	return s.repo.UnsaveAlbum(albumID, userID)
+}
+
+func (s *Service) GetLibraryAlbums(userID int) ([]Album, error) {
+	return s.repo.GetLibraryAlbums(userID)
+}
+
+// releaseJobBatch - сколько альбомов публикуется за одну транзакцию
+const releaseJobBatch = 50
+
+// RunAlbumReleaseJobs публикует альбомы в дату выхода: пре-сейвы попадают в библиотеки,
+// подписчики артиста и сделавшие пре-сейв получают уведомления
+func (s *Service) RunAlbumReleaseJobs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			releases, err := s.repo.PublishDueAlbums(releaseJobBatch)
+			if err != nil {
+				log.Printf("ошибка публикации альбомов: %v", err)
+				break
+			}
+			for _, rel := range releases {
+				s.notifyAlbumRelease(rel)
+			}
+			if len(releases) < releaseJobBatch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) notifyAlbumRelease(rel AlbumRelease) {
+	event := notify.Event{
+		ActorID:    rel.AuthorID,
+		Type:       notify.TypeAlbumRelease,
+		TargetType: "album",
+		TargetID:   rel.AlbumID,
+		Data:       map[string]interface{}{"title": rel.Title, "release_date": rel.ReleaseDate, "presaved": true},
+	}
+	for _, userID := range rel.Presavers {
+		event.RecipientID = userID
+		s.notifier.Emit(event)
+	}
+
+	event.Data = map[string]interface{}{"title": rel.Title, "release_date": rel.ReleaseDate}
+	s.notifier.EmitToFollowers(rel.AuthorID, event, rel.Presavers...)
+}
+
+// ownAlbum возвращает альбом, если его автор - userID
+func (s *Service) ownAlbum(albumID, userID int) (*Album, error) {
+	album, err := s.repo.GetAlbumMeta(albumID)
+	if err != nil {
+		return nil, err
+	}
+	if album.Author.ID != userID {
+		return nil, errorspkg.ErrAlbumNotFound
+	}
+	return album, nil
+}
+
+const maxReleaseLineLen = 200
+
+// normalizeAlbumMetadata проверяет тип релиза и коды, убирает знаки ℗ и © в начале строк прав
+func normalizeAlbumMetadata(meta *AlbumMetadata) error {
+	if !isReleaseType(meta.ReleaseType) {
+		return fmt.Errorf("%w: тип релиза должен быть одним из %s", errorspkg.ErrInvalidAlbumEdit, strings.Join(ReleaseTypes, ", "))
+	}
+	if meta.UPC = strings.TrimSpace(meta.UPC); meta.UPC != "" {
+		upc, err := ddex.NormalizeICPN(meta.UPC)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errorspkg.ErrInvalidAlbumEdit, err)
+		}
+		meta.UPC = upc
+	}
+	if meta.Language = strings.TrimSpace(meta.Language); meta.Language != "" {
+		lang, err := ddex.NormalizeLanguage(meta.Language)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errorspkg.ErrInvalidAlbumEdit, err)
+		}
+		meta.Language = lang
+	}
+	meta.Label = strings.TrimSpace(meta.Label)
+	meta.PLine = trimRightsSymbol(meta.PLine, "℗", "(P)")
+	meta.CLine = trimRightsSymbol(meta.CLine, "©", "(C)")
+	for _, line := range []string{meta.Label, meta.PLine, meta.CLine} {
+		if len([]rune(line)) > maxReleaseLineLen {
+			return fmt.Errorf("%w: лейбл и строки ℗/© не длиннее %d символов", errorspkg.ErrInvalidAlbumEdit, maxReleaseLineLen)
+		}
+	}
+	return nil
+}
+
+func isReleaseType(t string) bool {
+	for _, rt := range ReleaseTypes {
+		if rt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// trimRightsSymbol: "℗ 2024 Label" и "(P) 2024 Label" хранятся как "2024 Label", знак добавляется при выгрузке
+func trimRightsSymbol(line string, symbols ...string) string {
+	line = strings.TrimSpace(line)
+	for _, sym := range symbols {
+		if len(line) >= len(sym) && strings.EqualFold(line[:len(sym)], sym) {
+			return strings.TrimSpace(line[len(sym):])
+		}
+	}
+	return line
+}
+
+// UpdateAlbum меняет переданные поля. Если будущий альбом впервые анонсируют, подписчики получают уведомление
+func (s *Service) UpdateAlbum(albumID, userID int, req UpdateAlbumRequest) error {
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if title == "" {
+			return fmt.Errorf("%w: название не может быть пустым", errorspkg.ErrInvalidAlbumEdit)
+		}
+		req.Title = &title
+	}
+	if req.ReleaseDate != nil && req.ReleaseDate.IsZero() {
+		return fmt.Errorf("%w: некорректная дата выхода", errorspkg.ErrInvalidAlbumEdit)
+	}
+
+	before, err := s.ownAlbum(albumID, userID)
+	if err != nil {
+		return err
+	}
+
+	// Проверяем метаданные вместе с текущими значениями и передаем в запрос уже нормализованными
+	meta := before.AlbumMetadata
+	if req.ReleaseType != nil {
+		meta.ReleaseType = *req.ReleaseType
+	}
+	if req.Label != nil {
+		meta.Label = *req.Label
+	}
+	if req.UPC != nil {
+		meta.UPC = *req.UPC
+	}
+	if req.PLine != nil {
+		meta.PLine = *req.PLine
+	}
+	if req.CLine != nil {
+		meta.CLine = *req.CLine
+	}
+	if req.Language != nil {
+		meta.Language = *req.Language
+	}
+	if err := normalizeAlbumMetadata(&meta); err != nil {
+		return err
+	}
+	if req.ReleaseType != nil {
+		req.ReleaseType = &meta.ReleaseType
+	}
+	if req.Label != nil {
+		req.Label = &meta.Label
+	}
+	if req.UPC != nil {
+		req.UPC = &meta.UPC
+	}
+	if req.PLine != nil {
+		req.PLine = &meta.PLine
+	}
+	if req.CLine != nil {
+		req.CLine = &meta.CLine
+	}
+	if req.Language != nil {
+		req.Language = &meta.Language
+	}
+
+	if before.Is_Released && req.ReleaseDate != nil && req.ReleaseDate.After(time.Now()) {
+		return fmt.Errorf("%w: альбом уже вышел, дату выхода нельзя перенести в будущее", errorspkg.ErrInvalidAlbumEdit)
+	}
+	if err := s.repo.UpdateAlbum(albumID, userID, req); err != nil {
+		return err
+	}
+
+	title, releaseDate := before.Title, before.Release_Date
+	if req.Title != nil {
+		title = *req.Title
+	}
+	if req.ReleaseDate != nil {
+		releaseDate = *req.ReleaseDate
+	}
+	if req.IsAnnounced != nil && *req.IsAnnounced && !before.Is_Announced && releaseDate.After(time.Now()) {
+		s.notifier.EmitToFollowers(userID, notify.Event{
+			Type:       notify.TypeAlbumAnnounced,
+			TargetType: "album",
+			TargetID:   albumID,
+			Data:       map[string]interface{}{"title": title, "release_date": releaseDate},
+		})
+	}
+	return nil
+}
+
+// SetAlbumCover заменяет обложку альбома
+func (s *Service) SetAlbumCover(albumID, userID int, r io.Reader) error {
+	if _, err := s.ownAlbum(albumID, userID); err != nil {
+		return err
+	}
+	data, ext, err := readCoverUpload(r, errorspkg.ErrInvalidAlbumEdit)
+	if err != nil {
+		return err
+	}
+	// Сначала загружаем новую: если загрузка не удалась, у альбома остается прежняя обложка.
+	// Затем убираем обложку в другом формате, иначе GetImage продолжит отдавать .jpg
+	if err := s.storage.UploadImage("album", fmt.Sprintf("%d%s", albumID, ext), bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if err := s.storage.DeleteOldAlbumCover(albumID, ext); err != nil {
+		return err
+	}
+	return s.repo.SetAlbumCoverUpdated(albumID)
+}
+
+// AddAlbumTrack добавляет трек на диск (0 - первый) под номером (0 - в конец диска)
+func (s *Service) AddAlbumTrack(albumID, userID int, ref AlbumTrackRef) error {
+	if ref.DiscNumber < 0 || ref.TrackNumber < 0 {
+		return fmt.Errorf("%w: номера диска и трека не могут быть отрицательными", errorspkg.ErrInvalidAlbumEdit)
+	}
+	if ref.DiscNumber == 0 {
+		ref.DiscNumber = 1
+	}
+	return s.repo.AddAlbumTrack(albumID, userID, ref)
+}
+
+func (s *Service) RemoveAlbumTrack(albumID, userID, trackID int) error {
+	return s.repo.RemoveAlbumTrack(albumID, userID, trackID)
+}
+
+// SetTrackMetadata меняет переданные поля: ISRC, пометку explicit и язык исполнения трека
+func (s *Service) SetTrackMetadata(trackID, userID int, req UpdateTrackMetadataRequest) error {
+	if req.ISRC != nil {
+		isrc := strings.TrimSpace(*req.ISRC)
+		if isrc != "" {
+			var err error
+			if isrc, err = ddex.NormalizeISRC(isrc); err != nil {
+				return fmt.Errorf("%w: %v", errorspkg.ErrInvalidTrackEdit, err)
+			}
+		}
+		req.ISRC = &isrc
+	}
+	if req.Language != nil {
+		lang := strings.TrimSpace(*req.Language)
+		if lang != "" {
+			var err error
+			if lang, err = ddex.NormalizeLanguage(lang); err != nil {
+				return fmt.Errorf("%w: %v", errorspkg.ErrInvalidTrackEdit, err)
+			}
+		}
+		req.Language = &lang
+	}
+
+	found, err := s.repo.SetTrackMetadata(trackID, userID, req)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errorspkg.ErrTrackNotFound
+	}
+	return nil
+}
+
+// ddexRoles - роли участников в терминах DDEX
+var ddexRoles = map[string]string{
+	CreditPrimary:  "MainArtist",
+	CreditFeatured: "FeaturedArtist",
+	CreditProducer: "Producer",
+	CreditComposer: "Composer",
+	CreditRemixer:  "Remixer",
+}
+
+// ExportAlbumRelease собирает DDEX-подобное описание релиза для дистрибьютора. Недостающие для
+// отправки данные не мешают выгрузке, а перечисляются в Warnings
+func (s *Service) ExportAlbumRelease(albumID, userID int) (*ddex.NewReleaseMessage, error) {
+	album, err := s.ownAlbum(albumID, userID)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := s.repo.GetAlbumTrackList(albumID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	year := album.Release_Date.Year()
+	release := ddex.Release{
+		ReleaseReference:      "R0",
+		ReleaseType:           ddex.ReleaseTypeAlbum,
+		IsCompilation:         album.ReleaseType == ReleaseCompilation,
+		ICPN:                  album.UPC,
+		Title:                 album.Title,
+		DisplayArtistName:     album.Author.Username,
+		LabelName:             album.Label,
+		LanguageOfPerformance: album.Language,
+		OriginalReleaseDate:   album.Release_Date.Format("2006-01-02"),
+	}
+	switch album.ReleaseType {
+	case ReleaseSingle:
+		release.ReleaseType = ddex.ReleaseTypeSingle
+	case ReleaseEP:
+		release.ReleaseType = ddex.ReleaseTypeEP
+	}
+	if album.PLine != "" {
+		release.PLine = &ddex.Line{Year: year, Text: "℗ " + album.PLine}
+	}
+	if album.CLine != "" {
+		release.CLine = &ddex.Line{Year: year, Text: "© " + album.CLine}
+	}
+
+	msg := &ddex.NewReleaseMessage{
+		MessageHeader: ddex.MessageHeader{
+			MessageID:              fmt.Sprintf("album-%d-%d", album.ID, now.Unix()),
+			MessageSender:          "BeatStreet",
+			MessageCreatedDateTime: now,
+		},
+		SoundRecordings: []ddex.SoundRecording{},
+	}
+	if album.UPC == "" {
+		msg.Warnings = append(msg.Warnings, "не указан UPC/EAN релиза")
+	}
+	if album.Label == "" {
+		msg.Warnings = append(msg.Warnings, "не указан лейбл")
+	}
+	if album.PLine == "" || album.CLine == "" {
+		msg.Warnings = append(msg.Warnings, "не указаны строки ℗ и ©")
+	}
+
+	explicit, total := album.Explicit, 0
+	for i, t := range tracks {
+		ref := ddex.ResourceReference(i + 1)
+		rec := ddex.SoundRecording{
+			ResourceReference:     ref,
+			ISRC:                  t.ISRC,
+			Title:                 t.Title,
+			DisplayArtistName:     t.Author.Username,
+			Duration:              ddex.Duration(t.Duration),
+			ParentalWarningType:   ddex.ParentalWarning(t.Explicit),
+			LanguageOfPerformance: t.Language,
+		}
+		for _, c := range t.Credits {
+			rec.Contributors = append(rec.Contributors, ddex.Contributor{Name: c.Name, Role: ddexRoles[c.Role]})
+		}
+		if t.ISRC == "" {
+			msg.Warnings = append(msg.Warnings, fmt.Sprintf("у трека «%s» нет ISRC", t.Title))
+		}
+		msg.SoundRecordings = append(msg.SoundRecordings, rec)
+
+		if n := len(release.ResourceGroups); n == 0 || release.ResourceGroups[n-1].SequenceNumber != t.DiscNumber {
+			release.ResourceGroups = append(release.ResourceGroups, ddex.ResourceGroup{SequenceNumber: t.DiscNumber})
+		}
+		group := &release.ResourceGroups[len(release.ResourceGroups)-1]
+		group.ContentItems = append(group.ContentItems, ddex.ContentItem{SequenceNumber: t.TrackNumber, ReleaseResourceReference: ref})
+
+		explicit = explicit || t.Explicit
+		total += t.Duration
+	}
+	// Релиз помечается explicit, если такой хотя бы один трек
+	release.ParentalWarningType = ddex.ParentalWarning(explicit)
+	release.Duration = ddex.Duration(total)
+	msg.Release = release
+	return msg, nil
+}
+
+// ReplaceAlbumTracks задает трек-лист целиком. Номера дисков сжимаются без пропусков с сохранением
+// порядка, треки на диске нумеруются в порядке списка; переданные track_number не учитываются
+func (s *Service) ReplaceAlbumTracks(albumID, userID int, refs []AlbumTrackRef) error {
+	if len(refs) == 0 {
+		return fmt.Errorf("%w: в альбоме должен быть хотя бы один трек", errorspkg.ErrInvalidAlbumEdit)
+	}
+
+	seen := make(map[int]bool, len(refs))
+	discSet := map[int]bool{}
+	for i := range refs {
+		if refs[i].DiscNumber < 0 {
+			return fmt.Errorf("%w: номер диска не может быть отрицательным", errorspkg.ErrInvalidAlbumEdit)
+		}
+		if refs[i].DiscNumber == 0 {
+			refs[i].DiscNumber = 1
+		}
+		if seen[refs[i].TrackID] {
+			return fmt.Errorf("%w: трек %d указан дважды", errorspkg.ErrInvalidAlbumEdit, refs[i].TrackID)
+		}
+		seen[refs[i].TrackID] = true
+		discSet[refs[i].DiscNumber] = true
+	}
+
+	discs := make([]int, 0, len(discSet))
+	for d := range discSet {
+		discs = append(discs, d)
+	}
+	sort.Ints(discs)
+	discIndex := make(map[int]int, len(discs))
+	for i, d := range discs {
+		discIndex[d] = i + 1
+	}
+
+	counts := map[int]int{}
+	for i := range refs {
+		disc := discIndex[refs[i].DiscNumber]
+		counts[disc]++
+		refs[i].DiscNumber, refs[i].TrackNumber = disc, counts[disc]
+	}
+	return s.repo.ReplaceAlbumTracks(albumID, userID, refs)
 }
 
 func (s *Service) GetAudienceRetention(trackID int, period string) ([]RetentionPoint, error) {
@@ -228,3 +1435,1268 @@ func (s *Service) GetTimeOfDay(trackID int, period string) ([]TimeOfDay, error)
 func (s *Service) GetGeography(trackID int, period string) (*GeographyData, error) {
 	return s.repo.GetGeography(trackID, period)
 }
+
+// RequireStepUp проверяет step-up токен из user-service перед опасными операциями
+func (s *Service) RequireStepUp(userID int, stepUpToken string) error {
+	enabled, required, err := s.repo.GetTwoFactorState(userID)
+	if err != nil {
+		return err
+	}
+
+	if !enabled {
+		if required {
+			return errorspkg.ErrTwoFactorSetupRequired
+		}
+		return nil
+	}
+
+	claims, err := auth.ParseMFAToken(stepUpToken, auth.PurposeStepUp)
+	if err != nil || claims.UserID != userID {
+		return errorspkg.ErrStepUpRequired
+	}
+	return nil
+}
+
+const (
+	maxBioLength      = 1000
+	maxLocationLength = 100
+	maxArtistLinks    = 10
+	maxArtistGenres   = 5
+	maxGenreLength    = 30
+)
+
+func (s *Service) UpdateArtistProfile(userID int, req UpdateArtistProfileRequest) error {
+	req.Bio = strings.TrimSpace(req.Bio)
+	req.Location = strings.TrimSpace(req.Location)
+	if len([]rune(req.Bio)) > maxBioLength {
+		return fmt.Errorf("биография длиннее %d символов", maxBioLength)
+	}
+	if len([]rune(req.Location)) > maxLocationLength {
+		return fmt.Errorf("местоположение длиннее %d символов", maxLocationLength)
+	}
+
+	links, err := validateArtistLinks(req.Links)
+	if err != nil {
+		return err
+	}
+	req.Links = links
+
+	genres := []string{}
+	seen := make(map[string]bool)
+	for _, g := range req.Genres {
+		g = strings.ToLower(strings.TrimSpace(g))
+		if g == "" || seen[g] {
+			continue
+		}
+		if len([]rune(g)) > maxGenreLength {
+			return fmt.Errorf("название жанра длиннее %d символов", maxGenreLength)
+		}
+		seen[g] = true
+		genres = append(genres, g)
+	}
+	if len(genres) > maxArtistGenres {
+		return fmt.Errorf("можно указать не больше %d жанров", maxArtistGenres)
+	}
+	req.Genres = genres
+
+	return s.repo.UpsertArtistProfile(userID, req)
+}
+
+func validateArtistLinks(links []ArtistLink) ([]ArtistLink, error) {
+	if len(links) > maxArtistLinks {
+		return nil, fmt.Errorf("можно указать не больше %d ссылок", maxArtistLinks)
+	}
+	result := make([]ArtistLink, 0, len(links))
+	for _, link := range links {
+		link.Title = strings.TrimSpace(link.Title)
+		u, err := url.Parse(strings.TrimSpace(link.URL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("некорректная ссылка: %s", link.URL)
+		}
+		link.URL = u.String()
+		if link.Title == "" {
+			link.Title = u.Host
+		}
+		result = append(result, link)
+	}
+	return result, nil
+}
+
+// SetArtistBanner загружает баннер артиста (JPEG или PNG). Новый файл загружается до удаления
+// старого в другом формате, чтобы при ошибке у артиста остался прежний баннер
+func (s *Service) SetArtistBanner(userID int, r io.Reader) error {
+	data, ext, err := readCoverUpload(r, errorspkg.ErrInvalidBanner)
+	if err != nil {
+		return err
+	}
+	if err := s.storage.UploadImage("banner", fmt.Sprintf("%d%s", userID, ext), bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if err := s.storage.DeleteOldBanner(userID, ext); err != nil {
+		return err
+	}
+	return s.repo.SetArtistBanner(userID)
+}
+
+func (s *Service) RequestVerification(userID int, req CreateVerificationRequest) (int, error) {
+	profile, err := s.repo.GetArtistProfile(userID)
+	if err != nil {
+		return 0, err
+	}
+	if profile == nil {
+		return 0, errors.New("пользователь не найден")
+	}
+	if profile.IsVerified {
+		return 0, errors.New("артист уже верифицирован")
+	}
+
+	links, err := validateArtistLinks(req.Links)
+	if err != nil {
+		return 0, err
+	}
+	return s.repo.CreateVerificationRequest(userID, strings.TrimSpace(req.Message), links)
+}
+
+func (s *Service) GetVerificationStatus(userID int) (*VerificationRequest, error) {
+	return s.repo.GetLatestVerificationRequest(userID)
+}
+
+func (s *Service) GetVerificationRequests(status string) ([]VerificationRequest, error) {
+	if status == "" {
+		status = "pending"
+	}
+	return s.repo.GetVerificationRequests(status)
+}
+
+func (s *Service) ReviewVerificationRequest(requestID, reviewerID int, req ReviewVerificationRequest) (bool, error) {
+	return s.repo.ReviewVerificationRequest(requestID, reviewerID, req.Approve, strings.TrimSpace(req.Note))
+}
+
+func (s *Service) RevokeVerification(userID int) error {
+	return s.repo.RevokeVerification(userID)
+}
+
+const (
+	maxTrackCredits  = 20
+	maxCreditNameLen = 100
+)
+
+// SetTrackCredits проверяет и сохраняет участников трека; автор трека в список не входит
+func (s *Service) SetTrackCredits(trackID, userID int, credits []TrackCredit) error {
+	result := make([]TrackCredit, 0, len(credits))
+	seen := make(map[string]bool)
+	for _, credit := range credits {
+		credit.Role = strings.ToLower(strings.TrimSpace(credit.Role))
+		credit.Name = strings.TrimSpace(credit.Name)
+		credit.Avatar = ""
+		if !isCreditRole(credit.Role) {
+			return fmt.Errorf("неизвестная роль участника: %s", credit.Role)
+		}
+
+		var key string
+		if credit.UserID != nil {
+			user, err := s.repo.GetUserByID(*credit.UserID)
+			if err != nil {
+				return err
+			}
+			if user == nil {
+				return fmt.Errorf("пользователь %d не найден", *credit.UserID)
+			}
+			// Снимок имени остается в титрах, если аккаунт участника удалят (user_id станет NULL)
+			credit.Name = user.Username
+			key = fmt.Sprintf("%s:%d", credit.Role, *credit.UserID)
+		} else {
+			if credit.Name == "" {
+				return errors.New("у участника должен быть указан пользователь или имя")
+			}
+			if len([]rune(credit.Name)) > maxCreditNameLen {
+				return fmt.Errorf("имя участника длиннее %d символов", maxCreditNameLen)
+			}
+			key = credit.Role + ":" + strings.ToLower(credit.Name)
+		}
+
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, credit)
+	}
+	if len(result) > maxTrackCredits {
+		return fmt.Errorf("можно указать не больше %d участников", maxTrackCredits)
+	}
+
+	found, err := s.repo.ReplaceTrackCredits(trackID, userID, result)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("трек не найден или у вас нет прав")
+	}
+	return nil
+}
+
+func isCreditRole(role string) bool {
+	for _, r := range CreditRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Итоги прослушиваний
+
+const reportTopSize = 5
+
+// ParseReportPeriod разбирает ключ периода: "2024" для года, "2024-03" для месяца
+func ParseReportPeriod(period, key string) (start, end time.Time, err error) {
+	switch period {
+	case ReportYear:
+		year, convErr := strconv.Atoi(key)
+		if convErr != nil || len(key) != 4 {
+			return start, end, errors.New("год указывается в формате ГГГГ")
+		}
+		start = time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0), nil
+	case ReportMonth:
+		t, parseErr := time.Parse("2006-01", key)
+		if parseErr != nil {
+			return start, end, errors.New("месяц указывается в формате ГГГГ-ММ")
+		}
+		return t, t.AddDate(0, 1, 0), nil
+	}
+	return start, end, errors.New("период должен быть year или month")
+}
+
+func reportKey(period string, start time.Time) string {
+	if period == ReportYear {
+		return start.Format("2006")
+	}
+	return start.Format("2006-01")
+}
+
+// BuildListeningReport считает итоги заново; nil, если за период не было прослушиваний
+func (s *Service) BuildListeningReport(userID int, period string, start, end time.Time) (*ListeningReport, error) {
+	listens, seconds, err := s.repo.GetListeningTotals(userID, start, end)
+	if err != nil || listens == 0 {
+		return nil, err
+	}
+
+	report := &ListeningReport{
+		UserID:       userID,
+		Period:       period,
+		Key:          reportKey(period, start),
+		PeriodStart:  start,
+		PeriodEnd:    end,
+		TotalListens: listens,
+		TotalMinutes: seconds / 60,
+		GeneratedAt:  time.Now(),
+	}
+
+	if report.TopTracks, err = s.repo.GetReportTopTracks(userID, start, end, reportTopSize); err != nil {
+		return nil, err
+	}
+	if report.TopArtists, err = s.repo.GetReportTopArtists(userID, start, end, reportTopSize); err != nil {
+		return nil, err
+	}
+	if report.TopGenres, err = s.repo.GetReportTopGenres(userID, start, end, reportTopSize); err != nil {
+		return nil, err
+	}
+	if report.TopDiscoveries, err = s.repo.GetReportDiscoveries(userID, start, end, reportTopSize); err != nil {
+		return nil, err
+	}
+	if report.NewArtists, err = s.repo.GetNewArtistCount(userID, start, end); err != nil {
+		return nil, err
+	}
+	if report.NewTracks, err = s.repo.GetNewTrackCount(userID, start, end); err != nil {
+		return nil, err
+	}
+	if report.MostActiveHour, err = s.repo.GetMostActiveHour(userID, start, end); err != nil {
+		return nil, err
+	}
+
+	days, err := s.repo.GetListeningDays(userID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	report.ListeningDays = len(days)
+	report.LongestStreak = longestStreak(days)
+
+	profile, ok, err := s.repo.getAudioProfile(userID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		report.Personality = listeningPersonality(profile)
+	}
+
+	return report, nil
+}
+
+// longestStreak - самая длинная серия дней подряд; days отсортированы по возрастанию
+func longestStreak(days []time.Time) int {
+	best, current := 0, 0
+	for i, d := range days {
+		if i > 0 && d.Sub(days[i-1]) == 24*time.Hour {
+			current++
+		} else {
+			current = 1
+		}
+		if current > best {
+			best = current
+		}
+	}
+	return best
+}
+
+// listeningPersonality относит слушателя к одному из типов по темпу, громкости (RMSE) и яркости звучания
+func listeningPersonality(p audioProfile) *ListeningPersonality {
+	result := &ListeningPersonality{
+		TempoBPM:   p.TempoBPM,
+		Energy:     p.RMSEMean,
+		Brightness: p.SpectralCentroid,
+	}
+
+	switch {
+	case p.Genres >= 8:
+		result.Type = "explorer"
+		result.Title = "Исследователь"
+		result.Description = "Вы не привязаны к одному жанру и постоянно ищете новое звучание"
+	case p.TempoBPM >= 125 && p.RMSEMean >= 0.2:
+		result.Type = "energizer"
+		result.Title = "Энерджайзер"
+		result.Description = "Быстрый темп и плотный звук - ваша музыка не дает сидеть на месте"
+	case p.TempoBPM < 95 && p.RMSEMean < 0.12:
+		result.Type = "dreamer"
+		result.Title = "Мечтатель"
+		result.Description = "Спокойные, медленные треки, под которые хорошо думается"
+	case p.SpectralCentroid >= 3000 || p.ZeroCrossingRate >= 0.12:
+		result.Type = "edgy"
+		result.Title = "Бунтарь"
+		result.Description = "Яркое, резкое звучание с большим количеством высоких частот"
+	default:
+		result.Type = "balanced"
+		result.Title = "Гармония"
+		result.Description = "Ровный баланс темпа и энергии - музыка на любой случай"
+	}
+	return result
+}
+
+// GetListeningReport отдает готовые итоги с учетом настройки приватности истории прослушиваний
+func (s *Service) GetListeningReport(userID int, period, key string, viewerID int, isAdmin bool) (*ListeningReport, error) {
+	start, _, err := ParseReportPeriod(period, key)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, access, err := s.privacyAccess(userID, viewerID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if !settings.CanViewHistory(access) {
+		return nil, errorspkg.ErrPrivateContent
+	}
+
+	return s.repo.GetListeningReport(userID, period, start)
+}
+
+func (s *Service) GetListeningReports(userID int) ([]ReportSummary, error) {
+	return s.repo.GetListeningReports(userID)
+}
+
+// RenderReportCard рисует карточку итогов для публикации
+func (s *Service) RenderReportCard(report *ListeningReport) ([]byte, error) {
+	user, err := s.repo.GetUserByID(report.UserID)
+	if err != nil {
+		return nil, err
+	}
+	username := ""
+	if user != nil {
+		username = user.Username
+	}
+
+	title := "Мой " + report.Key + " в музыке"
+	subtitle := "Итоги года"
+	if report.Period == ReportMonth {
+		title = "Мой месяц в музыке"
+		subtitle = "Итоги " + report.Key
+	}
+	if report.Personality != nil {
+		subtitle += " · " + report.Personality.Title
+	}
+
+	card := sharecard.Card{
+		Title:    title,
+		Subtitle: subtitle,
+		Username: username,
+		Stats: []sharecard.Stat{
+			{Label: "минут", Value: strconv.Itoa(report.TotalMinutes)},
+			{Label: "дней подряд", Value: strconv.Itoa(report.LongestStreak)},
+			{Label: "новых артистов", Value: strconv.Itoa(report.NewArtists)},
+		},
+		Footer: "BeatStreet",
+	}
+
+	tracks := sharecard.List{Title: "Треки"}
+	for _, t := range report.TopTracks {
+		tracks.Items = append(tracks.Items, t.Title)
+	}
+	artists := sharecard.List{Title: "Артисты"}
+	for _, a := range report.TopArtists {
+		artists.Items = append(artists.Items, a.Username)
+	}
+	card.Lists = []sharecard.List{tracks, artists}
+
+	return sharecard.RenderSVG(card)
+}
+
+// RunReportJobs считает итоги за прошедший месяц и прошедший год для всех, кто слушал музыку в этот период
+func (s *Service) RunReportJobs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now().UTC()
+		thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		thisYear := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+
+		s.generateReports(ReportMonth, thisMonth.AddDate(0, -1, 0), thisMonth)
+		s.generateReports(ReportYear, thisYear.AddDate(-1, 0, 0), thisYear)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) generateReports(period string, start, end time.Time) {
+	userIDs, err := s.repo.GetUsersWithoutReport(period, start, end)
+	if err != nil {
+		log.Printf("ошибка получения слушателей для итогов: %v", err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		report, err := s.BuildListeningReport(userID, period, start, end)
+		if err == nil && report != nil {
+			err = s.repo.SaveListeningReport(report)
+		}
+		if err != nil {
+			log.Printf("ошибка расчета итогов %s %s для пользователя %d: %v", period, reportKey(period, start), userID, err)
+		}
+	}
+}
+
+// Скробблинг
+
+const (
+	scrobbleBatchSize   = 100
+	scrobbleLease       = 10 * time.Minute
+	scrobbleMaxAttempts = 8
+	scrobbleRetryDelay  = time.Minute
+	scrobbleClient      = "BeatStreet"
+)
+
+func (s *Service) ConnectScrobbler(userID int, token string) (*ScrobbleConnection, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, errors.New("токен ListenBrainz обязателен")
+	}
+
+	username, err := s.scrobbler.ValidateToken(token)
+	if err != nil {
+		return nil, err
+	}
+	// Токен хранится только зашифрованным и наружу из API не отдается
+	sealed, err := auth.SealSecret(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SaveScrobbleConnection(userID, sealed, username); err != nil {
+		return nil, err
+	}
+	return s.repo.GetScrobbleConnection(userID)
+}
+
+func (s *Service) DisconnectScrobbler(userID int) error {
+	return s.repo.DeleteScrobbleConnection(userID)
+}
+
+func (s *Service) GetScrobbleConnection(userID int) (*ScrobbleConnection, error) {
+	return s.repo.GetScrobbleConnection(userID)
+}
+
+func (s *Service) enqueueScrobble(userID, listenID, trackID, listenTime int) error {
+	enabled, err := s.repo.IsScrobblingEnabled(userID)
+	if err != nil || !enabled {
+		return err
+	}
+
+	track, err := s.repo.GetTrackByID(trackID)
+	if err != nil || track == nil {
+		return err
+	}
+	if !listenbrainz.Qualifies(track.Duration, listenTime) {
+		return nil
+	}
+
+	listen := listenbrainz.Listen{
+		ListenedAt: time.Now().Unix(),
+		TrackMetadata: listenbrainz.TrackMetadata{
+			ArtistName: scrobbleArtistName(track.Author.Username, track.Credits),
+			TrackName:  track.Title,
+			AdditionalInfo: listenbrainz.AdditionalInfo{
+				DurationMs:       track.Duration * 1000,
+				SubmissionClient: scrobbleClient,
+			},
+		},
+	}
+	payload, err := json.Marshal(listen)
+	if err != nil {
+		return err
+	}
+	return s.repo.EnqueueScrobble(userID, listenID, payload)
+}
+
+// scrobbleArtistName: "Автор & Соавтор feat. Гость" - так артистов записывает большинство скробблеров
+func scrobbleArtistName(author string, credits []TrackCredit) string {
+	name := author
+	var featured []string
+	for _, c := range credits {
+		switch c.Role {
+		case CreditPrimary:
+			name += " & " + c.Name
+		case CreditFeatured:
+			featured = append(featured, c.Name)
+		}
+	}
+	if len(featured) > 0 {
+		name += " feat. " + strings.Join(featured, ", ")
+	}
+	return name
+}
+
+// RunScrobbleWorker отправляет очередь скробблинга до отмены ctx
+func (s *Service) RunScrobbleWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := s.ProcessScrobbles()
+			if err != nil {
+				log.Printf("ошибка обработки очереди скробблинга: %v", err)
+				break
+			}
+			if n < scrobbleBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessScrobbles отправляет одну пачку очереди, сгруппировав прослушивания по пользователям
+func (s *Service) ProcessScrobbles() (int, error) {
+	batch, err := s.repo.ClaimScrobbles(scrobbleBatchSize, scrobbleLease)
+	if err != nil {
+		return 0, err
+	}
+
+	byUser := make(map[int][]queuedScrobble)
+	var order []int
+	for _, q := range batch {
+		if _, ok := byUser[q.UserID]; !ok {
+			order = append(order, q.UserID)
+		}
+		byUser[q.UserID] = append(byUser[q.UserID], q)
+	}
+
+	for _, userID := range order {
+		if err := s.submitScrobbles(userID, byUser[userID]); err != nil {
+			return 0, err
+		}
+	}
+	return len(batch), nil
+}
+
+func (s *Service) submitScrobbles(userID int, items []queuedScrobble) error {
+	listens := make([]listenbrainz.Listen, 0, len(items))
+	ids := make([]int, 0, len(items))
+	for _, q := range items {
+		var l listenbrainz.Listen
+		if err := json.Unmarshal(q.Payload, &l); err != nil {
+			if err := s.repo.FailScrobbles([]int{q.ID}, "некорректные данные прослушивания"); err != nil {
+				return err
+			}
+			continue
+		}
+		listens = append(listens, l)
+		ids = append(ids, q.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	listenType := listenbrainz.ListenTypeImport
+	if len(listens) == 1 {
+		listenType = listenbrainz.ListenTypeSingle
+	}
+
+	token, err := auth.OpenSecret(items[0].Token)
+	if err != nil {
+		return s.repo.DisableScrobbleConnection(userID, err.Error())
+	}
+
+	sendErr := s.scrobbler.SubmitListens(token, listenType, listens)
+	switch {
+	case sendErr == nil:
+		return s.repo.MarkScrobblesSent(userID, ids)
+	case errors.Is(sendErr, listenbrainz.ErrInvalidToken):
+		// Очередь остается в pending и уйдет после переподключения
+		return s.repo.DisableScrobbleConnection(userID, sendErr.Error())
+	case errors.Is(sendErr, listenbrainz.ErrRejected):
+		return s.repo.FailScrobbles(ids, sendErr.Error())
+	}
+
+	for _, q := range items {
+		attempts := q.Attempts + 1
+		// 1, 2, 4, ... минут между попытками
+		delay := scrobbleRetryDelay << (attempts - 1)
+		if err := s.repo.RetryScrobble(q.ID, attempts, scrobbleMaxAttempts, time.Now().Add(delay), sendErr.Error()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportScrobbles собирает засчитываемые прослушивания за период в формате импорта ListenBrainz
+func (s *Service) ExportScrobbles(userID int, from, to time.Time) ([]listenbrainz.Listen, error) {
+	rows, err := s.repo.GetScrobbleListens(userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	trackIDs := make([]int, 0, len(rows))
+	seen := make(map[int]bool)
+	for _, l := range rows {
+		if !seen[l.TrackID] {
+			seen[l.TrackID] = true
+			trackIDs = append(trackIDs, l.TrackID)
+		}
+	}
+	credits, err := s.repo.GetTrackCredits(trackIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	listens := make([]listenbrainz.Listen, 0, len(rows))
+	for _, l := range rows {
+		if !listenbrainz.Qualifies(l.Duration, l.ListenTime) {
+			continue
+		}
+		listens = append(listens, listenbrainz.Listen{
+			ListenedAt: l.ListenedAt.Unix(),
+			TrackMetadata: listenbrainz.TrackMetadata{
+				ArtistName:  scrobbleArtistName(l.Artist, credits[l.TrackID]),
+				TrackName:   l.Title,
+				ReleaseName: l.Album,
+				AdditionalInfo: listenbrainz.AdditionalInfo{
+					DurationMs:       l.Duration * 1000,
+					SubmissionClient: scrobbleClient,
+				},
+			},
+		})
+	}
+	return listens, nil
+}
+
+// Импорт истории прослушиваний
+
+const (
+	importBatchSize       = 1000
+	importUnmatchedSample = 50
+)
+
+// ImportListeningHistory разбирает файл сразу, чтобы ошибка формата вернулась пользователю,
+// а сопоставление с каталогом выполняет в фоне
+func (s *Service) ImportListeningHistory(userID int, source, fileName string, r io.Reader) (*ListeningImport, error) {
+	entries, err := historyimport.Parse(source, r)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.repo.CreateListeningImport(userID, source, fileName, len(entries))
+	if err != nil {
+		return nil, err
+	}
+
+	go s.processListeningImport(userID, id, source, entries)
+
+	return &ListeningImport{
+		ID:        id,
+		Source:    source,
+		FileName:  fileName,
+		Status:    "processing",
+		Total:     len(entries),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func (s *Service) processListeningImport(userID, importID int, source string, entries []historyimport.Entry) {
+	imp := &ListeningImport{ID: importID, Status: "done"}
+	if err := s.matchListeningImport(userID, source, entries, imp); err != nil {
+		log.Printf("ошибка импорта истории %d: %v", importID, err)
+		imp.Status = "failed"
+		imp.Error = err.Error()
+	}
+	if err := s.repo.FinishListeningImport(imp); err != nil {
+		log.Printf("не удалось сохранить результат импорта %d: %v", importID, err)
+	}
+}
+
+func (s *Service) matchListeningImport(userID int, source string, entries []historyimport.Entry, imp *ListeningImport) error {
+	artists := make([]string, len(entries))
+	for i, e := range entries {
+		artists[i] = e.Artist
+	}
+	candidates, err := s.repo.GetImportCandidates(historyimport.ArtistKeys(artists), nil)
+	if err != nil {
+		return err
+	}
+	matcher := historyimport.NewMatcher(candidates)
+
+	type result struct {
+		candidate historyimport.Candidate
+		ok        bool
+	}
+	cache := make(map[string]result)
+	unmatched := make(map[string]*UnmatchedListen)
+
+	var batch []ImportedListen
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := s.repo.InsertImportedListens(userID, imp.ID, source, batch)
+		imp.Imported += n
+		batch = batch[:0]
+		return err
+	}
+
+	for _, e := range entries {
+		key := strings.ToLower(e.Artist) + "\x00" + strings.ToLower(e.Title)
+		res, seen := cache[key]
+		if !seen {
+			res.candidate, res.ok = matcher.Match(e.Artist, e.Title)
+			cache[key] = res
+		}
+		if !res.ok {
+			imp.Unmatched++
+			if u, ok := unmatched[key]; ok {
+				u.Plays++
+			} else {
+				unmatched[key] = &UnmatchedListen{Artist: e.Artist, Title: e.Title, Plays: 1}
+			}
+			continue
+		}
+
+		imp.Matched++
+		// Spotify сообщает, сколько играл трек; Last.fm и ListenBrainz хранят уже засчитанные скробблы
+		listenTime := res.candidate.Duration
+		if e.MsPlayed > 0 {
+			listenTime = e.MsPlayed / 1000
+			if !listenbrainz.Qualifies(res.candidate.Duration, listenTime) {
+				continue
+			}
+		}
+
+		batch = append(batch, ImportedListen{TrackID: res.candidate.TrackID, ListenTime: listenTime, PlayedAt: e.PlayedAt})
+		if len(batch) >= importBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	// Короткие прослушивания и уже импортированные ранее
+	imp.Skipped = imp.Matched - imp.Imported
+	imp.UnmatchedSample = topUnmatched(unmatched, importUnmatchedSample)
+	return nil
+}
+
+// topUnmatched - самые часто слушаемые из ненайденных треков, по ним видно, чего не хватает в каталоге
+func topUnmatched(unmatched map[string]*UnmatchedListen, limit int) []UnmatchedListen {
+	list := make([]UnmatchedListen, 0, len(unmatched))
+	for _, u := range unmatched {
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Plays != list[j].Plays {
+			return list[i].Plays > list[j].Plays
+		}
+		return list[i].Artist+list[i].Title < list[j].Artist+list[j].Title
+	})
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+func (s *Service) GetListeningImport(importID, userID int) (*ListeningImport, error) {
+	return s.repo.GetListeningImport(importID, userID)
+}
+
+func (s *Service) GetListeningImports(userID int) ([]ListeningImport, error) {
+	return s.repo.GetListeningImports(userID)
+}
+
+func (s *Service) DeleteListeningImport(importID, userID int) (bool, error) {
+	return s.repo.DeleteListeningImport(importID, userID)
+}
+
+// FailStaleImports вызывается при старте: фоновые импорты не переживают перезапуск
+func (s *Service) FailStaleImports() error {
+	return s.repo.FailStaleImports()
+}
+
+// Импорт и экспорт плейлистов
+
+// ExportPlaylist собирает плейлист для выгрузки; адреса треков указывают на HLS-потоки сервиса
+func (s *Service) ExportPlaylist(playlistID, viewerID int, isAdmin bool, baseURL string) (*playlistfile.Playlist, error) {
+	playlist, err := s.GetPlaylistByID(playlistID, viewerID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if playlist == nil {
+		return nil, errorspkg.ErrPlaylistNotFound
+	}
+
+	out := &playlistfile.Playlist{
+		Title:       playlist.Title,
+		Creator:     playlist.Author.Username,
+		Description: playlist.Description,
+	}
+	for _, t := range playlist.Tracks {
+		out.Entries = append(out.Entries, playlistfile.Entry{
+			Title:    t.Title,
+			Artist:   t.Author.Username,
+			Duration: t.Duration,
+			Location: fmt.Sprintf("%s/songs/%d", baseURL, t.ID),
+		})
+	}
+	return out, nil
+}
+
+// ownTrackURL узнает адреса наших HLS-потоков, например из ранее выгруженного плейлиста
+var ownTrackURL = regexp.MustCompile(`/songs/(\d+)/?$`)
+
+// ImportPlaylist создает плейлист из файла. Записи сопоставляются с каталогом по артисту и названию
+// с учетом опечаток; повторы одного трека пропускаются, ненайденные возвращаются в отчете
+func (s *Service) ImportPlaylist(userID int, format, fileName, visibility string, r io.Reader) (*PlaylistImportResult, error) {
+	if format == "" {
+		format = playlistfile.FormatFromName(fileName)
+	}
+	if visibility == "" {
+		visibility = VisibilityPublic
+	}
+	if !validVisibility(visibility) {
+		return nil, fmt.Errorf("%w: неизвестная видимость %q", errorspkg.ErrInvalidPlaylistEdit, visibility)
+	}
+
+	parsed, err := playlistfile.Parse(format, r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errorspkg.ErrInvalidPlaylistEdit, err)
+	}
+
+	// Кроме артистов из файла берем треки, на которые ведут наши же ссылки
+	artists := make([]string, 0, len(parsed.Entries))
+	var linkedIDs []int
+	for _, e := range parsed.Entries {
+		artists = append(artists, e.Artist)
+		if m := ownTrackURL.FindStringSubmatch(urlPath(e.Location)); m != nil {
+			if id, err := strconv.Atoi(m[1]); err == nil {
+				linkedIDs = append(linkedIDs, id)
+			}
+		}
+	}
+	candidates, err := s.repo.GetImportCandidates(historyimport.ArtistKeys(artists), linkedIDs)
+	if err != nil {
+		return nil, err
+	}
+	matcher := historyimport.NewMatcher(candidates)
+	available := make(map[int]bool, len(candidates))
+	for _, c := range candidates {
+		available[c.TrackID] = true
+	}
+
+	result := &PlaylistImportResult{Total: len(parsed.Entries), Unmatched: []UnmatchedPlaylistEntry{}}
+	seen := make(map[int]bool)
+	var trackIDs []int
+	for i, e := range parsed.Entries {
+		trackID := 0
+		if m := ownTrackURL.FindStringSubmatch(urlPath(e.Location)); m != nil {
+			if id, _ := strconv.Atoi(m[1]); available[id] {
+				trackID = id
+			}
+		}
+		if trackID == 0 {
+			if c, ok := matcher.Match(e.Artist, e.Title); ok {
+				trackID = c.TrackID
+			}
+		}
+
+		if trackID == 0 {
+			result.Unmatched = append(result.Unmatched, UnmatchedPlaylistEntry{
+				Position: i + 1,
+				Artist:   e.Artist,
+				Title:    e.Title,
+				Location: e.Location,
+			})
+			continue
+		}
+		result.Matched++
+		if seen[trackID] {
+			result.Duplicates++
+			continue
+		}
+		seen[trackID] = true
+		trackIDs = append(trackIDs, trackID)
+	}
+
+	title := strings.TrimSpace(parsed.Title)
+	if title == "" {
+		title = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	}
+	if title == "" {
+		title = "Импортированный плейлист"
+	}
+
+	result.PlaylistID, err = s.repo.ImportPlaylist(title, parsed.Description, visibility, userID, trackIDs)
+	if err != nil {
+		return nil, err
+	}
+	s.schedulePlaylistCover(result.PlaylistID)
+	return result, nil
+}
+
+func urlPath(location string) string {
+	if u, err := url.Parse(location); err == nil {
+		return u.Path
+	}
+	return location
+}
+
+// Обложки плейлистов
+
+// coverCandidates - сколько первых треков просматривать в поисках четырех обложек
+const (
+	coverCandidates = 20
+	coverJobBatch   = 100
+)
+
+// coverURL - адрес обложки для клиента; v меняется при каждой пересборке, чтобы не мешал кеш
+func coverURL(playlistID int) string {
+	return fmt.Sprintf("/images/%d/playlist?v=%d", playlistID, time.Now().Unix())
+}
+
+// afterTracksChange пересобирает мозаику после успешной правки состава
+func (s *Service) afterTracksChange(playlistID int) func(int, error) (int, error) {
+	return func(revision int, err error) (int, error) {
+		if err == nil {
+			s.schedulePlaylistCover(playlistID)
+		}
+		return revision, err
+	}
+}
+
+// coverQueue - плейлисты, ждущие пересборки мозаики, и блокировки обложек по плейлистам.
+// Повторные правки одного плейлиста до начала сборки схлопываются в одну задачу,
+// поэтому очередь не длиннее числа разных плейлистов, а собирает их один воркер
+type coverQueue struct {
+	mu      sync.Mutex
+	pending map[int]bool
+	order   []int
+	wake    chan struct{}
+	locks   map[int]*coverLock
+}
+
+type coverLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newCoverQueue() *coverQueue {
+	return &coverQueue{pending: map[int]bool{}, wake: make(chan struct{}, 1), locks: map[int]*coverLock{}}
+}
+
+func (q *coverQueue) push(playlistID int) {
+	q.mu.Lock()
+	if !q.pending[playlistID] {
+		q.pending[playlistID] = true
+		q.order = append(q.order, playlistID)
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop снимает плейлист с очереди до сборки: правка во время сборки поставит его снова
+func (q *coverQueue) pop() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.order) == 0 {
+		return 0, false
+	}
+	playlistID := q.order[0]
+	q.order = q.order[1:]
+	delete(q.pending, playlistID)
+	return playlistID, true
+}
+
+// lock блокирует обложку одного плейлиста; сборка других плейлистов не ждет
+func (q *coverQueue) lock(playlistID int) func() {
+	q.mu.Lock()
+	l := q.locks[playlistID]
+	if l == nil {
+		l = &coverLock{}
+		q.locks[playlistID] = l
+	}
+	l.refs++
+	q.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		q.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(q.locks, playlistID)
+		}
+		q.mu.Unlock()
+	}
+}
+
+func (s *Service) schedulePlaylistCover(playlistID int) {
+	s.covers.push(playlistID)
+}
+
+// RunPlaylistCoverWorker собирает мозаики плейлистов, поставленных в очередь после правок состава
+func (s *Service) RunPlaylistCoverWorker(ctx context.Context) {
+	for {
+		for {
+			playlistID, ok := s.covers.pop()
+			if !ok {
+				break
+			}
+			if err := s.updatePlaylistCover(playlistID); err != nil {
+				log.Printf("ошибка сборки обложки плейлиста %d: %v", playlistID, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.covers.wake:
+		}
+	}
+}
+
+// updatePlaylistCover собирает мозаику 2×2 из обложек первых треков, если у плейлиста нет своей обложки
+// и набор треков для мозаики изменился
+func (s *Service) updatePlaylistCover(playlistID int) error {
+	defer s.covers.lock(playlistID)()
+
+	st, err := s.repo.GetPlaylistCoverState(playlistID, coverCandidates)
+	if err != nil || st.Custom {
+		return err
+	}
+	// Обложки, загруженные при создании плейлиста до появления мозаик, считаем своими
+	if !st.Checked && s.hasPlaylistCover(playlistID) {
+		return s.repo.SetPlaylistCustomCover(playlistID, true, coverURL(playlistID))
+	}
+	// Первые треки те же, что в прошлый раз, и у всех есть обложки - результат не изменится
+	if st.Checked && len(st.Tracks) == covermosaic.Cells && len(st.Candidates) >= covermosaic.Cells &&
+		equalInts(st.Tracks, st.Candidates[:covermosaic.Cells]) {
+		return nil
+	}
+
+	var covers []image.Image
+	used := []int{}
+	for _, trackID := range st.Candidates {
+		img, err := s.loadTrackCover(trackID)
+		if err != nil {
+			continue
+		}
+		covers = append(covers, img)
+		used = append(used, trackID)
+		if len(used) == covermosaic.Cells {
+			break
+		}
+	}
+	if st.Checked && equalInts(used, st.Tracks) {
+		return nil
+	}
+
+	if err := s.storage.DeletePlaylistCover(playlistID); err != nil {
+		return err
+	}
+	if len(covers) == 0 {
+		return s.repo.SetPlaylistAutoCover(playlistID, used, "")
+	}
+
+	var buf bytes.Buffer
+	if err := covermosaic.Encode(&buf, covermosaic.Compose(covers)); err != nil {
+		return err
+	}
+	if err := s.storage.UploadImage("playlist", fmt.Sprintf("%d.jpg", playlistID), &buf); err != nil {
+		return err
+	}
+	return s.repo.SetPlaylistAutoCover(playlistID, used, coverURL(playlistID))
+}
+
+// loadTrackCover ищет обложку трека так же, как GetImage: сначала .jpg, затем .png
+func (s *Service) loadTrackCover(trackID int) (image.Image, error) {
+	var lastErr error
+	for _, ext := range []string{".jpg", ".png"} {
+		obj, err := s.storage.GetImage("track", fmt.Sprintf("%d%s", trackID, ext))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		img, err := covermosaic.Decode(obj)
+		obj.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return img, nil
+	}
+	return nil, lastErr
+}
+
+func (s *Service) hasPlaylistCover(playlistID int) bool {
+	for _, ext := range []string{".jpg", ".png"} {
+		if obj, err := s.storage.GetImage("playlist", fmt.Sprintf("%d%s", playlistID, ext)); err == nil {
+			obj.Close()
+			return true
+		}
+	}
+	return false
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+const maxPlaylistCoverSize = 10 << 20
+
+// readCoverUpload читает загруженную обложку или баннер и определяет расширение по содержимому.
+// Ошибки формата оборачивают invalid, чтобы обработчик ответил 400
+func readCoverUpload(r io.Reader, invalid error) ([]byte, string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxPlaylistCoverSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxPlaylistCoverSize {
+		return nil, "", fmt.Errorf("%w: изображение больше %d МБ", invalid, maxPlaylistCoverSize>>20)
+	}
+	var ext string
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		ext = ".jpg"
+	case "image/png":
+		ext = ".png"
+	default:
+		return nil, "", fmt.Errorf("%w: изображение должно быть в формате JPEG или PNG", invalid)
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		return nil, "", fmt.Errorf("%w: не удалось прочитать изображение", invalid)
+	}
+	return data, ext, nil
+}
+
+// SetPlaylistCover загружает свою обложку (JPEG или PNG); мозаика для плейлиста больше не собирается
+func (s *Service) SetPlaylistCover(playlistID, userID int, r io.Reader) error {
+	if err := s.requirePlaylistRole(playlistID, userID, PlaylistOwner); err != nil {
+		return err
+	}
+
+	data, ext, err := readCoverUpload(r, errorspkg.ErrInvalidPlaylistEdit)
+	if err != nil {
+		return err
+	}
+
+	defer s.covers.lock(playlistID)()
+
+	// Старая обложка могла быть в другом формате, а GetImage предпочитает .jpg
+	if err := s.storage.DeletePlaylistCover(playlistID); err != nil {
+		return err
+	}
+	if err := s.storage.UploadImage("playlist", fmt.Sprintf("%d%s", playlistID, ext), bytes.NewReader(data)); err != nil {
+		return err
+	}
+	return s.repo.SetPlaylistCustomCover(playlistID, true, coverURL(playlistID))
+}
+
+// ResetPlaylistCover удаляет свою обложку и возвращает мозаику
+func (s *Service) ResetPlaylistCover(playlistID, userID int) error {
+	if err := s.requirePlaylistRole(playlistID, userID, PlaylistOwner); err != nil {
+		return err
+	}
+
+	unlock := s.covers.lock(playlistID)
+	err := s.storage.DeletePlaylistCover(playlistID)
+	if err == nil {
+		err = s.repo.SetPlaylistCustomCover(playlistID, false, "")
+	}
+	unlock()
+	if err != nil {
+		return err
+	}
+	return s.updatePlaylistCover(playlistID)
+}
+
+// RunPlaylistCoverJobs собирает мозаики для плейлистов, которые еще ни разу не обрабатывались
+func (s *Service) RunPlaylistCoverJobs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ids, err := s.repo.GetPlaylistsWithoutCover(coverJobBatch)
+		if err != nil {
+			log.Printf("ошибка получения плейлистов без обложки: %v", err)
+		}
+		for _, id := range ids {
+			if err := s.updatePlaylistCover(id); err != nil {
+				log.Printf("ошибка сборки обложки плейлиста %d: %v", id, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
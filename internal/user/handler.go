@@ -1,12 +1,18 @@
 package user
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Bossnicks/music-streaming-service-kurs/pkg/auth"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/errorspkg"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/oidc"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/privacy"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/realtime"
 	"github.com/labstack/echo/v4"
 )
 
@@ -40,6 +46,26 @@ func (h *Handler) Register(c echo.Context) error {
 	return c.JSON(http.StatusCreated, map[string]string{"message": "Успешная регистрация"})
 }
 
+// viewer возвращает id и признак админа из необязательного токена; для гостя id = 0
+func viewer(c echo.Context) (int, bool) {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return 0, false
+	}
+	claims, err := auth.ParseRequestToken(c, strings.TrimPrefix(authHeader, "Bearer "))
+	if err != nil {
+		return 0, false
+	}
+	return claims.UserID, claims.Role == "admin"
+}
+
+func loginMeta(c echo.Context) LoginMeta {
+	return LoginMeta{
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	}
+}
+
 // Авторизация пользователя
 func (h *Handler) Login(c echo.Context) error {
 	var req LoginRequest
@@ -48,14 +74,31 @@ func (h *Handler) Login(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Неверный формат запроса"})
 	}
 
-	token, user, err := h.service.Authenticate(req.Email, req.Password)
+	token, user, err := h.service.Authenticate(req.Email, req.Password, loginMeta(c))
+	if errors.Is(err, errorspkg.ErrTwoFactorSetupRequired) {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"two_factor_setup_required": true,
+			"setup_token":               token,
+		})
+	}
+	if errors.Is(err, errorspkg.ErrTwoFactorRequired) {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"two_factor_required": true,
+			"mfa_token":           token,
+		})
+	}
+	if errors.Is(err, errorspkg.ErrAccountLocked) || errors.Is(err, errorspkg.ErrTooManyAttempts) {
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"message": err.Error()})
+	}
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Неверные учетные данные"})
 	}
 
+	// Обязательная 2FA без подключения обработана выше: вместо сессии выдан setup_token
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"token": token,
-		"user":  user,
+		"token":                     token,
+		"user":                      user,
+		"two_factor_setup_required": false,
 	})
 }
 
@@ -74,8 +117,8 @@ func (h *Handler) RecoverPassword(c echo.Context) error {
 	// Формируем ссылку для сброса пароля
 	resetLink := fmt.Sprintf("http://localhost:5173/resetpassword?token=%s", token)
 
-	// Отправка письма
-	err = auth.SendResetEmail(req.Email, resetLink)
+	// Письмо отправит воркер очереди
+	err = h.service.QueuePasswordReset(req.Email, resetLink)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Ошибка отправки письма"})
 	}
@@ -127,7 +170,7 @@ func (h *Handler) GetUser(c echo.Context) error {
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -166,6 +209,41 @@ func (h *Handler) UpdateUser(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"message": "Данные пользователя обновлены"})
 }
 
+// Смена email требует повторного подтверждения вторым фактором
+func (h *Handler) ChangeEmail(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	var req ChangeEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	if err := h.service.RequireStepUp(claims.UserID, c.Request().Header.Get("X-Step-Up-Token")); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
+	err = h.service.ChangeEmail(claims.UserID, req.Email)
+	switch {
+	case errors.Is(err, errorspkg.ErrInvalidEmail):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, errorspkg.ErrEmailTaken):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка обновления данных"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Email обновлен"})
+}
+
 // Получение аватара
 func (h *Handler) GetAvatar(c echo.Context) error {
 	userID, err := strconv.Atoi(c.Param("id"))
@@ -188,7 +266,7 @@ func (h *Handler) FollowUser(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -198,9 +276,16 @@ func (h *Handler) FollowUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
 	}
 
-	if err := h.service.FollowUser(claims.UserID, followingUserID); err != nil {
+	requested, err := h.service.FollowUser(claims.UserID, followingUserID)
+	if errors.Is(err, errorspkg.ErrUserBlocked) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to follow user"})
 	}
+	if requested {
+		return c.JSON(http.StatusAccepted, map[string]string{"message": "Follow request sent"})
+	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Followed successfully"})
 }
@@ -212,7 +297,7 @@ func (h *Handler) UnfollowUser(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -235,7 +320,11 @@ func (h *Handler) GetFollowers(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
 	}
 
-	followers, err := h.service.GetFollowers(userID)
+	viewerID, isAdmin := viewer(c)
+	followers, err := h.service.GetFollowers(userID, viewerID, isAdmin)
+	if errors.Is(err, errorspkg.ErrPrivateContent) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get followers"})
 	}
@@ -249,7 +338,11 @@ func (h *Handler) GetFollowing(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
 	}
 
-	following, err := h.service.GetFollowing(userID)
+	viewerID, isAdmin := viewer(c)
+	following, err := h.service.GetFollowing(userID, viewerID, isAdmin)
+	if errors.Is(err, errorspkg.ErrPrivateContent) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get following"})
 	}
@@ -264,7 +357,7 @@ func (h *Handler) IsUserSubscribed(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -278,7 +371,12 @@ func (h *Handler) IsUserSubscribed(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
 
-	return c.JSON(http.StatusOK, map[string]bool{"subscribed": subscribed})
+	requested, err := h.service.HasFollowRequest(claims.UserID, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{"subscribed": subscribed, "requested": requested})
 }
 
 func (h *Handler) BlockComments(c echo.Context) error {
@@ -288,7 +386,7 @@ func (h *Handler) BlockComments(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -331,7 +429,7 @@ func (h *Handler) UnblockComments(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -360,7 +458,7 @@ func (h *Handler) IsCommentAbilityBlocked(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -401,7 +499,7 @@ func (h *Handler) GetUserFeed(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -423,21 +521,7 @@ func (h *Handler) SearchHandler(c echo.Context) error {
 	sortField := c.QueryParam("sort")
 	order := c.QueryParam("order")
 
-	authHeader := c.Request().Header.Get("Authorization")
-	isAdmin := false
-
-	if authHeader != "" {
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		fmt.Println("Token:", tokenString) // Проверяем, что приходит
-		claims, err := auth.ParseJWT(tokenString)
-		if err != nil {
-			fmt.Println("JWT Error:", err)
-		}
-		if err == nil && claims.Role == "admin" {
-			isAdmin = true
-		}
-	}
-	fmt.Println("Token:", isAdmin)
+	viewerID, isAdmin := viewer(c)
 
 	// Устанавливаем значения по умолчанию
 	if sortField == "" {
@@ -448,7 +532,7 @@ func (h *Handler) SearchHandler(c echo.Context) error {
 	}
 
 	// Выполняем поиск
-	result, err := h.service.Search(query, entityTypes, genre, sortField, order, isAdmin)
+	result, err := h.service.Search(query, entityTypes, genre, sortField, order, viewerID, isAdmin)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -511,7 +595,7 @@ func (h *Handler) HideAlbum(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -554,7 +638,7 @@ func (h *Handler) UnhideAlbum(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -597,7 +681,7 @@ func (h *Handler) IsAlbumHidden(c echo.Context) error {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	claims, err := auth.ParseJWT(tokenString)
+	claims, err := auth.ParseRequestToken(c, tokenString)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
 	}
@@ -614,3 +698,1134 @@ func (h *Handler) IsAlbumHidden(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, map[string]bool{"commentBlocked": albumHidden})
 }
+
+// Начало подключения 2FA: секрет и otpauth-ссылка для QR-кода
+// twoFactorSetupClaims принимает сессию или токен подключения обязательной 2FA (setup_token после входа)
+func twoFactorSetupClaims(c echo.Context) (userID int, setupToken bool, err error) {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return 0, false, errors.New("Токен отсутствует")
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if claims, err := auth.ParseRequestToken(c, tokenString); err == nil {
+		return claims.UserID, false, nil
+	}
+	claims, err := auth.ParseMFAToken(tokenString, auth.PurposeTwoFactorSetup)
+	if err != nil {
+		return 0, false, errors.New("Неверный токен")
+	}
+	return claims.UserID, true, nil
+}
+
+func (h *Handler) EnrollTwoFactor(c echo.Context) error {
+	userID, _, err := twoFactorSetupClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+
+	secret, uri, err := h.service.EnrollTOTP(userID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	})
+}
+
+func (h *Handler) ConfirmTwoFactor(c echo.Context) error {
+	userID, setupToken, err := twoFactorSetupClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+
+	var req TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	codes, err := h.service.ConfirmTOTP(userID, req.Code)
+	if errors.Is(err, errorspkg.ErrInvalidTwoFactorCode) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	resp := map[string]interface{}{
+		"message":        "Двухфакторная аутентификация включена",
+		"recovery_codes": codes,
+	}
+	// Подключение по setup_token завершает вход: только теперь выдается сессия
+	if setupToken {
+		token, user, err := h.service.FinishTwoFactorSetup(userID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка завершения входа"})
+		}
+		resp["token"] = token
+		resp["user"] = user
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) DisableTwoFactor(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	var req TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	err = h.service.DisableTOTP(claims.UserID, req.Code, req.RecoveryCode)
+	if errors.Is(err, errorspkg.ErrTwoFactorSetupRequired) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Двухфакторная аутентификация отключена"})
+}
+
+// Второй шаг входа: код из приложения или одноразовый код восстановления
+func (h *Handler) VerifyTwoFactorLogin(c echo.Context) error {
+	var req TwoFactorVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Неверный формат запроса"})
+	}
+
+	token, user, err := h.service.VerifyLogin(req.MFAToken, req.Code, req.RecoveryCode, loginMeta(c))
+	if errors.Is(err, errorspkg.ErrTwoFactorSetupRequired) {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"two_factor_setup_required": true,
+			"setup_token":               token,
+		})
+	}
+	if errors.Is(err, errorspkg.ErrAccountLocked) || errors.Is(err, errorspkg.ErrTooManyAttempts) {
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"message": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Неверный код подтверждения"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"token": token,
+		"user":  user,
+	})
+}
+
+func (h *Handler) RegenerateRecoveryCodes(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	var req TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(claims.UserID, req.Code)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+func (h *Handler) GetTwoFactorStatus(c echo.Context) error {
+	userID, _, err := twoFactorSetupClaims(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+
+	user, err := h.service.GetUser(userID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Пользователь не найден"})
+	}
+
+	setupRequired, err := h.service.IsTwoFactorSetupRequired(user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	remaining, err := h.service.CountRecoveryCodes(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"enabled":                  user.TOTPEnabled,
+		"setup_required":           setupRequired,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// Step-up: повторная проверка кода перед удалением треков/альбомов и сменой email
+func (h *Handler) StepUpTwoFactor(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	var req TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	stepUpToken, err := h.service.StepUp(claims.UserID, req.Code, req.RecoveryCode)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"step_up_token": stepUpToken})
+}
+
+func (h *Handler) SetTwoFactorPolicy(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "admin" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
+	}
+
+	var req TwoFactorPolicyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	if err := h.service.SetTwoFactorPolicy(c.Param("role"), req.Required); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update 2fa policy"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "2fa policy updated"})
+}
+
+func (h *Handler) GetTwoFactorPolicies(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "admin" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
+	}
+
+	policies, err := h.service.GetTwoFactorPolicies()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, policies)
+}
+
+func (h *Handler) GetOIDCProviders(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string][]string{"providers": h.service.OIDCProviders()})
+}
+
+const oidcStateCookie = "oidc_state"
+
+// setOIDCStateCookie привязывает state к браузеру, начавшему вход. SameSite=Lax, потому что
+// провайдер возвращает пользователя обычным переходом на callback.
+func setOIDCStateCookie(c echo.Context, state string, maxAge int) {
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/beatstreet/api/users/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Начало входа через внешнего провайдера: перенаправляем на страницу авторизации
+func (h *Handler) StartOIDCLogin(c echo.Context) error {
+	authURL, state, err := h.service.StartOIDCLogin(c.Request().Context(), c.Param("provider"), 0)
+	if errors.Is(err, oidc.ErrUnknownProvider) {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Не удалось начать вход через провайдера"})
+	}
+
+	setOIDCStateCookie(c, auth.SignOIDCState(state), 600)
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// Привязка внешнего аккаунта к текущему пользователю; адрес отдаем фронтенду
+func (h *Handler) StartOIDCLink(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	authURL, state, err := h.service.StartOIDCLogin(c.Request().Context(), c.Param("provider"), claims.UserID)
+	if errors.Is(err, oidc.ErrUnknownProvider) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Не удалось начать привязку аккаунта"})
+	}
+
+	setOIDCStateCookie(c, auth.SignOIDCState(state), 600)
+	return c.JSON(http.StatusOK, map[string]string{"authorization_url": authURL})
+}
+
+func (h *Handler) OIDCCallback(c echo.Context) error {
+	if errParam := c.QueryParam("error"); errParam != "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Провайдер отклонил вход: " + errParam})
+	}
+
+	state := c.QueryParam("state")
+	code := c.QueryParam("code")
+	if state == "" || code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Отсутствует state или code"})
+	}
+
+	// state должен прийти в тот же браузер, который начинал вход
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil || !auth.VerifyOIDCState(state, cookie.Value) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "state не совпадает с начатым входом"})
+	}
+	setOIDCStateCookie(c, "", -1)
+
+	token, user, err := h.service.CompleteOIDCLogin(c.Request().Context(), c.Param("provider"), state, code, loginMeta(c))
+	if errors.Is(err, errorspkg.ErrTwoFactorSetupRequired) {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"two_factor_setup_required": true,
+			"setup_token":               token,
+		})
+	}
+	if errors.Is(err, errorspkg.ErrTwoFactorRequired) {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"two_factor_required": true,
+			"mfa_token":           token,
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"token": token,
+		"user":  user,
+	})
+}
+
+func (h *Handler) GetIdentities(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	identities, err := h.service.GetIdentities(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, identities)
+}
+
+func (h *Handler) UnlinkOIDCIdentity(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	removed, err := h.service.UnlinkIdentity(claims.UserID, c.Param("provider"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to unlink identity"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{"removed": removed})
+}
+
+// История входов текущего пользователя
+func (h *Handler) GetLoginActivity(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	events, err := h.service.GetLoginActivity(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
+
+func (h *Handler) GetSecurityEvents(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "admin" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
+	}
+
+	filter := SecurityEventFilter{
+		IP:   c.QueryParam("ip"),
+		Type: c.QueryParam("type"),
+	}
+	if userID := c.QueryParam("user_id"); userID != "" {
+		filter.UserID, err = strconv.Atoi(userID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+		}
+	}
+	filter.Limit, _ = strconv.Atoi(c.QueryParam("limit"))
+
+	events, err := h.service.GetSecurityEvents(filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
+
+func (h *Handler) UnlockUser(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if claims.Role != "admin" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Недостаточно прав"})
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+	}
+
+	if err := h.service.UnlockUser(userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to unlock user"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "user unlocked"})
+}
+
+// Персональные токены доступа для скриптов и интеграций
+func (h *Handler) CreatePersonalToken(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	var req CreateTokenRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	plain, token, err := h.service.CreatePersonalToken(claims.UserID, req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"token":   plain,
+		"details": token,
+	})
+}
+
+func (h *Handler) GetPersonalTokens(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	tokens, err := h.service.GetPersonalTokens(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"tokens":           tokens,
+		"available_scopes": auth.PersonalTokenScopes,
+	})
+}
+
+func (h *Handler) RevokePersonalToken(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	tokenID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid token ID"})
+	}
+
+	revoked, err := h.service.RevokePersonalToken(claims.UserID, tokenID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to revoke token"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{"revoked": revoked})
+}
+
+func (h *Handler) SetLocale(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	var req LocaleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	locale, err := h.service.SetLocale(claims.UserID, req.Locale)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"locale": locale})
+}
+
+// Выгрузка персональных данных
+func (h *Handler) RequestDataExport(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	export, err := h.service.RequestDataExport(claims.UserID)
+	if errors.Is(err, errorspkg.ErrExportInProgress) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Не удалось создать выгрузку"})
+	}
+
+	return c.JSON(http.StatusAccepted, export)
+}
+
+func (h *Handler) GetDataExports(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	exports, err := h.service.GetDataExports(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, exports)
+}
+
+// DownloadDataExport открывается по подписанной ссылке из письма, без заголовка Authorization
+func (h *Handler) DownloadDataExport(c echo.Context) error {
+	exportID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid export ID"})
+	}
+	expires, err := strconv.ParseInt(c.QueryParam("expires"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errorspkg.ErrInvalidDownloadLink.Error()})
+	}
+
+	obj, err := h.service.OpenDataExport(exportID, expires, c.QueryParam("signature"))
+	if errors.Is(err, errorspkg.ErrInvalidDownloadLink) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Архив не найден"})
+	}
+	defer obj.Close()
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"beatstreet-export-%d.zip\"", exportID))
+	return c.Stream(http.StatusOK, "application/zip", obj)
+}
+
+// Удаление аккаунта
+func (h *Handler) DeleteAccount(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	var req DeleteAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	at, err := h.service.ScheduleAccountDeletion(claims.UserID, req.Password, c.Request().Header.Get("X-Step-Up-Token"))
+	if err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]interface{}{"deletion_scheduled_at": at})
+}
+
+func (h *Handler) CancelAccountDeletion(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	canceled, err := h.service.CancelAccountDeletion(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Не удалось отменить удаление"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{"canceled": canceled})
+}
+
+// Настройки приватности
+func (h *Handler) GetPrivacySettings(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	settings, err := h.service.GetPrivacySettings(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, settings)
+}
+
+func (h *Handler) UpdatePrivacySettings(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	var settings privacy.Settings
+	if err := c.Bind(&settings); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные данные"})
+	}
+
+	if err := h.service.UpdatePrivacySettings(claims.UserID, settings); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Не удалось сохранить настройки"})
+	}
+
+	return c.JSON(http.StatusOK, settings)
+}
+
+// Заявки на подписку
+func (h *Handler) GetFollowRequests(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	requests, err := h.service.GetFollowRequests(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, requests)
+}
+
+func (h *Handler) AcceptFollowRequest(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	requesterID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	accepted, err := h.service.AcceptFollowRequest(claims.UserID, requesterID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if !accepted {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Заявка не найдена"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{"accepted": true})
+}
+
+func (h *Handler) DeclineFollowRequest(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	requesterID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	declined, err := h.service.DeclineFollowRequest(claims.UserID, requesterID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if !declined {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Заявка не найдена"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{"declined": true})
+}
+
+// Блокировка и скрытие пользователей
+func (h *Handler) BlockUser(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if err := h.service.BlockUser(claims.UserID, targetID); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Пользователь заблокирован"})
+}
+
+func (h *Handler) UnblockUser(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if err := h.service.UnblockUser(claims.UserID, targetID); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Пользователь разблокирован"})
+}
+
+func (h *Handler) GetBlockedUsers(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	users, err := h.service.GetBlockedUsers(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, users)
+}
+
+func (h *Handler) MuteUser(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if err := h.service.MuteUser(claims.UserID, targetID); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Пользователь скрыт из ленты"})
+}
+
+func (h *Handler) UnmuteUser(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if err := h.service.UnmuteUser(claims.UserID, targetID); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Пользователь возвращен в ленту"})
+}
+
+func (h *Handler) GetMutedUsers(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	users, err := h.service.GetMutedUsers(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, users)
+}
+
+func (h *Handler) GetNotifications(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	unreadOnly := c.QueryParam("unread") == "true"
+
+	notifications, err := h.service.GetNotifications(claims.UserID, page, unreadOnly)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, notifications)
+}
+
+func (h *Handler) GetUnreadNotificationCount(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	count, err := h.service.CountUnreadNotifications(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{"unread_count": count})
+}
+
+func (h *Handler) MarkNotificationRead(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	notificationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid notification ID"})
+	}
+
+	found, err := h.service.MarkNotificationRead(notificationID, claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Уведомление не найдено"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{"read": true})
+}
+
+func (h *Handler) MarkAllNotificationsRead(c echo.Context) error {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	if err := h.service.MarkAllNotificationsRead(claims.UserID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{"read": true})
+}
+
+// StreamEvents - поток Server-Sent Events. EventSource в браузере не умеет передавать
+// заголовки, поэтому токен можно передать параметром access_token
+func (h *Handler) StreamEvents(c echo.Context) error {
+	tokenString := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	if tokenString == "" {
+		tokenString = c.QueryParam("access_token")
+		// персональные токены проверяются по заголовку в RequirePersonalTokenScopes, в параметре их не принимаем
+		if strings.HasPrefix(tokenString, auth.PersonalTokenPrefix) {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Токен не дает доступа к этой операции"})
+		}
+	}
+	if tokenString == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Токен отсутствует"})
+	}
+
+	claims, err := auth.ParseRequestToken(c, tokenString)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+	}
+
+	trackID := 0
+	if c.QueryParam("track") != "" {
+		trackID, err = strconv.Atoi(c.QueryParam("track"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid track ID"})
+		}
+		// Скрытый или недоступный из-за блокировки трек неотличим от несуществующего
+		ok, err := h.service.CanWatchTrack(trackID, claims.UserID, claims.Role == "admin")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		}
+		if !ok {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Трек не найден"})
+		}
+	}
+
+	unread, err := h.service.CountUnreadNotifications(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	sub := h.service.Subscribe(claims.UserID, trackID)
+	defer h.service.Unsubscribe(sub)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	if err := writeEvent(res, realtime.TypeUnreadCount, []byte(fmt.Sprintf(`{"unread_count":%d}`, unread))); err != nil {
+		return nil
+	}
+
+	heartbeat := time.NewTicker(25 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case e := <-sub.C:
+			if err := writeEvent(res, e.Type, e.Data); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			// комментарий не дает прокси закрыть простаивающее соединение
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+func writeEvent(res *echo.Response, eventType string, data []byte) error {
+	if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", eventType, data); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
@@ -1,6 +1,9 @@
 package user
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type User struct {
 	ID          int        `json:"id"`
@@ -14,6 +17,15 @@ type User struct {
 	Token       string     `json:"token"`
 	CanComment  bool       `json:"can_comment"`
 	Is_verified bool       `json:"is_verified"`
+	TOTPEnabled bool       `json:"totp_enabled"`
+	TOTPSecret  string     `json:"-"`
+	Locale      string     `json:"locale"`
+
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+	IsPrivate           bool       `json:"is_private"`
+
+	FailedLoginCount int        `json:"-"`
+	LockedUntil      *time.Time `json:"-"`
 }
 
 type RegisterRequest struct {
@@ -32,6 +44,124 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TwoFactorVerifyRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TwoFactorPolicyRequest struct {
+	Required bool `json:"required"`
+}
+
+type TwoFactorPolicy struct {
+	Role     string `json:"role"`
+	Required bool   `json:"required"`
+}
+
+type LinkedIdentity struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OIDCLoginState struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	LinkUserID   *int
+}
+
+// LoginMeta - откуда пришла попытка входа
+type LoginMeta struct {
+	IP        string
+	UserAgent string
+}
+
+type SecurityEvent struct {
+	ID        int       `json:"id"`
+	UserID    *int      `json:"user_id"`
+	Email     string    `json:"email,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SecurityEventFilter struct {
+	UserID int
+	IP     string
+	Type   string
+	Limit  int
+}
+
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type DataExport struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"-"`
+	Status      string     `json:"status"`
+	ObjectName  string     `json:"-"`
+	SizeBytes   *int64     `json:"size_bytes,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// UserMedia - файлы пользователя в хранилище, которые удаляются вместе с аккаунтом
+type UserMedia struct {
+	TrackIDs    []int
+	PlaylistIDs []int
+	AlbumIDs    []int
+	Exports     []string
+}
+
+type ChangeEmailRequest struct {
+	Email string `json:"email"`
+}
+
+type FollowRequest struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RelatedUser - пользователь в списке заблокированных или скрытых
+type RelatedUser struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LocaleRequest struct {
+	Locale string `json:"locale"`
+}
+
+type CreateTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
 type UpdateUserRequest struct {
 	Username *string `json:"username"`
 	Email    *string `json:"email"`
@@ -43,7 +173,7 @@ type FeedItem struct {
 	ID         int       `json:"id"`
 	UserID     int       `json:"user_id"`
 	UserName   string    `json:"user_name"`
-	Type       string    `json:"type"` // "repost", "upload", "playlist"
+	Type       string    `json:"type"` // "repost", "upload", "playlist", "album"
 	TargetID   int       `json:"target_id"`
 	TargetName string    `json:"target_name"`
 	CreatedAt  time.Time `json:"created_at"`
@@ -56,20 +186,30 @@ type Playlist struct {
 	Avatar      string    `json:"avatar"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	Visibility  string    `json:"visibility"`
+	Followers   int       `json:"followers_count"`
 	Author      User      `json:"author"`
 	Tracks      []Track   `json:"tracks"`
 }
 
 type Track struct {
-	ID          int        `json:"id"`
-	Title       string     `json:"title"`
-	Description string     `json:"description"`
-	Avatar      string     `json:"avatar"`
-	Duration    int        `json:"duration"`
-	CreatedAt   *time.Time `json:"created_at"`
-	Is_blocked  bool       `json:"is_blocked"`
-	UpdatedAt   *time.Time `json:"updated_at"`
-	Author      User       `json:"author"`
+	ID          int           `json:"id"`
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	Avatar      string        `json:"avatar"`
+	Duration    int           `json:"duration"`
+	CreatedAt   *time.Time    `json:"created_at"`
+	Is_blocked  bool          `json:"is_blocked"`
+	UpdatedAt   *time.Time    `json:"updated_at"`
+	Author      User          `json:"author"`
+	Credits     []TrackCredit `json:"credits,omitempty"`
+}
+
+// TrackCredit - участник трека помимо автора
+type TrackCredit struct {
+	UserID *int   `json:"user_id,omitempty"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
 }
 
 type UserAvatar struct {
@@ -83,3 +223,30 @@ type ResetPasswordRequest struct {
 	NewPassword     string `json:"newPassword"`
 	ConfirmPassword string `json:"confirmPassword"`
 }
+
+// Notification - запись во входящих; у сгруппированных событий Actors - последние участники
+type Notification struct {
+	ID         int                 `json:"id"`
+	Type       string              `json:"type"`
+	TargetType string              `json:"target_type"`
+	TargetID   int                 `json:"target_id"`
+	Actors     []NotificationActor `json:"actors"`
+	ActorCount int                 `json:"actor_count"`
+	Data       json.RawMessage     `json:"data"`
+	Message    string              `json:"message"`
+	IsRead     bool                `json:"is_read"`
+	CreatedAt  time.Time           `json:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+}
+
+type NotificationPage struct {
+	Items       []Notification `json:"items"`
+	UnreadCount int            `json:"unread_count"`
+	Page        int            `json:"page"`
+	HasMore     bool           `json:"has_more"`
+}
+
+type NotificationActor struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
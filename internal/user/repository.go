@@ -2,8 +2,13 @@ package user
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
+
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/privacy"
+	"github.com/lib/pq"
 )
 
 type Repository struct {
@@ -23,8 +28,8 @@ func (r *Repository) CreateUser(user *User) error {
 
 func (r *Repository) GetUserByEmail(email string) (*User, error) {
 	var user User
-	query := "SELECT id, username, email, password, avatar, role, created_at, can_comment, is_verified FROM users WHERE email = $1"
-	err := r.db.QueryRow(query, email).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Avatar, &user.Role, &user.CreatedAt, &user.CanComment, &user.Is_verified)
+	query := "SELECT id, username, email, password, avatar, role, created_at, can_comment, is_verified, totp_enabled, COALESCE(totp_secret, ''), failed_login_count, locked_until, locale, deletion_scheduled_at FROM users WHERE email = $1"
+	err := r.db.QueryRow(query, email).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Avatar, &user.Role, &user.CreatedAt, &user.CanComment, &user.Is_verified, &user.TOTPEnabled, &user.TOTPSecret, &user.FailedLoginCount, &user.LockedUntil, &user.Locale, &user.DeletionScheduledAt)
 	if err == sql.ErrNoRows {
 		return nil, errors.New("пользователь не найден")
 	}
@@ -34,8 +39,8 @@ func (r *Repository) GetUserByEmail(email string) (*User, error) {
 func (r *Repository) GetUserByID(userID int) (*User, error) {
 	fmt.Println(userID)
 	var user User
-	query := "SELECT id, username, email, password, avatar, role, created_at, token, can_comment, is_verified FROM users WHERE id = $1"
-	err := r.db.QueryRow(query, userID).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Avatar, &user.Role, &user.CreatedAt, &user.Token, &user.CanComment, &user.Is_verified)
+	query := "SELECT id, username, email, password, avatar, role, created_at, token, can_comment, is_verified, totp_enabled, COALESCE(totp_secret, ''), failed_login_count, locked_until, locale, deletion_scheduled_at FROM users WHERE id = $1"
+	err := r.db.QueryRow(query, userID).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Avatar, &user.Role, &user.CreatedAt, &user.Token, &user.CanComment, &user.Is_verified, &user.TOTPEnabled, &user.TOTPSecret, &user.FailedLoginCount, &user.LockedUntil, &user.Locale, &user.DeletionScheduledAt)
 	fmt.Println(err)
 	if err == sql.ErrNoRows {
 		return nil, errors.New("пользователь не найден")
@@ -176,6 +181,7 @@ func (r *Repository) GetUserFeed(userID int) ([]FeedItem, error) {
 			JOIN tracks t ON r.track_id = t.id
 			JOIN follows f ON r.user_id = f.followed_user_id
 			WHERE f.following_user_id = $1
+				AND NOT EXISTS (SELECT 1 FROM user_mutes m WHERE m.muter_id = $1 AND m.muted_id = r.user_id)
 		)
 		UNION ALL
 		(
@@ -184,6 +190,7 @@ func (r *Repository) GetUserFeed(userID int) ([]FeedItem, error) {
 			JOIN users u ON t.author_id = u.id
 			JOIN follows f ON t.author_id = f.followed_user_id
 			WHERE f.following_user_id = $1
+				AND NOT EXISTS (SELECT 1 FROM user_mutes m WHERE m.muter_id = $1 AND m.muted_id = t.author_id)
 		)
 		UNION ALL
 		(
@@ -191,7 +198,17 @@ func (r *Repository) GetUserFeed(userID int) ([]FeedItem, error) {
 			FROM playlists p
 			JOIN users u ON p.author_id = u.id
 			JOIN follows f ON p.author_id = f.followed_user_id
-			WHERE f.following_user_id = $1
+			WHERE f.following_user_id = $1 AND p.visibility = 'public'
+		)
+		UNION ALL
+		(
+			-- Альбом появляется в ленте в момент публикации, а не создания
+			SELECT a.id, a.author_id, u.username, 'album' AS type, a.id AS target_id, a.title AS target_name, a.published_at
+			FROM albums a
+			JOIN users u ON a.author_id = u.id
+			JOIN follows f ON a.author_id = f.followed_user_id
+			WHERE f.following_user_id = $1 AND a.published_at IS NOT NULL AND NOT a.is_hidden
+				AND NOT EXISTS (SELECT 1 FROM user_mutes m WHERE m.muter_id = $1 AND m.muted_id = a.author_id)
 		)
 		ORDER BY created_at DESC
 		LIMIT 50
@@ -214,7 +231,7 @@ func (r *Repository) GetUserFeed(userID int) ([]FeedItem, error) {
 	return feed, nil
 }
 
-func (r *Repository) SearchTracks(query string, genre string, sortField string, order string, isAdmin bool) ([]Track, error) {
+func (r *Repository) SearchTracks(query string, genre string, sortField string, order string, viewerID int, isAdmin bool) ([]Track, error) {
 	var tracks []Track
 	fmt.Println(genre)
 
@@ -231,34 +248,39 @@ func (r *Repository) SearchTracks(query string, genre string, sortField string,
 			u.username AS author_username  
 		FROM tracks t  
 		JOIN users u ON t.author_id = u.id  
-		WHERE (t.title ILIKE $1 OR t.description ILIKE $1)  
+		WHERE (t.title ILIKE $1 OR t.description ILIKE $1
+			OR EXISTS (
+				SELECT 1 FROM track_credits tc
+				LEFT JOIN users cu ON cu.id = tc.user_id
+				WHERE tc.track_id = t.id AND COALESCE(cu.username, tc.name) ILIKE $1
+			))  
 		%s  
 		%s  
 		ORDER BY %s %s`
 
-	// Фильтр по блокировке для не-админов
-	blockFilter := ""
-	if !isAdmin {
-		blockFilter = "AND t.is_blocked = false"
-	}
+	args := []interface{}{"%" + query + "%"}
 
 	// Фильтр по жанру
 	genreFilter := ""
 	if genre != "" {
-		genreFilter = "AND t.genre = $2"
+		args = append(args, genre)
+		genreFilter = fmt.Sprintf("AND t.genre = $%d", len(args))
+	}
+
+	// Фильтр по блокировке и приватности для не-админов
+	blockFilter := ""
+	if !isAdmin {
+		args = append(args, viewerID)
+		blockFilter = fmt.Sprintf(`AND t.is_blocked = false
+		AND (NOT u.private_profile OR u.id = $%[1]d
+			OR EXISTS (SELECT 1 FROM follows f WHERE f.following_user_id = $%[1]d AND f.followed_user_id = u.id))`, len(args))
 	}
 
 	querySQL = fmt.Sprintf(querySQL, genreFilter, blockFilter, sortField, order)
 
 	fmt.Println(querySQL)
 
-	var rows *sql.Rows
-	var err error
-	if genre != "" {
-		rows, err = r.db.Query(querySQL, "%"+query+"%", genre)
-	} else {
-		rows, err = r.db.Query(querySQL, "%"+query+"%")
-	}
+	rows, err := r.db.Query(querySQL, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -282,11 +304,57 @@ func (r *Repository) SearchTracks(query string, genre string, sortField string,
 		}
 		tracks = append(tracks, track)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
+	if err := r.attachTrackCredits(tracks); err != nil {
+		return nil, err
+	}
 	return tracks, nil
 }
 
-func (r *Repository) SearchPlaylists(query string, sortField string, order string) ([]Playlist, error) {
+// attachTrackCredits подгружает участников найденных треков одним запросом
+func (r *Repository) attachTrackCredits(tracks []Track) error {
+	if len(tracks) == 0 {
+		return nil
+	}
+	ids := make([]int, len(tracks))
+	index := make(map[int]int, len(tracks))
+	for i, t := range tracks {
+		ids[i] = t.ID
+		index[t.ID] = i
+	}
+
+	rows, err := r.db.Query(`
+		SELECT tc.track_id, tc.user_id, COALESCE(u.username, tc.name), tc.role
+		FROM track_credits tc
+		LEFT JOIN users u ON u.id = tc.user_id
+		WHERE tc.track_id = ANY($1)
+		ORDER BY tc.track_id, tc.position, tc.id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var trackID int
+		var userID sql.NullInt64
+		var credit TrackCredit
+		if err := rows.Scan(&trackID, &userID, &credit.Name, &credit.Role); err != nil {
+			return err
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			credit.UserID = &id
+		}
+		i := index[trackID]
+		tracks[i].Credits = append(tracks[i].Credits, credit)
+	}
+	return rows.Err()
+}
+
+func (r *Repository) SearchPlaylists(query string, sortField string, order string, viewerID int, isAdmin bool) ([]Playlist, error) {
 	var playlists []Playlist
 
 	querySQL := `
@@ -296,16 +364,37 @@ func (r *Repository) SearchPlaylists(query string, sortField string, order strin
 			p.description, 
 			p.created_at, 
 			p.updated_at, 
+			p.visibility,
+			(SELECT COUNT(*) FROM playlist_follows pf WHERE pf.playlist_id = p.id),
 			u.id AS author_id, 
 			u.username AS author_username
 		FROM playlists p
 		JOIN users u ON p.author_id = u.id
-		WHERE p.title ILIKE $1 OR p.description ILIKE $1
+		WHERE (p.title ILIKE $1 OR p.description ILIKE $1)
+		%s
 		ORDER BY %s %s`
 
-	querySQL = fmt.Sprintf(querySQL, sortField, order)
+	// Плейлисты закрытых профилей и "только для подписчиков" видны подписчикам.
+	// В поиске только public; свои и совместные плейлисты видны всегда
+	privacyFilter := ""
+	if !isAdmin {
+		privacyFilter = `AND (
+			u.id = $2
+			OR EXISTS (SELECT 1 FROM playlist_members pm WHERE pm.playlist_id = p.id AND pm.user_id = $2)
+			OR (p.visibility = 'public' AND (
+				(NOT u.private_profile AND NOT u.playlists_followers_only)
+				OR EXISTS (SELECT 1 FROM follows f WHERE f.following_user_id = $2 AND f.followed_user_id = u.id)
+			))
+		)`
+	}
+
+	querySQL = fmt.Sprintf(querySQL, privacyFilter, sortField, order)
 
-	rows, err := r.db.Query(querySQL, "%"+query+"%")
+	args := []interface{}{"%" + query + "%"}
+	if !isAdmin {
+		args = append(args, viewerID)
+	}
+	rows, err := r.db.Query(querySQL, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -319,6 +408,8 @@ func (r *Repository) SearchPlaylists(query string, sortField string, order strin
 			&playlist.Description,
 			&playlist.CreatedAt,
 			&playlist.UpdatedAt,
+			&playlist.Visibility,
+			&playlist.Followers,
 			&playlist.Author.ID,
 			&playlist.Author.Username,
 		)
@@ -332,7 +423,7 @@ func (r *Repository) SearchPlaylists(query string, sortField string, order strin
 }
 
 // SearchUsers возвращает пользователей, соответствующих поисковому запросу
-func (r *Repository) SearchUsers(query string, sortField string, order string) ([]User, error) {
+func (r *Repository) SearchUsers(query string, sortField string, order string, viewerID int) ([]User, error) {
 	var users []User
 
 	querySQL := `
@@ -341,9 +432,11 @@ func (r *Repository) SearchUsers(query string, sortField string, order string) (
 			username, 
 			created_at, 
 			updated_at,
-			role
+			role,
+			private_profile
 		FROM users
 		WHERE username ILIKE $1
+			AND NOT EXISTS (SELECT 1 FROM user_blocks b WHERE b.blocker_id = users.id AND b.blocked_id = $2)
 		ORDER BY %s %s`
 	if sortField == "title" {
 		sortField = "username"
@@ -351,7 +444,7 @@ func (r *Repository) SearchUsers(query string, sortField string, order string) (
 
 	querySQL = fmt.Sprintf(querySQL, sortField, order)
 
-	rows, err := r.db.Query(querySQL, "%"+query+"%")
+	rows, err := r.db.Query(querySQL, "%"+query+"%", viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -365,6 +458,7 @@ func (r *Repository) SearchUsers(query string, sortField string, order string) (
 			&user.CreatedAt,
 			&user.UpdatedAt,
 			&user.Role,
+			&user.IsPrivate,
 		)
 		if err != nil {
 			return nil, err
@@ -396,3 +490,911 @@ func (r *Repository) IsValidResetToken(token, email string) (bool, error) {
 	}
 	return storedToken == token, nil
 }
+
+// SetTOTPSecret сохраняет секрет до подтверждения первым кодом
+func (r *Repository) SetTOTPSecret(userID int, secret string) error {
+	_, err := r.db.Exec("UPDATE users SET totp_secret = $1, totp_enabled = FALSE, totp_last_step = NULL WHERE id = $2", secret, userID)
+	return err
+}
+
+// AcceptTOTPStep запоминает шаг принятого кода; false - код этого или более позднего шага уже использован
+func (r *Repository) AcceptTOTPStep(userID int, step int64) (bool, error) {
+	res, err := r.db.Exec(`
+		UPDATE users SET totp_last_step = $2
+		WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (r *Repository) EnableTOTP(userID int) error {
+	_, err := r.db.Exec("UPDATE users SET totp_enabled = TRUE WHERE id = $1 AND totp_secret IS NOT NULL", userID)
+	return err
+}
+
+func (r *Repository) DisableTOTP(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_secret = NULL, totp_enabled = FALSE WHERE id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes удаляет старые коды восстановления и сохраняет новые
+func (r *Repository) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode помечает код использованным; false, если код не найден или уже использован
+func (r *Repository) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	res, err := r.db.Exec(`
+		UPDATE user_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func (r *Repository) CountUnusedRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+func (r *Repository) IsTwoFactorRequiredForRole(role string) (bool, error) {
+	var required bool
+	err := r.db.QueryRow("SELECT required FROM role_2fa_policies WHERE role = $1", role).Scan(&required)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return required, err
+}
+
+func (r *Repository) SetTwoFactorPolicy(role string, required bool) error {
+	query := `
+		INSERT INTO role_2fa_policies (role, required, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (role) DO UPDATE SET required = EXCLUDED.required, updated_at = NOW()`
+	_, err := r.db.Exec(query, role, required)
+	return err
+}
+
+func (r *Repository) GetTwoFactorPolicies() ([]TwoFactorPolicy, error) {
+	rows, err := r.db.Query("SELECT role, required FROM role_2fa_policies ORDER BY role")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []TwoFactorPolicy
+	for rows.Next() {
+		var p TwoFactorPolicy
+		if err := rows.Scan(&p.Role, &p.Required); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+func (r *Repository) SaveOIDCLoginState(st *OIDCLoginState) error {
+	query := `INSERT INTO oidc_login_states (state, provider, nonce, code_verifier, link_user_id) VALUES ($1, $2, $3, $4, $5)`
+	_, err := r.db.Exec(query, st.State, st.Provider, st.Nonce, st.CodeVerifier, st.LinkUserID)
+	return err
+}
+
+// ConsumeOIDCLoginState удаляет state и возвращает его, если он не просрочен (одноразовый)
+func (r *Repository) ConsumeOIDCLoginState(state, provider string) (*OIDCLoginState, error) {
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state = $1 AND provider = $2 AND created_at >= NOW() - INTERVAL '10 minutes'
+		RETURNING state, provider, nonce, code_verifier, link_user_id`
+
+	var st OIDCLoginState
+	var linkUserID sql.NullInt64
+	err := r.db.QueryRow(query, state, provider).Scan(&st.State, &st.Provider, &st.Nonce, &st.CodeVerifier, &linkUserID)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("state не найден или истек")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if linkUserID.Valid {
+		id := int(linkUserID.Int64)
+		st.LinkUserID = &id
+	}
+	return &st, nil
+}
+
+func (r *Repository) DeleteExpiredOIDCLoginStates() error {
+	_, err := r.db.Exec("DELETE FROM oidc_login_states WHERE created_at < NOW() - INTERVAL '10 minutes'")
+	return err
+}
+
+// GetUserIDByIdentity возвращает 0, если внешний аккаунт еще не привязан
+func (r *Repository) GetUserIDByIdentity(provider, subject string) (int, error) {
+	var userID int
+	err := r.db.QueryRow("SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2", provider, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return userID, err
+}
+
+func (r *Repository) LinkIdentity(userID int, provider, subject, email string) error {
+	query := `INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)`
+	_, err := r.db.Exec(query, userID, provider, subject, email)
+	return err
+}
+
+func (r *Repository) UnlinkIdentity(userID int, provider string) (bool, error) {
+	res, err := r.db.Exec("DELETE FROM user_identities WHERE user_id = $1 AND provider = $2", userID, provider)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func (r *Repository) GetIdentities(userID int) ([]LinkedIdentity, error) {
+	rows, err := r.db.Query("SELECT provider, COALESCE(email, ''), created_at FROM user_identities WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []LinkedIdentity
+	for rows.Next() {
+		var identity LinkedIdentity
+		if err := rows.Scan(&identity.Provider, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (r *Repository) UsernameExists(username string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", username).Scan(&exists)
+	return exists, err
+}
+
+// CreateUserWithIdentity создает пользователя с подтвержденным email и сразу привязывает
+// внешний аккаунт в одной транзакции: без привязки повторный вход не нашел бы пользователя
+func (r *Repository) CreateUserWithIdentity(user *User, provider, subject, email string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO users (username, email, password, is_verified) VALUES ($1, $2, $3, TRUE) RETURNING id, role, created_at"
+	if err := tx.QueryRow(query, user.Username, user.Email, user.Password).Scan(&user.ID, &user.Role, &user.CreatedAt); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)`, user.ID, provider, subject, email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IncrementFailedLogins увеличивает счетчик неудачных попыток подряд и возвращает новое значение
+func (r *Repository) IncrementFailedLogins(userID int) (int, error) {
+	var count int
+	err := r.db.QueryRow("UPDATE users SET failed_login_count = failed_login_count + 1 WHERE id = $1 RETURNING failed_login_count", userID).Scan(&count)
+	return count, err
+}
+
+func (r *Repository) SetLockedUntil(userID int, until time.Time) error {
+	_, err := r.db.Exec("UPDATE users SET locked_until = $1 WHERE id = $2", until, userID)
+	return err
+}
+
+func (r *Repository) ResetFailedLogins(userID int) error {
+	_, err := r.db.Exec("UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = $1", userID)
+	return err
+}
+
+func (r *Repository) CountRecentFailuresByIP(ip string, since time.Time) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM security_events WHERE ip = $1 AND event_type IN ('login_failed', '2fa_failed') AND created_at >= $2`
+	err := r.db.QueryRow(query, ip, since).Scan(&count)
+	return count, err
+}
+
+func (r *Repository) AddSecurityEvent(event *SecurityEvent) error {
+	query := `INSERT INTO security_events (user_id, email, ip, user_agent, event_type) VALUES ($1, $2, $3, $4, $5)`
+	_, err := r.db.Exec(query, event.UserID, event.Email, event.IP, event.UserAgent, event.Type)
+	return err
+}
+
+func (r *Repository) GetSecurityEvents(filter SecurityEventFilter) ([]SecurityEvent, error) {
+	query := `
+		SELECT id, user_id, COALESCE(email, ''), COALESCE(ip, ''), COALESCE(user_agent, ''), event_type, created_at
+		FROM security_events
+		WHERE ($1 = 0 OR user_id = $1)
+		  AND ($2 = '' OR ip = $2)
+		  AND ($3 = '' OR event_type = $3)
+		ORDER BY created_at DESC
+		LIMIT $4`
+
+	rows, err := r.db.Query(query, filter.UserID, filter.IP, filter.Type, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []SecurityEvent
+	for rows.Next() {
+		var event SecurityEvent
+		var userID sql.NullInt64
+		if err := rows.Scan(&event.ID, &userID, &event.Email, &event.IP, &event.UserAgent, &event.Type, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			event.UserID = &id
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (r *Repository) CreatePersonalToken(userID int, token *PersonalAccessToken, tokenHash string) error {
+	query := `
+		INSERT INTO personal_access_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+	return r.db.QueryRow(query, userID, token.Name, tokenHash, token.Prefix, pq.Array(token.Scopes), token.ExpiresAt).
+		Scan(&token.ID, &token.CreatedAt)
+}
+
+func (r *Repository) GetPersonalTokens(userID int) ([]PersonalAccessToken, error) {
+	query := `
+		SELECT id, name, token_prefix, scopes, expires_at, last_used_at, created_at
+		FROM personal_access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []PersonalAccessToken
+	for rows.Next() {
+		var t PersonalAccessToken
+		if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, pq.Array(&t.Scopes), &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (r *Repository) RevokePersonalToken(userID, tokenID int) (bool, error) {
+	res, err := r.db.Exec("UPDATE personal_access_tokens SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL", tokenID, userID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func (r *Repository) SetLocale(userID int, locale string) error {
+	_, err := r.db.Exec("UPDATE users SET locale = $1 WHERE id = $2", locale, userID)
+	return err
+}
+
+// ExportRows выполняет запрос выгрузки и возвращает имена колонок и строки
+func (r *Repository) ExportRows(query string, userID int) ([]string, [][]interface{}, error) {
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		for i, v := range values {
+			switch val := v.(type) {
+			case []byte:
+				values[i] = string(val)
+			case time.Time:
+				values[i] = val.UTC().Format(time.RFC3339)
+			}
+		}
+		result = append(result, values)
+	}
+	return columns, result, rows.Err()
+}
+
+func (r *Repository) CreateDataExport(userID int) (*DataExport, error) {
+	export := DataExport{UserID: userID, Status: "pending"}
+	err := r.db.QueryRow("INSERT INTO data_exports (user_id) VALUES ($1) RETURNING id, created_at", userID).
+		Scan(&export.ID, &export.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func (r *Repository) HasActiveDataExport(userID int) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow("SELECT EXISTS(SELECT 1 FROM data_exports WHERE user_id = $1 AND status IN ('pending', 'processing'))", userID).Scan(&exists)
+	return exists, err
+}
+
+func (r *Repository) GetDataExports(userID int) ([]DataExport, error) {
+	query := `
+		SELECT id, user_id, status, COALESCE(object_name, ''), size_bytes, created_at, completed_at, expires_at
+		FROM data_exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT 10`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []DataExport
+	for rows.Next() {
+		var e DataExport
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Status, &e.ObjectName, &e.SizeBytes, &e.CreatedAt, &e.CompletedAt, &e.ExpiresAt); err != nil {
+			return nil, err
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+func (r *Repository) GetDataExport(id int) (*DataExport, error) {
+	query := `
+		SELECT id, user_id, status, COALESCE(object_name, ''), size_bytes, created_at, completed_at, expires_at
+		FROM data_exports
+		WHERE id = $1`
+
+	var e DataExport
+	err := r.db.QueryRow(query, id).Scan(&e.ID, &e.UserID, &e.Status, &e.ObjectName, &e.SizeBytes, &e.CreatedAt, &e.CompletedAt, &e.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ClaimDataExport берет следующую задачу выгрузки; зависшие после падения задачи берутся повторно через час
+func (r *Repository) ClaimDataExport() (*DataExport, error) {
+	query := `
+		UPDATE data_exports SET status = 'processing'
+		WHERE id = (
+			SELECT id FROM data_exports
+			WHERE status = 'pending' OR (status = 'processing' AND created_at < NOW() - INTERVAL '1 hour')
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, created_at`
+
+	e := DataExport{Status: "processing"}
+	err := r.db.QueryRow(query).Scan(&e.ID, &e.UserID, &e.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (r *Repository) CompleteDataExport(id int, objectName string, size int64, expiresAt time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE data_exports SET status = 'ready', object_name = $1, size_bytes = $2, completed_at = NOW(), expires_at = $3
+		WHERE id = $4`, objectName, size, expiresAt, id)
+	return err
+}
+
+func (r *Repository) FailDataExport(id int, reason string) error {
+	_, err := r.db.Exec("UPDATE data_exports SET status = 'failed', error = $1, completed_at = NOW() WHERE id = $2", reason, id)
+	return err
+}
+
+func (r *Repository) GetExpiredDataExports() ([]DataExport, error) {
+	rows, err := r.db.Query("SELECT id, COALESCE(object_name, '') FROM data_exports WHERE status = 'ready' AND expires_at < NOW()")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []DataExport
+	for rows.Next() {
+		var e DataExport
+		if err := rows.Scan(&e.ID, &e.ObjectName); err != nil {
+			return nil, err
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+func (r *Repository) MarkDataExportExpired(id int) error {
+	_, err := r.db.Exec("UPDATE data_exports SET status = 'expired' WHERE id = $1", id)
+	return err
+}
+
+func (r *Repository) ScheduleAccountDeletion(userID int, at time.Time) error {
+	_, err := r.db.Exec("UPDATE users SET deletion_scheduled_at = $1 WHERE id = $2", at, userID)
+	return err
+}
+
+func (r *Repository) CancelAccountDeletion(userID int) (bool, error) {
+	res, err := r.db.Exec("UPDATE users SET deletion_scheduled_at = NULL WHERE id = $1 AND deletion_scheduled_at IS NOT NULL", userID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func (r *Repository) GetUsersDueForDeletion() ([]int, error) {
+	rows, err := r.db.Query("SELECT id FROM users WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= NOW()")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetUserMedia возвращает id треков, плейлистов и альбомов пользователя и его архивы выгрузки, чтобы удалить файлы из хранилища
+func (r *Repository) GetUserMedia(userID int) (*UserMedia, error) {
+	media := &UserMedia{}
+
+	if err := r.db.QueryRow("SELECT COALESCE(array_agg(id), '{}') FROM tracks WHERE author_id = $1", userID).
+		Scan(pq.Array(&media.TrackIDs)); err != nil {
+		return nil, err
+	}
+	if err := r.db.QueryRow("SELECT COALESCE(array_agg(id), '{}') FROM playlists WHERE author_id = $1", userID).
+		Scan(pq.Array(&media.PlaylistIDs)); err != nil {
+		return nil, err
+	}
+	if err := r.db.QueryRow("SELECT COALESCE(array_agg(id), '{}') FROM albums WHERE author_id = $1", userID).
+		Scan(pq.Array(&media.AlbumIDs)); err != nil {
+		return nil, err
+	}
+	if err := r.db.QueryRow("SELECT COALESCE(array_agg(object_name), '{}') FROM data_exports WHERE user_id = $1 AND object_name IS NOT NULL AND status = 'ready'", userID).
+		Scan(pq.Array(&media.Exports)); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// DeleteUserAccount обезличивает прослушивания пользователя и удаляет его аккаунт вместе с контентом.
+// Прослушивания чужих треков остаются в статистике авторов с listener_id = NULL.
+func (r *Repository) DeleteUserAccount(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Чужие плейлисты с треками пользователя и сколько треков из них уйдет: после удаления
+	// в них нужно закрыть дыры и записать системную ревизию. Блокируем в порядке id, как правки состава
+	var affectedPlaylists, removedCounts []int
+	err = tx.QueryRow(`
+		SELECT COALESCE(array_agg(playlist_id ORDER BY playlist_id), '{}'), COALESCE(array_agg(removed ORDER BY playlist_id), '{}')
+		FROM (
+			SELECT tp.playlist_id, COUNT(*) AS removed
+			FROM tracks_playlists tp
+			JOIN tracks t ON t.id = tp.track_id
+			JOIN playlists p ON p.id = tp.playlist_id
+			WHERE t.author_id = $1 AND p.author_id <> $1
+			GROUP BY tp.playlist_id
+		) affected`, userID).Scan(pq.Array(&affectedPlaylists), pq.Array(&removedCounts))
+	if err != nil {
+		return err
+	}
+	if len(affectedPlaylists) > 0 {
+		_, err = tx.Exec("SELECT id FROM playlists WHERE id = ANY($1) ORDER BY id FOR UPDATE", pq.Array(affectedPlaylists))
+		if err != nil {
+			return err
+		}
+	}
+
+	statements := []string{
+		"DELETE FROM track_listens WHERE listener_id = $1 AND is_imported",
+		"UPDATE track_listens SET listener_id = NULL WHERE listener_id = $1",
+
+		// Все, что ссылается на треки пользователя
+		"DELETE FROM listens_parts WHERE listen_id IN (SELECT tl.id FROM track_listens tl JOIN tracks t ON t.id = tl.track_id WHERE t.author_id = $1)",
+		"DELETE FROM track_listens WHERE track_id IN (SELECT id FROM tracks WHERE author_id = $1)",
+		"DELETE FROM likes WHERE track_id IN (SELECT id FROM tracks WHERE author_id = $1)",
+		"DELETE FROM reposts WHERE track_id IN (SELECT id FROM tracks WHERE author_id = $1)",
+		"DELETE FROM comments WHERE track_id IN (SELECT id FROM tracks WHERE author_id = $1)",
+		"DELETE FROM tracks_playlists WHERE track_id IN (SELECT id FROM tracks WHERE author_id = $1)",
+		"DELETE FROM tracks_albums WHERE track_id IN (SELECT id FROM tracks WHERE author_id = $1)",
+		"DELETE FROM track_credits WHERE track_id IN (SELECT id FROM tracks WHERE author_id = $1)",
+
+		// Действия пользователя
+		"DELETE FROM likes WHERE user_id = $1",
+		"DELETE FROM reposts WHERE user_id = $1",
+		"DELETE FROM comments WHERE user_id = $1",
+		"DELETE FROM follows WHERE following_user_id = $1 OR followed_user_id = $1",
+		"DELETE FROM track_credits WHERE user_id = $1",
+
+		// Собственный контент
+		"DELETE FROM tracks_playlists WHERE playlist_id IN (SELECT id FROM playlists WHERE author_id = $1)",
+		"DELETE FROM playlists WHERE author_id = $1",
+		"DELETE FROM tracks_albums WHERE album_id IN (SELECT id FROM albums WHERE author_id = $1)",
+		"DELETE FROM albums WHERE author_id = $1",
+		"DELETE FROM tracks WHERE author_id = $1",
+
+		"DELETE FROM users WHERE id = $1",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, userID); err != nil {
+			return err
+		}
+	}
+
+	// Закрываем дыры в позициях только затронутых чужих плейлистов
+	if len(affectedPlaylists) > 0 {
+		_, err = tx.Exec(`
+			UPDATE tracks_playlists tp SET position = n.rn
+			FROM (
+				SELECT playlist_id, track_id, ROW_NUMBER() OVER (PARTITION BY playlist_id ORDER BY position) AS rn
+				FROM tracks_playlists
+				WHERE playlist_id = ANY($1)
+			) n
+			WHERE tp.playlist_id = n.playlist_id AND tp.track_id = n.track_id AND tp.position <> n.rn`,
+			pq.Array(affectedPlaylists))
+		if err != nil {
+			return err
+		}
+
+		// Состав изменился без участия владельцев: новая ревизия и системная запись в истории (user_id = NULL)
+		_, err = tx.Exec(`
+			WITH bumped AS (
+				UPDATE playlists p SET revision = p.revision + 1, updated_at = NOW()
+				FROM unnest($1::int[], $2::int[]) AS a(playlist_id, removed)
+				WHERE p.id = a.playlist_id
+				RETURNING p.id, p.revision, p.title, p.description, a.removed
+			)
+			INSERT INTO playlist_revisions (playlist_id, revision, user_id, action, details, title, description, track_ids)
+			SELECT b.id, b.revision, NULL, 'remove', jsonb_build_object('action', 'remove', 'removed', b.removed),
+			       b.title, COALESCE(b.description, ''),
+			       COALESCE((SELECT array_agg(tp.track_id ORDER BY tp.position) FROM tracks_playlists tp WHERE tp.playlist_id = b.id), '{}')
+			FROM bumped b`,
+			pq.Array(affectedPlaylists), pq.Array(removedCounts))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *Repository) GetPrivacySettings(userID int) (privacy.Settings, error) {
+	var settings privacy.Settings
+	query := "SELECT private_profile, hide_follows, private_history, playlists_followers_only FROM users WHERE id = $1"
+	err := r.db.QueryRow(query, userID).Scan(&settings.PrivateProfile, &settings.HideFollows, &settings.PrivateHistory, &settings.PlaylistsFollowersOnly)
+	if err == sql.ErrNoRows {
+		return settings, errors.New("пользователь не найден")
+	}
+	return settings, err
+}
+
+func (r *Repository) UpdatePrivacySettings(userID int, settings privacy.Settings) error {
+	query := `
+		UPDATE users
+		SET private_profile = $1, hide_follows = $2, private_history = $3, playlists_followers_only = $4
+		WHERE id = $5`
+	_, err := r.db.Exec(query, settings.PrivateProfile, settings.HideFollows, settings.PrivateHistory, settings.PlaylistsFollowersOnly, userID)
+	return err
+}
+
+func (r *Repository) CreateFollowRequest(requesterID, targetID int) error {
+	_, err := r.db.Exec("INSERT INTO follow_requests (requester_id, target_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", requesterID, targetID)
+	return err
+}
+
+func (r *Repository) DeleteFollowRequest(requesterID, targetID int) (bool, error) {
+	res, err := r.db.Exec("DELETE FROM follow_requests WHERE requester_id = $1 AND target_id = $2", requesterID, targetID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func (r *Repository) HasFollowRequest(requesterID, targetID int) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow("SELECT EXISTS(SELECT 1 FROM follow_requests WHERE requester_id = $1 AND target_id = $2)", requesterID, targetID).Scan(&exists)
+	return exists, err
+}
+
+func (r *Repository) GetFollowRequests(targetID int) ([]FollowRequest, error) {
+	query := `
+		SELECT u.id, u.username, fr.created_at
+		FROM follow_requests fr
+		JOIN users u ON u.id = fr.requester_id
+		WHERE fr.target_id = $1
+		ORDER BY fr.created_at DESC`
+
+	rows, err := r.db.Query(query, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []FollowRequest
+	for rows.Next() {
+		var req FollowRequest
+		if err := rows.Scan(&req.UserID, &req.Username, &req.CreatedAt); err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+// AcceptFollowRequests превращает заявки в подписки; requesterID = 0 принимает все заявки
+func (r *Repository) AcceptFollowRequests(targetID, requesterID int) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		DELETE FROM follow_requests
+		WHERE target_id = $1 AND ($2 = 0 OR requester_id = $2)
+		RETURNING requester_id`, targetID, requesterID)
+	if err != nil {
+		return 0, err
+	}
+	var requesters []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		requesters = append(requesters, id)
+	}
+	rows.Close()
+
+	for _, id := range requesters {
+		_, err := tx.Exec(`
+			INSERT INTO follows (following_user_id, followed_user_id)
+			SELECT $1, $2
+			WHERE NOT EXISTS (SELECT 1 FROM follows WHERE following_user_id = $1 AND followed_user_id = $2)`, id, targetID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(requesters), tx.Commit()
+}
+
+// BlockUser блокирует пользователя и разрывает подписки и заявки в обе стороны
+func (r *Repository) BlockUser(blockerID, blockedID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"INSERT INTO user_blocks (blocker_id, blocked_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		"DELETE FROM follows WHERE (following_user_id = $1 AND followed_user_id = $2) OR (following_user_id = $2 AND followed_user_id = $1)",
+		"DELETE FROM follow_requests WHERE (requester_id = $1 AND target_id = $2) OR (requester_id = $2 AND target_id = $1)",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, blockerID, blockedID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *Repository) UnblockUser(blockerID, blockedID int) error {
+	_, err := r.db.Exec("DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2", blockerID, blockedID)
+	return err
+}
+
+// IsBlockedBetween проверяет блокировку в любую сторону
+func (r *Repository) IsBlockedBetween(userID, otherID int) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (
+		SELECT 1 FROM user_blocks
+		WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+	)`
+	err := r.db.QueryRow(query, userID, otherID).Scan(&exists)
+	return exists, err
+}
+
+// CanWatchTrack - трек существует, не скрыт модерацией (кроме автора и админа)
+// и автор не заблокировал пользователя
+func (r *Repository) CanWatchTrack(trackID, userID int, isAdmin bool) (bool, error) {
+	var ok bool
+	err := r.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM tracks t
+			WHERE t.id = $1
+			  AND (NOT t.is_blocked OR t.author_id = $2 OR $3)
+			  AND NOT EXISTS (SELECT 1 FROM user_blocks b WHERE b.blocker_id = t.author_id AND b.blocked_id = $2)
+		)`, trackID, userID, isAdmin).Scan(&ok)
+	return ok, err
+}
+
+func (r *Repository) GetBlockedUsers(userID int) ([]RelatedUser, error) {
+	return r.getRelatedUsers(`
+		SELECT u.id, u.username, b.created_at
+		FROM user_blocks b JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = $1
+		ORDER BY b.created_at DESC`, userID)
+}
+
+func (r *Repository) MuteUser(muterID, mutedID int) error {
+	_, err := r.db.Exec("INSERT INTO user_mutes (muter_id, muted_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", muterID, mutedID)
+	return err
+}
+
+func (r *Repository) UnmuteUser(muterID, mutedID int) error {
+	_, err := r.db.Exec("DELETE FROM user_mutes WHERE muter_id = $1 AND muted_id = $2", muterID, mutedID)
+	return err
+}
+
+func (r *Repository) GetMutedUsers(userID int) ([]RelatedUser, error) {
+	return r.getRelatedUsers(`
+		SELECT u.id, u.username, m.created_at
+		FROM user_mutes m JOIN users u ON u.id = m.muted_id
+		WHERE m.muter_id = $1
+		ORDER BY m.created_at DESC`, userID)
+}
+
+func (r *Repository) getRelatedUsers(query string, userID int) ([]RelatedUser, error) {
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []RelatedUser
+	for rows.Next() {
+		var u RelatedUser
+		if err := rows.Scan(&u.UserID, &u.Username, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// Уведомления
+
+// GetNotifications возвращает страницу входящих, новые и обновленные группы первыми
+func (r *Repository) GetNotifications(userID, limit, offset int, unreadOnly bool) ([]Notification, error) {
+	rows, err := r.db.Query(`
+		SELECT n.id, n.type, n.target_type, n.target_id, n.data, n.is_read, n.created_at, n.updated_at,
+		       (SELECT COUNT(*) FROM notification_actors na WHERE na.notification_id = n.id),
+		       COALESCE((
+		           SELECT json_agg(json_build_object('id', u.id, 'username', u.username) ORDER BY a.created_at DESC)
+		           FROM (
+		               SELECT actor_id, created_at FROM notification_actors
+		               WHERE notification_id = n.id
+		               ORDER BY created_at DESC
+		               LIMIT 3
+		           ) a
+		           JOIN users u ON u.id = a.actor_id
+		       ), '[]')
+		FROM notifications n
+		WHERE n.recipient_id = $1 AND (NOT $4 OR NOT n.is_read)
+		ORDER BY n.updated_at DESC, n.id DESC
+		LIMIT $2 OFFSET $3`, userID, limit, offset, unreadOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		var actors []byte
+		if err := rows.Scan(&n.ID, &n.Type, &n.TargetType, &n.TargetID, &n.Data, &n.IsRead, &n.CreatedAt, &n.UpdatedAt,
+			&n.ActorCount, &actors); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(actors, &n.Actors); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (r *Repository) CountUnreadNotifications(userID int) (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM notifications WHERE recipient_id = $1 AND NOT is_read", userID).Scan(&count)
+	return count, err
+}
+
+func (r *Repository) MarkNotificationRead(notificationID, userID int) (bool, error) {
+	res, err := r.db.Exec("UPDATE notifications SET is_read = TRUE WHERE id = $1 AND recipient_id = $2", notificationID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *Repository) MarkAllNotificationsRead(userID int) error {
+	_, err := r.db.Exec("UPDATE notifications SET is_read = TRUE WHERE recipient_id = $1 AND NOT is_read", userID)
+	return err
+}
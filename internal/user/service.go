@@ -1,19 +1,40 @@
 package user
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
 
 	"github.com/Bossnicks/music-streaming-service-kurs/pkg/auth"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/errorspkg"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/mailer"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/notify"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/oidc"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/privacy"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/realtime"
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/storage"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Service struct {
-	repo *Repository
+	repo      *Repository
+	providers *oidc.Registry
+	outbox    *mailer.Outbox
+	storage   *storage.MinioStorage
+	notifier  *notify.Notifier
+	hub       *realtime.Hub
 }
 
-func NewService(repo *Repository) *Service {
-	return &Service{repo: repo}
+func NewService(repo *Repository, providers *oidc.Registry, outbox *mailer.Outbox, storage *storage.MinioStorage, notifier *notify.Notifier, hub *realtime.Hub) *Service {
+	return &Service{repo: repo, providers: providers, outbox: outbox, storage: storage, notifier: notifier, hub: hub}
 }
 
 func (s *Service) RegisterUser(user *User) error {
@@ -31,18 +52,173 @@ func (s *Service) RegisterUser(user *User) error {
 	return s.repo.CreateUser(user)
 }
 
-func (s *Service) Authenticate(email, password string) (string, *User, error) {
+// Параметры защиты от перебора
+const (
+	backoffAfterFailures = 3  // после 3 ошибок подряд включается задержка
+	lockoutAfterFailures = 10 // после 10 - временная блокировка
+	lockoutDuration      = 30 * time.Minute
+	maxBackoff           = 15 * time.Minute
+	ipFailureWindow      = 15 * time.Minute
+	maxFailuresPerIP     = 30
+)
+
+func (s *Service) Authenticate(email, password string, meta LoginMeta) (string, *User, error) {
+	if err := s.checkIPThrottle(meta); err != nil {
+		return "", nil, err
+	}
+
 	user, err := s.repo.GetUserByEmail(email)
-	fmt.Println(user)
 	if err != nil {
+		s.recordEvent(nil, email, meta, "login_failed")
 		return "", nil, errors.New("неверные учетные данные")
 	}
 
+	if err := checkLocked(user); err != nil {
+		s.recordEvent(&user.ID, email, meta, "login_blocked")
+		return "", nil, err
+	}
+
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
+		s.registerFailure(user, meta, "login_failed")
 		return "", nil, errors.New("неверные учетные данные")
 	}
 
+	// Пароль верный, но включена 2FA: выдаем промежуточный токен вместо сессии
+	if user.TOTPEnabled {
+		mfaToken, err := auth.GenerateMFAToken(user.ID, user.Role, auth.PurposeMFALogin)
+		if err != nil {
+			return "", nil, err
+		}
+		s.recordEvent(&user.ID, email, meta, "login_password_ok")
+		return mfaToken, nil, errorspkg.ErrTwoFactorRequired
+	}
+
+	return s.completeLogin(user, meta)
+}
+
+func (s *Service) checkIPThrottle(meta LoginMeta) error {
+	if meta.IP == "" {
+		return nil
+	}
+	failures, err := s.repo.CountRecentFailuresByIP(meta.IP, time.Now().Add(-ipFailureWindow))
+	if err != nil {
+		return err
+	}
+	if failures >= maxFailuresPerIP {
+		s.recordEvent(nil, "", meta, "ip_throttled")
+		return errorspkg.ErrTooManyAttempts
+	}
+	return nil
+}
+
+func checkLocked(user *User) error {
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		return fmt.Errorf("%w, повторите после %s", errorspkg.ErrAccountLocked, user.LockedUntil.Format("15:04:05"))
+	}
+	return nil
+}
+
+// registerFailure увеличивает счетчик и выставляет задержку 1с, 2с, 4с, ... или блокировку
+func (s *Service) registerFailure(user *User, meta LoginMeta, eventType string) {
+	s.recordEvent(&user.ID, user.Email, meta, eventType)
+
+	count, err := s.repo.IncrementFailedLogins(user.ID)
+	if err != nil {
+		log.Printf("не удалось обновить счетчик попыток входа: %v", err)
+		return
+	}
+
+	switch {
+	case count >= lockoutAfterFailures:
+		until := time.Now().Add(lockoutDuration)
+		if err := s.repo.SetLockedUntil(user.ID, until); err != nil {
+			log.Printf("не удалось заблокировать вход: %v", err)
+			return
+		}
+		s.recordEvent(&user.ID, user.Email, meta, "account_locked")
+		if count == lockoutAfterFailures {
+			data := map[string]interface{}{"Until": until}
+			if err := s.outbox.Enqueue(user.Email, user.Locale, mailer.TemplateAccountLocked, data); err != nil {
+				log.Printf("не удалось поставить в очередь уведомление о блокировке: %v", err)
+			}
+		}
+	case count >= backoffAfterFailures:
+		delay := time.Second << (count - backoffAfterFailures)
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+		if err := s.repo.SetLockedUntil(user.ID, time.Now().Add(delay)); err != nil {
+			log.Printf("не удалось выставить задержку входа: %v", err)
+		}
+	}
+}
+
+func (s *Service) completeLogin(user *User, meta LoginMeta) (string, *User, error) {
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		if err := s.repo.ResetFailedLogins(user.ID); err != nil {
+			return "", nil, err
+		}
+	}
+	s.recordEvent(&user.ID, user.Email, meta, "login_success")
+
+	// Пока обязательная 2FA не подключена, вместо сессии выдается токен только для ее подключения
+	setupRequired, err := s.IsTwoFactorSetupRequired(user)
+	if err != nil {
+		return "", nil, err
+	}
+	if setupRequired {
+		setupToken, err := auth.GenerateMFAToken(user.ID, user.Role, auth.PurposeTwoFactorSetup)
+		if err != nil {
+			return "", nil, err
+		}
+		return setupToken, nil, errorspkg.ErrTwoFactorSetupRequired
+	}
+	return s.issueSession(user)
+}
+
+// FinishTwoFactorSetup выдает сессию после подключения 2FA по токену подключения
+func (s *Service) FinishTwoFactorSetup(userID int) (string, *User, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if !user.TOTPEnabled {
+		return "", nil, errorspkg.ErrTwoFactorSetupRequired
+	}
+	return s.issueSession(user)
+}
+
+func (s *Service) recordEvent(userID *int, email string, meta LoginMeta, eventType string) {
+	event := &SecurityEvent{
+		UserID:    userID,
+		Email:     email,
+		IP:        meta.IP,
+		UserAgent: meta.UserAgent,
+		Type:      eventType,
+	}
+	if err := s.repo.AddSecurityEvent(event); err != nil {
+		log.Printf("не удалось записать событие безопасности %s: %v", eventType, err)
+	}
+}
+
+func (s *Service) GetLoginActivity(userID int) ([]SecurityEvent, error) {
+	return s.repo.GetSecurityEvents(SecurityEventFilter{UserID: userID, Limit: 50})
+}
+
+func (s *Service) GetSecurityEvents(filter SecurityEventFilter) ([]SecurityEvent, error) {
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+	return s.repo.GetSecurityEvents(filter)
+}
+
+func (s *Service) UnlockUser(userID int) error {
+	return s.repo.ResetFailedLogins(userID)
+}
+
+// issueSession выдает JWT и сохраняет его как текущий токен пользователя
+func (s *Service) issueSession(user *User) (string, *User, error) {
 	token, err := auth.GenerateJWT(user.ID, user.Role)
 	if err != nil {
 		return "", nil, err
@@ -56,15 +232,16 @@ func (s *Service) Authenticate(email, password string) (string, *User, error) {
 	}
 
 	sanitizedUser := &User{
-		ID:         user.ID,
-		Username:   user.Username,
-		Email:      user.Email,
-		Password:   "",
-		Avatar:     user.Avatar,
-		Role:       user.Role,
-		CreatedAt:  user.CreatedAt,
-		UpdatedAt:  nil,
-		CanComment: user.CanComment,
+		ID:          user.ID,
+		Username:    user.Username,
+		Email:       user.Email,
+		Password:    "",
+		Avatar:      user.Avatar,
+		Role:        user.Role,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   nil,
+		CanComment:  user.CanComment,
+		TOTPEnabled: user.TOTPEnabled,
 	}
 
 	return token, sanitizedUser, nil // Возвращаем и токен, и пользователя
@@ -82,29 +259,116 @@ func (s *Service) UpdateUser(userID int, req UpdateUserRequest) error {
 	return s.repo.UpdateUser(userID, &req)
 }
 
+// ChangeEmail меняет адрес входа; step-up проверяет обработчик до вызова
+func (s *Service) ChangeEmail(userID int, email string) error {
+	email = strings.TrimSpace(email)
+	if at := strings.Index(email, "@"); at <= 0 || at == len(email)-1 || strings.ContainsAny(email, " \t\r\n") {
+		return errorspkg.ErrInvalidEmail
+	}
+	existing, _ := s.repo.GetUserByEmail(email)
+	if existing != nil {
+		if existing.ID == userID {
+			return nil
+		}
+		return errorspkg.ErrEmailTaken
+	}
+	return s.repo.UpdateUser(userID, &UpdateUserRequest{Email: &email})
+}
+
 func (s *Service) GetAvatar(userID int) ([]byte, error) {
 	return s.repo.GetAvatar(userID)
 }
 
-func (s *Service) FollowUser(userID, followingUserID int) error {
+// FollowUser подписывает сразу или, если профиль закрыт, создает заявку (requested = true)
+func (s *Service) FollowUser(userID, followingUserID int) (bool, error) {
 	if userID == followingUserID {
-		return errors.New("you cannot follow yourself")
+		return false, errors.New("you cannot follow yourself")
+	}
+
+	blocked, err := s.repo.IsBlockedBetween(userID, followingUserID)
+	if err != nil {
+		return false, err
+	}
+	if blocked {
+		return false, errorspkg.ErrUserBlocked
+	}
+
+	settings, err := s.repo.GetPrivacySettings(followingUserID)
+	if err != nil {
+		return false, err
+	}
+	if settings.PrivateProfile {
+		following, err := s.repo.IsUserSubscribed(userID, followingUserID)
+		if err != nil {
+			return false, err
+		}
+		if !following {
+			if err := s.repo.CreateFollowRequest(userID, followingUserID); err != nil {
+				return false, err
+			}
+			s.notifier.Emit(notify.Event{RecipientID: followingUserID, ActorID: userID, Type: notify.TypeFollowRequest, TargetType: "user", TargetID: userID})
+			return true, nil
+		}
+		return false, nil
+	}
+
+	if err := s.repo.FollowUser(userID, followingUserID); err != nil {
+		return false, err
 	}
-	return s.repo.FollowUser(userID, followingUserID)
+	s.notifier.Emit(notify.Event{RecipientID: followingUserID, ActorID: userID, Type: notify.TypeFollow, TargetType: "user", TargetID: userID})
+	return false, nil
 }
 
+// UnfollowUser отменяет подписку или неподтвержденную заявку
 func (s *Service) UnfollowUser(userID, followingUserID int) error {
+	if _, err := s.repo.DeleteFollowRequest(userID, followingUserID); err != nil {
+		return err
+	}
 	return s.repo.UnfollowUser(userID, followingUserID)
 }
 
-func (s *Service) GetFollowers(userID int) ([]int, error) {
+func (s *Service) GetFollowers(userID, viewerID int, isAdmin bool) ([]int, error) {
+	if err := s.checkFollowsVisible(userID, viewerID, isAdmin); err != nil {
+		return nil, err
+	}
 	return s.repo.GetFollowers(userID)
 }
 
-func (s *Service) GetFollowing(userID int) ([]int, error) {
+func (s *Service) GetFollowing(userID, viewerID int, isAdmin bool) ([]int, error) {
+	if err := s.checkFollowsVisible(userID, viewerID, isAdmin); err != nil {
+		return nil, err
+	}
 	return s.repo.GetFollowing(userID)
 }
 
+func (s *Service) checkFollowsVisible(ownerID, viewerID int, isAdmin bool) error {
+	settings, access, err := s.privacyAccess(ownerID, viewerID, isAdmin)
+	if err != nil {
+		return err
+	}
+	if !settings.CanViewFollows(access) {
+		return errorspkg.ErrPrivateContent
+	}
+	return nil
+}
+
+// privacyAccess загружает настройки владельца и определяет, кем ему приходится смотрящий (viewerID = 0 - гость)
+func (s *Service) privacyAccess(ownerID, viewerID int, isAdmin bool) (privacy.Settings, privacy.Access, error) {
+	settings, err := s.repo.GetPrivacySettings(ownerID)
+	if err != nil {
+		return settings, privacy.Access{}, err
+	}
+
+	access := privacy.Access{Owner: viewerID != 0 && viewerID == ownerID, Admin: isAdmin}
+	if viewerID != 0 && !access.Owner {
+		access.Follower, err = s.repo.IsUserSubscribed(viewerID, ownerID)
+		if err != nil {
+			return settings, access, err
+		}
+	}
+	return settings, access, nil
+}
+
 func (s *Service) IsUserSubscribed(userID, targetID int) (bool, error) {
 	return s.repo.IsUserSubscribed(userID, targetID)
 }
@@ -133,7 +397,7 @@ func (s *Service) IsValidResetToken(token, email string) (bool, error) {
 	return s.repo.IsValidResetToken(token, email)
 }
 
-func (s *Service) Search(query string, entityTypes []string, genre string, sortField string, order string, isAdmin bool) (map[string]interface{}, error) {
+func (s *Service) Search(query string, entityTypes []string, genre string, sortField string, order string, viewerID int, isAdmin bool) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
 
 	// Если категории не указаны, ищем по всем
@@ -145,19 +409,19 @@ func (s *Service) Search(query string, entityTypes []string, genre string, sortF
 	for _, entityType := range entityTypes {
 		switch entityType {
 		case "track":
-			tracks, err := s.repo.SearchTracks(query, genre, sortField, order, isAdmin)
+			tracks, err := s.repo.SearchTracks(query, genre, sortField, order, viewerID, isAdmin)
 			if err != nil {
 				return nil, err
 			}
 			result["tracks"] = tracks
 		case "playlist":
-			playlists, err := s.repo.SearchPlaylists(query, sortField, order)
+			playlists, err := s.repo.SearchPlaylists(query, sortField, order, viewerID, isAdmin)
 			if err != nil {
 				return nil, err
 			}
 			result["playlists"] = playlists
 		case "user":
-			users, err := s.repo.SearchUsers(query, sortField, order)
+			users, err := s.repo.SearchUsers(query, sortField, order, viewerID)
 			if err != nil {
 				return nil, err
 			}
@@ -191,3 +455,953 @@ func (s *Service) UnhideAlbum(userID, albumID int) error {
 func (s *Service) IsAlbumHidden(userID, albumID int) (bool, error) {
 	return s.repo.IsAlbumHidden(userID, albumID)
 }
+
+const recoveryCodesCount = 10
+
+// EnrollTOTP создает новый секрет; 2FA включается только после ConfirmTOTP
+func (s *Service) EnrollTOTP(userID int) (string, string, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return "", "", err
+	}
+	if user.TOTPEnabled {
+		return "", "", errors.New("двухфакторная аутентификация уже включена")
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.repo.SetTOTPSecret(userID, secret); err != nil {
+		return "", "", err
+	}
+
+	return secret, auth.TOTPURI(user.Email, secret), nil
+}
+
+// ConfirmTOTP включает 2FA по первому верному коду и возвращает коды восстановления
+func (s *Service) ConfirmTOTP(userID int, code string) ([]string, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPSecret == "" {
+		return nil, errors.New("сначала начните подключение 2FA")
+	}
+	ok, err := s.acceptTOTP(user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errorspkg.ErrInvalidTwoFactorCode
+	}
+
+	if err := s.repo.EnableTOTP(userID); err != nil {
+		return nil, err
+	}
+	return s.replaceRecoveryCodes(userID)
+}
+
+func (s *Service) DisableTOTP(userID int, code, recoveryCode string) error {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	required, err := s.repo.IsTwoFactorRequiredForRole(user.Role)
+	if err != nil {
+		return err
+	}
+	if required {
+		return errorspkg.ErrTwoFactorSetupRequired
+	}
+
+	if err := s.verifySecondFactor(user, code, recoveryCode); err != nil {
+		return err
+	}
+	return s.repo.DisableTOTP(userID)
+}
+
+// VerifyLogin завершает вход: проверяет промежуточный токен и второй фактор
+func (s *Service) VerifyLogin(mfaToken, code, recoveryCode string, meta LoginMeta) (string, *User, error) {
+	claims, err := auth.ParseMFAToken(mfaToken, auth.PurposeMFALogin)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := s.checkIPThrottle(meta); err != nil {
+		return "", nil, err
+	}
+
+	user, err := s.repo.GetUserByID(claims.UserID)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkLocked(user); err != nil {
+		return "", nil, err
+	}
+
+	// Неверные коды 2FA считаются так же, как неверные пароли
+	if err := s.verifySecondFactor(user, code, recoveryCode); err != nil {
+		s.registerFailure(user, meta, "2fa_failed")
+		return "", nil, err
+	}
+
+	return s.completeLogin(user, meta)
+}
+
+func (s *Service) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verifySecondFactor(user, code, ""); err != nil {
+		return nil, err
+	}
+	return s.replaceRecoveryCodes(userID)
+}
+
+func (s *Service) CountRecoveryCodes(userID int) (int, error) {
+	return s.repo.CountUnusedRecoveryCodes(userID)
+}
+
+// StepUp повторно проверяет второй фактор и выдает токен для опасных операций
+func (s *Service) StepUp(userID int, code, recoveryCode string) (string, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+	if err := s.verifySecondFactor(user, code, recoveryCode); err != nil {
+		return "", err
+	}
+	return auth.GenerateMFAToken(user.ID, user.Role, auth.PurposeStepUp)
+}
+
+// RequireStepUp проверяет step-up токен, если у пользователя включена или обязательна 2FA
+func (s *Service) RequireStepUp(userID int, stepUpToken string) error {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if !user.TOTPEnabled {
+		required, err := s.repo.IsTwoFactorRequiredForRole(user.Role)
+		if err != nil {
+			return err
+		}
+		if required {
+			return errorspkg.ErrTwoFactorSetupRequired
+		}
+		return nil
+	}
+
+	claims, err := auth.ParseMFAToken(stepUpToken, auth.PurposeStepUp)
+	if err != nil || claims.UserID != userID {
+		return errorspkg.ErrStepUpRequired
+	}
+	return nil
+}
+
+// IsTwoFactorSetupRequired сообщает, что роль требует 2FA, а пользователь ее еще не включил
+func (s *Service) IsTwoFactorSetupRequired(user *User) (bool, error) {
+	if user.TOTPEnabled {
+		return false, nil
+	}
+	return s.repo.IsTwoFactorRequiredForRole(user.Role)
+}
+
+func (s *Service) SetTwoFactorPolicy(role string, required bool) error {
+	return s.repo.SetTwoFactorPolicy(role, required)
+}
+
+func (s *Service) GetTwoFactorPolicies() ([]TwoFactorPolicy, error) {
+	return s.repo.GetTwoFactorPolicies()
+}
+
+func (s *Service) verifySecondFactor(user *User, code, recoveryCode string) error {
+	if !user.TOTPEnabled {
+		return errors.New("двухфакторная аутентификация не включена")
+	}
+
+	// Неверный TOTP не мешает проверить код восстановления, если передан и он
+	if code != "" {
+		ok, err := s.acceptTOTP(user, code)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	if recoveryCode != "" {
+		ok, err := s.repo.UseRecoveryCode(user.ID, auth.HashRecoveryCode(recoveryCode))
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errorspkg.ErrInvalidTwoFactorCode
+}
+
+// acceptTOTP принимает код один раз: повтор кода того же или более раннего шага отклоняется
+func (s *Service) acceptTOTP(user *User, code string) (bool, error) {
+	step, ok := auth.MatchTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return s.repo.AcceptTOTPStep(user.ID, step)
+}
+
+func (s *Service) replaceRecoveryCodes(userID int) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, auth.HashRecoveryCode(code))
+	}
+	if err := s.repo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *Service) OIDCProviders() []string {
+	return s.providers.Names()
+}
+
+// StartOIDCLogin сохраняет state/nonce/PKCE и возвращает адрес авторизации у провайдера и state
+// (его подпись хендлер кладет в cookie). linkUserID != 0 означает привязку к уже вошедшему пользователю.
+func (s *Service) StartOIDCLogin(ctx context.Context, providerName string, linkUserID int) (string, string, error) {
+	provider, err := s.providers.Get(providerName)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	st := &OIDCLoginState{
+		State:        state,
+		Provider:     providerName,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	}
+	if linkUserID != 0 {
+		st.LinkUserID = &linkUserID
+	}
+
+	if err := s.repo.DeleteExpiredOIDCLoginStates(); err != nil {
+		return "", "", err
+	}
+	if err := s.repo.SaveOIDCLoginState(st); err != nil {
+		return "", "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, oidc.CodeChallengeS256(verifier))
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// CompleteOIDCLogin обменивает code на ID-токен и входит, привязывает или создает аккаунт
+func (s *Service) CompleteOIDCLogin(ctx context.Context, providerName, state, code string, meta LoginMeta) (string, *User, error) {
+	provider, err := s.providers.Get(providerName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	st, err := s.repo.ConsumeOIDCLoginState(state, providerName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	identity, err := provider.Exchange(ctx, code, st.CodeVerifier, st.Nonce)
+	if err != nil {
+		return "", nil, err
+	}
+
+	userID, err := s.repo.GetUserIDByIdentity(identity.Provider, identity.Subject)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch {
+	case st.LinkUserID != nil:
+		if userID != 0 && userID != *st.LinkUserID {
+			return "", nil, errors.New("этот аккаунт уже привязан к другому пользователю")
+		}
+		if userID == 0 {
+			if err := s.repo.LinkIdentity(*st.LinkUserID, identity.Provider, identity.Subject, identity.Email); err != nil {
+				return "", nil, err
+			}
+		}
+		userID = *st.LinkUserID
+	case userID == 0:
+		userID, err = s.registerFromIdentity(identity)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkLocked(user); err != nil {
+		return "", nil, err
+	}
+
+	if user.TOTPEnabled {
+		mfaToken, err := auth.GenerateMFAToken(user.ID, user.Role, auth.PurposeMFALogin)
+		if err != nil {
+			return "", nil, err
+		}
+		return mfaToken, nil, errorspkg.ErrTwoFactorRequired
+	}
+
+	return s.completeLogin(user, meta)
+}
+
+// registerFromIdentity создает аккаунт при первом входе с подтвержденным email.
+// Существующий аккаунт с тем же email автоматически не привязывается: это делает сам владелец.
+func (s *Service) registerFromIdentity(identity *oidc.Identity) (int, error) {
+	if identity.Email == "" || !identity.EmailVerified {
+		return 0, errors.New("провайдер не подтвердил email")
+	}
+
+	existing, _ := s.repo.GetUserByEmail(identity.Email)
+	if existing != nil {
+		return 0, errors.New("пользователь с таким email уже существует, войдите и привяжите аккаунт в настройках")
+	}
+
+	username, err := s.pickUsername(identity)
+	if err != nil {
+		return 0, err
+	}
+
+	// Пароль неизвестен никому; при необходимости его можно задать через восстановление
+	randomPassword, err := oidc.RandomString()
+	if err != nil {
+		return 0, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	user := &User{
+		Username: username,
+		Email:    identity.Email,
+		Password: string(hashedPassword),
+	}
+	if err := s.repo.CreateUserWithIdentity(user, identity.Provider, identity.Subject, identity.Email); err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+func (s *Service) pickUsername(identity *oidc.Identity) (string, error) {
+	base := identity.Username
+	if base == "" {
+		base = identity.Name
+	}
+	if base == "" {
+		base = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	base = strings.TrimSpace(base)
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		exists, err := s.repo.UsernameExists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+		suffix, err := oidc.RandomString()
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s_%s", base, strings.ToLower(suffix[:4]))
+	}
+	return "", errors.New("не удалось подобрать имя пользователя")
+}
+
+func (s *Service) GetIdentities(userID int) ([]LinkedIdentity, error) {
+	return s.repo.GetIdentities(userID)
+}
+
+func (s *Service) UnlinkIdentity(userID int, provider string) (bool, error) {
+	return s.repo.UnlinkIdentity(userID, provider)
+}
+
+const (
+	defaultTokenLifetimeDays = 90
+	maxTokenLifetimeDays     = 365
+)
+
+// CreatePersonalToken возвращает токен в открытом виде; в БД остается только хеш
+func (s *Service) CreatePersonalToken(userID int, req CreateTokenRequest) (string, *PersonalAccessToken, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return "", nil, errors.New("укажите название токена")
+	}
+	if len(req.Scopes) == 0 {
+		return "", nil, errors.New("укажите хотя бы одну область доступа")
+	}
+	for _, scope := range req.Scopes {
+		if !auth.IsValidScope(scope) {
+			return "", nil, fmt.Errorf("неизвестная область доступа: %s", scope)
+		}
+	}
+
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = defaultTokenLifetimeDays
+	}
+	if days < 0 || days > maxTokenLifetimeDays {
+		return "", nil, fmt.Errorf("срок действия должен быть от 1 до %d дней", maxTokenLifetimeDays)
+	}
+	expiresAt := time.Now().AddDate(0, 0, days)
+
+	plain, hash, err := auth.GeneratePersonalToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := &PersonalAccessToken{
+		Name:      name,
+		Prefix:    plain[:len(auth.PersonalTokenPrefix)+6],
+		Scopes:    req.Scopes,
+		ExpiresAt: &expiresAt,
+	}
+	if err := s.repo.CreatePersonalToken(userID, token, hash); err != nil {
+		return "", nil, err
+	}
+	return plain, token, nil
+}
+
+func (s *Service) GetPersonalTokens(userID int) ([]PersonalAccessToken, error) {
+	return s.repo.GetPersonalTokens(userID)
+}
+
+func (s *Service) RevokePersonalToken(userID, tokenID int) (bool, error) {
+	return s.repo.RevokePersonalToken(userID, tokenID)
+}
+
+// QueuePasswordReset ставит письмо со ссылкой сброса в очередь на языке пользователя.
+// Для незарегистрированного адреса письмо не отправляется, но ошибка не возвращается,
+// чтобы по ответу нельзя было перебирать email.
+func (s *Service) QueuePasswordReset(email, resetLink string) error {
+	user, err := s.repo.GetUserByEmail(email)
+	if err != nil {
+		return nil
+	}
+	return s.outbox.Enqueue(user.Email, user.Locale, mailer.TemplateResetPassword, map[string]interface{}{"Link": resetLink})
+}
+
+func (s *Service) SetLocale(userID int, locale string) (string, error) {
+	normalized := mailer.NormalizeLocale(locale)
+	if normalized != strings.ToLower(strings.TrimSpace(locale)) {
+		return "", fmt.Errorf("язык не поддерживается, доступны: %s", strings.Join(mailer.SupportedLocales, ", "))
+	}
+	return normalized, s.repo.SetLocale(userID, normalized)
+}
+
+const (
+	// Адрес user-service, по которому открываются подписанные ссылки на выгрузку
+	userServiceURL = "http://localhost:12000"
+
+	exportLinkLifetime   = 7 * 24 * time.Hour
+	accountDeletionGrace = 30 * 24 * time.Hour
+)
+
+// exportFiles - состав архива выгрузки: имя файла -> запрос по id пользователя.
+// Файлы .json выгружаются массивом объектов, .csv - таблицей с заголовком.
+var exportFiles = []struct {
+	name  string
+	query string
+}{
+	{"profile.json", "SELECT id, username, email, role, locale, is_verified, totp_enabled, created_at FROM users WHERE id = $1"},
+	{"tracks.json", "SELECT id, title, description, genre, duration, isrc, explicit, language, created_at FROM tracks WHERE author_id = $1 ORDER BY id"},
+	{"albums.json", "SELECT id, title, description, release_date, release_type, label, upc, p_line, c_line, explicit, language FROM albums WHERE author_id = $1 ORDER BY id"},
+	{"playlists.json", `
+		SELECT p.id, p.title, p.description, p.visibility, p.created_at,
+			ARRAY(SELECT tp.track_id FROM tracks_playlists tp WHERE tp.playlist_id = p.id ORDER BY tp.position)::TEXT AS track_ids
+		FROM playlists p WHERE p.author_id = $1 ORDER BY p.id`},
+	{"comments.json", "SELECT id, track_id, text, moment, created_at FROM comments WHERE user_id = $1 ORDER BY created_at"},
+	{"likes.csv", "SELECT l.track_id, t.title, l.created_at FROM likes l JOIN tracks t ON t.id = l.track_id WHERE l.user_id = $1 ORDER BY l.created_at"},
+	{"reposts.csv", "SELECT r.track_id, t.title, r.created_at FROM reposts r JOIN tracks t ON t.id = r.track_id WHERE r.user_id = $1 ORDER BY r.created_at"},
+	{"following.csv", "SELECT u.id AS user_id, u.username FROM follows f JOIN users u ON u.id = f.followed_user_id WHERE f.following_user_id = $1"},
+	{"followers.csv", "SELECT u.id AS user_id, u.username FROM follows f JOIN users u ON u.id = f.following_user_id WHERE f.followed_user_id = $1"},
+	{"saved_albums.csv", "SELECT a.id AS album_id, a.title, s.created_at FROM album_saves s JOIN albums a ON a.id = s.album_id WHERE s.user_id = $1 ORDER BY s.created_at"},
+	{"presaved_albums.csv", "SELECT a.id AS album_id, a.title, a.release_date, ps.created_at FROM album_presaves ps JOIN albums a ON a.id = ps.album_id WHERE ps.user_id = $1 ORDER BY ps.created_at"},
+	{"saved_playlists.csv", "SELECT p.id AS playlist_id, p.title, pf.created_at FROM playlist_follows pf JOIN playlists p ON p.id = pf.playlist_id WHERE pf.user_id = $1 ORDER BY pf.created_at"},
+	{"listens.csv", `
+		SELECT tl.track_id, t.title, tl.created_at, tl.total_listen_time, tl.country, tl.device
+		FROM track_listens tl JOIN tracks t ON t.id = tl.track_id
+		WHERE tl.listener_id = $1 ORDER BY tl.created_at`},
+	{"security_events.csv", "SELECT event_type, ip, user_agent, created_at FROM security_events WHERE user_id = $1 ORDER BY created_at"},
+}
+
+func (s *Service) RequestDataExport(userID int) (*DataExport, error) {
+	active, err := s.repo.HasActiveDataExport(userID)
+	if err != nil {
+		return nil, err
+	}
+	if active {
+		return nil, errorspkg.ErrExportInProgress
+	}
+	return s.repo.CreateDataExport(userID)
+}
+
+func (s *Service) GetDataExports(userID int) ([]DataExport, error) {
+	exports, err := s.repo.GetDataExports(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range exports {
+		e := &exports[i]
+		if e.Status == "ready" && e.ExpiresAt != nil && e.ExpiresAt.After(time.Now()) {
+			e.DownloadURL = exportDownloadURL(e.ID, *e.ExpiresAt)
+		}
+	}
+	return exports, nil
+}
+
+func exportDownloadURL(exportID int, expires time.Time) string {
+	signature := auth.SignDownload(fmt.Sprintf("export:%d", exportID), expires)
+	return fmt.Sprintf("%s/beatstreet/api/users/export/%d/download?expires=%d&signature=%s",
+		userServiceURL, exportID, expires.Unix(), signature)
+}
+
+// OpenDataExport проверяет подписанную ссылку и открывает архив
+func (s *Service) OpenDataExport(exportID int, expires int64, signature string) (io.ReadCloser, error) {
+	if !auth.VerifyDownload(fmt.Sprintf("export:%d", exportID), expires, signature) {
+		return nil, errorspkg.ErrInvalidDownloadLink
+	}
+	export, err := s.repo.GetDataExport(exportID)
+	if err != nil {
+		return nil, err
+	}
+	if export == nil || export.Status != "ready" {
+		return nil, errorspkg.ErrInvalidDownloadLink
+	}
+	return s.storage.GetExport(export.ObjectName)
+}
+
+func (s *Service) processDataExport(export *DataExport) error {
+	user, err := s.repo.GetUserByID(export.UserID)
+	if err != nil {
+		return err
+	}
+
+	archive, err := s.buildExportArchive(export.UserID)
+	if err != nil {
+		return err
+	}
+
+	token, err := oidc.RandomString()
+	if err != nil {
+		return err
+	}
+	objectName := fmt.Sprintf("exports/%d/%d-%s.zip", export.UserID, export.ID, token[:16])
+	size := int64(archive.Len())
+	if err := s.storage.UploadExport(objectName, archive, size); err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(exportLinkLifetime)
+	if err := s.repo.CompleteDataExport(export.ID, objectName, size, expiresAt); err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{"Link": exportDownloadURL(export.ID, expiresAt), "Expires": expiresAt}
+	return s.outbox.Enqueue(user.Email, user.Locale, mailer.TemplateDataExport, data)
+}
+
+func (s *Service) buildExportArchive(userID int) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	for _, file := range exportFiles {
+		columns, rows, err := s.repo.ExportRows(file.query, userID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.name, err)
+		}
+
+		w, err := zw.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasSuffix(file.name, ".csv") {
+			cw := csv.NewWriter(w)
+			cw.Write(columns)
+			for _, row := range rows {
+				record := make([]string, len(row))
+				for i, v := range row {
+					if v != nil {
+						record[i] = fmt.Sprint(v)
+					}
+				}
+				cw.Write(record)
+			}
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		objects := make([]map[string]interface{}, 0, len(rows))
+		for _, row := range rows {
+			obj := make(map[string]interface{}, len(columns))
+			for i, col := range columns {
+				obj[col] = row[i]
+			}
+			objects = append(objects, obj)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(objects); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// ScheduleAccountDeletion откладывает удаление на accountDeletionGrace; до этого его можно отменить
+func (s *Service) ScheduleAccountDeletion(userID int, password, stepUpToken string) (time.Time, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return time.Time{}, errors.New("неверный пароль")
+	}
+	if err := s.RequireStepUp(userID, stepUpToken); err != nil {
+		return time.Time{}, err
+	}
+
+	at := time.Now().Add(accountDeletionGrace)
+	if err := s.repo.ScheduleAccountDeletion(userID, at); err != nil {
+		return time.Time{}, err
+	}
+
+	if err := s.outbox.Enqueue(user.Email, user.Locale, mailer.TemplateAccountDelete, map[string]interface{}{"Date": at}); err != nil {
+		log.Printf("не удалось поставить в очередь письмо об удалении аккаунта: %v", err)
+	}
+	return at, nil
+}
+
+func (s *Service) CancelAccountDeletion(userID int) (bool, error) {
+	return s.repo.CancelAccountDeletion(userID)
+}
+
+// purgeAccount сначала удаляет файлы из хранилища, затем записи в БД:
+// при ошибке хранилища аккаунт останется в очереди и попытка повторится
+func (s *Service) purgeAccount(userID int) error {
+	media, err := s.repo.GetUserMedia(userID)
+	if err != nil {
+		return err
+	}
+	for _, id := range media.TrackIDs {
+		if err := s.storage.DeleteTrackMedia(id); err != nil {
+			return fmt.Errorf("трек %d: %w", id, err)
+		}
+	}
+	for _, id := range media.PlaylistIDs {
+		if err := s.storage.DeletePlaylistCover(id); err != nil {
+			return fmt.Errorf("плейлист %d: %w", id, err)
+		}
+	}
+	for _, id := range media.AlbumIDs {
+		if err := s.storage.DeleteAlbumCover(id); err != nil {
+			return fmt.Errorf("альбом %d: %w", id, err)
+		}
+	}
+	if err := s.storage.DeleteBanner(userID); err != nil {
+		return fmt.Errorf("баннер: %w", err)
+	}
+	for _, name := range media.Exports {
+		if err := s.storage.DeleteExport(name); err != nil {
+			return fmt.Errorf("выгрузка %s: %w", name, err)
+		}
+	}
+	return s.repo.DeleteUserAccount(userID)
+}
+
+// RunPrivacyJobs собирает выгрузки, удаляет устаревшие архивы и аккаунты, срок удаления которых наступил
+func (s *Service) RunPrivacyJobs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.runDataExports()
+		s.removeExpiredExports()
+		s.purgeDueAccounts()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) runDataExports() {
+	for {
+		export, err := s.repo.ClaimDataExport()
+		if err != nil {
+			log.Printf("ошибка получения задачи выгрузки: %v", err)
+			return
+		}
+		if export == nil {
+			return
+		}
+		if err := s.processDataExport(export); err != nil {
+			log.Printf("ошибка выгрузки данных %d: %v", export.ID, err)
+			if err := s.repo.FailDataExport(export.ID, err.Error()); err != nil {
+				log.Printf("не удалось отметить выгрузку %d как неудачную: %v", export.ID, err)
+			}
+		}
+	}
+}
+
+func (s *Service) removeExpiredExports() {
+	exports, err := s.repo.GetExpiredDataExports()
+	if err != nil {
+		log.Printf("ошибка получения устаревших выгрузок: %v", err)
+		return
+	}
+	for _, e := range exports {
+		if err := s.storage.DeleteExport(e.ObjectName); err != nil {
+			log.Printf("не удалось удалить архив %s: %v", e.ObjectName, err)
+			continue
+		}
+		if err := s.repo.MarkDataExportExpired(e.ID); err != nil {
+			log.Printf("не удалось отметить выгрузку %d: %v", e.ID, err)
+		}
+	}
+}
+
+func (s *Service) purgeDueAccounts() {
+	ids, err := s.repo.GetUsersDueForDeletion()
+	if err != nil {
+		log.Printf("ошибка получения аккаунтов на удаление: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if err := s.purgeAccount(id); err != nil {
+			log.Printf("ошибка удаления аккаунта %d: %v", id, err)
+			continue
+		}
+		log.Printf("аккаунт %d удален", id)
+	}
+}
+
+func (s *Service) GetPrivacySettings(userID int) (privacy.Settings, error) {
+	return s.repo.GetPrivacySettings(userID)
+}
+
+// UpdatePrivacySettings при открытии профиля принимает все ожидающие заявки
+func (s *Service) UpdatePrivacySettings(userID int, settings privacy.Settings) error {
+	if err := s.repo.UpdatePrivacySettings(userID, settings); err != nil {
+		return err
+	}
+	if !settings.PrivateProfile {
+		if _, err := s.repo.AcceptFollowRequests(userID, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) HasFollowRequest(requesterID, targetID int) (bool, error) {
+	return s.repo.HasFollowRequest(requesterID, targetID)
+}
+
+func (s *Service) GetFollowRequests(userID int) ([]FollowRequest, error) {
+	return s.repo.GetFollowRequests(userID)
+}
+
+func (s *Service) AcceptFollowRequest(userID, requesterID int) (bool, error) {
+	accepted, err := s.repo.AcceptFollowRequests(userID, requesterID)
+	return accepted > 0, err
+}
+
+func (s *Service) DeclineFollowRequest(userID, requesterID int) (bool, error) {
+	return s.repo.DeleteFollowRequest(requesterID, userID)
+}
+
+func (s *Service) BlockUser(userID, targetID int) error {
+	if userID == targetID {
+		return errors.New("нельзя заблокировать самого себя")
+	}
+	return s.repo.BlockUser(userID, targetID)
+}
+
+func (s *Service) UnblockUser(userID, targetID int) error {
+	return s.repo.UnblockUser(userID, targetID)
+}
+
+func (s *Service) GetBlockedUsers(userID int) ([]RelatedUser, error) {
+	return s.repo.GetBlockedUsers(userID)
+}
+
+func (s *Service) MuteUser(userID, targetID int) error {
+	if userID == targetID {
+		return errors.New("нельзя скрыть самого себя")
+	}
+	return s.repo.MuteUser(userID, targetID)
+}
+
+func (s *Service) UnmuteUser(userID, targetID int) error {
+	return s.repo.UnmuteUser(userID, targetID)
+}
+
+func (s *Service) GetMutedUsers(userID int) ([]RelatedUser, error) {
+	return s.repo.GetMutedUsers(userID)
+}
+
+const notificationsPageSize = 20
+
+func (s *Service) GetNotifications(userID, page int, unreadOnly bool) (*NotificationPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	// берем на одну запись больше, чтобы понять, есть ли следующая страница
+	items, err := s.repo.GetNotifications(userID, notificationsPageSize+1, (page-1)*notificationsPageSize, unreadOnly)
+	if err != nil {
+		return nil, err
+	}
+	hasMore := len(items) > notificationsPageSize
+	if hasMore {
+		items = items[:notificationsPageSize]
+	}
+	for i := range items {
+		items[i].Message = notificationMessage(&items[i])
+	}
+
+	unread, err := s.repo.CountUnreadNotifications(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &NotificationPage{Items: items, UnreadCount: unread, Page: page, HasMore: hasMore}, nil
+}
+
+func (s *Service) CountUnreadNotifications(userID int) (int, error) {
+	return s.repo.CountUnreadNotifications(userID)
+}
+
+// Счетчик рассылается, чтобы он обновился и в других открытых вкладках
+func (s *Service) MarkNotificationRead(notificationID, userID int) (bool, error) {
+	found, err := s.repo.MarkNotificationRead(notificationID, userID)
+	if err == nil && found {
+		s.notifier.SyncUnreadCount(userID)
+	}
+	return found, err
+}
+
+func (s *Service) MarkAllNotificationsRead(userID int) error {
+	if err := s.repo.MarkAllNotificationsRead(userID); err != nil {
+		return err
+	}
+	s.notifier.SyncUnreadCount(userID)
+	return nil
+}
+
+// CanWatchTrack проверяет, что события трека можно показывать пользователю
+func (s *Service) CanWatchTrack(trackID, userID int, isAdmin bool) (bool, error) {
+	return s.repo.CanWatchTrack(trackID, userID, isAdmin)
+}
+
+// Subscribe подписывает на личные события пользователя и, если trackID != 0, на события открытого трека
+func (s *Service) Subscribe(userID, trackID int) *realtime.Subscription {
+	topics := []string{realtime.UserTopic(userID)}
+	if trackID != 0 {
+		topics = append(topics, realtime.TrackTopic(trackID))
+	}
+	return s.hub.Subscribe(topics...)
+}
+
+func (s *Service) Unsubscribe(sub *realtime.Subscription) {
+	s.hub.Unsubscribe(sub)
+}
+
+// notificationActors: "A", "A и B", "A, B и ещё N"
+func notificationActors(n *Notification) string {
+	names := make([]string, 0, len(n.Actors))
+	for _, a := range n.Actors {
+		names = append(names, a.Username)
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	if n.ActorCount <= len(names) && len(names) <= 2 {
+		return strings.Join(names, " и ")
+	}
+	if len(names) > 2 {
+		names = names[:2]
+	}
+	return fmt.Sprintf("%s и ещё %d", strings.Join(names, ", "), n.ActorCount-len(names))
+}
+
+func notificationMessage(n *Notification) string {
+	var data struct {
+		Title  string `json:"title"`
+		Text   string `json:"text"`
+		Moment *int   `json:"moment"`
+		// Альбом из пре-сейва уже добавлен в библиотеку
+		Presaved bool `json:"presaved"`
+	}
+	_ = json.Unmarshal(n.Data, &data)
+
+	actors := notificationActors(n)
+	switch n.Type {
+	case notify.TypeLike:
+		return fmt.Sprintf("%s: нравится ваш трек «%s»", actors, data.Title)
+	case notify.TypeRepost:
+		return fmt.Sprintf("%s: репост вашего трека «%s»", actors, data.Title)
+	case notify.TypeFollow:
+		return fmt.Sprintf("%s: подписка на вас", actors)
+	case notify.TypeFollowRequest:
+		return fmt.Sprintf("%s: запрос на подписку", actors)
+	case notify.TypeComment:
+		if data.Moment != nil {
+			return fmt.Sprintf("%s прокомментировал(а) «%s» на %d:%02d: %s", actors, data.Title, *data.Moment/60, *data.Moment%60, data.Text)
+		}
+		return fmt.Sprintf("%s прокомментировал(а) «%s»: %s", actors, data.Title, data.Text)
+	case notify.TypeAlbumRelease:
+		if data.Presaved {
+			return fmt.Sprintf("%s: вышел альбом «%s», он уже в вашей библиотеке", actors, data.Title)
+		}
+		return fmt.Sprintf("%s: новый альбом «%s»", actors, data.Title)
+	case notify.TypeAlbumAnnounced:
+		return fmt.Sprintf("%s: анонс альбома «%s»", actors, data.Title)
+	}
+	return actors
+}
@@ -0,0 +1,23 @@
+-- Вход через внешних OpenID Connect провайдеров
+
+-- Привязанные внешние аккаунты (provider + sub однозначно определяют пользователя)
+CREATE TABLE IF NOT EXISTS user_identities (
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider   TEXT NOT NULL,
+    subject    TEXT NOT NULL,
+    email      TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+-- Незавершенные авторизации: state, nonce и PKCE code_verifier живут 10 минут
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state         TEXT PRIMARY KEY,
+    provider      TEXT NOT NULL,
+    nonce         TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    link_user_id  INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    created_at    TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,19 @@
+-- Защита входа от перебора паролей
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS failed_login_count INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
+
+-- Журнал событий безопасности: входы, неудачные попытки, блокировки
+CREATE TABLE IF NOT EXISTS security_events (
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    email      TEXT,
+    ip         TEXT,
+    user_agent TEXT,
+    event_type TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_user ON security_events(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_security_events_ip ON security_events(ip, event_type, created_at DESC);
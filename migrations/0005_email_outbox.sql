@@ -0,0 +1,22 @@
+-- Язык писем пользователя
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS locale TEXT NOT NULL DEFAULT 'ru';
+
+-- Очередь исходящих писем: запрос только добавляет запись, отправляет воркер
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id              SERIAL PRIMARY KEY,
+    recipient       TEXT NOT NULL,
+    template        TEXT NOT NULL,
+    locale          TEXT NOT NULL,
+    subject         TEXT NOT NULL,
+    html_body       TEXT NOT NULL,
+    text_body       TEXT NOT NULL,
+    status          TEXT NOT NULL DEFAULT 'pending', -- pending, sent, failed
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    last_error      TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    created_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+    sent_at         TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS email_outbox_pending_idx ON email_outbox (next_attempt_at) WHERE status = 'pending';
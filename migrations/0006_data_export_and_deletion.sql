@@ -0,0 +1,21 @@
+-- Выгрузка персональных данных (ZIP по подписанной ссылке)
+CREATE TABLE IF NOT EXISTS data_exports (
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status       TEXT NOT NULL DEFAULT 'pending', -- pending, processing, ready, failed, expired
+    object_name  TEXT,
+    size_bytes   BIGINT,
+    error        TEXT,
+    created_at   TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP,
+    expires_at   TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_user_idx ON data_exports (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS data_exports_pending_idx ON data_exports (created_at) WHERE status = 'pending';
+
+-- Удаление аккаунта с отложенным сроком: до этого момента пользователь может отменить удаление
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS users_deletion_scheduled_idx ON users (deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
@@ -0,0 +1,17 @@
+-- Блокировка: заблокированный не может подписаться, комментировать треки и найти блокирующего
+CREATE TABLE IF NOT EXISTS user_blocks (
+    blocker_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id)
+);
+
+CREATE INDEX IF NOT EXISTS user_blocks_blocked_idx ON user_blocks (blocked_id);
+
+-- Скрытие: репосты и загрузки пользователя не попадают в ленту
+CREATE TABLE IF NOT EXISTS user_mutes (
+    muter_id   INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    muted_id   INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (muter_id, muted_id)
+);
@@ -0,0 +1,28 @@
+-- Профиль артиста
+CREATE TABLE IF NOT EXISTS artist_profiles (
+    user_id     INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    bio         TEXT NOT NULL DEFAULT '',
+    location    TEXT NOT NULL DEFAULT '',
+    links       JSONB NOT NULL DEFAULT '[]',
+    genres      TEXT[] NOT NULL DEFAULT '{}',
+    has_banner  BOOLEAN NOT NULL DEFAULT FALSE,
+    is_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    verified_at TIMESTAMP,
+    updated_at  TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Заявки на верификацию, рассматриваются администратором
+CREATE TABLE IF NOT EXISTS artist_verification_requests (
+    id          SERIAL PRIMARY KEY,
+    user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message     TEXT NOT NULL DEFAULT '',
+    links       JSONB NOT NULL DEFAULT '[]',
+    status      TEXT NOT NULL DEFAULT 'pending', -- pending, approved, rejected
+    reviewer_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    review_note TEXT,
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMP
+);
+
+-- Не больше одной заявки на рассмотрении
+CREATE UNIQUE INDEX IF NOT EXISTS artist_verification_pending_idx ON artist_verification_requests (user_id) WHERE status = 'pending';
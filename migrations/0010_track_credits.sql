@@ -0,0 +1,16 @@
+-- Участники трека помимо автора (tracks.author_id): соавторы, фиты, продюсеры и т.д.
+-- Участник либо ссылается на пользователя, либо задан просто именем
+CREATE TABLE IF NOT EXISTS track_credits (
+    id         SERIAL PRIMARY KEY,
+    track_id   INTEGER NOT NULL REFERENCES tracks(id) ON DELETE CASCADE,
+    user_id    INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    name       TEXT NOT NULL DEFAULT '',
+    role       TEXT NOT NULL, -- primary, featured, producer, composer, remixer
+    position   INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (role IN ('primary', 'featured', 'producer', 'composer', 'remixer')),
+    CHECK (user_id IS NOT NULL OR name <> '')
+);
+
+CREATE INDEX IF NOT EXISTS track_credits_track_idx ON track_credits (track_id, position);
+CREATE INDEX IF NOT EXISTS track_credits_user_idx ON track_credits (user_id) WHERE user_id IS NOT NULL;
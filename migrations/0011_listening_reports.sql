@@ -0,0 +1,12 @@
+-- Итоги прослушиваний за год и за месяц, считаются фоновой задачей music-service
+CREATE TABLE IF NOT EXISTS listening_reports (
+    user_id      INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    period       TEXT NOT NULL, -- year, month
+    period_start DATE NOT NULL,
+    data         JSONB NOT NULL,
+    generated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, period, period_start),
+    CHECK (period IN ('year', 'month'))
+);
+
+CREATE INDEX IF NOT EXISTS track_listens_listener_created_idx ON track_listens (listener_id, created_at);
@@ -0,0 +1,27 @@
+-- Подключение к ListenBrainz-совместимому сервису скробблинга
+CREATE TABLE IF NOT EXISTS scrobble_connections (
+    user_id           INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    service           TEXT NOT NULL DEFAULT 'listenbrainz',
+    token             TEXT NOT NULL,
+    external_username TEXT NOT NULL DEFAULT '',
+    enabled           BOOLEAN NOT NULL DEFAULT TRUE,
+    last_error        TEXT,
+    last_submitted_at TIMESTAMP,
+    created_at        TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Очередь отправки; payload - прослушивание в формате ListenBrainz на момент постановки
+CREATE TABLE IF NOT EXISTS scrobble_queue (
+    id              SERIAL PRIMARY KEY,
+    user_id         INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    listen_id       INTEGER NOT NULL UNIQUE REFERENCES track_listens(id) ON DELETE CASCADE,
+    payload         JSONB NOT NULL,
+    status          TEXT NOT NULL DEFAULT 'pending', -- pending, sent, failed
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_error      TEXT,
+    created_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+    sent_at         TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS scrobble_queue_pending_idx ON scrobble_queue (next_attempt_at) WHERE status = 'pending';
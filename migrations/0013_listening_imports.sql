@@ -0,0 +1,26 @@
+-- Импорт истории прослушиваний из Spotify, Last.fm и ListenBrainz
+CREATE TABLE IF NOT EXISTS listening_imports (
+    id          SERIAL PRIMARY KEY,
+    user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    source      TEXT NOT NULL, -- spotify, lastfm, listenbrainz
+    file_name   TEXT NOT NULL DEFAULT '',
+    status      TEXT NOT NULL DEFAULT 'processing', -- processing, done, failed
+    total       INTEGER NOT NULL DEFAULT 0,
+    matched     INTEGER NOT NULL DEFAULT 0,
+    imported    INTEGER NOT NULL DEFAULT 0,
+    skipped     INTEGER NOT NULL DEFAULT 0,
+    unmatched   INTEGER NOT NULL DEFAULT 0,
+    unmatched_sample JSONB NOT NULL DEFAULT '[]',
+    error       TEXT,
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP
+);
+
+-- Импортированные прослушивания хранятся вместе с обычными, но помечены и не попадают в статистику артистов
+ALTER TABLE track_listens
+    ADD COLUMN IF NOT EXISTS is_imported BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS import_id INTEGER REFERENCES listening_imports(id) ON DELETE CASCADE;
+
+-- Повторный импорт того же файла не дублирует прослушивания
+CREATE UNIQUE INDEX IF NOT EXISTS track_listens_imported_uniq
+    ON track_listens (listener_id, track_id, created_at) WHERE is_imported;
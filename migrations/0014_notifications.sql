@@ -0,0 +1,25 @@
+-- Уведомления. Однотипные события об одном объекте (лайки трека, подписки) копятся
+-- в одном непрочитанном уведомлении с ключом group_key
+CREATE TABLE IF NOT EXISTS notifications (
+    id           SERIAL PRIMARY KEY,
+    recipient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type         TEXT NOT NULL, -- follow, follow_request, like, repost, comment, album_release, album_announced
+    target_type  TEXT NOT NULL DEFAULT '', -- track, album, user
+    target_id    INTEGER NOT NULL DEFAULT 0,
+    group_key    TEXT NOT NULL,
+    data         JSONB NOT NULL DEFAULT '{}',
+    is_read      BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at   TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS notifications_unread_group_idx ON notifications (recipient_id, group_key) WHERE NOT is_read;
+CREATE INDEX IF NOT EXISTS notifications_inbox_idx ON notifications (recipient_id, updated_at DESC);
+
+-- Кто вызвал уведомление; для сгруппированных - все участники
+CREATE TABLE IF NOT EXISTS notification_actors (
+    notification_id INTEGER NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+    actor_id        INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (notification_id, actor_id)
+);
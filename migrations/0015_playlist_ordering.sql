@@ -0,0 +1,23 @@
+-- Порядок треков в плейлисте. Из-за старой ошибки в AddSongToPlaylist почти у всех треков position = 1,
+-- поэтому сначала пересчитываем позиции в порядке добавления
+UPDATE tracks_playlists tp
+SET position = n.rn
+FROM (
+    SELECT playlist_id, track_id,
+           ROW_NUMBER() OVER (PARTITION BY playlist_id ORDER BY position, ctid) AS rn
+    FROM tracks_playlists
+) n
+WHERE tp.playlist_id = n.playlist_id AND tp.track_id = n.track_id;
+
+-- Позиции плотные (1..N). Ограничение отложенное: сдвиг соседей при вставке и перемещении
+-- временно дает дубли внутри транзакции
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'tracks_playlists_position_key') THEN
+        ALTER TABLE tracks_playlists
+            ADD CONSTRAINT tracks_playlists_position_key UNIQUE (playlist_id, position) DEFERRABLE INITIALLY DEFERRED;
+    END IF;
+END $$;
+
+-- Ревизия растет при каждом изменении состава или порядка; клиент передает ее для оптимистичной блокировки
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS revision INTEGER NOT NULL DEFAULT 0;
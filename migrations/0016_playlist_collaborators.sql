@@ -0,0 +1,33 @@
+-- Совместные плейлисты. Автор (playlists.author_id) всегда владелец и в таблицу не попадает;
+-- owner может управлять участниками и настройками, editor - составом и порядком, viewer - только смотреть
+CREATE TABLE IF NOT EXISTS playlist_members (
+    playlist_id INTEGER NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+    user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role        TEXT NOT NULL,
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (playlist_id, user_id),
+    CHECK (role IN ('owner', 'editor', 'viewer'))
+);
+
+CREATE INDEX IF NOT EXISTS playlist_members_user_idx ON playlist_members (user_id);
+
+-- Ссылки-приглашения; хранится только sha256 токена
+CREATE TABLE IF NOT EXISTS playlist_invites (
+    id          SERIAL PRIMARY KEY,
+    playlist_id INTEGER NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+    token_hash  TEXT NOT NULL UNIQUE,
+    role        TEXT NOT NULL,
+    created_by  INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    max_uses    INTEGER, -- NULL - без ограничения
+    uses        INTEGER NOT NULL DEFAULT 0,
+    expires_at  TIMESTAMP,
+    revoked_at  TIMESTAMP,
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (role IN ('editor', 'viewer'))
+);
+
+CREATE INDEX IF NOT EXISTS playlist_invites_playlist_idx ON playlist_invites (playlist_id);
+
+-- Кто и когда добавил трек в плейлист
+ALTER TABLE tracks_playlists ADD COLUMN IF NOT EXISTS added_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE tracks_playlists ADD COLUMN IF NOT EXISTS added_at TIMESTAMP NOT NULL DEFAULT NOW();
@@ -0,0 +1,21 @@
+-- Видимость плейлиста: public - везде, unlisted - только по прямой ссылке (нет в поиске, ленте и профиле),
+-- private - только участникам. Настройки приватности автора продолжают действовать поверх
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'public';
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'playlists_visibility_check') THEN
+        ALTER TABLE playlists
+            ADD CONSTRAINT playlists_visibility_check CHECK (visibility IN ('public', 'unlisted', 'private'));
+    END IF;
+END $$;
+
+-- Подписки на чужие плейлисты (сохранение в библиотеку)
+CREATE TABLE IF NOT EXISTS playlist_follows (
+    playlist_id INTEGER NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+    user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (playlist_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS playlist_follows_user_idx ON playlist_follows (user_id, created_at DESC);
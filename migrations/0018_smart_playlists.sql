@@ -0,0 +1,16 @@
+-- Умные плейлисты: состав вычисляется по дереву правил (см. pkg/smartplaylist) и сохраняется в tracks_playlists.
+-- on_read - пересчет при открытии (не чаще раза в минуту), scheduled - фоновой задачей
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS smart_rules JSONB;
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS smart_refresh TEXT NOT NULL DEFAULT 'on_read';
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS smart_refreshed_at TIMESTAMP;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'playlists_smart_refresh_check') THEN
+        ALTER TABLE playlists
+            ADD CONSTRAINT playlists_smart_refresh_check CHECK (smart_refresh IN ('on_read', 'scheduled'));
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS playlists_smart_scheduled_idx ON playlists (smart_refreshed_at)
+    WHERE smart_rules IS NOT NULL AND smart_refresh = 'scheduled';
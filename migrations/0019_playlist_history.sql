@@ -0,0 +1,33 @@
+-- История плейлиста: по строке на каждую ревизию. Кроме самого изменения хранится снимок состава,
+-- поэтому сравнение и откат к любой ревизии не требуют проигрывать журнал
+CREATE TABLE IF NOT EXISTS playlist_revisions (
+    playlist_id INTEGER NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+    revision INTEGER NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL, -- NULL - пересчет умного плейлиста
+    action TEXT NOT NULL,
+    details JSONB NOT NULL DEFAULT '{}',
+    title TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    track_ids INTEGER[] NOT NULL DEFAULT '{}', -- без внешнего ключа: удаленный трек остается в истории
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (playlist_id, revision)
+);
+
+-- Журнал только дополняется
+CREATE OR REPLACE FUNCTION playlist_revisions_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'playlist_revisions: изменение истории запрещено';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS playlist_revisions_no_update ON playlist_revisions;
+CREATE TRIGGER playlist_revisions_no_update BEFORE UPDATE ON playlist_revisions
+    FOR EACH ROW EXECUTE FUNCTION playlist_revisions_append_only();
+
+-- Текущее состояние существующих плейлистов - точка отсчета истории
+INSERT INTO playlist_revisions (playlist_id, revision, user_id, action, title, description, track_ids, created_at)
+SELECT p.id, p.revision, NULL, 'baseline', p.title, COALESCE(p.description, ''),
+       COALESCE((SELECT array_agg(tp.track_id ORDER BY tp.position) FROM tracks_playlists tp WHERE tp.playlist_id = p.id), '{}'),
+       COALESCE(p.updated_at, p.created_at, NOW())
+FROM playlists p
+ON CONFLICT (playlist_id, revision) DO NOTHING;
@@ -0,0 +1,9 @@
+-- Обложки плейлистов. Без своей обложки сервис собирает мозаику 2×2 из обложек первых треков;
+-- cover_tracks - из каких треков собрана текущая мозаика, чтобы не пересобирать ее без нужды
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS cover_custom BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS cover_tracks INTEGER[] NOT NULL DEFAULT '{}';
+-- NULL - обложка еще не проверялась, такие плейлисты обрабатывает фоновая задача
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS cover_updated_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS playlists_cover_pending_idx ON playlists (id)
+    WHERE cover_updated_at IS NULL AND NOT cover_custom;
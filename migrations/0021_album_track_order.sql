@@ -0,0 +1,30 @@
+-- Порядок треков в альбоме: номер диска и номер трека на диске (с 1).
+-- Существующим альбомам номера раздаются в порядке добавления треков
+ALTER TABLE tracks_albums ADD COLUMN IF NOT EXISTS disc_number INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE tracks_albums ADD COLUMN IF NOT EXISTS track_number INTEGER;
+
+UPDATE tracks_albums ta
+SET track_number = n.rn
+FROM (
+    SELECT album_id, track_id, ROW_NUMBER() OVER (PARTITION BY album_id ORDER BY ctid) AS rn
+    FROM tracks_albums
+) n
+WHERE ta.album_id = n.album_id AND ta.track_id = n.track_id AND ta.track_number IS NULL;
+
+ALTER TABLE tracks_albums ALTER COLUMN track_number SET NOT NULL;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'tracks_albums_numbers_check') THEN
+        ALTER TABLE tracks_albums
+            ADD CONSTRAINT tracks_albums_numbers_check CHECK (disc_number >= 1 AND track_number >= 1);
+    END IF;
+    -- Отложенное, как и у плейлистов: перенумерация временно дает дубли внутри транзакции
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'tracks_albums_number_key') THEN
+        ALTER TABLE tracks_albums
+            ADD CONSTRAINT tracks_albums_number_key UNIQUE (album_id, disc_number, track_number) DEFERRABLE INITIALLY DEFERRED;
+    END IF;
+END $$;
+
+-- Обложка альбома лежит в бакете альбомов; время загрузки сбрасывает кэш картинки
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS cover_updated_at TIMESTAMPTZ;
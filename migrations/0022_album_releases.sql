@@ -0,0 +1,29 @@
+-- Публикация альбомов по расписанию: published_at ставит фоновая задача, когда наступает release_date.
+-- Альбом виден слушателям и попадает в ленту только после публикации
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS published_at TIMESTAMP;
+
+-- Уже вышедшие альбомы считаются опубликованными в дату выхода, уведомления по ним не рассылаются
+UPDATE albums SET published_at = release_date WHERE published_at IS NULL AND release_date <= NOW();
+
+CREATE INDEX IF NOT EXISTS albums_unpublished_idx ON albums (release_date) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS albums_published_idx ON albums (author_id, published_at DESC) WHERE published_at IS NOT NULL;
+
+-- Альбомы в библиотеке пользователя
+CREATE TABLE IF NOT EXISTS album_saves (
+    album_id   INTEGER NOT NULL REFERENCES albums(id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (album_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS album_saves_user_idx ON album_saves (user_id, created_at DESC);
+
+-- Пре-сейвы анонсированных альбомов: при публикации переносятся в album_saves
+CREATE TABLE IF NOT EXISTS album_presaves (
+    album_id   INTEGER NOT NULL REFERENCES albums(id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (album_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS album_presaves_user_idx ON album_presaves (user_id);
@@ -0,0 +1,25 @@
+-- Метаданные релиза для дистрибуции: тип, лейбл, UPC/EAN, строки ℗ и ©, пометка explicit и язык.
+-- Коды хранятся без дефисов, проверка контрольных цифр выполняется в сервисе
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS release_type TEXT NOT NULL DEFAULT 'album';
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS label TEXT NOT NULL DEFAULT '';
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS upc TEXT;
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS p_line TEXT NOT NULL DEFAULT '';
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS c_line TEXT NOT NULL DEFAULT '';
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS explicit BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE tracks ADD COLUMN IF NOT EXISTS isrc TEXT;
+ALTER TABLE tracks ADD COLUMN IF NOT EXISTS explicit BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE tracks ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT '';
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'albums_release_type_check') THEN
+        ALTER TABLE albums
+            ADD CONSTRAINT albums_release_type_check CHECK (release_type IN ('single', 'ep', 'album', 'compilation'));
+    END IF;
+END $$;
+
+-- Один код - один релиз и одна запись
+CREATE UNIQUE INDEX IF NOT EXISTS albums_upc_key ON albums (upc) WHERE upc IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS tracks_isrc_key ON tracks (isrc) WHERE isrc IS NOT NULL;
@@ -0,0 +1,3 @@
+-- Защита от повторного использования TOTP: последний принятый шаг (unix/30).
+-- Коды этого и более ранних шагов больше не принимаются
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
@@ -0,0 +1,5 @@
+-- Чтение альбомов вынесено в отдельную область albums:read.
+-- Уже выданные токены с albums:write сохраняют доступ к чтению
+UPDATE personal_access_tokens
+SET scopes = array_append(scopes, 'albums:read')
+WHERE 'albums:write' = ANY(scopes) AND NOT 'albums:read' = ANY(scopes);
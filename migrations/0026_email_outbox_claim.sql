@@ -0,0 +1,3 @@
+-- Воркер сначала забирает письма (status = 'sending') и только потом отправляет их вне транзакции.
+-- claimed_at позволяет вернуть в очередь письма, забранные упавшим воркером
+ALTER TABLE email_outbox ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMP;
@@ -0,0 +1,6 @@
+-- Участники-пользователи хранились с пустым name, и удаление аккаунта (ON DELETE SET NULL)
+-- нарушало CHECK (user_id IS NOT NULL OR name <> ''). Сохраняем снимок имени пользователя
+UPDATE track_credits tc
+SET name = u.username
+FROM users u
+WHERE u.id = tc.user_id AND tc.name = '';
@@ -0,0 +1,8 @@
+-- Импорт истории и плейлистов отбирает каталог по ключу артиста (первые буквы имени
+-- без регистра и знаков) вместо загрузки всех треков. Выражение совпадает с artistKeySQL
+CREATE INDEX IF NOT EXISTS users_artist_key_idx
+    ON users ((LEFT(REGEXP_REPLACE(LOWER(TRANSLATE(username, 'Ёё', 'ее')), '[^[:alnum:]]', '', 'g'), 2)));
+
+CREATE INDEX IF NOT EXISTS track_credits_artist_key_idx
+    ON track_credits ((LEFT(REGEXP_REPLACE(LOWER(TRANSLATE(name, 'Ёё', 'ее')), '[^[:alnum:]]', '', 'g'), 2)))
+    WHERE role IN ('primary', 'featured', 'remixer');
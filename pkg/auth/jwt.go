@@ -3,6 +3,7 @@ package auth
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -15,6 +16,10 @@ type Claims struct {
 	UserID int    `json:"user_id"`
 	Role   string `json:"role"`
 	jwt.RegisteredClaims
+
+	// Заполняются только для персональных токенов доступа
+	Scopes  []string `json:"-"`
+	TokenID int      `json:"-"`
 }
 
 type ResetClaims struct {
@@ -37,6 +42,10 @@ func GenerateJWT(userID int, role string) (string, error) {
 }
 
 func ParseJWT(tokenString string) (*Claims, error) {
+	if strings.HasPrefix(tokenString, PersonalTokenPrefix) {
+		return parsePersonalToken(tokenString)
+	}
+
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		return jwtSecret, nil
 	})
@@ -85,3 +94,60 @@ func ParseResetToken(tokenString string) (*ResetClaims, error) {
 
 	return claims, nil
 }
+
+var jwtSecretMFA = []byte("your_secret_key_mfa")
+
+// Назначение промежуточных токенов двухфакторной аутентификации
+const (
+	PurposeMFALogin       = "mfa_login"
+	PurposeStepUp         = "step_up"
+	PurposeTwoFactorSetup = "2fa_setup"
+)
+
+type MFAClaims struct {
+	UserID  int    `json:"user_id"`
+	Role    string `json:"role"`
+	Purpose string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+// GenerateMFAToken создает короткоживущий токен: после пароля (mfa_login, 5 минут),
+// после повторной проверки кода перед опасной операцией (step_up, 10 минут)
+// или для подключения обязательной 2FA вместо сессии (2fa_setup, 15 минут)
+func GenerateMFAToken(userID int, role, purpose string) (string, error) {
+	ttl := 5 * time.Minute
+	switch purpose {
+	case PurposeStepUp:
+		ttl = 10 * time.Minute
+	case PurposeTwoFactorSetup:
+		ttl = 15 * time.Minute
+	}
+	claims := &MFAClaims{
+		UserID:  userID,
+		Role:    role,
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecretMFA)
+}
+
+// ParseMFAToken проверяет токен и его назначение
+func ParseMFAToken(tokenString, purpose string) (*MFAClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &MFAClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecretMFA, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*MFAClaims)
+	if !ok || !token.Valid || claims.Purpose != purpose {
+		return nil, errors.New("невалидный токен")
+	}
+
+	return claims, nil
+}
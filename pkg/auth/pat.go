@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// PersonalTokenPrefix отличает персональные токены доступа от JWT
+const PersonalTokenPrefix = "bst_"
+
+// Области доступа персональных токенов
+var PersonalTokenScopes = []string{
+	"tracks:write",
+	"stats:read",
+	"playlists:read",
+	"playlists:write",
+	"albums:read",
+	"albums:write",
+	"social:write",
+}
+
+var ErrTokenRevoked = errors.New("токен отозван или истек")
+
+// PersonalToken - запись о токене, как она хранится в БД
+type PersonalToken struct {
+	ID        int
+	UserID    int
+	Role      string
+	Scopes    []string
+	ExpiresAt *time.Time
+}
+
+// PersonalTokenStore ищет токен по sha256 и отмечает его использование
+type PersonalTokenStore interface {
+	LookupPersonalToken(tokenHash string) (*PersonalToken, error)
+	TouchPersonalToken(id int) error
+}
+
+var personalTokenStore PersonalTokenStore
+
+// SetPersonalTokenStore включает прием персональных токенов в ParseJWT
+func SetPersonalTokenStore(store PersonalTokenStore) {
+	personalTokenStore = store
+}
+
+// GeneratePersonalToken возвращает токен (показывается один раз) и его хеш для БД
+func GeneratePersonalToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := PersonalTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashPersonalToken(token), nil
+}
+
+func HashPersonalToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsValidScope(scope string) bool {
+	for _, s := range PersonalTokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope: у JWT сессии Scopes == nil и доступ полный
+func (c *Claims) HasScope(scope string) bool {
+	if !c.IsPersonalToken() {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Claims) IsPersonalToken() bool {
+	return c.TokenID != 0
+}
+
+func parsePersonalToken(tokenString string) (*Claims, error) {
+	if personalTokenStore == nil {
+		return nil, errors.New("персональные токены не поддерживаются")
+	}
+
+	token, err := personalTokenStore.LookupPersonalToken(HashPersonalToken(tokenString))
+	if err != nil {
+		return nil, err
+	}
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
+		return nil, ErrTokenRevoked
+	}
+
+	if err := personalTokenStore.TouchPersonalToken(token.ID); err != nil {
+		return nil, err
+	}
+
+	return &Claims{
+		UserID:  token.UserID,
+		Role:    token.Role,
+		Scopes:  token.Scopes,
+		TokenID: token.ID,
+	}, nil
+}
+
+// RequirePersonalTokenScopes ограничивает персональные токены маршрутами из routes
+// ("METHOD /path" -> scope). Маршруты вне списка для таких токенов закрыты.
+func RequirePersonalTokenScopes(routes map[string]string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			tokenString := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+			if !strings.HasPrefix(tokenString, PersonalTokenPrefix) {
+				return next(c)
+			}
+
+			claims, err := ParseJWT(tokenString)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Неверный токен"})
+			}
+
+			scope, ok := routes[c.Request().Method+" "+c.Path()]
+			if !ok || !claims.HasScope(scope) {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Токен не дает доступа к этой операции"})
+			}
+			c.Set(personalTokenContextKey, &checkedPersonalToken{token: tokenString, claims: claims})
+			return next(c)
+		}
+	}
+}
+
+const personalTokenContextKey = "auth.personal_token"
+
+// checkedPersonalToken - персональный токен, уже проверенный RequirePersonalTokenScopes в этом запросе
+type checkedPersonalToken struct {
+	token  string
+	claims *Claims
+}
+
+// ParseRequestToken разбирает токен запроса так же, как ParseJWT, но персональный токен,
+// проверенный middleware, берет из контекста без повторного похода в БД
+func ParseRequestToken(c echo.Context, tokenString string) (*Claims, error) {
+	if checked, ok := c.Get(personalTokenContextKey).(*checkedPersonalToken); ok && checked.token == tokenString {
+		return checked.claims, nil
+	}
+	return ParseJWT(tokenString)
+}
+
+// SQLPersonalTokenStore хранит токены в таблице personal_access_tokens
+type SQLPersonalTokenStore struct {
+	db *sql.DB
+}
+
+func NewSQLPersonalTokenStore(db *sql.DB) *SQLPersonalTokenStore {
+	return &SQLPersonalTokenStore{db: db}
+}
+
+func (s *SQLPersonalTokenStore) LookupPersonalToken(tokenHash string) (*PersonalToken, error) {
+	query := `
+		SELECT t.id, t.user_id, u.role, t.scopes, t.expires_at
+		FROM personal_access_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND t.revoked_at IS NULL`
+
+	var token PersonalToken
+	err := s.db.QueryRow(query, tokenHash).Scan(&token.ID, &token.UserID, &token.Role, pq.Array(&token.Scopes), &token.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// TouchPersonalToken обновляет last_used_at не чаще раза в минуту
+func (s *SQLPersonalTokenStore) TouchPersonalToken(id int) error {
+	_, err := s.db.Exec(`
+		UPDATE personal_access_tokens SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`, id)
+	return err
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+type countingTokenStore struct {
+	token   PersonalToken
+	lookups int
+}
+
+func (s *countingTokenStore) LookupPersonalToken(tokenHash string) (*PersonalToken, error) {
+	s.lookups++
+	token := s.token
+	return &token, nil
+}
+
+func (s *countingTokenStore) TouchPersonalToken(id int) error {
+	return nil
+}
+
+func TestRequirePersonalTokenScopesReusesClaims(t *testing.T) {
+	store := &countingTokenStore{token: PersonalToken{ID: 7, UserID: 42, Role: "user", Scopes: []string{"albums:read"}}}
+	SetPersonalTokenStore(store)
+	defer SetPersonalTokenStore(nil)
+
+	e := echo.New()
+	e.Use(RequirePersonalTokenScopes(map[string]string{
+		"GET /albums":  "albums:read",
+		"POST /albums": "albums:write",
+	}))
+	handler := func(c echo.Context) error {
+		claims, err := ParseRequestToken(c, PersonalTokenPrefix+"secret")
+		if err != nil {
+			return c.NoContent(http.StatusUnauthorized)
+		}
+		if claims.UserID != 42 {
+			t.Fatalf("UserID = %d", claims.UserID)
+		}
+		return c.NoContent(http.StatusOK)
+	}
+	e.GET("/albums", handler)
+	e.POST("/albums", handler)
+
+	tests := []struct {
+		method  string
+		status  int
+		lookups int
+	}{
+		{method: http.MethodGet, status: http.StatusOK, lookups: 1},
+		{method: http.MethodPost, status: http.StatusForbidden, lookups: 1},
+	}
+	for _, tt := range tests {
+		store.lookups = 0
+		req := httptest.NewRequest(tt.method, "/albums", nil)
+		req.Header.Set("Authorization", "Bearer "+PersonalTokenPrefix+"secret")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("%s /albums: статус %d, ожидался %d", tt.method, rec.Code, tt.status)
+		}
+		if store.lookups != tt.lookups {
+			t.Errorf("%s /albums: токен искался в БД %d раз, ожидалось %d", tt.method, store.lookups, tt.lookups)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSealSecret(t *testing.T) {
+	sealed, err := SealSecret("listenbrainz-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "listenbrainz-token") {
+		t.Fatal("токен хранится в открытом виде")
+	}
+
+	opened, err := OpenSecret(sealed)
+	if err != nil || opened != "listenbrainz-token" {
+		t.Fatalf("OpenSecret = %q, %v", opened, err)
+	}
+
+	// Записи, сохраненные до шифрования, читаются как есть
+	if legacy, err := OpenSecret("plain-token"); err != nil || legacy != "plain-token" {
+		t.Fatalf("OpenSecret(legacy) = %q, %v", legacy, err)
+	}
+
+	// Меняем символ в середине: хвост base64 может нести незначащие биты
+	mid := len(sealed) / 2
+	replacement := "A"
+	if sealed[mid] == 'A' {
+		replacement = "B"
+	}
+	tampered := sealed[:mid] + replacement + sealed[mid+1:]
+	if _, err := OpenSecret(tampered); !errors.Is(err, ErrSecretCorrupted) {
+		t.Fatalf("измененный шифротекст: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+var downloadSecret = []byte("your_secret_key_download")
+
+// SignDownload подписывает ссылку на скачивание ресурса до момента expires
+func SignDownload(resource string, expires time.Time) string {
+	mac := hmac.New(sha256.New, downloadSecret)
+	mac.Write([]byte(resource + "|" + strconv.FormatInt(expires.Unix(), 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyDownload проверяет подпись и срок действия ссылки
+func VerifyDownload(resource string, expiresUnix int64, signature string) bool {
+	expires := time.Unix(expiresUnix, 0)
+	if time.Now().After(expires) {
+		return false
+	}
+	expected := SignDownload(resource, expires)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+var oidcStateSecret = []byte("your_secret_key_oidc_state")
+
+// SignOIDCState подписывает state входа через провайдера для cookie браузера,
+// начавшего вход: callback с чужим state (login CSRF) не пройдет проверку
+func SignOIDCState(state string) string {
+	mac := hmac.New(sha256.New, oidcStateSecret)
+	mac.Write([]byte(state))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyOIDCState(state, signature string) bool {
+	return hmac.Equal([]byte(SignOIDCState(state)), []byte(signature))
+}
@@ -0,0 +1,17 @@
+package auth
+
+import "testing"
+
+func TestVerifyOIDCState(t *testing.T) {
+	cookie := SignOIDCState("state-1")
+
+	if !VerifyOIDCState("state-1", cookie) {
+		t.Fatal("подпись своего state не прошла проверку")
+	}
+	if VerifyOIDCState("state-2", cookie) {
+		t.Fatal("cookie другого входа не должна подходить к state")
+	}
+	if VerifyOIDCState("state-1", "state-1") {
+		t.Fatal("неподписанный state не должен проходить проверку")
+	}
+}
@@ -0,0 +1,121 @@
+package covermosaic
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+
+	// Обложки треков загружаются в jpg, png или gif
+	_ "image/gif"
+	_ "image/png"
+)
+
+// Size - сторона готовой обложки в пикселях
+const Size = 600
+
+// Cells - сколько обложек нужно для мозаики 2×2
+const Cells = 4
+
+// MaxSide - наибольшая сторона исходной обложки. Картинка декодируется в память целиком,
+// поэтому маленький файл с огромными размерами в заголовке отклоняется до декодирования
+const MaxSide = 4096
+
+var ErrTooLarge = fmt.Errorf("обложка больше %d×%d пикселей", MaxSide, MaxSide)
+
+var ErrEmptyImage = errors.New("обложка нулевого размера")
+
+// Decode читает обложку любого поддерживаемого формата, сначала проверяя размеры по заголовку
+func Decode(r io.Reader) (image.Image, error) {
+	var header bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width > MaxSide || cfg.Height > MaxSide {
+		return nil, ErrTooLarge
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrEmptyImage
+	}
+	img, _, err := image.Decode(io.MultiReader(&header, r))
+	return img, err
+}
+
+// Compose собирает квадратную обложку. Одна картинка занимает всю площадь, из двух и трех
+// мозаика собирается с повторами по диагонали, лишние после четвертой игнорируются
+func Compose(covers []image.Image) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, Size, Size))
+	switch len(covers) {
+	case 0:
+		return dst
+	case 1:
+		drawScaled(dst, dst.Bounds(), covers[0])
+		return dst
+	case 2:
+		covers = []image.Image{covers[0], covers[1], covers[1], covers[0]}
+	case 3:
+		covers = []image.Image{covers[0], covers[1], covers[2], covers[0]}
+	}
+
+	half := Size / 2
+	for i, img := range covers[:Cells] {
+		x, y := (i%2)*half, (i/2)*half
+		drawScaled(dst, image.Rect(x, y, x+half, y+half), img)
+	}
+	return dst
+}
+
+func Encode(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+}
+
+// drawScaled вписывает центральный квадрат src в rect, усредняя пиксели исходника (box-фильтр)
+func drawScaled(dst *image.RGBA, rect image.Rectangle, src image.Image) {
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	if side == 0 {
+		return
+	}
+	crop := image.Rect(0, 0, side, side)
+	rgba := image.NewRGBA(crop)
+	offset := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	draw.Draw(rgba, crop, src, offset, draw.Src)
+
+	w, h := rect.Dx(), rect.Dy()
+	for dy := 0; dy < h; dy++ {
+		sy0, sy1 := dy*side/h, (dy+1)*side/h
+		if sy1 == sy0 {
+			sy1 = sy0 + 1
+		}
+		for dx := 0; dx < w; dx++ {
+			sx0, sx1 := dx*side/w, (dx+1)*side/w
+			if sx1 == sx0 {
+				sx1 = sx0 + 1
+			}
+			var r, g, bl, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := sx0; sx < sx1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint32(p[0])
+					g += uint32(p[1])
+					bl += uint32(p[2])
+					a += uint32(p[3])
+					n++
+				}
+			}
+			o := dst.PixOffset(rect.Min.X+dx, rect.Min.Y+dy)
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(bl / n)
+			dst.Pix[o+3] = uint8(a / n)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package covermosaic
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func solid(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+	white = color.RGBA{255, 255, 255, 255}
+)
+
+func at(img image.Image, x, y int) color.RGBA {
+	return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+}
+
+func TestDecode(t *testing.T) {
+	src := solid(40, 30, red)
+	tests := []struct {
+		name   string
+		encode func(*bytes.Buffer) error
+	}{
+		{name: "png", encode: func(b *bytes.Buffer) error { return png.Encode(b, src) }},
+		{name: "jpeg", encode: func(b *bytes.Buffer) error { return jpeg.Encode(b, src, nil) }},
+		{name: "gif", encode: func(b *bytes.Buffer) error { return gif.Encode(b, src, nil) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.encode(&buf); err != nil {
+				t.Fatal(err)
+			}
+			img, err := Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds() != image.Rect(0, 0, 40, 30) {
+				t.Fatalf("размеры %v", img.Bounds())
+			}
+		})
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	encodePNG := func(w, h int) *bytes.Buffer {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+			t.Fatal(err)
+		}
+		return &buf
+	}
+
+	tests := []struct {
+		name    string
+		input   *bytes.Buffer
+		wantErr error
+	}{
+		{name: "слишком широкая", input: encodePNG(MaxSide+1, 1), wantErr: ErrTooLarge},
+		{name: "слишком высокая", input: encodePNG(1, MaxSide+1), wantErr: ErrTooLarge},
+		{name: "не картинка", input: bytes.NewBufferString("<svg></svg>")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.input)
+			if err == nil {
+				t.Fatal("ожидалась ошибка")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := Decode(encodePNG(MaxSide, 1)); err != nil {
+		t.Fatalf("обложка на границе допустимого: %v", err)
+	}
+}
+
+func TestCompose(t *testing.T) {
+	half := Size / 2
+	// Центры четвертей: левый верхний, правый верхний, левый нижний, правый нижний
+	quarters := []image.Point{{half / 2, half / 2}, {half + half/2, half / 2}, {half / 2, half + half/2}, {half + half/2, half + half/2}}
+
+	tests := []struct {
+		name   string
+		covers []image.Image
+		want   []color.RGBA
+	}{
+		{name: "без обложек", want: []color.RGBA{{}, {}, {}, {}}},
+		{name: "одна на всю площадь", covers: []image.Image{solid(10, 10, red)}, want: []color.RGBA{red, red, red, red}},
+		{name: "две по диагонали", covers: []image.Image{solid(10, 10, red), solid(10, 10, green)}, want: []color.RGBA{red, green, green, red}},
+		{name: "три с повтором первой", covers: []image.Image{solid(10, 10, red), solid(10, 10, green), solid(10, 10, blue)}, want: []color.RGBA{red, green, blue, red}},
+		{
+			name:   "пятая игнорируется",
+			covers: []image.Image{solid(10, 10, red), solid(10, 10, green), solid(10, 10, blue), solid(10, 10, white), solid(10, 10, blue)},
+			want:   []color.RGBA{red, green, blue, white},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := Compose(tt.covers)
+			if img.Bounds() != image.Rect(0, 0, Size, Size) {
+				t.Fatalf("размеры %v", img.Bounds())
+			}
+			for i, p := range quarters {
+				if got := at(img, p.X, p.Y); got != tt.want[i] {
+					t.Errorf("четверть %d: %v, ожидался %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// Из прямоугольной обложки берется центральный квадрат
+func TestComposeCropsCenter(t *testing.T) {
+	src := solid(300, 100, blue)
+	for y := 0; y < 100; y++ {
+		for x := 100; x < 200; x++ {
+			src.SetRGBA(x, y, red)
+		}
+	}
+	img := Compose([]image.Image{src})
+	for _, p := range []image.Point{{0, 0}, {Size - 1, 0}, {Size / 2, Size / 2}, {Size - 1, Size - 1}} {
+		if got := at(img, p.X, p.Y); got != red {
+			t.Fatalf("пиксель %v: %v, ожидался красный", p, got)
+		}
+	}
+}
+
+// При уменьшении пиксели исходника усредняются
+func TestComposeAveragesPixels(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, Size*2, Size*2))
+	for y := 0; y < Size*2; y++ {
+		for x := 0; x < Size*2; x++ {
+			if (x+y)%2 == 0 {
+				src.SetRGBA(x, y, white)
+			} else {
+				src.SetRGBA(x, y, color.RGBA{0, 0, 0, 255})
+			}
+		}
+	}
+	got := at(Compose([]image.Image{src}), 10, 10)
+	if got.R != 127 || got.G != 127 || got.B != 127 || got.A != 255 {
+		t.Fatalf("пиксель %v, ожидался серый", got)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, Compose([]image.Image{solid(10, 10, red)})); err != nil {
+		t.Fatal(err)
+	}
+	_, format, err := image.DecodeConfig(&buf)
+	if err != nil || !strings.EqualFold(format, "jpeg") {
+		t.Fatalf("формат %q, ошибка %v", format, err)
+	}
+}
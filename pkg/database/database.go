@@ -11,6 +11,22 @@ import (
 )
 
 func Connect() (*sql.DB, error) {
+	db, err := sql.Open("postgres", DSN())
+	if err != nil {
+		return nil, err
+	}
+
+	// Проверяем соединение
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+
+	log.Println("Подключение к БД успешно!")
+	return db, nil
+}
+
+// DSN нужен отдельно для pq.Listener, которому требуется собственное соединение
+func DSN() string {
 	// Загружаем переменные окружения из .env
 	if err := godotenv.Load("pkg/database/.env"); err != nil {
 		log.Println("Предупреждение: .env файл не найден, используются системные переменные окружения")
@@ -24,19 +40,6 @@ func Connect() (*sql.DB, error) {
 	dbname := os.Getenv("DB_NAME")
 
 	// Формируем строку подключения
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
-
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		return nil, err
-	}
-
-	// Проверяем соединение
-	if err := db.Ping(); err != nil {
-		return nil, err
-	}
-
-	log.Println("Подключение к БД успешно!")
-	return db, nil
 }
@@ -0,0 +1,79 @@
+package ddex
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalidICPN     = errors.New("UPC/EAN должен состоять из 12 или 13 цифр с верной контрольной цифрой")
+	ErrInvalidISRC     = errors.New("ISRC должен иметь вид CC-XXX-YY-NNNNN")
+	ErrInvalidLanguage = errors.New("язык указывается кодом ISO 639, например ru или en-US")
+)
+
+// stripSeparators убирает пробелы и дефисы, которыми коды обычно разбивают на группы
+func stripSeparators(s string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(s))
+}
+
+// NormalizeICPN проверяет код релиза: UPC-A (12 цифр) или EAN-13. Контрольная цифра считается
+// по правилу GTIN: справа налево веса 3 и 1, сумма с контрольной цифрой кратна 10
+func NormalizeICPN(code string) (string, error) {
+	code = stripSeparators(code)
+	if len(code) != 12 && len(code) != 13 {
+		return "", ErrInvalidICPN
+	}
+	sum := 0
+	for i := len(code) - 1; i >= 0; i-- {
+		c := code[i]
+		if c < '0' || c > '9' {
+			return "", ErrInvalidICPN
+		}
+		digit := int(c - '0')
+		if (len(code)-1-i)%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	if sum%10 != 0 {
+		return "", ErrInvalidICPN
+	}
+	return code, nil
+}
+
+// ISRC: страна (2 буквы), регистрант (3 символа), год (2 цифры), номер (5 цифр). Контрольной цифры в коде нет
+var isrcPattern = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$`)
+
+// NormalizeISRC приводит ISRC к виду без дефисов в верхнем регистре
+func NormalizeISRC(code string) (string, error) {
+	code = strings.ToUpper(stripSeparators(code))
+	if !isrcPattern.MatchString(code) {
+		return "", ErrInvalidISRC
+	}
+	return code, nil
+}
+
+// FormatISRC возвращает ISRC с дефисами для показа
+func FormatISRC(code string) string {
+	if len(code) != 12 {
+		return code
+	}
+	return code[:2] + "-" + code[2:5] + "-" + code[5:7] + "-" + code[7:]
+}
+
+var languagePattern = regexp.MustCompile(`^([a-z]{2,3})(?:-([a-z]{2}|[0-9]{3}))?$`)
+
+// NormalizeLanguage принимает код ISO 639-1/639-2 с необязательным регионом (en, pt-BR, es-419).
+// Для инструментальной музыки DDEX использует zxx
+func NormalizeLanguage(tag string) (string, error) {
+	tag = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(tag)), "_", "-")
+	m := languagePattern.FindStringSubmatch(tag)
+	if m == nil {
+		return "", ErrInvalidLanguage
+	}
+	if m[2] == "" {
+		return m[1], nil
+	}
+	return m[1] + "-" + strings.ToUpper(m[2]), nil
+}
@@ -0,0 +1,104 @@
+package ddex
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeICPN(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+		err  error
+	}{
+		{code: "036000291452", want: "036000291452"},
+		{code: "4006381333931", want: "4006381333931"},
+		{code: " 4 006381-333931 ", want: "4006381333931"},
+		{code: "036000291453", err: ErrInvalidICPN},  // неверная контрольная цифра
+		{code: "4006381333932", err: ErrInvalidICPN}, // неверная контрольная цифра
+		{code: "03600029145", err: ErrInvalidICPN},   // 11 цифр
+		{code: "40063813339310", err: ErrInvalidICPN},
+		{code: "03600029145A", err: ErrInvalidICPN},
+		{code: "", err: ErrInvalidICPN},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeICPN(tt.code)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("NormalizeICPN(%q) = %q, %v; ожидалось %q, %v", tt.code, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestNormalizeISRC(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+		err  error
+	}{
+		{code: "USRC17607839", want: "USRC17607839"},
+		{code: "us-rc1-76-07839", want: "USRC17607839"},
+		{code: "GB A1B 24 00001", want: "GBA1B2400001"},
+		{code: "USRC1760783", err: ErrInvalidISRC},   // номер короче 5 цифр
+		{code: "1SRC17607839", err: ErrInvalidISRC},  // страна - буквы
+		{code: "USRC1A607839", err: ErrInvalidISRC},  // год - цифры
+		{code: "USRC176078390", err: ErrInvalidISRC}, // лишний символ
+		{code: "", err: ErrInvalidISRC},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeISRC(tt.code)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("NormalizeISRC(%q) = %q, %v; ожидалось %q, %v", tt.code, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestFormatISRC(t *testing.T) {
+	if got := FormatISRC("USRC17607839"); got != "US-RC1-76-07839" {
+		t.Errorf("FormatISRC = %q", got)
+	}
+	// Коды другой длины показываются как есть
+	if got := FormatISRC("USRC1760"); got != "USRC1760" {
+		t.Errorf("FormatISRC = %q", got)
+	}
+}
+
+func TestNormalizeLanguage(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+		err  error
+	}{
+		{tag: "ru", want: "ru"},
+		{tag: "EN", want: "en"},
+		{tag: "pt-br", want: "pt-BR"},
+		{tag: "en_US", want: "en-US"},
+		{tag: "es-419", want: "es-419"},
+		{tag: "zxx", want: "zxx"},
+		{tag: "russian", err: ErrInvalidLanguage},
+		{tag: "e", err: ErrInvalidLanguage},
+		{tag: "en-USA", err: ErrInvalidLanguage},
+		{tag: "en-", err: ErrInvalidLanguage},
+		{tag: "", err: ErrInvalidLanguage},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeLanguage(tt.tag)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("NormalizeLanguage(%q) = %q, %v; ожидалось %q, %v", tt.tag, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	tests := map[int]string{
+		0:    "PT0M0S",
+		-5:   "PT0M0S",
+		205:  "PT3M25S",
+		3600: "PT1H0M0S",
+		3725: "PT1H2M5S",
+	}
+	for seconds, want := range tests {
+		if got := Duration(seconds); got != want {
+			t.Errorf("Duration(%d) = %q, ожидалось %q", seconds, got, want)
+		}
+	}
+}
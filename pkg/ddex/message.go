@@ -0,0 +1,110 @@
+package ddex
+
+import (
+	"fmt"
+	"time"
+)
+
+// Упрощенное JSON-представление сообщения DDEX ERN 4: один релиз, его звукозаписи и трек-лист.
+// Имена полей повторяют элементы ERN, чтобы выгрузку было легко перевести в XML дистрибьютора
+
+// Типы релизов ERN
+const (
+	ReleaseTypeSingle = "Single"
+	ReleaseTypeEP     = "EP"
+	ReleaseTypeAlbum  = "Album"
+)
+
+// Значения ParentalWarningType
+const (
+	WarningExplicit    = "Explicit"
+	WarningNotExplicit = "NotExplicit"
+)
+
+type NewReleaseMessage struct {
+	MessageHeader   MessageHeader    `json:"MessageHeader"`
+	Release         Release          `json:"Release"`
+	SoundRecordings []SoundRecording `json:"SoundRecordingList"`
+	// Чего не хватает для отправки дистрибьютору; пустой список - релиз готов
+	Warnings []string `json:"Warnings,omitempty"`
+}
+
+type MessageHeader struct {
+	MessageID              string    `json:"MessageId"`
+	MessageSender          string    `json:"MessageSender"`
+	MessageCreatedDateTime time.Time `json:"MessageCreatedDateTime"`
+}
+
+type Release struct {
+	ReleaseReference      string          `json:"ReleaseReference"`
+	ReleaseType           string          `json:"ReleaseType"`
+	IsCompilation         bool            `json:"IsCompilation"`
+	ICPN                  string          `json:"ICPN,omitempty"`
+	Title                 string          `json:"ReferenceTitle"`
+	DisplayArtistName     string          `json:"DisplayArtistName"`
+	LabelName             string          `json:"LabelName,omitempty"`
+	PLine                 *Line           `json:"PLine,omitempty"`
+	CLine                 *Line           `json:"CLine,omitempty"`
+	ParentalWarningType   string          `json:"ParentalWarningType"`
+	LanguageOfPerformance string          `json:"LanguageOfPerformance,omitempty"`
+	OriginalReleaseDate   string          `json:"OriginalReleaseDate"`
+	Duration              string          `json:"Duration"`
+	ResourceGroups        []ResourceGroup `json:"ResourceGroup"`
+}
+
+// Line - строка ℗ или ©: год и текст правообладателя
+type Line struct {
+	Year int    `json:"Year"`
+	Text string `json:"Text"`
+}
+
+// ResourceGroup - один диск релиза
+type ResourceGroup struct {
+	SequenceNumber int           `json:"SequenceNumber"`
+	ContentItems   []ContentItem `json:"ResourceGroupContentItem"`
+}
+
+type ContentItem struct {
+	SequenceNumber           int    `json:"SequenceNumber"`
+	ReleaseResourceReference string `json:"ReleaseResourceReference"`
+}
+
+type SoundRecording struct {
+	ResourceReference     string        `json:"ResourceReference"`
+	ISRC                  string        `json:"ISRC,omitempty"`
+	Title                 string        `json:"Title"`
+	DisplayArtistName     string        `json:"DisplayArtistName"`
+	Contributors          []Contributor `json:"Contributor,omitempty"`
+	Duration              string        `json:"Duration"`
+	ParentalWarningType   string        `json:"ParentalWarningType"`
+	LanguageOfPerformance string        `json:"LanguageOfPerformance,omitempty"`
+}
+
+type Contributor struct {
+	Name string `json:"Name"`
+	Role string `json:"Role"`
+}
+
+// ResourceReference - ссылка на звукозапись внутри сообщения: A1, A2...
+func ResourceReference(n int) string {
+	return fmt.Sprintf("A%d", n)
+}
+
+// Duration переводит секунды в ISO 8601 (PT3M25S), как требует ERN
+func Duration(seconds int) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	h, m, s := seconds/3600, seconds/60%60, seconds%60
+	if h > 0 {
+		return fmt.Sprintf("PT%dH%dM%dS", h, m, s)
+	}
+	return fmt.Sprintf("PT%dM%dS", m, s)
+}
+
+func ParentalWarning(explicit bool) string {
+	if explicit {
+		return WarningExplicit
+	}
+	return WarningNotExplicit
+}
@@ -3,3 +3,61 @@ package errorspkg
 import "errors"
 
 var ErrCommentBanned = errors.New("Администратор запретил вам оставлять комментарии")
+
+var ErrTwoFactorRequired = errors.New("требуется код двухфакторной аутентификации")
+
+var ErrTwoFactorSetupRequired = errors.New("для вашей роли необходимо включить двухфакторную аутентификацию")
+
+var ErrInvalidTwoFactorCode = errors.New("неверный код подтверждения")
+
+var ErrStepUpRequired = errors.New("операция требует повторного подтверждения кодом 2FA")
+
+var ErrAccountLocked = errors.New("вход временно заблокирован из-за неудачных попыток")
+
+var ErrTooManyAttempts = errors.New("слишком много попыток входа с вашего адреса, попробуйте позже")
+
+var ErrExportInProgress = errors.New("выгрузка данных уже готовится")
+
+var ErrInvalidDownloadLink = errors.New("ссылка недействительна или устарела")
+
+var ErrPrivateContent = errors.New("пользователь ограничил доступ настройками приватности")
+
+var ErrUserBlocked = errors.New("действие недоступно: пользователь вас заблокировал или заблокирован вами")
+
+var ErrVerificationPending = errors.New("заявка на верификацию уже на рассмотрении")
+
+var ErrPlaylistNotFound = errors.New("плейлист не найден или у вас нет прав на его изменение")
+
+var ErrPlaylistRevisionConflict = errors.New("плейлист был изменен, обновите его и повторите действие")
+
+var ErrTrackNotInPlaylist = errors.New("трека нет в плейлисте")
+
+var ErrTrackAlreadyInPlaylist = errors.New("трек уже есть в плейлисте")
+
+var ErrTrackNotFound = errors.New("трек не найден")
+
+var ErrInvalidPlaylistEdit = errors.New("некорректное изменение плейлиста")
+
+var ErrInvalidInvite = errors.New("приглашение недействительно или устарело")
+
+var ErrPlaylistMemberNotFound = errors.New("участник плейлиста не найден")
+
+var ErrSmartPlaylistReadOnly = errors.New("состав умного плейлиста задается правилами, его нельзя менять вручную")
+
+var ErrPlaylistRevisionNotFound = errors.New("ревизия плейлиста не найдена")
+
+var ErrAlbumNotFound = errors.New("альбом не найден или у вас нет прав на его изменение")
+
+var ErrInvalidAlbumEdit = errors.New("некорректное изменение альбома")
+
+var ErrTracksUnavailable = errors.New("треки должны быть вашими и не входить в другие ваши альбомы")
+
+var ErrReleaseCodeTaken = errors.New("такой UPC/EAN или ISRC уже используется")
+
+var ErrInvalidTrackEdit = errors.New("некорректное изменение трека")
+
+var ErrInvalidBanner = errors.New("некорректный баннер")
+
+var ErrInvalidEmail = errors.New("некорректный email")
+
+var ErrEmailTaken = errors.New("пользователь с таким email уже существует")
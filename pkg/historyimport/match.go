@@ -0,0 +1,227 @@
+package historyimport
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Пороги похожести: названия должны почти совпадать, у артистов допускается больше расхождений
+const (
+	minTitleSimilarity  = 0.85
+	minArtistSimilarity = 0.8
+)
+
+// Candidate - трек каталога. Artists - автор и указанные участники
+type Candidate struct {
+	TrackID  int
+	Title    string
+	Artists  []string
+	Duration int
+}
+
+type indexed struct {
+	Candidate
+	title   string
+	artists []string
+}
+
+// Matcher ищет трек каталога по артисту и названию с учетом опечаток и приписок вроде "Remastered" и "feat."
+type Matcher struct {
+	byTitle  map[string][]*indexed
+	byArtist map[string][]*indexed
+}
+
+func NewMatcher(candidates []Candidate) *Matcher {
+	m := &Matcher{byTitle: make(map[string][]*indexed), byArtist: make(map[string][]*indexed)}
+	for _, c := range candidates {
+		ix := &indexed{Candidate: c, title: NormalizeTitle(c.Title)}
+		for _, a := range c.Artists {
+			if n := normalize(a); n != "" {
+				ix.artists = append(ix.artists, n)
+				m.byArtist[n] = append(m.byArtist[n], ix)
+			}
+		}
+		m.byTitle[ix.title] = append(m.byTitle[ix.title], ix)
+	}
+	return m
+}
+
+// Match возвращает лучший подходящий трек. Сначала ищем по точному нормализованному названию,
+// затем среди треков артиста - по похожему названию
+func (m *Matcher) Match(artist, title string) (Candidate, bool) {
+	t := NormalizeTitle(title)
+	artists := splitArtists(artist)
+	if t == "" || len(artists) == 0 {
+		return Candidate{}, false
+	}
+
+	var best *indexed
+	bestScore := 0.0
+	consider := func(ix *indexed) {
+		ts := similarity(t, ix.title)
+		if ts < minTitleSimilarity {
+			return
+		}
+		as := 0.0
+		for _, a := range artists {
+			for _, ca := range ix.artists {
+				if s := similarity(a, ca); s > as {
+					as = s
+				}
+			}
+		}
+		if as < minArtistSimilarity {
+			return
+		}
+		if score := ts*0.6 + as*0.4; score > bestScore {
+			best, bestScore = ix, score
+		}
+	}
+
+	for _, ix := range m.byTitle[t] {
+		consider(ix)
+	}
+	if best == nil {
+		for _, a := range artists {
+			for _, ix := range m.byArtist[a] {
+				consider(ix)
+			}
+		}
+	}
+	if best == nil {
+		return Candidate{}, false
+	}
+	return best.Candidate, true
+}
+
+// ArtistKeyLength - длина ключа артиста для предварительного отбора каталога в БД
+const ArtistKeyLength = 2
+
+// ArtistKey - первые ArtistKeyLength букв и цифр нормализованного имени без пробелов.
+// Каталог отбирается по этому ключу до нечеткого сравнения, поэтому опечатка в самом
+// начале имени артиста не найдется - это плата за то, что не грузим весь каталог
+func ArtistKey(name string) string {
+	key := []rune(strings.ReplaceAll(normalize(name), " ", ""))
+	if len(key) > ArtistKeyLength {
+		key = key[:ArtistKeyLength]
+	}
+	return string(key)
+}
+
+// ArtistKeys - ключи всех артистов из строк импорта, включая соавторов после "feat.", "&" и т.д.
+func ArtistKeys(artists []string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, artist := range artists {
+		for _, a := range splitArtists(artist) {
+			if k := ArtistKey(a); k != "" && !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+var (
+	featPattern     = regexp.MustCompile(`(?i)[\(\[]?\s*\b(feat|ft|featuring)\b\.?.*$`)
+	bracketPattern  = regexp.MustCompile(`[\(\[]([^\)\]]*)[\)\]]`)
+	dashSuffix      = regexp.MustCompile(`\s+-\s+(.*)$`)
+	noiseKeywords   = []string{"remaster", "explicit", "radio edit", "single version", "album version", "mono", "stereo", "bonus track"}
+	artistSeparator = regexp.MustCompile(`(?i)\s*(,|&|\bx\b|\band\b|\sи\s|;|/|\bfeat\b\.?|\bft\b\.?|\bfeaturing\b)\s*`)
+)
+
+// NormalizeTitle убирает приписки, которые разные сервисы добавляют к одному и тому же треку
+func NormalizeTitle(title string) string {
+	title = featPattern.ReplaceAllString(title, "")
+	title = bracketPattern.ReplaceAllStringFunc(title, func(part string) string {
+		if isNoise(part) {
+			return ""
+		}
+		return part
+	})
+	if m := dashSuffix.FindStringSubmatch(title); m != nil && isNoise(m[1]) {
+		title = strings.TrimSuffix(title, m[0])
+	}
+	return normalize(title)
+}
+
+func isNoise(s string) bool {
+	s = strings.ToLower(s)
+	for _, k := range noiseKeywords {
+		if strings.Contains(s, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitArtists: полная строка и каждый артист по отдельности ("A feat. B" -> "a feat b", "a", "b")
+func splitArtists(artist string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	add := func(s string) {
+		if n := normalize(s); n != "" && !seen[n] {
+			seen[n] = true
+			result = append(result, n)
+		}
+	}
+	add(artist)
+	for _, part := range artistSeparator.Split(artist, -1) {
+		add(part)
+	}
+	return result
+}
+
+// normalize приводит к нижнему регистру, ё к е и оставляет только буквы и цифры через пробел
+func normalize(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		if r == 'ё' {
+			r = 'е'
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = false
+		} else {
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// similarity - 1 минус расстояние Левенштейна, отнесенное к длине большей строки
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	longest := max(len(ra), len(rb))
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
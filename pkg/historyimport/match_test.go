@@ -0,0 +1,90 @@
+package historyimport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatcherMatch(t *testing.T) {
+	m := NewMatcher([]Candidate{
+		{TrackID: 1, Title: "Группа крови", Artists: []string{"Кино"}},
+		{TrackID: 2, Title: "Blinding Lights", Artists: []string{"The Weeknd"}},
+		{TrackID: 3, Title: "Bohemian Rhapsody", Artists: []string{"Queen"}},
+		{TrackID: 4, Title: "Song", Artists: []string{"Artist A", "Guest B"}},
+		{TrackID: 5, Title: "Прованс", Artists: []string{"Ёлка"}},
+	})
+
+	tests := []struct {
+		name   string
+		artist string
+		title  string
+		want   int // 0 - совпадения нет
+	}{
+		{name: "точное совпадение", artist: "Кино", title: "Группа крови", want: 1},
+		{name: "регистр и пунктуация", artist: "КИНО", title: "Группа  крови!", want: 1},
+		{name: "ё и е", artist: "Елка", title: "Прованс", want: 5},
+		{name: "remaster через дефис", artist: "Queen", title: "Bohemian Rhapsody - Remastered 2011", want: 3},
+		{name: "remaster в скобках", artist: "Queen", title: "Bohemian Rhapsody (Remastered)", want: 3},
+		{name: "feat. в названии", artist: "The Weeknd", title: "Blinding Lights (feat. Someone)", want: 2},
+		{name: "опечатка в названии в пределах порога", artist: "The Weeknd", title: "Blinding Ligths", want: 2},
+		{name: "название ниже порога", artist: "The Weeknd", title: "Blind Lights", want: 0},
+		{name: "опечатка в артисте в пределах порога", artist: "The Weekend", title: "Blinding Lights", want: 2},
+		{name: "артист ниже порога", artist: "Weeknd", title: "Blinding Lights", want: 0},
+		{name: "другой артист с тем же названием", artist: "Кино", title: "Blinding Lights", want: 0},
+		{name: "несколько артистов через &", artist: "Guest B & Someone", title: "Song", want: 4},
+		{name: "артисты через feat.", artist: "Artist A feat. Guest B", title: "Song", want: 4},
+		{name: "нет в каталоге", artist: "Nobody", title: "Nothing", want: 0},
+		{name: "пустое название", artist: "Кино", title: "", want: 0},
+		{name: "пустой артист", artist: "", title: "Группа крови", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok := m.Match(tt.artist, tt.title)
+			got := 0
+			if ok {
+				got = c.TrackID
+			}
+			if got != tt.want {
+				t.Fatalf("Match(%q, %q) = %d, ожидалось %d", tt.artist, tt.title, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := map[string]string{
+		"Bohemian Rhapsody - Remastered 2011": "bohemian rhapsody",
+		"Song (Radio Edit)":                   "song",
+		"Song [Explicit]":                     "song",
+		"Song (Live)":                         "song live",
+		"Song - Live at Wembley":              "song live at wembley",
+		"Song feat. Guest":                    "song",
+		"Ёжик в тумане":                       "ежик в тумане",
+	}
+	for title, want := range tests {
+		if got := NormalizeTitle(title); got != want {
+			t.Errorf("NormalizeTitle(%q) = %q, ожидалось %q", title, got, want)
+		}
+	}
+}
+
+func TestArtistKeys(t *testing.T) {
+	tests := map[string]string{
+		"Ёлка":       "ел",
+		"The Weeknd": "th",
+		"A$AP Rocky": "aa",
+		"X":          "x",
+		"!!!":        "",
+	}
+	for name, want := range tests {
+		if got := ArtistKey(name); got != want {
+			t.Errorf("ArtistKey(%q) = %q, ожидалось %q", name, got, want)
+		}
+	}
+
+	got := ArtistKeys([]string{"Artist A feat. Guest B", "artist a", ""})
+	if want := []string{"ar", "gu"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ArtistKeys = %v, ожидалось %v", got, want)
+	}
+}
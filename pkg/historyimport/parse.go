@@ -0,0 +1,264 @@
+package historyimport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Источники истории прослушиваний
+const (
+	SourceSpotify      = "spotify"
+	SourceLastFM       = "lastfm"
+	SourceListenBrainz = "listenbrainz"
+)
+
+var Sources = []string{SourceSpotify, SourceLastFM, SourceListenBrainz}
+
+// MaxEntries ограничивает размер одного импорта
+const MaxEntries = 500000
+
+var (
+	ErrUnknownSource = errors.New("неизвестный источник истории")
+	ErrTooManyItems  = fmt.Errorf("в файле больше %d прослушиваний", MaxEntries)
+	ErrEmpty         = errors.New("в файле не найдено ни одного прослушивания")
+)
+
+// Entry - одно прослушивание из чужого сервиса. MsPlayed = 0, если сервис хранит только факт скроббла
+type Entry struct {
+	Artist   string
+	Title    string
+	Album    string
+	PlayedAt time.Time
+	MsPlayed int
+}
+
+// Parse разбирает выгрузку указанного источника; записи без артиста, названия или времени пропускаются
+func Parse(source string, r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var err error
+	switch source {
+	case SourceSpotify:
+		entries, err = parseSpotify(r)
+	case SourceLastFM:
+		entries, err = parseLastFM(r)
+	case SourceListenBrainz:
+		entries, err = parseListenBrainz(r)
+	default:
+		return nil, ErrUnknownSource
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrEmpty
+	}
+	if len(entries) > MaxEntries {
+		return nil, ErrTooManyItems
+	}
+	return entries, nil
+}
+
+func (e Entry) valid() bool {
+	return strings.TrimSpace(e.Artist) != "" && strings.TrimSpace(e.Title) != "" && !e.PlayedAt.IsZero()
+}
+
+// parseSpotify понимает и StreamingHistory*.json (endTime, artistName, trackName, msPlayed),
+// и расширенную историю Streaming_History_Audio_*.json (ts, master_metadata_*, ms_played)
+func parseSpotify(r io.Reader) ([]Entry, error) {
+	var items []struct {
+		EndTime    string `json:"endTime"`
+		ArtistName string `json:"artistName"`
+		TrackName  string `json:"trackName"`
+		MsPlayed   int    `json:"msPlayed"`
+
+		TS          string `json:"ts"`
+		MsPlayedExt int    `json:"ms_played"`
+		Track       string `json:"master_metadata_track_name"`
+		Artist      string `json:"master_metadata_album_artist_name"`
+		Album       string `json:"master_metadata_album_album_name"`
+	}
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, fmt.Errorf("некорректный JSON Spotify: %v", err)
+	}
+
+	entries := make([]Entry, 0, len(items))
+	for _, it := range items {
+		var e Entry
+		if it.TS != "" {
+			t, err := time.Parse(time.RFC3339, it.TS)
+			if err != nil {
+				continue
+			}
+			// В расширенной истории ts - время окончания воспроизведения
+			e = Entry{Artist: it.Artist, Title: it.Track, Album: it.Album, MsPlayed: it.MsPlayedExt,
+				PlayedAt: t.Add(-time.Duration(it.MsPlayedExt) * time.Millisecond)}
+		} else {
+			t, err := time.Parse("2006-01-02 15:04", it.EndTime)
+			if err != nil {
+				continue
+			}
+			e = Entry{Artist: it.ArtistName, Title: it.TrackName, MsPlayed: it.MsPlayed,
+				PlayedAt: t.Add(-time.Duration(it.MsPlayed) * time.Millisecond)}
+		}
+		if e.valid() {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// Форматы дат в выгрузках Last.fm от сторонних экспортеров
+var lastFMDateLayouts = []string{"02 Jan 2006 15:04", "2 Jan 2006 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05Z", "02 Jan 2006, 15:04"}
+
+// parseLastFM: CSV с заголовком (uts/utc_time, artist, album, track) или без него (artist, album, track, date)
+func parseLastFM(r io.Reader) ([]Entry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("некорректный CSV Last.fm: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	col := map[string]int{"artist": 0, "album": 1, "track": 2, "date": 3, "uts": -1}
+	header := make(map[string]int)
+	for i, name := range records[0] {
+		header[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF")))] = i
+	}
+	if _, hasArtist := header["artist"]; hasArtist {
+		col = map[string]int{"artist": header["artist"], "album": -1, "track": -1, "date": -1, "uts": -1}
+		for _, k := range []string{"album", "track", "uts"} {
+			if i, ok := header[k]; ok {
+				col[k] = i
+			}
+		}
+		for _, k := range []string{"utc_time", "date", "time"} {
+			if i, ok := header[k]; ok {
+				col["date"] = i
+				break
+			}
+		}
+		records = records[1:]
+	}
+
+	field := func(rec []string, name string) string {
+		i := col[name]
+		if i < 0 || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	entries := make([]Entry, 0, len(records))
+	for _, rec := range records {
+		e := Entry{Artist: field(rec, "artist"), Album: field(rec, "album"), Title: field(rec, "track")}
+		if uts, err := strconv.ParseInt(field(rec, "uts"), 10, 64); err == nil && uts > 0 {
+			e.PlayedAt = time.Unix(uts, 0).UTC()
+		} else {
+			e.PlayedAt = parseLastFMDate(field(rec, "date"))
+		}
+		if e.valid() {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func parseLastFMDate(s string) time.Time {
+	for _, layout := range lastFMDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64 `json:"listened_at"`
+	TrackMetadata struct {
+		ArtistName     string `json:"artist_name"`
+		TrackName      string `json:"track_name"`
+		ReleaseName    string `json:"release_name"`
+		AdditionalInfo struct {
+			DurationMs int `json:"duration_ms"`
+		} `json:"additional_info"`
+	} `json:"track_metadata"`
+}
+
+// parseListenBrainz принимает JSON-массив (экспорт с сайта) или JSON Lines (архив экспорта по месяцам)
+func parseListenBrainz(r io.Reader) ([]Entry, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err != nil {
+		return nil, nil
+	}
+
+	var listens []listenBrainzListen
+	if first == '[' {
+		if err := json.NewDecoder(br).Decode(&listens); err != nil {
+			return nil, fmt.Errorf("некорректный JSON ListenBrainz: %v", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(br)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var l listenBrainzListen
+			if err := json.Unmarshal(line, &l); err != nil {
+				return nil, fmt.Errorf("некорректная строка ListenBrainz: %v", err)
+			}
+			listens = append(listens, l)
+			if len(listens) > MaxEntries {
+				return nil, ErrTooManyItems
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]Entry, 0, len(listens))
+	for _, l := range listens {
+		if l.ListenedAt <= 0 {
+			continue
+		}
+		e := Entry{
+			Artist:   l.TrackMetadata.ArtistName,
+			Title:    l.TrackMetadata.TrackName,
+			Album:    l.TrackMetadata.ReleaseName,
+			PlayedAt: time.Unix(l.ListenedAt, 0).UTC(),
+		}
+		if e.valid() {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b == ' ' || b == '\n' || b == '\r' || b == '\t' || b == 0xEF || b == 0xBB || b == 0xBF {
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
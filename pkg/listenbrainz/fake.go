@@ -0,0 +1,90 @@
+package listenbrainz
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakeServer - минимальная реализация API ListenBrainz для разработки и проверки скробблинга.
+// Принимает токены из Tokens (токен -> имя пользователя) и хранит прослушивания в памяти.
+type FakeServer struct {
+	Tokens map[string]string
+
+	mu      sync.Mutex
+	listens map[string][]Listen
+	// FailNext заставляет следующие n отправок вернуть 503
+	failNext int
+}
+
+func NewFakeServer(tokens map[string]string) *FakeServer {
+	return &FakeServer{Tokens: tokens, listens: make(map[string][]Listen)}
+}
+
+func (f *FakeServer) FailNext(n int) {
+	f.mu.Lock()
+	f.failNext = n
+	f.mu.Unlock()
+}
+
+// Listens возвращает принятые прослушивания пользователя
+func (f *FakeServer) Listens(user string) []Listen {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Listen(nil), f.listens[user]...)
+}
+
+func (f *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := f.Tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Token ")]
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/1/validate-token":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"code": 200, "valid": ok, "user_name": user})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/1/submit-listens":
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"code": 401, "error": "Invalid authorization token."})
+			return
+		}
+
+		f.mu.Lock()
+		if f.failNext > 0 {
+			f.failNext--
+			f.mu.Unlock()
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"code": 503, "error": "Service unavailable"})
+			return
+		}
+		f.mu.Unlock()
+
+		var req submitRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Payload) == 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"code": 400, "error": "Invalid JSON document submitted."})
+			return
+		}
+		if req.ListenType == ListenTypeSingle && len(req.Payload) != 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"code": 400, "error": "JSON document should contain exactly one listen."})
+			return
+		}
+		for _, l := range req.Payload {
+			if l.ListenedAt == 0 || l.TrackMetadata.ArtistName == "" || l.TrackMetadata.TrackName == "" {
+				writeJSON(w, http.StatusBadRequest, map[string]interface{}{"code": 400, "error": "Listen is missing required fields."})
+				return
+			}
+		}
+
+		f.mu.Lock()
+		f.listens[user] = append(f.listens[user], req.Payload...)
+		f.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
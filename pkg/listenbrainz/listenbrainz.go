@@ -0,0 +1,165 @@
+package listenbrainz
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const DefaultURL = "https://api.listenbrainz.org"
+
+// Типы отправки: single - одно прослушивание, import - пачка прошлых прослушиваний
+const (
+	ListenTypeSingle = "single"
+	ListenTypeImport = "import"
+)
+
+// MaxListensPerRequest - ограничение ListenBrainz на размер одной отправки
+const MaxListensPerRequest = 1000
+
+var (
+	// ErrInvalidToken - токен отозван или неверен, повторять отправку бессмысленно
+	ErrInvalidToken = errors.New("токен ListenBrainz недействителен")
+	// ErrRejected - сервер отклонил данные (400), повтор не поможет
+	ErrRejected = errors.New("ListenBrainz отклонил прослушивания")
+)
+
+// Listen - прослушивание в формате ListenBrainz (он же формат импорта)
+type Listen struct {
+	ListenedAt    int64         `json:"listened_at"`
+	TrackMetadata TrackMetadata `json:"track_metadata"`
+}
+
+type TrackMetadata struct {
+	ArtistName     string         `json:"artist_name"`
+	TrackName      string         `json:"track_name"`
+	ReleaseName    string         `json:"release_name,omitempty"`
+	AdditionalInfo AdditionalInfo `json:"additional_info"`
+}
+
+type AdditionalInfo struct {
+	DurationMs       int    `json:"duration_ms,omitempty"`
+	SubmissionClient string `json:"submission_client,omitempty"`
+	OriginURL        string `json:"origin_url,omitempty"`
+}
+
+type submitRequest struct {
+	ListenType string   `json:"listen_type"`
+	Payload    []Listen `json:"payload"`
+}
+
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// NewClientFromEnv берет адрес из LISTENBRAINZ_URL; для разработки можно указать фейковый сервер
+func NewClientFromEnv() *Client {
+	if err := godotenv.Load("pkg/listenbrainz/.env"); err != nil {
+		log.Println("Предупреждение: .env файл не найден, используются системные переменные окружения")
+	}
+	url := os.Getenv("LISTENBRAINZ_URL")
+	if url == "" {
+		url = DefaultURL
+	}
+	return NewClient(url)
+}
+
+// ValidateToken возвращает имя пользователя ListenBrainz, которому принадлежит токен
+func (c *Client) ValidateToken(token string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/1/validate-token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Valid    bool   `json:"valid"`
+		UserName string `json:"user_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("некорректный ответ ListenBrainz: %v", err)
+	}
+	if !body.Valid {
+		return "", ErrInvalidToken
+	}
+	return body.UserName, nil
+}
+
+// SubmitListens отправляет прослушивания. ErrInvalidToken и ErrRejected - окончательные ошибки,
+// остальные (сеть, 429, 5xx) стоит повторить позже
+func (c *Client) SubmitListens(token, listenType string, listens []Listen) error {
+	data, err := json.Marshal(submitRequest{ListenType: listenType, Payload: listens})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/1/submit-listens", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrInvalidToken
+	case http.StatusBadRequest:
+		return fmt.Errorf("%w: %s", ErrRejected, strings.TrimSpace(string(msg)))
+	}
+	return fmt.Errorf("ListenBrainz ответил %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+// WriteListens пишет прослушивания JSON-массивом - в этом виде ListenBrainz принимает файл импорта
+func WriteListens(w io.Writer, listens []Listen) error {
+	if listens == nil {
+		listens = []Listen{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(listens)
+}
+
+// Qualifies - правило скробблинга ListenBrainz/Last.fm: трек длиннее 30 секунд,
+// прослушан хотя бы наполовину или не меньше 4 минут. Для треков без длительности нужны 4 минуты.
+func Qualifies(trackDuration, listenedSeconds int) bool {
+	if trackDuration > 0 && trackDuration <= 30 {
+		return false
+	}
+	if listenedSeconds >= 240 {
+		return true
+	}
+	return trackDuration > 0 && listenedSeconds*2 >= trackDuration
+}
@@ -0,0 +1,115 @@
+package listenbrainz
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func startFake(t *testing.T) (*FakeServer, *Client) {
+	t.Helper()
+	fake := NewFakeServer(map[string]string{"good-token": "alice"})
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return fake, NewClient(srv.URL)
+}
+
+func testListen() Listen {
+	return Listen{
+		ListenedAt: 1700000000,
+		TrackMetadata: TrackMetadata{
+			ArtistName:     "Автор",
+			TrackName:      "Трек",
+			AdditionalInfo: AdditionalInfo{DurationMs: 180000, SubmissionClient: "BeatStreet"},
+		},
+	}
+}
+
+func TestValidateToken(t *testing.T) {
+	_, client := startFake(t)
+
+	user, err := client.ValidateToken("good-token")
+	if err != nil || user != "alice" {
+		t.Fatalf("ValidateToken = %q, %v", user, err)
+	}
+	if _, err := client.ValidateToken("bad-token"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("ожидалась ErrInvalidToken, получено %v", err)
+	}
+}
+
+func TestSubmitListens(t *testing.T) {
+	fake, client := startFake(t)
+
+	if err := client.SubmitListens("good-token", ListenTypeSingle, []Listen{testListen()}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Listens("alice"); len(got) != 1 || got[0].TrackMetadata.TrackName != "Трек" {
+		t.Fatalf("сервер принял %+v", got)
+	}
+}
+
+// Временные ошибки не должны выглядеть как окончательные: воркер повторит отправку позже
+func TestSubmitListensRetry(t *testing.T) {
+	fake, client := startFake(t)
+	fake.FailNext(1)
+
+	err := client.SubmitListens("good-token", ListenTypeSingle, []Listen{testListen()})
+	if err == nil {
+		t.Fatal("ожидалась ошибка 503")
+	}
+	if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrRejected) {
+		t.Fatalf("503 считается окончательной ошибкой: %v", err)
+	}
+	if len(fake.Listens("alice")) != 0 {
+		t.Fatal("неудачная отправка не должна сохраниться")
+	}
+
+	if err := client.SubmitListens("good-token", ListenTypeSingle, []Listen{testListen()}); err != nil {
+		t.Fatalf("повторная отправка: %v", err)
+	}
+	if len(fake.Listens("alice")) != 1 {
+		t.Fatal("после повтора прослушивание должно быть принято")
+	}
+}
+
+func TestSubmitListensFinalErrors(t *testing.T) {
+	_, client := startFake(t)
+
+	tests := []struct {
+		name    string
+		token   string
+		listens []Listen
+		want    error
+	}{
+		{name: "отозванный токен", token: "revoked", listens: []Listen{testListen()}, want: ErrInvalidToken},
+		{name: "single с двумя прослушиваниями", token: "good-token", listens: []Listen{testListen(), testListen()}, want: ErrRejected},
+		{name: "без названия трека", token: "good-token", listens: []Listen{{ListenedAt: 1700000000, TrackMetadata: TrackMetadata{ArtistName: "Автор"}}}, want: ErrRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.SubmitListens(tt.token, ListenTypeSingle, tt.listens)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("получено %v, ожидалось %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQualifies(t *testing.T) {
+	tests := []struct {
+		duration, listened int
+		want               bool
+	}{
+		{duration: 30, listened: 30, want: false},
+		{duration: 200, listened: 99, want: false},
+		{duration: 200, listened: 100, want: true},
+		{duration: 600, listened: 240, want: true},
+		{duration: 0, listened: 239, want: false},
+		{duration: 0, listened: 240, want: true},
+	}
+	for _, tt := range tests {
+		if got := Qualifies(tt.duration, tt.listened); got != tt.want {
+			t.Errorf("Qualifies(%d, %d) = %v, ожидалось %v", tt.duration, tt.listened, got, tt.want)
+		}
+	}
+}
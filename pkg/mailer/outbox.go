@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+const (
+	maxAttempts    = 8
+	retryBaseDelay = time.Minute
+	batchSize      = 20
+	// Письмо в статусе sending дольше этого времени считается брошенным упавшим воркером
+	claimTimeout = 10 * time.Minute
+)
+
+// Outbox кладет письма в таблицу email_outbox; отправкой занимается Worker
+type Outbox struct {
+	db       *sql.DB
+	renderer *Renderer
+}
+
+func NewOutbox(db *sql.DB, renderer *Renderer) *Outbox {
+	return &Outbox{db: db, renderer: renderer}
+}
+
+// Enqueue рендерит письмо сразу, чтобы ошибка в шаблоне вернулась вызывающему
+func (o *Outbox) Enqueue(to, locale, template string, data interface{}) error {
+	msg, err := o.renderer.Render(locale, template, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.db.Exec(`
+		INSERT INTO email_outbox (recipient, template, locale, subject, html_body, text_body)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		to, msg.Template, msg.Locale, msg.Subject, msg.HTML, msg.Text)
+	return err
+}
+
+type Worker struct {
+	db        *sql.DB
+	transport Transport
+	interval  time.Duration
+}
+
+func NewWorker(db *sql.DB, transport Transport, interval time.Duration) *Worker {
+	return &Worker{db: db, transport: transport, interval: interval}
+}
+
+// Run разбирает очередь до отмены ctx
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := w.ProcessBatch()
+			if err != nil {
+				log.Printf("ошибка обработки очереди писем: %v", err)
+				break
+			}
+			if n < batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessBatch отправляет до batchSize писем. Письма забираются отдельным запросом
+// (SKIP LOCKED позволяет запускать несколько воркеров), а отправляются уже без транзакции,
+// чтобы медленный SMTP не держал блокировки; результат записывается по каждому письму.
+func (w *Worker) ProcessBatch() (int, error) {
+	rows, err := w.db.Query(`
+		UPDATE email_outbox SET status = 'sending', claimed_at = NOW()
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE (status = 'pending' AND next_attempt_at <= NOW())
+			   OR (status = 'sending' AND claimed_at < NOW() - $2 * INTERVAL '1 second')
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, recipient, template, locale, subject, html_body, text_body, attempts`,
+		batchSize, int(claimTimeout.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+
+	type queued struct {
+		id       int
+		attempts int
+		msg      Message
+	}
+	var batch []queued
+	for rows.Next() {
+		var q queued
+		if err := rows.Scan(&q.id, &q.msg.To, &q.msg.Template, &q.msg.Locale, &q.msg.Subject, &q.msg.HTML, &q.msg.Text, &q.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, q := range batch {
+		sendErr := w.transport.Send(&q.msg)
+		if sendErr == nil {
+			_, err = w.db.Exec("UPDATE email_outbox SET status = 'sent', attempts = attempts + 1, sent_at = NOW(), last_error = NULL WHERE id = $1", q.id)
+		} else {
+			attempts := q.attempts + 1
+			status := "pending"
+			if attempts >= maxAttempts {
+				status = "failed"
+			}
+			// 1, 2, 4, ... минут между попытками
+			delay := retryBaseDelay << (attempts - 1)
+			_, err = w.db.Exec("UPDATE email_outbox SET status = $1, attempts = $2, last_error = $3, next_attempt_at = $4 WHERE id = $5",
+				status, attempts, sendErr.Error(), time.Now().Add(delay), q.id)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(batch), nil
+}
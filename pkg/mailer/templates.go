@@ -0,0 +1,100 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Шаблоны писем
+const (
+	TemplateResetPassword = "reset_password"
+	TemplateAccountLocked = "account_locked"
+	TemplateDataExport    = "data_export_ready"
+	TemplateAccountDelete = "account_deletion_scheduled"
+)
+
+const DefaultLocale = "ru"
+
+var SupportedLocales = []string{"ru", "en"}
+
+var ErrUnknownTemplate = errors.New("неизвестный шаблон письма")
+
+// NormalizeLocale приводит "en-US", "EN" и т.п. к поддерживаемому языку, иначе DefaultLocale
+func NormalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		locale = locale[:i]
+	}
+	for _, l := range SupportedLocales {
+		if l == locale {
+			return l
+		}
+	}
+	return DefaultLocale
+}
+
+// Renderer собирает письмо из трех файлов шаблона: <name>.subject.txt, <name>.txt и <name>.html
+type Renderer struct {
+	fsys fs.FS
+}
+
+func NewRenderer() *Renderer {
+	sub, _ := fs.Sub(templateFS, "templates")
+	return &Renderer{fsys: sub}
+}
+
+// Render возвращает письмо на языке locale; если перевода нет, используется DefaultLocale
+func (r *Renderer) Render(locale, name string, data interface{}) (*Message, error) {
+	locale = NormalizeLocale(locale)
+	if _, err := fs.Stat(r.fsys, locale+"/"+name+".html"); err != nil {
+		locale = DefaultLocale
+	}
+
+	subject, err := r.renderText(locale+"/"+name+".subject.txt", data)
+	if err != nil {
+		return nil, err
+	}
+	text, err := r.renderText(locale+"/"+name+".txt", data)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlPath := locale + "/" + name + ".html"
+	tmpl, err := htmltemplate.ParseFS(r.fsys, htmlPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Template: name,
+		Locale:   locale,
+		Subject:  strings.TrimSpace(subject),
+		HTML:     html.String(),
+		Text:     text,
+	}, nil
+}
+
+func (r *Renderer) renderText(path string, data interface{}) (string, error) {
+	tmpl, err := texttemplate.ParseFS(r.fsys, path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrUnknownTemplate, path)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
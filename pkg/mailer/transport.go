@@ -0,0 +1,196 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/gomail.v2"
+)
+
+// Message - готовое к отправке письмо
+type Message struct {
+	To       string
+	Template string
+	Locale   string
+	Subject  string
+	HTML     string
+	Text     string
+}
+
+// Transport доставляет письмо получателю
+type Transport interface {
+	Send(msg *Message) error
+}
+
+// Config читается один раз при старте сервиса
+type Config struct {
+	Transport  string // smtp, capture, memory или log
+	CaptureDir string
+	SMTPHost   string
+	SMTPPort   int
+	SMTPUser   string
+	SMTPPass   string
+	From       string
+}
+
+// LoadConfig читает pkg/mailer/.env, а SMTP_* по-прежнему берет и из pkg/auth/.env,
+// где они лежали до появления очереди писем
+func LoadConfig() Config {
+	loaded := false
+	for _, path := range []string{"pkg/mailer/.env", "pkg/auth/.env"} {
+		if err := godotenv.Load(path); err == nil {
+			loaded = true
+		}
+	}
+	if !loaded {
+		log.Println("Предупреждение: .env файл не найден, используются системные переменные окружения")
+	}
+
+	port, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	cfg := Config{
+		Transport:  os.Getenv("MAIL_TRANSPORT"),
+		CaptureDir: os.Getenv("MAIL_CAPTURE_DIR"),
+		SMTPHost:   os.Getenv("SMTP_HOST"),
+		SMTPPort:   port,
+		SMTPUser:   os.Getenv("SMTP_USER"),
+		SMTPPass:   os.Getenv("SMTP_PASSWORD"),
+		From:       os.Getenv("MAIL_FROM"),
+	}
+	if cfg.Transport == "" {
+		cfg.Transport = "smtp"
+		if cfg.SMTPHost == "" {
+			cfg.Transport = "log"
+		}
+	}
+	if cfg.CaptureDir == "" {
+		cfg.CaptureDir = "tmp/mail"
+	}
+	if cfg.From == "" {
+		cfg.From = cfg.SMTPUser
+	}
+	return cfg
+}
+
+// NewTransport выбирает транспорт по MAIL_TRANSPORT
+func NewTransport(cfg Config) (Transport, error) {
+	switch cfg.Transport {
+	case "smtp":
+		if cfg.SMTPHost == "" || cfg.SMTPPort == 0 {
+			// Без почты сервис должен подниматься: письма только пишутся в лог
+			log.Println("Предупреждение: не заданы SMTP_HOST или SMTP_PORT, письма не отправляются")
+			return NewLogTransport(), nil
+		}
+		return NewSMTPTransport(cfg), nil
+	case "capture":
+		return NewCaptureTransport(cfg.CaptureDir)
+	case "memory":
+		return NewMemoryTransport(), nil
+	case "log":
+		return NewLogTransport(), nil
+	}
+	return nil, fmt.Errorf("неизвестный транспорт почты: %s", cfg.Transport)
+}
+
+type SMTPTransport struct {
+	dialer *gomail.Dialer
+	from   string
+}
+
+func NewSMTPTransport(cfg Config) *SMTPTransport {
+	return &SMTPTransport{
+		dialer: gomail.NewDialer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPass),
+		from:   cfg.From,
+	}
+}
+
+func (t *SMTPTransport) Send(msg *Message) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", t.from)
+	m.SetHeader("To", msg.To)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.Text)
+	m.AddAlternative("text/html", msg.HTML)
+
+	if err := t.dialer.DialAndSend(m); err != nil {
+		return fmt.Errorf("ошибка отправки письма: %v", err)
+	}
+	return nil
+}
+
+// CaptureTransport складывает письма файлами .eml в каталог вместо отправки (для разработки)
+type CaptureTransport struct {
+	dir string
+	mu  sync.Mutex
+	seq int
+}
+
+func NewCaptureTransport(dir string) (*CaptureTransport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &CaptureTransport{dir: dir}, nil
+}
+
+func (t *CaptureTransport) Send(msg *Message) error {
+	t.mu.Lock()
+	t.seq++
+	name := fmt.Sprintf("%s-%03d-%s.eml", time.Now().Format("20060102-150405"), t.seq, msg.Template)
+	t.mu.Unlock()
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", "capture@localhost")
+	m.SetHeader("To", msg.To)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.Text)
+	m.AddAlternative("text/html", msg.HTML)
+
+	f, err := os.Create(filepath.Join(t.dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = m.WriteTo(f)
+	return err
+}
+
+// LogTransport ничего не отправляет, только пишет в лог получателя и тему
+type LogTransport struct{}
+
+func NewLogTransport() *LogTransport {
+	return &LogTransport{}
+}
+
+func (t *LogTransport) Send(msg *Message) error {
+	log.Printf("письмо не отправлено (почта не настроена): %s -> %s: %s", msg.Template, msg.To, msg.Subject)
+	return nil
+}
+
+// MemoryTransport хранит письма в памяти
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (t *MemoryTransport) Send(msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = append(t.messages, *msg)
+	return nil
+}
+
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Message(nil), t.messages...)
+}
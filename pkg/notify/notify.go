@@ -0,0 +1,224 @@
+package notify
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Bossnicks/music-streaming-service-kurs/pkg/realtime"
+	"github.com/lib/pq"
+)
+
+// Типы уведомлений
+const (
+	TypeFollow         = "follow"
+	TypeFollowRequest  = "follow_request"
+	TypeLike           = "like"
+	TypeRepost         = "repost"
+	TypeComment        = "comment"
+	TypeAlbumRelease   = "album_release"
+	TypeAlbumAnnounced = "album_announced"
+)
+
+// Event - событие для одного получателя. Data сохраняется как есть и отдается клиенту
+type Event struct {
+	RecipientID int
+	ActorID     int
+	Type        string
+	TargetType  string
+	TargetID    int
+	Data        map[string]interface{}
+}
+
+// groupKey: лайки и репосты группируются по треку, подписки - по получателю,
+// остальные события не группируются (ключ включает автора события и время)
+func (e Event) groupKey() string {
+	switch e.Type {
+	case TypeLike, TypeRepost:
+		return fmt.Sprintf("%s:%s:%d", e.Type, e.TargetType, e.TargetID)
+	case TypeFollow, TypeFollowRequest:
+		return e.Type
+	}
+	return fmt.Sprintf("%s:%s:%d:%d:%d", e.Type, e.TargetType, e.TargetID, e.ActorID, time.Now().UnixNano())
+}
+
+// Notifier записывает уведомления. Ошибки только логируются: уведомление не должно ломать действие пользователя
+type Notifier struct {
+	db        *sql.DB
+	publisher *realtime.Publisher
+}
+
+func NewNotifier(db *sql.DB) *Notifier {
+	return &Notifier{db: db, publisher: realtime.NewPublisher(db)}
+}
+
+// Emit создает уведомление или добавляет участника в непрочитанную группу.
+// Себе, заблокированным и скрытым пользователям уведомления не отправляются
+func (n *Notifier) Emit(e Event) {
+	if err := n.emit(e); err != nil {
+		log.Printf("ошибка создания уведомления %s для %d: %v", e.Type, e.RecipientID, err)
+	}
+}
+
+func (n *Notifier) emit(e Event) error {
+	if e.RecipientID == 0 || e.RecipientID == e.ActorID {
+		return nil
+	}
+
+	var silenced bool
+	err := n.db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2)
+		    OR EXISTS (SELECT 1 FROM user_mutes WHERE muter_id = $1 AND muted_id = $2)`,
+		e.RecipientID, e.ActorID).Scan(&silenced)
+	if err != nil || silenced {
+		return err
+	}
+
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+
+	tx, err := n.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO notifications (recipient_id, type, target_type, target_id, group_key, data)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (recipient_id, group_key) WHERE NOT is_read
+		DO UPDATE SET data = EXCLUDED.data, updated_at = NOW()
+		RETURNING id`,
+		e.RecipientID, e.Type, e.TargetType, e.TargetID, e.groupKey(), data).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	if e.ActorID != 0 {
+		if _, err := tx.Exec(`
+			INSERT INTO notification_actors (notification_id, actor_id) VALUES ($1, $2)
+			ON CONFLICT (notification_id, actor_id) DO UPDATE SET created_at = NOW()`, id, e.ActorID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	unread, err := n.unreadCount(e.RecipientID)
+	if err != nil {
+		return err
+	}
+	n.publisher.Publish(realtime.UserTopic(e.RecipientID), realtime.TypeNotification, map[string]interface{}{
+		"id":           id,
+		"type":         e.Type,
+		"target_type":  e.TargetType,
+		"target_id":    e.TargetID,
+		"unread_count": unread,
+	})
+	return nil
+}
+
+// SyncUnreadCount отправляет клиентам получателя актуальный счетчик после прочтения
+func (n *Notifier) SyncUnreadCount(recipientID int) {
+	unread, err := n.unreadCount(recipientID)
+	if err != nil {
+		log.Printf("ошибка подсчета уведомлений %d: %v", recipientID, err)
+		return
+	}
+	n.publisher.Publish(realtime.UserTopic(recipientID), realtime.TypeUnreadCount, map[string]int{"unread_count": unread})
+}
+
+func (n *Notifier) unreadCount(recipientID int) (int, error) {
+	var count int
+	err := n.db.QueryRow("SELECT COUNT(*) FROM notifications WHERE recipient_id = $1 AND NOT is_read", recipientID).Scan(&count)
+	return count, err
+}
+
+// EmitToFollowers рассылает событие всем подписчикам автора (новый альбом), кроме except -
+// им отправлено отдельное уведомление. Уведомления создаются одним запросом, без цикла по подписчикам
+func (n *Notifier) EmitToFollowers(authorID int, e Event, except ...int) {
+	if err := n.emitToFollowers(authorID, e, except); err != nil {
+		log.Printf("ошибка рассылки %s подписчикам %d: %v", e.Type, authorID, err)
+	}
+}
+
+func (n *Notifier) emitToFollowers(authorID int, e Event, except []int) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	e.ActorID = authorID
+
+	rows, err := n.db.Query(`
+		WITH recipients AS (
+			SELECT f.following_user_id AS id
+			FROM follows f
+			WHERE f.followed_user_id = $1
+			  AND f.following_user_id <> $1
+			  AND NOT f.following_user_id = ANY(COALESCE($7::int[], '{}'))
+			  AND NOT EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = f.following_user_id AND blocked_id = $1)
+			  AND NOT EXISTS (SELECT 1 FROM user_mutes WHERE muter_id = f.following_user_id AND muted_id = $1)
+		), created AS (
+			INSERT INTO notifications (recipient_id, type, target_type, target_id, group_key, data)
+			SELECT id, $2, $3, $4, $5, $6 FROM recipients
+			ON CONFLICT (recipient_id, group_key) WHERE NOT is_read
+			DO UPDATE SET data = EXCLUDED.data, updated_at = NOW()
+			RETURNING id, recipient_id
+		), actors AS (
+			INSERT INTO notification_actors (notification_id, actor_id)
+			SELECT id, $1 FROM created
+			ON CONFLICT (notification_id, actor_id) DO UPDATE SET created_at = NOW()
+		)
+		SELECT id, recipient_id FROM created`,
+		authorID, e.Type, e.TargetType, e.TargetID, e.groupKey(), data, pq.Array(except))
+	if err != nil {
+		return err
+	}
+
+	created := make(map[int]int)
+	var recipients []int
+	for rows.Next() {
+		var id, recipientID int
+		if err := rows.Scan(&id, &recipientID); err != nil {
+			rows.Close()
+			return err
+		}
+		created[recipientID] = id
+		recipients = append(recipients, recipientID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(recipients) == 0 {
+		return err
+	}
+
+	// Счетчики непрочитанных - тоже одним запросом
+	rows, err = n.db.Query(`
+		SELECT recipient_id, COUNT(*) FROM notifications
+		WHERE recipient_id = ANY($1) AND NOT is_read
+		GROUP BY recipient_id`, pq.Array(recipients))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var recipientID, unread int
+		if err := rows.Scan(&recipientID, &unread); err != nil {
+			return err
+		}
+		n.publisher.Publish(realtime.UserTopic(recipientID), realtime.TypeNotification, map[string]interface{}{
+			"id":           created[recipientID],
+			"type":         e.Type,
+			"target_type":  e.TargetType,
+			"target_id":    e.TargetID,
+			"unread_count": unread,
+		})
+	}
+	return rows.Err()
+}
@@ -0,0 +1,152 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// FakeIssuer - минимальный OpenID Connect провайдер для разработки и проверки входа.
+// Авторизует без страницы входа пользователя из User и выдает ID-токены, подписанные RS256.
+// Issuer нужно выставить в адрес, на котором запущен сервер.
+type FakeIssuer struct {
+	Issuer   string
+	ClientID string
+	User     Identity
+
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]fakeGrant
+}
+
+type fakeGrant struct {
+	identity      Identity
+	nonce         string
+	codeChallenge string
+	redirectURI   string
+}
+
+func NewFakeIssuer(clientID string, user Identity) (*FakeIssuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &FakeIssuer{ClientID: clientID, User: user, key: key, codes: make(map[string]fakeGrant)}, nil
+}
+
+func (f *FakeIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJSON(w, http.StatusOK, discoveryDocument{
+			Issuer:                f.Issuer,
+			AuthorizationEndpoint: f.Issuer + "/authorize",
+			TokenEndpoint:         f.Issuer + "/token",
+			JWKSURI:               f.Issuer + "/jwks",
+		})
+
+	case "/jwks":
+		writeJSON(w, http.StatusOK, map[string][]jsonWebKey{"keys": {{
+			Kid: "fake",
+			Kty: "RSA",
+			Alg: "RS256",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}}})
+
+	case "/authorize":
+		f.authorize(w, r)
+
+	case "/token":
+		f.token(w, r)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorize сразу возвращает пользователя на redirect_uri с кодом и исходным state
+func (f *FakeIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != f.ClientID || q.Get("code_challenge_method") != "S256" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code, err := RandomString()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	f.mu.Lock()
+	f.codes[code] = fakeGrant{
+		identity:      f.User,
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		redirectURI:   q.Get("redirect_uri"),
+	}
+	f.mu.Unlock()
+
+	params := url.Values{}
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+params.Encode(), http.StatusFound)
+}
+
+// token обменивает одноразовый код на ID-токен, проверяя PKCE и redirect_uri
+func (f *FakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	f.mu.Lock()
+	grant, ok := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	f.mu.Unlock()
+
+	if !ok || r.PostForm.Get("client_id") != f.ClientID ||
+		r.PostForm.Get("redirect_uri") != grant.redirectURI ||
+		CodeChallengeS256(r.PostForm.Get("code_verifier")) != grant.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, idTokenClaims{
+		Nonce:             grant.nonce,
+		Email:             grant.identity.Email,
+		EmailVerified:     grant.identity.EmailVerified,
+		Name:              grant.identity.Name,
+		PreferredUsername: grant.identity.Username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    f.Issuer,
+			Subject:   grant.identity.Subject,
+			Audience:  jwt.ClaimStrings{f.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	})
+	token.Header["kid"] = "fake"
+	signed, err := token.SignedString(f.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,125 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const testRedirectURL = "https://app.example/beatstreet/api/users/oidc/fake/callback"
+
+func startFakeIssuer(t *testing.T) *DiscoveryProvider {
+	t.Helper()
+
+	issuer, err := NewFakeIssuer("beatstreet", Identity{
+		Subject:       "user-42",
+		Email:         "Listener@Example.com",
+		EmailVerified: true,
+		Name:          "Listener",
+		Username:      "listener",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(issuer)
+	t.Cleanup(srv.Close)
+	issuer.Issuer = srv.URL
+
+	provider := NewDiscoveryProvider(ProviderConfig{
+		Name:        "fake",
+		Issuer:      srv.URL,
+		ClientID:    "beatstreet",
+		RedirectURL: testRedirectURL,
+		HTTPClient:  srv.Client(),
+	})
+	return provider
+}
+
+// authorize проходит страницу авторизации и возвращает параметры callback
+func authorize(t *testing.T, provider *DiscoveryProvider, state, nonce, verifier string) url.Values {
+	t.Helper()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, CodeChallengeS256(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: статус %d", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := location.Scheme + "://" + location.Host + location.Path; got != testRedirectURL {
+		t.Fatalf("redirect на %s, ожидался %s", got, testRedirectURL)
+	}
+	return location.Query()
+}
+
+func TestDiscoveryProviderExchange(t *testing.T) {
+	provider := startFakeIssuer(t)
+
+	callback := authorize(t, provider, "state-1", "nonce-1", "verifier-1")
+	if callback.Get("state") != "state-1" {
+		t.Fatalf("state = %q", callback.Get("state"))
+	}
+
+	identity, err := provider.Exchange(context.Background(), callback.Get("code"), "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{
+		Provider:      "fake",
+		Subject:       "user-42",
+		Email:         "listener@example.com",
+		EmailVerified: true,
+		Name:          "Listener",
+		Username:      "listener",
+	}
+	if *identity != want {
+		t.Fatalf("identity = %+v, ожидалось %+v", *identity, want)
+	}
+
+	// Код одноразовый
+	if _, err := provider.Exchange(context.Background(), callback.Get("code"), "verifier-1", "nonce-1"); err == nil {
+		t.Fatal("повторный обмен кода должен завершиться ошибкой")
+	}
+}
+
+func TestDiscoveryProviderExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		verifier string
+		nonce    string
+	}{
+		{name: "чужой code_verifier", verifier: "other", nonce: "nonce-1"},
+		{name: "nonce не совпадает", verifier: "verifier-1", nonce: "other"},
+		{name: "неизвестный код", code: "forged", verifier: "verifier-1", nonce: "nonce-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := startFakeIssuer(t)
+			callback := authorize(t, provider, "state-1", "nonce-1", "verifier-1")
+
+			code := callback.Get("code")
+			if tt.code != "" {
+				code = tt.code
+			}
+			if _, err := provider.Exchange(context.Background(), code, tt.verifier, tt.nonce); err == nil {
+				t.Fatal("ожидалась ошибка")
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package playlistfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// writeM3U пишет extended M3U: #EXTINF с длительностью и "Артист - Название", затем адрес
+func writeM3U(w io.Writer, p Playlist) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#EXTM3U")
+	if p.Title != "" {
+		fmt.Fprintf(bw, "#PLAYLIST:%s\n", oneLine(p.Title))
+	}
+	for _, e := range p.Entries {
+		duration := e.Duration
+		if duration <= 0 {
+			duration = -1
+		}
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n", duration, oneLine(displayName(e)))
+		if e.Album != "" {
+			fmt.Fprintf(bw, "#EXTALB:%s\n", oneLine(e.Album))
+		}
+		fmt.Fprintln(bw, e.Location)
+	}
+	return bw.Flush()
+}
+
+func displayName(e Entry) string {
+	if e.Artist == "" {
+		return e.Title
+	}
+	return e.Artist + " - " + e.Title
+}
+
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// parseM3U понимает и простой, и extended M3U. Без названия в #EXTINF артист и название берутся из имени файла
+func parseM3U(r io.Reader) (*Playlist, error) {
+	p := &Playlist{}
+	var pending *Entry
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	firstLine := true
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if firstLine {
+			line = strings.TrimPrefix(line, "\ufeff")
+			firstLine = false
+		}
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			pending = parseExtInf(strings.TrimPrefix(line, "#EXTINF:"))
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			p.Title = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#EXTALB:"):
+			if pending != nil {
+				pending.Album = strings.TrimSpace(strings.TrimPrefix(line, "#EXTALB:"))
+			}
+		case strings.HasPrefix(line, "#EXTART:"):
+			if pending != nil && pending.Artist == "" {
+				pending.Artist = strings.TrimSpace(strings.TrimPrefix(line, "#EXTART:"))
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			e := Entry{}
+			if pending != nil {
+				e = *pending
+			}
+			if e.Title == "" {
+				e.Artist, e.Title = splitDisplayName(nameFromLocation(line))
+			}
+			e.Location = line
+			p.Entries = append(p.Entries, e)
+			pending = nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseExtInf: "123 tvg-id=\"x\",Артист - Название"; атрибуты между длительностью и запятой игнорируются
+func parseExtInf(s string) *Entry {
+	e := &Entry{}
+	info, name := s, ""
+	quoted := false
+	for i, r := range s {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ',' && !quoted {
+			info, name = s[:i], s[i+1:]
+			break
+		}
+	}
+	if fields := strings.Fields(info); len(fields) > 0 {
+		if d, err := strconv.ParseFloat(fields[0], 64); err == nil && d > 0 {
+			e.Duration = int(d + 0.5)
+		}
+	}
+	e.Artist, e.Title = splitDisplayName(strings.TrimSpace(name))
+	return e
+}
+
+func splitDisplayName(name string) (artist, title string) {
+	if a, t, ok := strings.Cut(name, " - "); ok {
+		return strings.TrimSpace(a), strings.TrimSpace(t)
+	}
+	return "", strings.TrimSpace(name)
+}
+
+func nameFromLocation(location string) string {
+	if u, err := url.Parse(location); err == nil && u.Path != "" {
+		location = u.Path
+	}
+	location = strings.ReplaceAll(location, "\\", "/")
+	base := path.Base(location)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
@@ -0,0 +1,117 @@
+package playlistfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Поддерживаемые форматы
+const (
+	FormatM3U8 = "m3u8"
+	FormatXSPF = "xspf"
+	FormatJSPF = "jspf"
+)
+
+var Formats = []string{FormatM3U8, FormatXSPF, FormatJSPF}
+
+// MaxEntries ограничивает размер импортируемого плейлиста
+const MaxEntries = 10000
+
+var (
+	ErrUnknownFormat = errors.New("неизвестный формат плейлиста")
+	ErrTooManyItems  = fmt.Errorf("в плейлисте больше %d треков", MaxEntries)
+	ErrEmpty         = errors.New("в файле не найдено ни одного трека")
+)
+
+// Entry - трек плейлиста. Duration в секундах, 0 - неизвестна
+type Entry struct {
+	Title    string
+	Artist   string
+	Album    string
+	Duration int
+	Location string
+}
+
+type Playlist struct {
+	Title       string
+	Creator     string
+	Description string
+	Entries     []Entry
+}
+
+// FormatFromName определяет формат по расширению файла; .m3u тоже читается как m3u8
+func FormatFromName(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".m3u", ".m3u8":
+		return FormatM3U8
+	case ".xspf":
+		return FormatXSPF
+	case ".jspf", ".json":
+		return FormatJSPF
+	}
+	return ""
+}
+
+func ContentType(format string) string {
+	switch format {
+	case FormatM3U8:
+		return "audio/x-mpegurl; charset=utf-8"
+	case FormatXSPF:
+		return "application/xspf+xml; charset=utf-8"
+	}
+	return "application/json; charset=utf-8"
+}
+
+func Write(format string, w io.Writer, p Playlist) error {
+	switch format {
+	case FormatM3U8:
+		return writeM3U(w, p)
+	case FormatXSPF:
+		return writeXSPF(w, p)
+	case FormatJSPF:
+		return writeJSPF(w, p)
+	}
+	return ErrUnknownFormat
+}
+
+// Parse разбирает плейлист; записи без названия и адреса пропускаются
+func Parse(format string, r io.Reader) (*Playlist, error) {
+	var p *Playlist
+	var err error
+	switch format {
+	case FormatM3U8:
+		p, err = parseM3U(r)
+	case FormatXSPF:
+		p, err = parseXSPF(r)
+	case FormatJSPF:
+		p, err = parseJSPF(r)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := p.Entries[:0]
+	for _, e := range p.Entries {
+		e.Title = strings.TrimSpace(e.Title)
+		e.Artist = strings.TrimSpace(e.Artist)
+		e.Location = strings.TrimSpace(e.Location)
+		if e.Title == "" && e.Location == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	p.Entries = entries
+
+	if len(p.Entries) == 0 {
+		return nil, ErrEmpty
+	}
+	if len(p.Entries) > MaxEntries {
+		return nil, ErrTooManyItems
+	}
+	return p, nil
+}
@@ -0,0 +1,183 @@
+package playlistfile
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func samplePlaylist() Playlist {
+	return Playlist{
+		Title:       "Ночная поездка",
+		Creator:     "listener",
+		Description: "для дороги",
+		Entries: []Entry{
+			{Title: "Intro", Artist: "Artist A", Album: "First", Duration: 185, Location: "https://example.com/tracks/1"},
+			{Title: "Без артиста", Duration: 60, Location: "https://example.com/tracks/2"},
+			{Title: "Без адреса", Artist: "Artist B"},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range Formats {
+		t.Run(format, func(t *testing.T) {
+			want := samplePlaylist()
+			if format == FormatM3U8 {
+				// M3U не хранит автора и описание, а запись без адреса в нем не выразить
+				want.Creator, want.Description = "", ""
+				want.Entries = want.Entries[:2]
+			}
+
+			var buf bytes.Buffer
+			if err := Write(format, &buf, samplePlaylist()); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Parse(format, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, want) {
+				t.Fatalf("после записи и чтения:\n%+v\nожидалось:\n%+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestParseM3U(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Entry
+	}{
+		{
+			name:  "простой список путей",
+			input: "C:\\Music\\Artist A - Song One.mp3\n/home/user/music/Song Two.flac\n",
+			want: []Entry{
+				{Artist: "Artist A", Title: "Song One", Location: "C:\\Music\\Artist A - Song One.mp3"},
+				{Title: "Song Two", Location: "/home/user/music/Song Two.flac"},
+			},
+		},
+		{
+			name:  "BOM, атрибуты и дробная длительность",
+			input: "\ufeff#EXTM3U\n#EXTINF:123.6 tvg-name=\"a,b\",Artist - Title\n#EXTALB:Album\nhttps://cdn.example/x.mp3?sig=1\n",
+			want: []Entry{
+				{Artist: "Artist", Title: "Title", Album: "Album", Duration: 124, Location: "https://cdn.example/x.mp3?sig=1"},
+			},
+		},
+		{
+			name:  "отрицательная длительность и #EXTART",
+			input: "#EXTM3U\n#EXTINF:-1,Title Only\n#EXTART:Somebody\nstream.mp3\n",
+			want: []Entry{
+				{Artist: "Somebody", Title: "Title Only", Location: "stream.mp3"},
+			},
+		},
+		{
+			name:  "пустой #EXTINF берет название из адреса",
+			input: "#EXTINF:30,\nhttps://example.com/a/Band%20-%20Song.ogg\n",
+			want: []Entry{
+				{Artist: "Band", Title: "Song", Duration: 30, Location: "https://example.com/a/Band%20-%20Song.ogg"},
+			},
+		},
+		{
+			name:  "#EXTINF не переходит на следующую запись",
+			input: "#EXTINF:10,A - B\none.mp3\ntwo.mp3\n",
+			want: []Entry{
+				{Artist: "A", Title: "B", Duration: 10, Location: "one.mp3"},
+				{Title: "two", Location: "two.mp3"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(FormatM3U8, strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.Entries, tt.want) {
+				t.Fatalf("записи %+v, ожидалось %+v", p.Entries, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteM3UKeepsOneLinePerEntry(t *testing.T) {
+	var buf bytes.Buffer
+	err := Write(FormatM3U8, &buf, Playlist{
+		Title:   "a\nb",
+		Entries: []Entry{{Title: "x\r\n#EXTINF:1,evil", Location: "https://example.com/1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "#EXTM3U\n#PLAYLIST:a b\n#EXTINF:-1,x  #EXTINF:1,evil\nhttps://example.com/1\n"
+	if buf.String() != want {
+		t.Fatalf("получено:\n%q\nожидалось:\n%q", buf.String(), want)
+	}
+}
+
+func TestParseJSPFLocationAsString(t *testing.T) {
+	input := `{"playlist": {"title": "LB", "track": [
+		{"title": "One", "creator": "A", "location": "https://example.com/1", "duration": 1500},
+		{"title": "Two", "location": ["https://example.com/2", "https://mirror.example/2"]}
+	]}}`
+	p, err := Parse(FormatJSPF, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Title: "One", Artist: "A", Duration: 1, Location: "https://example.com/1"},
+		{Title: "Two", Location: "https://example.com/2"},
+	}
+	if !reflect.DeepEqual(p.Entries, want) {
+		t.Fatalf("записи %+v, ожидалось %+v", p.Entries, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		wantErr error
+	}{
+		{name: "неизвестный формат", format: "pls", input: "[playlist]", wantErr: ErrUnknownFormat},
+		{name: "только комментарии", format: FormatM3U8, input: "#EXTM3U\n#EXTINF:1,\n", wantErr: ErrEmpty},
+		{name: "записи без названия и адреса", format: FormatJSPF, input: `{"playlist": {"track": [{"album": "x"}, {"title": "  "}]}}`, wantErr: ErrEmpty},
+		{name: "слишком много треков", format: FormatM3U8, input: strings.Repeat("a.mp3\n", MaxEntries+1), wantErr: ErrTooManyItems},
+		{name: "битый XML", format: FormatXSPF, input: "<playlist><trackList>"},
+		{name: "битый JSON", format: FormatJSPF, input: `{"playlist": [`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.format, strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatal("ожидалась ошибка")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormatFromName(t *testing.T) {
+	tests := map[string]string{
+		"mix.m3u":           FormatM3U8,
+		"MIX.M3U8":          FormatM3U8,
+		"export.xspf":       FormatXSPF,
+		"listenbrainz.json": FormatJSPF,
+		"list.jspf":         FormatJSPF,
+		"list.pls":          "",
+		"noext":             "",
+	}
+	for name, want := range tests {
+		if got := FormatFromName(name); got != want {
+			t.Errorf("FormatFromName(%q) = %q, ожидалось %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package playlistfile
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+)
+
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+// XSPF: https://xspf.org/spec. Длительность в формате - в миллисекундах
+type xspfPlaylist struct {
+	XMLName    xml.Name    `xml:"playlist"`
+	Version    string      `xml:"version,attr"`
+	Namespace  string      `xml:"xmlns,attr"`
+	Title      string      `xml:"title,omitempty"`
+	Creator    string      `xml:"creator,omitempty"`
+	Annotation string      `xml:"annotation,omitempty"`
+	Tracks     []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Locations []string `xml:"location"`
+	Title     string   `xml:"title,omitempty"`
+	Creator   string   `xml:"creator,omitempty"`
+	Album     string   `xml:"album,omitempty"`
+	Duration  int      `xml:"duration,omitempty"`
+}
+
+func writeXSPF(w io.Writer, p Playlist) error {
+	doc := xspfPlaylist{
+		Version:    "1",
+		Namespace:  xspfNamespace,
+		Title:      p.Title,
+		Creator:    p.Creator,
+		Annotation: p.Description,
+	}
+	for _, e := range p.Entries {
+		t := xspfTrack{Title: e.Title, Creator: e.Artist, Album: e.Album, Duration: e.Duration * 1000}
+		if e.Location != "" {
+			t.Locations = []string{e.Location}
+		}
+		doc.Tracks = append(doc.Tracks, t)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+func parseXSPF(r io.Reader) (*Playlist, error) {
+	var doc xspfPlaylist
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	p := &Playlist{Title: doc.Title, Creator: doc.Creator, Description: doc.Annotation}
+	for _, t := range doc.Tracks {
+		p.Entries = append(p.Entries, Entry{
+			Title:    t.Title,
+			Artist:   t.Creator,
+			Album:    t.Album,
+			Duration: t.Duration / 1000,
+			Location: first(t.Locations),
+		})
+	}
+	return p, nil
+}
+
+// JSPF - XSPF в JSON, в таком виде плейлисты отдает ListenBrainz
+type jspfDocument struct {
+	Playlist jspfPlaylist `json:"playlist"`
+}
+
+type jspfPlaylist struct {
+	Title      string      `json:"title,omitempty"`
+	Creator    string      `json:"creator,omitempty"`
+	Annotation string      `json:"annotation,omitempty"`
+	Tracks     []jspfTrack `json:"track"`
+}
+
+type jspfTrack struct {
+	Locations stringList `json:"location,omitempty"`
+	Title     string     `json:"title,omitempty"`
+	Creator   string     `json:"creator,omitempty"`
+	Album     string     `json:"album,omitempty"`
+	Duration  int        `json:"duration,omitempty"`
+}
+
+// stringList - по спецификации location это массив, но часть программ пишет строку
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*l = stringList{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*l = many
+	return nil
+}
+
+func writeJSPF(w io.Writer, p Playlist) error {
+	doc := jspfDocument{Playlist: jspfPlaylist{
+		Title:      p.Title,
+		Creator:    p.Creator,
+		Annotation: p.Description,
+		Tracks:     []jspfTrack{},
+	}}
+	for _, e := range p.Entries {
+		t := jspfTrack{Title: e.Title, Creator: e.Artist, Album: e.Album, Duration: e.Duration * 1000}
+		if e.Location != "" {
+			t.Locations = stringList{e.Location}
+		}
+		doc.Playlist.Tracks = append(doc.Playlist.Tracks, t)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func parseJSPF(r io.Reader) (*Playlist, error) {
+	var doc jspfDocument
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	pl := doc.Playlist
+	p := &Playlist{Title: pl.Title, Creator: pl.Creator, Description: pl.Annotation}
+	for _, t := range pl.Tracks {
+		p.Entries = append(p.Entries, Entry{
+			Title:    t.Title,
+			Artist:   t.Creator,
+			Album:    t.Album,
+			Duration: t.Duration / 1000,
+			Location: first(t.Locations),
+		})
+	}
+	return p, nil
+}
+
+func first(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return list[0]
+}
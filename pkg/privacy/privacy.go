@@ -0,0 +1,38 @@
+package privacy
+
+// Settings - настройки приватности пользователя
+type Settings struct {
+	PrivateProfile         bool `json:"private_profile"`
+	HideFollows            bool `json:"hide_follows"`
+	PrivateHistory         bool `json:"private_history"`
+	PlaylistsFollowersOnly bool `json:"playlists_followers_only"`
+}
+
+// Access - кем смотрящий приходится владельцу профиля
+type Access struct {
+	Owner    bool
+	Admin    bool
+	Follower bool
+}
+
+func (a Access) full() bool {
+	return a.Owner || a.Admin
+}
+
+// CanViewProfile: закрытый профиль видят только подписчики
+func (s Settings) CanViewProfile(a Access) bool {
+	return a.full() || !s.PrivateProfile || a.Follower
+}
+
+// CanViewFollows: скрытые списки подписок видит только владелец
+func (s Settings) CanViewFollows(a Access) bool {
+	return a.full() || (!s.HideFollows && s.CanViewProfile(a))
+}
+
+func (s Settings) CanViewHistory(a Access) bool {
+	return a.full() || (!s.PrivateHistory && s.CanViewProfile(a))
+}
+
+func (s Settings) CanViewPlaylists(a Access) bool {
+	return a.full() || (s.CanViewProfile(a) && (!s.PlaylistsFollowersOnly || a.Follower))
+}
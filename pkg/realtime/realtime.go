@@ -0,0 +1,171 @@
+package realtime
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Все события идут через один канал Postgres; получатель фильтрует их по Topic.
+// Так сервисы публикуют события, не зная друг о друге
+const channel = "realtime"
+
+// pg_notify ограничивает payload 8000 байтами
+const maxPayload = 7900
+
+const subscriptionBuffer = 32
+
+// Типы событий
+const (
+	TypeNotification  = "notification"
+	TypeUnreadCount   = "unread_count"
+	TypeTrackCounters = "track_counters"
+	TypeComment       = "comment"
+	// TypeResync отправляется после переподключения к БД: часть событий могла потеряться
+	TypeResync = "resync"
+)
+
+type Event struct {
+	Topic string          `json:"topic"`
+	Type  string          `json:"type"`
+	Data  json.RawMessage `json:"data"`
+}
+
+func UserTopic(userID int) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+func TrackTopic(trackID int) string {
+	return fmt.Sprintf("track:%d", trackID)
+}
+
+// Publisher отправляет события через pg_notify. Ошибки только логируются
+type Publisher struct {
+	db *sql.DB
+}
+
+func NewPublisher(db *sql.DB) *Publisher {
+	return &Publisher{db: db}
+}
+
+func (p *Publisher) Publish(topic, eventType string, data interface{}) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("ошибка сериализации события %s: %v", eventType, err)
+		return
+	}
+	payload, err := json.Marshal(Event{Topic: topic, Type: eventType, Data: raw})
+	if err != nil {
+		log.Printf("ошибка сериализации события %s: %v", eventType, err)
+		return
+	}
+	if len(payload) > maxPayload {
+		log.Printf("событие %s для %s слишком большое (%d байт), пропущено", eventType, topic, len(payload))
+		return
+	}
+	if _, err := p.db.Exec("SELECT pg_notify($1, $2)", channel, string(payload)); err != nil {
+		log.Printf("ошибка публикации события %s: %v", eventType, err)
+	}
+}
+
+// Subscription получает события выбранных топиков. Медленный клиент теряет события, а не тормозит остальных
+type Subscription struct {
+	C      chan Event
+	topics map[string]bool
+}
+
+// Hub слушает канал Postgres и раздает события подпискам процесса
+type Hub struct {
+	listener *pq.Listener
+	mu       sync.RWMutex
+	subs     map[*Subscription]struct{}
+}
+
+func NewHub(dsn string) (*Hub, error) {
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("realtime: ошибка соединения с БД: %v", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &Hub{listener: listener, subs: map[*Subscription]struct{}{}}, nil
+}
+
+func (h *Hub) Subscribe(topics ...string) *Subscription {
+	sub := &Subscription{C: make(chan Event, subscriptionBuffer), topics: map[string]bool{}}
+	for _, t := range topics {
+		sub.topics[t] = true
+	}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+// Run раздает события до отмены ctx
+func (h *Hub) Run(ctx context.Context) {
+	defer h.listener.Close()
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-h.listener.Notify:
+			// nil приходит после переподключения
+			if n == nil {
+				h.broadcast(Event{Type: TypeResync, Data: json.RawMessage("{}")})
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+				log.Printf("realtime: некорректное событие: %v", err)
+				continue
+			}
+			h.dispatch(e)
+		case <-ping.C:
+			go h.listener.Ping()
+		}
+	}
+}
+
+func (h *Hub) dispatch(e Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		if sub.topics[e.Topic] {
+			send(sub, e)
+		}
+	}
+}
+
+func (h *Hub) broadcast(e Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		send(sub, e)
+	}
+}
+
+func send(sub *Subscription, e Event) {
+	select {
+	case sub.C <- e:
+	default:
+	}
+}
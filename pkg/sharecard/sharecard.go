@@ -0,0 +1,111 @@
+package sharecard
+
+import (
+	"bytes"
+	"text/template"
+	"unicode/utf8"
+)
+
+// Card - данные карточки для публикации в соцсетях (1080x1350, SVG)
+type Card struct {
+	Title    string
+	Subtitle string
+	Username string
+	Stats    []Stat
+	Lists    []List
+	Footer   string
+}
+
+type Stat struct {
+	Label string
+	Value string
+}
+
+type List struct {
+	Title string
+	Items []string
+}
+
+const (
+	maxListItems = 5
+	maxItemRunes = 32
+)
+
+var cardTemplate = template.Must(template.New("card").Funcs(template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+	"mul": func(a, b int) int { return a * b },
+}).Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="1080" height="1350" viewBox="0 0 1080 1350">
+  <defs>
+    <linearGradient id="bg" x1="0" y1="0" x2="1" y2="1">
+      <stop offset="0" stop-color="#1d1b4b"/>
+      <stop offset="1" stop-color="#c2185b"/>
+    </linearGradient>
+  </defs>
+  <rect width="1080" height="1350" fill="url(#bg)"/>
+  <g font-family="Inter, Arial, sans-serif" fill="#ffffff">
+    <text x="80" y="150" font-size="72" font-weight="700">{{.Title}}</text>
+    <text x="80" y="215" font-size="40" opacity="0.8">{{.Subtitle}}</text>
+    <text x="80" y="275" font-size="36" opacity="0.8">@{{.Username}}</text>
+    {{range $i, $s := .Stats}}
+    <text x="{{add 80 (mul $i 320)}}" y="400" font-size="64" font-weight="700">{{$s.Value}}</text>
+    <text x="{{add 80 (mul $i 320)}}" y="445" font-size="28" opacity="0.7">{{$s.Label}}</text>
+    {{end}}
+    {{range $i, $l := .Lists}}
+    <text x="{{add 80 (mul $i 480)}}" y="560" font-size="36" font-weight="700">{{$l.Title}}</text>
+    {{range $j, $item := $l.Items}}
+    <text x="{{add 80 (mul $i 480)}}" y="{{add 620 (mul $j 60)}}" font-size="32">{{add $j 1}}. {{$item}}</text>
+    {{end}}
+    {{end}}
+    <text x="80" y="1270" font-size="30" opacity="0.7">{{.Footer}}</text>
+  </g>
+</svg>
+`))
+
+// RenderSVG собирает карточку; длинные названия обрезаются, чтобы не вылезать за границы
+func RenderSVG(card Card) ([]byte, error) {
+	card.Title = xmlEscape(truncate(card.Title, maxItemRunes))
+	card.Subtitle = xmlEscape(truncate(card.Subtitle, 48))
+	card.Username = xmlEscape(truncate(card.Username, maxItemRunes))
+	card.Footer = xmlEscape(card.Footer)
+	if len(card.Stats) > 3 {
+		card.Stats = card.Stats[:3]
+	}
+	for i := range card.Stats {
+		card.Stats[i].Label = xmlEscape(card.Stats[i].Label)
+		card.Stats[i].Value = xmlEscape(card.Stats[i].Value)
+	}
+	if len(card.Lists) > 2 {
+		card.Lists = card.Lists[:2]
+	}
+	for i := range card.Lists {
+		card.Lists[i].Title = xmlEscape(card.Lists[i].Title)
+		items := card.Lists[i].Items
+		if len(items) > maxListItems {
+			items = items[:maxListItems]
+		}
+		escaped := make([]string, len(items))
+		for j, item := range items {
+			escaped[j] = xmlEscape(truncate(item, 22))
+		}
+		card.Lists[i].Items = escaped
+	}
+
+	var buf bytes.Buffer
+	if err := cardTemplate.Execute(&buf, card); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	template.HTMLEscape(&buf, []byte(s))
+	return buf.String()
+}
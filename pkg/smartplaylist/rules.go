@@ -0,0 +1,377 @@
+package smartplaylist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrInvalidRules = errors.New("некорректные правила умного плейлиста")
+
+const (
+	MatchAll = "all"
+	MatchAny = "any"
+)
+
+// SortRandom перемешивает треки при каждом пересчете
+const SortRandom = "random"
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 500
+	maxDepth     = 5
+	maxRules     = 50
+	maxListSize  = 50
+	maxDays      = 3650
+)
+
+// Node - условие или группа условий. У группы заданы Match и Rules, у условия - Field, Op и Value
+type Node struct {
+	Match string          `json:"match,omitempty"`
+	Rules []Node          `json:"rules,omitempty"`
+	Field string          `json:"field,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Definition - корневая группа и параметры выборки. Пример:
+//
+//	{"match": "all", "rules": [
+//	    {"field": "genre", "op": "eq", "value": "techno"},
+//	    {"field": "tempo_bpm", "op": "between", "value": [120, 130]},
+//	    {"field": "liked", "op": "is", "value": true},
+//	    {"field": "liked_at", "op": "in_last_days", "value": 30}
+//	 ], "sort": "plays", "order": "desc", "limit": 100}
+type Definition struct {
+	Node
+	Sort  string `json:"sort,omitempty"`
+	Order string `json:"order,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+type kind int
+
+const (
+	kindNumber kind = iota
+	kindText
+	kindDate
+	kindFlag
+)
+
+// field - SQL-выражение над треком t. Выражения берутся только из этого списка;
+// значения пользователя всегда уходят параметрами
+type field struct {
+	kind kind
+	expr func(b *builder) string
+}
+
+func column(k kind, expr string) field {
+	return field{kind: k, expr: func(*builder) string { return expr }}
+}
+
+// perUser - выражение относительно владельца плейлиста; %[1]s заменяется на параметр с его id
+func perUser(k kind, format string) field {
+	return field{kind: k, expr: func(b *builder) string { return fmt.Sprintf(format, b.user()) }}
+}
+
+var fields = map[string]field{
+	// Признаки трека
+	"genre":              column(kindText, "t.genre"),
+	"title":              column(kindText, "t.title"),
+	"artist_id":          column(kindNumber, "t.author_id"),
+	"duration":           column(kindNumber, "t.duration"),
+	"created_at":         column(kindDate, "t.created_at"),
+	"tempo_bpm":          column(kindNumber, "t.tempo_bpm"),
+	"rmse_mean":          column(kindNumber, "t.rmse_mean"),
+	"spectral_centroid":  column(kindNumber, "t.spectral_centroid"),
+	"spectral_bandwidth": column(kindNumber, "t.spectral_bandwidth"),
+	"rolloff":            column(kindNumber, "t.rolloff"),
+	"zero_crossing_rate": column(kindNumber, "t.zero_crossing_rate"),
+	"chroma_mean":        column(kindNumber, "t.chroma_mean"),
+
+	// Общая статистика
+	"plays":   column(kindNumber, "(SELECT COUNT(*) FROM track_listens tl WHERE tl.track_id = t.id AND NOT tl.is_imported)"),
+	"likes":   column(kindNumber, "(SELECT COUNT(*) FROM likes l WHERE l.track_id = t.id)"),
+	"reposts": column(kindNumber, "(SELECT COUNT(*) FROM reposts r WHERE r.track_id = t.id)"),
+
+	// Действия владельца плейлиста
+	"liked":          perUser(kindFlag, "EXISTS (SELECT 1 FROM likes l WHERE l.track_id = t.id AND l.user_id = %[1]s)"),
+	"reposted":       perUser(kindFlag, "EXISTS (SELECT 1 FROM reposts r WHERE r.track_id = t.id AND r.user_id = %[1]s)"),
+	"listened":       perUser(kindFlag, "EXISTS (SELECT 1 FROM track_listens tl WHERE tl.track_id = t.id AND tl.listener_id = %[1]s)"),
+	"liked_at":       perUser(kindDate, "(SELECT l.created_at FROM likes l WHERE l.track_id = t.id AND l.user_id = %[1]s)"),
+	"reposted_at":    perUser(kindDate, "(SELECT r.created_at FROM reposts r WHERE r.track_id = t.id AND r.user_id = %[1]s)"),
+	"last_played_at": perUser(kindDate, "(SELECT MAX(tl.created_at) FROM track_listens tl WHERE tl.track_id = t.id AND tl.listener_id = %[1]s)"),
+	"my_plays":       perUser(kindNumber, "(SELECT COUNT(*) FROM track_listens tl WHERE tl.track_id = t.id AND tl.listener_id = %[1]s)"),
+}
+
+// Допустимые операторы для каждого типа поля
+var operators = map[kind][]string{
+	kindNumber: {"eq", "neq", "gt", "gte", "lt", "lte", "between", "in"},
+	kindText:   {"eq", "neq", "contains", "in"},
+	kindDate:   {"in_last_days", "not_in_last_days", "before", "after"},
+	kindFlag:   {"is"},
+}
+
+var comparisons = map[string]string{"eq": "=", "neq": "<>", "gt": ">", "gte": ">=", "lt": "<", "lte": "<="}
+
+// Validate проверяет дерево правил целиком; ошибка указывает путь к неверному узлу
+func (d *Definition) Validate() error {
+	if d.Limit == 0 {
+		d.Limit = DefaultLimit
+	}
+	if d.Limit < 1 || d.Limit > MaxLimit {
+		return fmt.Errorf("%w: limit должен быть от 1 до %d", ErrInvalidRules, MaxLimit)
+	}
+	if d.Sort != "" && d.Sort != SortRandom {
+		f, ok := fields[d.Sort]
+		if !ok || f.kind == kindFlag {
+			return fmt.Errorf("%w: нельзя сортировать по %q", ErrInvalidRules, d.Sort)
+		}
+	}
+	if d.Order != "" && d.Order != "asc" && d.Order != "desc" {
+		return fmt.Errorf("%w: order должен быть asc или desc", ErrInvalidRules)
+	}
+	if d.Match == "" && d.Field == "" {
+		d.Match = MatchAll
+	}
+
+	count := 0
+	// Пустой корень допустим: в плейлист попадают все треки по сортировке
+	if len(d.Rules) == 0 && d.Field == "" {
+		if d.Match != MatchAll && d.Match != MatchAny {
+			return fmt.Errorf("%w: match должен быть all или any", ErrInvalidRules)
+		}
+		return nil
+	}
+	return validateNode(&d.Node, "rules", 1, &count)
+}
+
+func validateNode(n *Node, path string, depth int, count *int) error {
+	if n.Field == "" {
+		if depth > maxDepth {
+			return fmt.Errorf("%w: %s: вложенность групп больше %d", ErrInvalidRules, path, maxDepth)
+		}
+		if n.Match != MatchAll && n.Match != MatchAny {
+			return fmt.Errorf("%w: %s: match должен быть all или any", ErrInvalidRules, path)
+		}
+		if len(n.Rules) == 0 {
+			return fmt.Errorf("%w: %s: пустая группа", ErrInvalidRules, path)
+		}
+		for i := range n.Rules {
+			if err := validateNode(&n.Rules[i], fmt.Sprintf("%s[%d]", path, i), depth+1, count); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	*count++
+	if *count > maxRules {
+		return fmt.Errorf("%w: больше %d условий", ErrInvalidRules, maxRules)
+	}
+	if n.Match != "" || len(n.Rules) > 0 {
+		return fmt.Errorf("%w: %s: условие не может одновременно быть группой", ErrInvalidRules, path)
+	}
+	f, ok := fields[n.Field]
+	if !ok {
+		return fmt.Errorf("%w: %s: неизвестное поле %q", ErrInvalidRules, path, n.Field)
+	}
+	if !contains(operators[f.kind], n.Op) {
+		return fmt.Errorf("%w: %s: оператор %q недоступен для поля %q", ErrInvalidRules, path, n.Op, n.Field)
+	}
+	if _, err := parseValue(f.kind, n.Op, n.Value); err != nil {
+		return fmt.Errorf("%w: %s.value: %v", ErrInvalidRules, path, err)
+	}
+	return nil
+}
+
+// parseValue приводит значение условия к типу, который ожидает оператор
+func parseValue(k kind, op string, raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("значение не задано")
+	}
+	switch {
+	case k == kindFlag:
+		var v bool
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, errors.New("ожидается true или false")
+		}
+		return v, nil
+
+	case op == "in_last_days" || op == "not_in_last_days":
+		var v int
+		if err := json.Unmarshal(raw, &v); err != nil || v < 1 || v > maxDays {
+			return nil, fmt.Errorf("ожидается число дней от 1 до %d", maxDays)
+		}
+		return v, nil
+
+	case op == "before" || op == "after":
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, errors.New("ожидается дата ГГГГ-ММ-ДД")
+		}
+		v, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return nil, errors.New("ожидается дата ГГГГ-ММ-ДД")
+		}
+		return v, nil
+
+	case op == "between":
+		var v []float64
+		if err := json.Unmarshal(raw, &v); err != nil || len(v) != 2 {
+			return nil, errors.New("ожидается [от, до]")
+		}
+		if v[0] > v[1] {
+			return nil, errors.New("начало диапазона больше конца")
+		}
+		return v, nil
+
+	case op == "in" && k == kindNumber:
+		var v []float64
+		if err := json.Unmarshal(raw, &v); err != nil || len(v) == 0 || len(v) > maxListSize {
+			return nil, fmt.Errorf("ожидается список из 1-%d чисел", maxListSize)
+		}
+		return v, nil
+
+	case op == "in":
+		var v []string
+		if err := json.Unmarshal(raw, &v); err != nil || len(v) == 0 || len(v) > maxListSize {
+			return nil, fmt.Errorf("ожидается список из 1-%d строк", maxListSize)
+		}
+		for i := range v {
+			v[i] = strings.ToLower(strings.TrimSpace(v[i]))
+		}
+		return v, nil
+
+	case k == kindNumber:
+		var v float64
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, errors.New("ожидается число")
+		}
+		return v, nil
+	}
+
+	var v string
+	if err := json.Unmarshal(raw, &v); err != nil || strings.TrimSpace(v) == "" {
+		return nil, errors.New("ожидается непустая строка")
+	}
+	if len(v) > 200 {
+		return nil, errors.New("строка длиннее 200 символов")
+	}
+	return strings.TrimSpace(v), nil
+}
+
+// builder собирает параметры запроса
+type builder struct {
+	args   []interface{}
+	userID int
+	userPH string
+}
+
+func (b *builder) arg(v interface{}) string {
+	b.args = append(b.args, v)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *builder) user() string {
+	if b.userPH == "" {
+		b.userPH = b.arg(b.userID)
+	}
+	return b.userPH
+}
+
+// Compile превращает проверенные правила в запрос, возвращающий id треков по порядку.
+// userID - владелец плейлиста, относительно него считаются liked, my_plays и т.п.
+func Compile(d Definition, userID int) (string, []interface{}, error) {
+	if err := d.Validate(); err != nil {
+		return "", nil, err
+	}
+
+	b := &builder{userID: userID}
+	where := "TRUE"
+	if len(d.Rules) > 0 || d.Field != "" {
+		where = b.node(d.Node)
+	}
+
+	orderBy := "t.created_at DESC"
+	if d.Sort == SortRandom {
+		orderBy = "RANDOM()"
+	} else if d.Sort != "" {
+		direction := "DESC"
+		if d.Order == "asc" {
+			direction = "ASC"
+		}
+		orderBy = fields[d.Sort].expr(b) + " " + direction + " NULLS LAST"
+	}
+
+	query := fmt.Sprintf(`SELECT t.id FROM tracks t WHERE t.is_blocked = false AND (%s) ORDER BY %s, t.id LIMIT %s`,
+		where, orderBy, b.arg(d.Limit))
+	return query, b.args, nil
+}
+
+func (b *builder) node(n Node) string {
+	if n.Field == "" {
+		parts := make([]string, len(n.Rules))
+		for i, r := range n.Rules {
+			parts[i] = b.node(r)
+		}
+		sep := " AND "
+		if n.Match == MatchAny {
+			sep = " OR "
+		}
+		return "(" + strings.Join(parts, sep) + ")"
+	}
+
+	f := fields[n.Field]
+	value, _ := parseValue(f.kind, n.Op, n.Value)
+	expr := f.expr(b)
+
+	switch n.Op {
+	case "is":
+		if value.(bool) {
+			return expr
+		}
+		return "NOT " + expr
+	case "in_last_days":
+		return fmt.Sprintf("%s >= NOW() - make_interval(days => %s)", expr, b.arg(value))
+	case "not_in_last_days":
+		return fmt.Sprintf("(%[1]s IS NULL OR %[1]s < NOW() - make_interval(days => %[2]s))", expr, b.arg(value))
+	case "before":
+		return fmt.Sprintf("%s < %s", expr, b.arg(value))
+	case "after":
+		return fmt.Sprintf("%s >= %s", expr, b.arg(value))
+	case "between":
+		v := value.([]float64)
+		return fmt.Sprintf("%s BETWEEN %s AND %s", expr, b.arg(v[0]), b.arg(v[1]))
+	case "contains":
+		return fmt.Sprintf("%s ILIKE %s", expr, b.arg("%"+escapeLike(value.(string))+"%"))
+	case "in":
+		if f.kind == kindText {
+			return fmt.Sprintf("LOWER(%s) = ANY(%s)", expr, b.arg(pq.Array(value)))
+		}
+		return fmt.Sprintf("%s = ANY(%s::float8[])", expr, b.arg(pq.Array(value)))
+	}
+
+	// eq, neq, gt, ...; строки сравниваются без учета регистра
+	if f.kind == kindText {
+		return fmt.Sprintf("LOWER(%s) %s LOWER(%s)", expr, comparisons[n.Op], b.arg(value))
+	}
+	return fmt.Sprintf("%s %s %s", expr, comparisons[n.Op], b.arg(value))
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package smartplaylist
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseDefinition(t *testing.T, raw string) Definition {
+	t.Helper()
+	var d Definition
+	if err := json.Unmarshal([]byte(raw), &d); err != nil {
+		t.Fatalf("некорректный JSON в тесте: %v", err)
+	}
+	return d
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		wantErr string // пусто - правила корректны
+	}{
+		{name: "пустой корень", rules: `{}`},
+		{name: "простое условие", rules: `{"rules": [{"field": "genre", "op": "eq", "value": "techno"}]}`},
+		{name: "вложенная группа", rules: `{"match": "any", "rules": [
+			{"field": "liked", "op": "is", "value": true},
+			{"match": "all", "rules": [{"field": "tempo_bpm", "op": "between", "value": [120, 130]}]}
+		]}`},
+		{name: "сортировка по числу прослушиваний", rules: `{"sort": "plays", "order": "asc", "limit": 20}`},
+		{name: "случайный порядок", rules: `{"sort": "random"}`},
+
+		{name: "неизвестное поле", rules: `{"rules": [{"field": "password", "op": "eq", "value": "x"}]}`, wantErr: `неизвестное поле "password"`},
+		{name: "неизвестный оператор", rules: `{"rules": [{"field": "genre", "op": "like", "value": "x"}]}`, wantErr: `оператор "like" недоступен`},
+		{name: "оператор другого типа поля", rules: `{"rules": [{"field": "genre", "op": "gt", "value": "x"}]}`, wantErr: `оператор "gt" недоступен`},
+		{name: "путь к неверному узлу", rules: `{"rules": [{"field": "genre", "op": "eq", "value": "x"}, {"match": "all", "rules": [{"field": "nope", "op": "eq", "value": 1}]}]}`, wantErr: "rules[1][0]"},
+		{name: "сортировка по флагу", rules: `{"sort": "liked"}`, wantErr: `нельзя сортировать по "liked"`},
+		{name: "сортировка по неизвестному полю", rules: `{"sort": "t.id; DROP TABLE tracks"}`, wantErr: "нельзя сортировать"},
+		{name: "неверный order", rules: `{"order": "sideways"}`, wantErr: "order должен быть"},
+		{name: "limit больше максимума", rules: `{"limit": 501}`, wantErr: "limit должен быть"},
+		{name: "неверный match", rules: `{"match": "some"}`, wantErr: "match должен быть"},
+		{name: "пустая группа", rules: `{"rules": [{"match": "all", "rules": []}]}`, wantErr: "пустая группа"},
+		{name: "условие и группа одновременно", rules: `{"rules": [{"field": "genre", "op": "eq", "value": "x", "match": "all"}]}`, wantErr: "одновременно быть группой"},
+		{name: "между с перевернутым диапазоном", rules: `{"rules": [{"field": "tempo_bpm", "op": "between", "value": [130, 120]}]}`, wantErr: "начало диапазона больше конца"},
+		{name: "дни вне диапазона", rules: `{"rules": [{"field": "liked_at", "op": "in_last_days", "value": 0}]}`, wantErr: "ожидается число дней"},
+		{name: "некорректная дата", rules: `{"rules": [{"field": "created_at", "op": "after", "value": "01.02.2024"}]}`, wantErr: "ожидается дата"},
+		{name: "флаг не bool", rules: `{"rules": [{"field": "liked", "op": "is", "value": "yes"}]}`, wantErr: "ожидается true или false"},
+		{name: "значение не задано", rules: `{"rules": [{"field": "duration", "op": "gt"}]}`, wantErr: "значение не задано"},
+		{name: "слишком глубокая вложенность", rules: `{"rules": [{"match": "all", "rules": [{"match": "all", "rules": [{"match": "all", "rules": [{"match": "all", "rules": [{"match": "all", "rules": [{"field": "genre", "op": "eq", "value": "x"}]}]}]}]}]}]}`, wantErr: "вложенность групп"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := parseDefinition(t, tt.rules)
+			err := d.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("неожиданная ошибка: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidRules) {
+				t.Fatalf("ожидалась ErrInvalidRules, получено %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ошибка %q не содержит %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTooManyRules(t *testing.T) {
+	rules := make([]string, maxRules+1)
+	for i := range rules {
+		rules[i] = `{"field": "duration", "op": "gt", "value": 1}`
+	}
+	d := parseDefinition(t, `{"rules": [`+strings.Join(rules, ",")+`]}`)
+	if err := d.Validate(); err == nil || !strings.Contains(err.Error(), "условий") {
+		t.Fatalf("ожидалась ошибка о числе условий, получено %v", err)
+	}
+}
+
+func TestValidateDefaults(t *testing.T) {
+	d := parseDefinition(t, `{}`)
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Limit != DefaultLimit || d.Match != MatchAll {
+		t.Fatalf("limit = %d, match = %q", d.Limit, d.Match)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     string
+		wantWhere string
+		wantOrder string
+		wantArgs  []interface{}
+	}{
+		{
+			name:      "все треки по умолчанию",
+			rules:     `{}`,
+			wantWhere: "(TRUE)",
+			wantOrder: "ORDER BY t.created_at DESC, t.id LIMIT $1",
+			wantArgs:  []interface{}{DefaultLimit},
+		},
+		{
+			name:      "текст сравнивается без регистра",
+			rules:     `{"rules": [{"field": "genre", "op": "eq", "value": "Techno"}], "limit": 10}`,
+			wantWhere: "((LOWER(t.genre) = LOWER($1)))",
+			wantArgs:  []interface{}{"Techno", 10},
+		},
+		{
+			name:      "any превращается в OR",
+			rules:     `{"match": "any", "rules": [{"field": "duration", "op": "gte", "value": 60}, {"field": "duration", "op": "lt", "value": 30}]}`,
+			wantWhere: "((t.duration >= $1 OR t.duration < $2))",
+			wantArgs:  []interface{}{60.0, 30.0, DefaultLimit},
+		},
+		{
+			name:      "параметр владельца добавляется один раз",
+			rules:     `{"rules": [{"field": "liked", "op": "is", "value": true}, {"field": "my_plays", "op": "gt", "value": 2}]}`,
+			wantWhere: "l.user_id = $1)",
+			wantArgs:  []interface{}{42, 2.0, DefaultLimit},
+		},
+		{
+			name:      "отрицание флага",
+			rules:     `{"rules": [{"field": "listened", "op": "is", "value": false}]}`,
+			wantWhere: "(NOT EXISTS",
+			wantArgs:  []interface{}{42, DefaultLimit},
+		},
+		{
+			name:      "contains экранирует шаблон LIKE",
+			rules:     `{"rules": [{"field": "title", "op": "contains", "value": "100%_mix"}]}`,
+			wantWhere: "t.title ILIKE $1",
+			wantArgs:  []interface{}{`%100\%\_mix%`, DefaultLimit},
+		},
+		{
+			name:      "сортировка по полю",
+			rules:     `{"sort": "tempo_bpm", "order": "asc"}`,
+			wantOrder: "ORDER BY t.tempo_bpm ASC NULLS LAST, t.id",
+			wantArgs:  []interface{}{DefaultLimit},
+		},
+		{
+			name:      "случайный порядок",
+			rules:     `{"sort": "random"}`,
+			wantOrder: "ORDER BY RANDOM(), t.id",
+			wantArgs:  []interface{}{DefaultLimit},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := Compile(parseDefinition(t, tt.rules), 42)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(query, "SELECT t.id FROM tracks t WHERE t.is_blocked = false AND ") {
+				t.Fatalf("запрос: %s", query)
+			}
+			if tt.wantWhere != "" && !strings.Contains(query, tt.wantWhere) {
+				t.Errorf("запрос %q не содержит %q", query, tt.wantWhere)
+			}
+			if tt.wantOrder != "" && !strings.Contains(query, tt.wantOrder) {
+				t.Errorf("запрос %q не содержит %q", query, tt.wantOrder)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("параметры %#v, ожидалось %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// Значения пользователя никогда не попадают в текст запроса
+func TestCompileKeepsValuesOutOfSQL(t *testing.T) {
+	query, _, err := Compile(parseDefinition(t, `{"rules": [
+		{"field": "genre", "op": "in", "value": ["rock'); DROP TABLE tracks; --"]},
+		{"field": "title", "op": "contains", "value": "' OR 1=1 --"}
+	]}`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(query, "DROP") || strings.Contains(query, "1=1") {
+		t.Fatalf("значение попало в запрос: %s", query)
+	}
+}
+
+func TestCompileRejectsInvalidRules(t *testing.T) {
+	_, _, err := Compile(parseDefinition(t, `{"rules": [{"field": "genre", "op": "regex", "value": ".*"}]}`), 1)
+	if !errors.Is(err, ErrInvalidRules) {
+		t.Fatalf("ожидалась ErrInvalidRules, получено %v", err)
+	}
+}
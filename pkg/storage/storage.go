@@ -19,6 +19,9 @@ type MinioStorage struct {
 	Track_bucket    string
 	Playlist_bucket string
 	Mp3_bucket      string
+	Export_bucket   string
+	Banner_bucket   string
+	Album_bucket    string
 }
 
 // NewMinioStorage инициализация MinIO
@@ -36,6 +39,18 @@ func NewMinioStorage() (*MinioStorage, error) {
 	track_bucket := os.Getenv("MINIO_TRACK_BUCKET")
 	playlist_bucket := os.Getenv("MINIO_PLAYLIST_BUCKET")
 	mp3_bucket := os.Getenv("MINIO_MP3_BUCKET")
+	export_bucket := os.Getenv("MINIO_EXPORT_BUCKET")
+	if export_bucket == "" {
+		export_bucket = bucket
+	}
+	banner_bucket := os.Getenv("MINIO_BANNER_BUCKET")
+	if banner_bucket == "" {
+		banner_bucket = playlist_bucket
+	}
+	album_bucket := os.Getenv("MINIO_ALBUM_BUCKET")
+	if album_bucket == "" {
+		album_bucket = playlist_bucket
+	}
 
 	// Подключение к MinIO
 	client, err := minio.New(endpoint, &minio.Options{
@@ -53,6 +68,9 @@ func NewMinioStorage() (*MinioStorage, error) {
 		Track_bucket:    track_bucket,
 		Playlist_bucket: playlist_bucket,
 		Mp3_bucket:      mp3_bucket,
+		Export_bucket:   export_bucket,
+		Banner_bucket:   banner_bucket,
+		Album_bucket:    album_bucket,
 	}, nil
 }
 
@@ -121,6 +139,12 @@ func (s *MinioStorage) UploadImage(bucketType, objectName string, file io.Reader
 	if bucketType == "playlist" {
 		_, err = s.Client.PutObject(context.Background(), s.Playlist_bucket, objectName, file, -1, minio.PutObjectOptions{})
 	}
+	if bucketType == "banner" {
+		_, err = s.Client.PutObject(context.Background(), s.Banner_bucket, "banner_"+objectName, file, -1, minio.PutObjectOptions{})
+	}
+	if bucketType == "album" {
+		_, err = s.Client.PutObject(context.Background(), s.Album_bucket, "album_"+objectName, file, -1, minio.PutObjectOptions{})
+	}
 	return err
 }
 
@@ -136,6 +160,12 @@ func (s *MinioStorage) GetImage(bucketType string, fileName string) (io.ReadClos
 	if bucketType == "playlist" {
 		_, err = s.Client.StatObject(ctx, s.Playlist_bucket, fileName, minio.StatObjectOptions{})
 	}
+	if bucketType == "banner" {
+		_, err = s.Client.StatObject(ctx, s.Banner_bucket, "banner_"+fileName, minio.StatObjectOptions{})
+	}
+	if bucketType == "album" {
+		_, err = s.Client.StatObject(ctx, s.Album_bucket, "album_"+fileName, minio.StatObjectOptions{})
+	}
 
 	// Если объект не существует, вернем ошибку
 	if err != nil {
@@ -155,6 +185,12 @@ func (s *MinioStorage) GetImage(bucketType string, fileName string) (io.ReadClos
 	if bucketType == "playlist" {
 		obj, err = s.Client.GetObject(ctx, s.Playlist_bucket, fileName, minio.GetObjectOptions{})
 	}
+	if bucketType == "banner" {
+		obj, err = s.Client.GetObject(ctx, s.Banner_bucket, "banner_"+fileName, minio.GetObjectOptions{})
+	}
+	if bucketType == "album" {
+		obj, err = s.Client.GetObject(ctx, s.Album_bucket, "album_"+fileName, minio.GetObjectOptions{})
+	}
 
 	if err != nil {
 		log.Printf("Ошибка при получении объекта %s: %v", fileName, err)
@@ -180,3 +216,85 @@ func (s *MinioStorage) GetMP3(fileName string) (io.ReadCloser, error) {
 	// Получаем объект из бакета
 	return s.Client.GetObject(ctx, s.Mp3_bucket, fileName, minio.GetObjectOptions{})
 }
+
+// UploadExport сохраняет архив с выгрузкой данных пользователя
+func (s *MinioStorage) UploadExport(objectName string, file io.Reader, size int64) error {
+	_, err := s.Client.PutObject(context.Background(), s.Export_bucket, objectName, file, size, minio.PutObjectOptions{ContentType: "application/zip"})
+	return err
+}
+
+func (s *MinioStorage) GetExport(objectName string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	if _, err := s.Client.StatObject(ctx, s.Export_bucket, objectName, minio.StatObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("file not found")
+		}
+		return nil, err
+	}
+	return s.Client.GetObject(ctx, s.Export_bucket, objectName, minio.GetObjectOptions{})
+}
+
+func (s *MinioStorage) DeleteExport(objectName string) error {
+	return s.Client.RemoveObject(context.Background(), s.Export_bucket, objectName, minio.RemoveObjectOptions{})
+}
+
+// DeleteTrackMedia удаляет mp3, плейлист HLS с сегментами и обложку трека
+func (s *MinioStorage) DeleteTrackMedia(trackID int) error {
+	id := fmt.Sprintf("%d", trackID)
+	if err := s.removePrefix(s.Mp3_bucket, id+"."); err != nil {
+		return err
+	}
+	if err := s.removePrefix(s.Bucket, id+"."); err != nil {
+		return err
+	}
+	if err := s.removePrefix(s.Bucket, id+"_"); err != nil {
+		return err
+	}
+	return s.removePrefix(s.Track_bucket, id+".")
+}
+
+// DeletePlaylistCover удаляет обложку плейлиста (.jpg или .png)
+func (s *MinioStorage) DeletePlaylistCover(playlistID int) error {
+	return s.removePrefix(s.Playlist_bucket, fmt.Sprintf("%d.", playlistID))
+}
+
+// DeleteBanner удаляет баннер артиста
+func (s *MinioStorage) DeleteBanner(userID int) error {
+	return s.removePrefix(s.Banner_bucket, fmt.Sprintf("banner_%d.", userID))
+}
+
+// DeleteOldBanner удаляет баннеры с другим расширением после загрузки нового с расширением ext
+func (s *MinioStorage) DeleteOldBanner(userID int, ext string) error {
+	return s.removePrefixExcept(s.Banner_bucket, fmt.Sprintf("banner_%d.", userID), fmt.Sprintf("banner_%d%s", userID, ext))
+}
+
+// DeleteAlbumCover удаляет обложку альбома (.jpg или .png)
+func (s *MinioStorage) DeleteAlbumCover(albumID int) error {
+	return s.removePrefix(s.Album_bucket, fmt.Sprintf("album_%d.", albumID))
+}
+
+// DeleteOldAlbumCover удаляет обложки альбома с другим расширением после загрузки новой с расширением ext
+func (s *MinioStorage) DeleteOldAlbumCover(albumID int, ext string) error {
+	return s.removePrefixExcept(s.Album_bucket, fmt.Sprintf("album_%d.", albumID), fmt.Sprintf("album_%d%s", albumID, ext))
+}
+
+func (s *MinioStorage) removePrefix(bucket, prefix string) error {
+	return s.removePrefixExcept(bucket, prefix, "")
+}
+
+// removePrefixExcept удаляет объекты с префиксом, кроме keep
+func (s *MinioStorage) removePrefixExcept(bucket, prefix, keep string) error {
+	ctx := context.Background()
+	for obj := range s.Client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if obj.Key == keep {
+			continue
+		}
+		if err := s.Client.RemoveObject(ctx, bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}